@@ -11,6 +11,8 @@ import (
 	"net/url"
 	"strings"
 	"time"
+
+	"github.com/vespa-engine/vespa/client/go/util"
 )
 
 const (
@@ -107,7 +109,7 @@ func (a *Authenticator) Wait(ctx context.Context, state State) (Result, error) {
 				if *res.Error == "authorization_pending" {
 					continue
 				}
-				return Result{}, errors.New(res.ErrorDescription)
+				return Result{}, errors.New(util.Redact(res.ErrorDescription))
 			}
 
 			return Result{
@@ -27,6 +27,9 @@ const accessTokenExpThreshold = 5 * time.Minute
 
 var errUnauthenticated = errors.New("not logged in. Try 'vespa auth login'")
 
+// ErrTokenExpired is returned when an expired access token cannot be renewed.
+var ErrTokenExpired = errors.New("access token expired, and could not be renewed")
+
 type configJsonFormat struct {
 	Version   int       `json:"version"`
 	Providers providers `json:"providers"`
@@ -55,10 +58,13 @@ type Auth0 struct {
 	initOnce      sync.Once
 	errOnce       error
 	Path          string
-	config        config
+	// Secrets holds the refresh token of each system. If nil, the keyring of the operating system is used.
+	Secrets auth.SecretStore
+	config  config
 }
 
-type authCfg struct {
+// DeviceFlowConfig configures the OAuth device authorization flow used for logging in.
+type DeviceFlowConfig struct {
 	Audience           string `json:"audience"`
 	ClientID           string `json:"client-id"`
 	DeviceCodeEndpoint string `json:"device-code-endpoint"`
@@ -80,13 +86,23 @@ func ContextWithCancel() context.Context {
 // GetAuth0 will try to initialize the config context, as well as figure out if
 // there's a readily available system.
 func GetAuth0(configPath string, systemName string, systemApiUrl string) (*Auth0, error) {
+	return GetAuth0WithConfig(configPath, systemName, systemApiUrl, DeviceFlowConfig{})
+}
+
+// GetAuth0WithConfig is like GetAuth0, but the fields set in override take precedence over the device flow config of
+// the system. The config of the system is not requested if all fields are set in override.
+func GetAuth0WithConfig(configPath string, systemName string, systemApiUrl string, override DeviceFlowConfig) (*Auth0, error) {
 	a := Auth0{}
 	a.Path = configPath
 	a.system = systemName
 	a.systemApiUrl = systemApiUrl
-	c, err := a.getDeviceFlowConfig()
-	if err != nil {
-		return nil, fmt.Errorf("cannot get auth config: %w", err)
+	c := override
+	if !override.complete() {
+		systemConfig, err := a.getDeviceFlowConfig()
+		if err != nil {
+			return nil, fmt.Errorf("cannot get auth config: %w", err)
+		}
+		c = systemConfig.merge(override)
 	}
 	a.Authenticator = &auth.Authenticator{
 		Audience:           c.Audience,
@@ -97,17 +113,38 @@ func GetAuth0(configPath string, systemName string, systemApiUrl string) (*Auth0
 	return &a, nil
 }
 
-func (a *Auth0) getDeviceFlowConfig() (authCfg, error) {
+func (c DeviceFlowConfig) complete() bool {
+	return c.Audience != "" && c.ClientID != "" && c.DeviceCodeEndpoint != "" && c.OauthTokenEndpoint != ""
+}
+
+// merge returns a copy of c where the fields set in override are replaced.
+func (c DeviceFlowConfig) merge(override DeviceFlowConfig) DeviceFlowConfig {
+	if override.Audience != "" {
+		c.Audience = override.Audience
+	}
+	if override.ClientID != "" {
+		c.ClientID = override.ClientID
+	}
+	if override.DeviceCodeEndpoint != "" {
+		c.DeviceCodeEndpoint = override.DeviceCodeEndpoint
+	}
+	if override.OauthTokenEndpoint != "" {
+		c.OauthTokenEndpoint = override.OauthTokenEndpoint
+	}
+	return c
+}
+
+func (a *Auth0) getDeviceFlowConfig() (DeviceFlowConfig, error) {
 	systemApiUrl, _ := url.Parse(a.systemApiUrl + "/auth0/v1/device-flow-config")
 	r, err := http.Get(systemApiUrl.String())
 	if err != nil {
-		return authCfg{}, fmt.Errorf("cannot get auth config: %w", err)
+		return DeviceFlowConfig{}, fmt.Errorf("cannot get auth config: %w", err)
 	}
 	defer r.Body.Close()
-	var res authCfg
+	var res DeviceFlowConfig
 	err = json.NewDecoder(r.Body).Decode(&res)
 	if err != nil {
-		return authCfg{}, fmt.Errorf("cannot decode response: %w", err)
+		return DeviceFlowConfig{}, fmt.Errorf("cannot decode response: %w", err)
 	}
 	return res, nil
 }
@@ -139,7 +176,8 @@ func (a *Auth0) IsLoggedIn() bool {
 // PrepareSystem loads the System, refreshing its token if necessary.
 // The System access token needs a refresh if:
 // 1. the System scopes are different from the currently required scopes - (auth0 changes).
-// 2. the access token is expired.
+// 2. the access token is expired, or expires soon. The stored refresh token is then used to renew it, and an error is
+// returned if renewal fails.
 func (a *Auth0) PrepareSystem(ctx context.Context) (*System, error) {
 	if err := a.init(); err != nil {
 		return nil, err
@@ -159,30 +197,24 @@ func (a *Auth0) PrepareSystem(ctx context.Context) (*System, error) {
 		// use the refresh token to get a new access token:
 		tr := &auth.TokenRetriever{
 			Authenticator: a.Authenticator,
-			Secrets:       &auth.Keyring{},
+			Secrets:       a.secrets(),
 			Client:        http.DefaultClient,
 		}
 
 		res, err := tr.Refresh(ctx, a.system)
 		if err != nil {
-			// ask and guide the user through the login process:
-			fmt.Println(fmt.Errorf("failed to renew access token, %s", err))
-			fmt.Print("\n")
-			s, err = RunLogin(ctx, a, true)
-			if err != nil {
-				return nil, err
-			}
-		} else {
-			// persist the updated system with renewed access token
-			s.AccessToken = res.AccessToken
-			s.ExpiresAt = time.Now().Add(
-				time.Duration(res.ExpiresIn) * time.Second,
-			)
-
-			err = a.AddSystem(s)
-			if err != nil {
-				return nil, err
-			}
+			// never send an expired access token, and leave logging in again to the user
+			return nil, fmt.Errorf("%w: %s", ErrTokenExpired, err)
+		}
+		// persist the updated system with renewed access token
+		s.AccessToken = res.AccessToken
+		s.ExpiresAt = time.Now().Add(
+			time.Duration(res.ExpiresIn) * time.Second,
+		)
+
+		err = a.AddSystem(s)
+		if err != nil {
+			return nil, err
 		}
 	}
 
@@ -220,6 +252,35 @@ func scopesChanged(s *System) bool {
 	return false
 }
 
+// CurrentSystem returns the stored credentials of the system in use, without refreshing them.
+func (a *Auth0) CurrentSystem() (*System, error) { return a.getSystem() }
+
+// Systems returns the stored credentials of all systems, ordered by name, without refreshing them.
+func (a *Auth0) Systems() ([]*System, error) {
+	if err := a.init(); err != nil {
+		return nil, err
+	}
+	var systems []*System
+	for _, s := range a.config.Systems {
+		systems = append(systems, s)
+	}
+	sort.Slice(systems, func(i, j int) bool { return systems[i].Name < systems[j].Name })
+	return systems, nil
+}
+
+// HasRefreshToken returns whether a refresh token is stored for the system in use.
+func (a *Auth0) HasRefreshToken() bool {
+	token, err := a.secrets().Get(auth.SecretsNamespace, a.system)
+	return err == nil && token != ""
+}
+
+func (a *Auth0) secrets() auth.SecretStore {
+	if a.Secrets != nil {
+		return a.Secrets
+	}
+	return &auth.Keyring{}
+}
+
 func (a *Auth0) getSystem() (*System, error) {
 	if err := a.init(); err != nil {
 		return nil, err
@@ -252,23 +313,22 @@ func (a *Auth0) AddSystem(s *System) error {
 	return nil
 }
 
+// removeSystem removes the stored credentials of system s, if any.
 func (a *Auth0) removeSystem(s string) error {
 	_ = a.init()
 
-	// If we're dealing with an empty file, we'll need to initialize this map.
-	if a.config.Systems == nil {
-		a.config.Systems = map[string]*System{}
-	}
-
-	delete(a.config.Systems, s)
-
-	if err := a.persistConfig(); err != nil {
-		return fmt.Errorf("unexpected error persisting config: %w", err)
+	if _, ok := a.config.Systems[s]; ok {
+		delete(a.config.Systems, s)
+		if err := a.persistConfig(); err != nil {
+			return fmt.Errorf("unexpected error persisting config: %w", err)
+		}
 	}
 
-	tr := &auth.TokenRetriever{Secrets: &auth.Keyring{}}
-	if err := tr.Delete(s); err != nil {
-		return fmt.Errorf("unexpected error clearing system information: %w", err)
+	if token, err := a.secrets().Get(auth.SecretsNamespace, s); err == nil && token != "" {
+		tr := &auth.TokenRetriever{Secrets: a.secrets()}
+		if err := tr.Delete(s); err != nil {
+			return fmt.Errorf("unexpected error clearing system information: %w", err)
+		}
 	}
 
 	return nil
@@ -410,19 +470,17 @@ func RunLogin(ctx context.Context, a *Auth0, expired bool) (*System, error) {
 	return &s, nil
 }
 
-func RunLogout(a *Auth0) error {
-	s, err := a.getSystem()
-	if err != nil {
-		return err
+// RunLogout removes the access token and refresh token stored for the system in use, and returns the name of the
+// system. Nothing is removed, and the returned name is empty, if no credentials are stored.
+func RunLogout(a *Auth0) (string, error) {
+	if err := a.init(); err != nil && !errors.Is(err, errUnauthenticated) {
+		return "", err
 	}
-
-	if err := a.removeSystem(s.Name); err != nil {
-		return err
+	if _, ok := a.config.Systems[a.system]; !ok && !a.HasRefreshToken() {
+		return "", nil
 	}
-
-	fmt.Print("\n")
-	fmt.Println("Successfully logged out.")
-	fmt.Print("\n")
-
-	return nil
+	if err := a.removeSystem(a.system); err != nil {
+		return "", err
+	}
+	return a.system, nil
 }
@@ -128,10 +128,14 @@ func printPublicKey(apiKeyFile, tenant string) error {
 	if err != nil {
 		return fmt.Errorf("failed to extract fingerprint: %w", err)
 	}
+	consoleURL, err := getConsoleURL()
+	if err != nil {
+		return err
+	}
 	log.Printf("\nThis is your public key:\n%s", color.Green(pemPublicKey))
 	log.Printf("Its fingerprint is:\n%s\n", color.Cyan(fingerprint))
 	log.Print("\nTo use this key in Vespa Cloud click 'Add custom key' at")
-	log.Printf(color.Cyan("%s/tenant/%s/keys").String(), getConsoleURL(), tenant)
+	log.Printf(color.Cyan("%s/tenant/%s/keys").String(), consoleURL, tenant)
 	log.Print("and paste the entire public key including the BEGIN and END lines.")
 	return nil
 }
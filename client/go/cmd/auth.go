@@ -16,6 +16,7 @@ func init() {
 		authCmd.AddCommand(apiKeyCmd)
 		authCmd.AddCommand(loginCmd)
 		authCmd.AddCommand(logoutCmd)
+		authCmd.AddCommand(authStatusCmd)
 	} else {
 		rootCmd.AddCommand(certCmd)
 		rootCmd.AddCommand(apiKeyCmd)
@@ -0,0 +1,171 @@
+// Copyright Yahoo. Licensed under the terms of the Apache 2.0 license. See LICENSE in the project root.
+// vespa auth status command
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/vespa-engine/vespa/client/go/auth0"
+	"github.com/vespa-engine/vespa/client/go/vespa"
+)
+
+// tokenExpiryWarningPeriod is the remaining validity of an access token below which a warning is printed.
+const tokenExpiryWarningPeriod = time.Hour
+
+var authStatusFormatArg string
+
+func init() {
+	authStatusCmd.Flags().StringVarP(&authStatusFormatArg, "format", "", "human", `Output format. Must be "human" or "json"`)
+}
+
+var authStatusCmd = &cobra.Command{
+	Use:   "status",
+	Args:  cobra.NoArgs,
+	Short: "Show the status of the stored Vespa Cloud credentials",
+	Long: `Show the status of the stored Vespa Cloud credentials.
+
+This prints the system and tenant in use, how long the stored access token
+remains valid, and whether a refresh token is available to renew it. Expired
+access tokens are renewed automatically when a refresh token is available,
+otherwise 'vespa auth login' must be run again. The access tokens stored for
+other systems are listed as well.
+
+With --format json, the same information is printed as a JSON object.`,
+	Example: `$ vespa auth status
+$ vespa auth status --format json`,
+	DisableAutoGenTag: true,
+	SilenceUsage:      true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if authStatusFormatArg != "human" && authStatusFormatArg != "json" {
+			return fmt.Errorf("invalid format: %q", authStatusFormatArg)
+		}
+		cfg, err := LoadConfig()
+		if err != nil {
+			return err
+		}
+		a, err := getAuth0(cfg)
+		if err != nil {
+			return err
+		}
+		system, err := a.CurrentSystem()
+		if err != nil {
+			return err
+		}
+		systems, err := a.Systems()
+		if err != nil {
+			return err
+		}
+		if authStatusFormatArg == "json" {
+			return printAuthStatusJSON(cfg, system, systems, a.HasRefreshToken())
+		}
+		if err := printAuthStatus(cfg, system, a.HasRefreshToken()); err != nil {
+			return err
+		}
+		printOtherSystems(system, systems)
+		return nil
+	},
+}
+
+func printAuthStatus(cfg *Config, system *auth0.System, hasRefreshToken bool) error {
+	log.Print("System: ", color.Cyan(system.Name))
+	if tenant := configuredTenant(cfg); tenant != "" {
+		log.Print("Tenant: ", color.Cyan(tenant))
+	}
+	log.Print("Credentials: ", color.Cyan(cfg.AuthConfigPath()))
+	if hasRefreshToken {
+		log.Print("Refresh token: ", color.Green("available"))
+	} else {
+		log.Print("Refresh token: ", color.Yellow("not available"))
+	}
+	expiresIn := time.Until(system.ExpiresAt)
+	expiresAt := system.ExpiresAt.UTC().Format(time.RFC3339)
+	switch {
+	case expiresIn <= 0 && hasRefreshToken:
+		log.Print("Access token: ", color.Yellow("expired"), " at ", color.Cyan(expiresAt), ", and is renewed by the next command using it")
+	case expiresIn <= 0:
+		return errHint(fmt.Errorf("access token expired at %s, and no refresh token is available", expiresAt), "Run 'vespa auth login' to log in again")
+	case expiresIn < tokenExpiryWarningPeriod:
+		log.Print("Access token: ", color.Green("valid"), ", expires in ", color.Cyan(formatExpiry(expiresIn)), " at ", color.Cyan(expiresAt))
+		if !hasRefreshToken {
+			fmt.Fprintln(stderr, color.Yellow("Warning:"), fmt.Sprintf("Access token expires in %s, and no refresh token is available", formatExpiry(expiresIn)))
+			fmt.Fprintln(stderr, color.Cyan("Hint:"), "Run 'vespa auth login' to log in again")
+		}
+	default:
+		log.Print("Access token: ", color.Green("valid"), ", expires in ", color.Cyan(formatExpiry(expiresIn)), " at ", color.Cyan(expiresAt))
+	}
+	return nil
+}
+
+// printOtherSystems prints the validity of the access token of each system in systems, other than current.
+func printOtherSystems(current *auth0.System, systems []*auth0.System) {
+	printed := false
+	for _, s := range systems {
+		if s.Name == current.Name {
+			continue
+		}
+		if !printed {
+			log.Print("Other systems:")
+			printed = true
+		}
+		expiresAt := s.ExpiresAt.UTC().Format(time.RFC3339)
+		if time.Now().Before(s.ExpiresAt) {
+			log.Print("  ", color.Cyan(s.Name), ": access token ", color.Green("valid"), " until ", color.Cyan(expiresAt))
+		} else {
+			log.Print("  ", color.Cyan(s.Name), ": access token ", color.Yellow("expired"), " at ", color.Cyan(expiresAt))
+		}
+	}
+}
+
+type authStatus struct {
+	System          string             `json:"system"`
+	Tenant          string             `json:"tenant,omitempty"`
+	Credentials     string             `json:"credentials"`
+	HasRefreshToken bool               `json:"refreshToken"`
+	Systems         []systemAuthStatus `json:"systems"`
+}
+
+type systemAuthStatus struct {
+	Name      string `json:"name"`
+	Valid     bool   `json:"valid"`
+	ExpiresAt string `json:"expiresAt"`
+}
+
+func printAuthStatusJSON(cfg *Config, system *auth0.System, systems []*auth0.System, hasRefreshToken bool) error {
+	status := authStatus{
+		System:          system.Name,
+		Tenant:          configuredTenant(cfg),
+		Credentials:     cfg.AuthConfigPath(),
+		HasRefreshToken: hasRefreshToken,
+		Systems:         []systemAuthStatus{},
+	}
+	for _, s := range systems {
+		status.Systems = append(status.Systems, systemAuthStatus{
+			Name:      s.Name,
+			Valid:     time.Now().Before(s.ExpiresAt),
+			ExpiresAt: s.ExpiresAt.UTC().Format(time.RFC3339),
+		})
+	}
+	b, err := json.MarshalIndent(status, "", "  ")
+	if err != nil {
+		return err
+	}
+	fmt.Fprintln(stdout, string(b))
+	return nil
+}
+
+// configuredTenant returns the tenant of the configured application, or empty if no application is configured.
+func configuredTenant(cfg *Config) string {
+	app, err := cfg.Get(applicationFlag)
+	if err != nil {
+		return ""
+	}
+	application, err := vespa.ApplicationFromString(app)
+	if err != nil {
+		return ""
+	}
+	return application.Tenant
+}
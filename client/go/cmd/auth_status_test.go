@@ -0,0 +1,165 @@
+// Copyright Yahoo. Licensed under the terms of the Apache 2.0 license. See LICENSE in the project root.
+package cmd
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+	"github.com/vespa-engine/vespa/client/go/auth0"
+)
+
+type mockSecretStore map[string]string
+
+func (s mockSecretStore) Get(namespace, key string) (string, error) {
+	if v, ok := s[namespace+"/"+key]; ok {
+		return v, nil
+	}
+	return "", errors.New("secret not found")
+}
+
+func (s mockSecretStore) Delete(namespace, key string) error {
+	delete(s, namespace+"/"+key)
+	return nil
+}
+
+// useMockAuth0 adds the auth command and given subcommands, and makes them use a fixed device flow config and a mock
+// secret store, which is returned. Everything is restored when the test ends.
+func useMockAuth0(t *testing.T, subCommands ...*cobra.Command) mockSecretStore {
+	if authCmd.Parent() == nil {
+		rootCmd.AddCommand(authCmd)
+		t.Cleanup(func() { rootCmd.RemoveCommand(authCmd) })
+	}
+	for _, c := range subCommands {
+		if c.Parent() == nil {
+			authCmd.AddCommand(c)
+		}
+	}
+	f := newAuth0
+	t.Cleanup(func() {
+		newAuth0 = f
+		auth0Current = nil
+		viper.Reset()
+	})
+	secrets := mockSecretStore{}
+	newAuth0 = func(configPath, systemName, systemApiUrl string, override auth0.DeviceFlowConfig) (*auth0.Auth0, error) {
+		a, err := auth0.GetAuth0WithConfig(configPath, systemName, systemApiUrl, auth0.DeviceFlowConfig{
+			Audience:           "https://api.example.com",
+			ClientID:           "client",
+			DeviceCodeEndpoint: "https://idp.example.com/device/code",
+			OauthTokenEndpoint: "https://idp.example.com/token",
+		})
+		if err != nil {
+			return nil, err
+		}
+		a.Secrets = secrets
+		return a, nil
+	}
+	return secrets
+}
+
+func TestAuthStatus(t *testing.T) {
+	secrets := useMockAuth0(t, authStatusCmd)
+	homeDir := filepath.Join(t.TempDir(), ".vespa")
+	authConfig := filepath.Join(homeDir, "auth.json")
+	run := func() (string, string) {
+		auth0Current = nil
+		viper.Reset()
+		return execute(command{homeDir: homeDir, args: []string{"auth", "status"}}, t, nil)
+	}
+	storeToken := func(validFor time.Duration) string {
+		a, err := newAuth0(authConfig, "public", "", auth0.DeviceFlowConfig{})
+		assert.Nil(t, err)
+		expiresAt := time.Now().Add(validFor)
+		assert.Nil(t, a.AddSystem(&auth0.System{Name: "public", AccessToken: "secret", ExpiresAt: expiresAt}))
+		return expiresAt.UTC().Format(time.RFC3339)
+	}
+
+	_, errOut := run()
+	assert.Equal(t, "Error: not logged in. Try 'vespa auth login'\n", errOut)
+	execute(command{homeDir: homeDir, args: []string{"config", "set", "application", "t1.a1.i1"}}, t, nil)
+
+	// Valid token
+	secrets["vespa-cli/public"] = "refresh"
+	expiresAt := storeToken(3*time.Hour + time.Minute)
+	out, errOut := run()
+	assert.Equal(t, "", errOut)
+	assert.Equal(t, "System: public\n"+
+		"Tenant: t1\n"+
+		"Credentials: "+authConfig+"\n"+
+		"Refresh token: available\n"+
+		"Access token: valid, expires in 3 hours at "+expiresAt+"\n", out)
+
+	// Soon to expire, and no refresh token
+	delete(secrets, "vespa-cli/public")
+	expiresAt = storeToken(30*time.Minute + 30*time.Second)
+	out, errOut = run()
+	assert.Contains(t, out, "Refresh token: not available\n"+
+		"Access token: valid, expires in 30 minutes at "+expiresAt+"\n")
+	assert.Equal(t, "Warning: Access token expires in 30 minutes, and no refresh token is available\n"+
+		"Hint: Run 'vespa auth login' to log in again\n", errOut)
+
+	// Expired
+	expiresAt = storeToken(-time.Hour)
+	_, errOut = run()
+	assert.Equal(t, "Error: access token expired at "+expiresAt+", and no refresh token is available\n"+
+		"Hint: Run 'vespa auth login' to log in again\n", errOut)
+	secrets["vespa-cli/public"] = "refresh"
+	out, errOut = run()
+	assert.Equal(t, "", errOut)
+	assert.Contains(t, out, "Access token: expired at "+expiresAt+", and is renewed by the next command using it\n")
+}
+
+func TestAuthStatusSystems(t *testing.T) {
+	useMockAuth0(t, authStatusCmd)
+	homeDir := filepath.Join(t.TempDir(), ".vespa")
+	authConfig := filepath.Join(homeDir, "auth.json")
+	storeToken := func(system string, expiresAt time.Time) {
+		a, err := newAuth0(authConfig, system, "", auth0.DeviceFlowConfig{})
+		assert.Nil(t, err)
+		assert.Nil(t, a.AddSystem(&auth0.System{Name: system, AccessToken: "secret", ExpiresAt: expiresAt}))
+	}
+	validUntil := time.Now().Add(3*time.Hour + time.Minute).UTC().Truncate(time.Second)
+	expiredAt := time.Now().Add(-time.Hour).UTC().Truncate(time.Second)
+	storeToken("public", validUntil)
+	storeToken("publiccd", expiredAt)
+	execute(command{homeDir: homeDir, args: []string{"config", "set", "application", "t1.a1.i1"}}, t, nil)
+
+	auth0Current = nil
+	viper.Reset()
+	out, errOut := execute(command{homeDir: homeDir, args: []string{"auth", "status"}}, t, nil)
+	assert.Equal(t, "", errOut)
+	assert.Contains(t, out, "Other systems:\n"+
+		"  publiccd: access token expired at "+expiredAt.Format(time.RFC3339)+"\n")
+
+	auth0Current = nil
+	viper.Reset()
+	out, errOut = execute(command{homeDir: homeDir, args: []string{"auth", "status", "--format", "json"}}, t, nil)
+	assert.Equal(t, "", errOut)
+	assert.Equal(t, `{
+  "system": "public",
+  "tenant": "t1",
+  "credentials": "`+authConfig+`",
+  "refreshToken": false,
+  "systems": [
+    {
+      "name": "public",
+      "valid": true,
+      "expiresAt": "`+validUntil.Format(time.RFC3339)+`"
+    },
+    {
+      "name": "publiccd",
+      "valid": false,
+      "expiresAt": "`+expiredAt.Format(time.RFC3339)+`"
+    }
+  ]
+}
+`, out)
+
+	_, errOut = execute(command{homeDir: homeDir, args: []string{"auth", "status", "--format", "yaml"}}, t, nil)
+	assert.Equal(t, "Error: invalid format: \"yaml\"\n", errOut)
+}
@@ -4,16 +4,26 @@
 package cmd
 
 import (
+	"crypto/x509"
 	"fmt"
+	"io/ioutil"
 	"os"
 	"path/filepath"
+	"strings"
+	"time"
 
 	"github.com/spf13/cobra"
 	"github.com/vespa-engine/vespa/client/go/util"
 	"github.com/vespa-engine/vespa/client/go/vespa"
 )
 
-var overwriteCertificate bool
+var (
+	overwriteCertificate bool
+	certFileArg          string
+)
+
+// certExpiryWarning is the remaining validity of a certificate below which cert info warns about its expiry.
+const certExpiryWarning = 30 * 24 * time.Hour
 
 const longDoc = `Create a new private key and self-signed certificate for Vespa Cloud deployment.
 
@@ -36,6 +46,13 @@ environment variables. This can be useful in continuous integration systems.
   export VESPA_CLI_DATA_PLANE_CERT_FILE=/path/to/cert
   export VESPA_CLI_DATA_PLANE_KEY_FILE=/path/to/key
 
+* VESPA_CLI_DATA_PLANE_PKCS12_FILE containing path to a PKCS#12 (.p12 or .pfx)
+  file containing both certificate and private key, and
+  VESPA_CLI_DATA_PLANE_PKCS12_PASSWORD containing the password of that file:
+
+  export VESPA_CLI_DATA_PLANE_PKCS12_FILE=/path/to/client.p12
+  export VESPA_CLI_DATA_PLANE_PKCS12_PASSWORD="my password"
+
 Note that when overriding key pair through environment variables, that key pair
 will always be used for all applications. It's not possible to specify an
 application-specific key.`
@@ -43,6 +60,8 @@ application-specific key.`
 func init() {
 	certCmd.Flags().BoolVarP(&overwriteCertificate, "force", "f", false, "Force overwrite of existing certificate and private key")
 	certCmd.MarkPersistentFlagRequired(applicationFlag)
+	certCmd.AddCommand(certInfoCmd)
+	certInfoCmd.Flags().StringVar(&certFileArg, "file", "", "The PEM-encoded certificate to show. Defaults to the data-plane certificate of the application")
 }
 
 func certExample() string {
@@ -77,15 +96,113 @@ var deprecatedCertCmd = &cobra.Command{
 	RunE:              doCert,
 }
 
+var certInfoCmd = &cobra.Command{
+	Use:   "info",
+	Short: "Show the details of a certificate",
+	Long: `Show the details of a certificate.
+
+The subject, issuer, serial number, validity period and subject alternative
+names of the certificate are printed. A warning is printed if the certificate
+has expired, or expires within 30 days.
+
+If no file is given, the data-plane certificate of the application is shown.`,
+	Example: `$ vespa cert info -a my-tenant.my-app.my-instance
+$ vespa cert info --file /path/to/cert.pem`,
+	DisableAutoGenTag: true,
+	SilenceUsage:      true,
+	Args:              cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		source, cert, err := readCertificate()
+		if err != nil {
+			return err
+		}
+		printCertificate(cert)
+		if remaining := time.Until(cert.NotAfter); remaining <= 0 {
+			fmt.Fprintln(stderr, color.Yellow("Warning:"), fmt.Sprintf("Certificate %s expired at %s", source, cert.NotAfter.UTC().Format(time.RFC3339)))
+		} else if remaining < certExpiryWarning {
+			fmt.Fprintln(stderr, color.Yellow("Warning:"), fmt.Sprintf("Certificate %s expires in %d days, at %s", source,
+				int(remaining.Hours()/24), cert.NotAfter.UTC().Format(time.RFC3339)))
+		}
+		return nil
+	},
+}
+
+// readCertificate reads the certificate given by the --file flag, or the data-plane certificate of the application.
+// This returns a description of where the certificate was read from, and the certificate itself.
+func readCertificate() (string, *x509.Certificate, error) {
+	if certFileArg != "" {
+		data, err := ioutil.ReadFile(certFileArg)
+		if err != nil {
+			return "", nil, err
+		}
+		cert, err := vespa.ParseCertificate(data)
+		if err != nil {
+			return "", nil, fmt.Errorf("could not parse certificate %s: %w", certFileArg, err)
+		}
+		return certFileArg, cert, nil
+	}
+	app, err := getApplication()
+	if err != nil {
+		return "", nil, err
+	}
+	cfg, err := LoadConfig()
+	if err != nil {
+		return "", nil, err
+	}
+	kp, err := cfg.X509KeyPair(app)
+	if err != nil {
+		hint := "Try 'vespa cert'"
+		if vespa.Auth0AccessTokenEnabled() {
+			hint = "Try 'vespa auth cert'"
+		}
+		return "", nil, errHint(fmt.Errorf("could not read data-plane certificate of %s: %w", app, err), hint)
+	}
+	if len(kp.KeyPair.Certificate) == 0 {
+		return "", nil, fmt.Errorf("no data-plane certificate found for %s", app)
+	}
+	cert, err := x509.ParseCertificate(kp.KeyPair.Certificate[0])
+	if err != nil {
+		return "", nil, fmt.Errorf("could not parse data-plane certificate of %s: %w", app, err)
+	}
+	source := kp.CertificateFile
+	if source == "" {
+		source = "of " + app.String()
+	}
+	return source, cert, nil
+}
+
+func printCertificate(cert *x509.Certificate) {
+	var names []string
+	names = append(names, cert.DNSNames...)
+	for _, ip := range cert.IPAddresses {
+		names = append(names, ip.String())
+	}
+	names = append(names, cert.EmailAddresses...)
+	for _, u := range cert.URIs {
+		names = append(names, u.String())
+	}
+	sans := "<none>"
+	if len(names) > 0 {
+		sans = strings.Join(names, ", ")
+	}
+	fmt.Fprintf(stdout, "Subject:     %s\n", cert.Subject)
+	fmt.Fprintf(stdout, "Issuer:      %s\n", cert.Issuer)
+	fmt.Fprintf(stdout, "Serial:      %s\n", cert.SerialNumber.Text(16))
+	fmt.Fprintf(stdout, "Not before:  %s\n", cert.NotBefore.UTC().Format(time.RFC3339))
+	fmt.Fprintf(stdout, "Not after:   %s\n", cert.NotAfter.UTC().Format(time.RFC3339))
+	fmt.Fprintf(stdout, "SANs:        %s\n", sans)
+}
+
 func doCert(_ *cobra.Command, args []string) error {
 	app, err := getApplication()
 	if err != nil {
 		return err
 	}
-	pkg, err := vespa.FindApplicationPackage(applicationSource(args), false)
+	pkg, err := findApplicationPackage(args, false)
 	if err != nil {
 		return err
 	}
+	defer pkg.Close()
 	cfg, err := LoadConfig()
 	if err != nil {
 		return err
@@ -118,7 +235,7 @@ func doCert(_ *cobra.Command, args []string) error {
 		} else {
 			hint = "Try running 'mvn clean' before 'vespa cert', and then 'mvn package'"
 		}
-		return errHint(fmt.Errorf("cannot add certificate to compressed application package %s", pkg.Path), hint)
+		return errHint(fmt.Errorf("cannot add certificate to compressed application package %s", pkg.Name()), hint)
 	}
 
 	keyPair, err := vespa.CreateKeyPair()
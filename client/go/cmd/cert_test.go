@@ -4,10 +4,21 @@
 package cmd
 
 import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
 	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net"
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/vespa-engine/vespa/client/go/vespa"
@@ -52,6 +63,43 @@ func TestCertCompressedPackage(t *testing.T) {
 	assert.Contains(t, out, "Success: Private key written to")
 }
 
+func TestCertInfo(t *testing.T) {
+	defer func() { certFileArg = "" }()
+	privateKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.Nil(t, err)
+	notBefore := time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC)
+	template := x509.Certificate{
+		SerialNumber: big.NewInt(0xcafe),
+		Subject:      pkix.Name{CommonName: "client.example.com"},
+		NotBefore:    notBefore,
+		NotAfter:     time.Now().Add(10 * 24 * time.Hour),
+		DNSNames:     []string{"a.example.com", "b.example.com"},
+		IPAddresses:  []net.IP{net.ParseIP("10.0.0.1")},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, &template, &template, &privateKey.PublicKey, privateKey)
+	assert.Nil(t, err)
+	certFile := filepath.Join(t.TempDir(), "cert.pem")
+	assert.Nil(t, ioutil.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600))
+
+	out, outErr := execute(command{args: []string{"cert", "info", "--file", certFile}}, t, nil)
+	assert.Equal(t, "Subject:     CN=client.example.com\n"+
+		"Issuer:      CN=client.example.com\n"+
+		"Serial:      cafe\n"+
+		"Not before:  2022-01-01T00:00:00Z\n"+
+		"Not after:   "+template.NotAfter.UTC().Format(time.RFC3339)+"\n"+
+		"SANs:        a.example.com, b.example.com, 10.0.0.1\n", out)
+	assert.True(t, strings.HasPrefix(outErr, "Warning: Certificate "+certFile+" expires in 9 days, at "), outErr)
+
+	// Defaults to the data-plane certificate of the application
+	homeDir := filepath.Join(t.TempDir(), ".vespa")
+	execute(command{args: []string{"cert", "-a", "t1.a1.i1", mockApplicationPackage(t, false)}, homeDir: homeDir}, t, nil)
+	certFileArg = ""
+	out, outErr = execute(command{args: []string{"cert", "info", "-a", "t1.a1.i1"}, homeDir: homeDir}, t, nil)
+	assert.Equal(t, "", outErr)
+	assert.Contains(t, out, "Subject:     CN=cloud.vespa.example\n")
+	assert.Contains(t, out, "SANs:        <none>\n")
+}
+
 func mockApplicationPackage(t *testing.T, java bool) string {
 	dir := t.TempDir()
 	appDir := filepath.Join(dir, "src", "main", "application")
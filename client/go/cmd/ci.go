@@ -0,0 +1,83 @@
+// Copyright Yahoo. Licensed under the terms of the Apache 2.0 license. See LICENSE in the project root.
+// Formatting of messages for continuous integration systems.
+
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"strings"
+)
+
+const (
+	ciNone     = "none"
+	ciGitHub   = "github"
+	ciTeamCity = "teamcity"
+)
+
+var (
+	// ciMode is the CI system whose annotation format is used for errors and other key messages. Messages are
+	// formatted normally if this is empty.
+	ciMode string
+
+	// detectCI returns the CI system this is running in, if any. This is a variable so that it can be overridden in
+	// tests.
+	detectCI = detectCIFromEnv
+)
+
+func detectCIFromEnv() string {
+	if os.Getenv("GITHUB_ACTIONS") == "true" {
+		return ciGitHub
+	}
+	if os.Getenv("TEAMCITY_VERSION") != "" {
+		return ciTeamCity
+	}
+	return ""
+}
+
+func configureCI() error {
+	switch ciArg {
+	case "":
+		ciMode = detectCI()
+	case ciNone:
+		ciMode = ""
+	case ciGitHub, ciTeamCity:
+		ciMode = ciArg
+	default:
+		return errHint(fmt.Errorf("invalid value for %s option", ciFlag), "Must be \"github\", \"teamcity\" or \"none\"")
+	}
+	return nil
+}
+
+// ciError formats msg as an error annotation for the current CI system. The second return value is false if there is
+// no current CI system.
+func ciError(msg string) (string, bool) {
+	switch ciMode {
+	case ciGitHub:
+		return "::error::" + escapeGitHub(msg), true
+	case ciTeamCity:
+		return "##teamcity[buildProblem description='" + escapeTeamCity(msg) + "']", true
+	}
+	return "", false
+}
+
+// ciNotice formats msg as an informational annotation for the current CI system. The second return value is false if
+// there is no current CI system.
+func ciNotice(msg string) (string, bool) {
+	switch ciMode {
+	case ciGitHub:
+		return "::notice::" + escapeGitHub(msg), true
+	case ciTeamCity:
+		return "##teamcity[message text='" + escapeTeamCity(msg) + "' status='NORMAL']", true
+	}
+	return "", false
+}
+
+var (
+	gitHubEscaper   = strings.NewReplacer("%", "%25", "\r", "%0D", "\n", "%0A")
+	teamCityEscaper = strings.NewReplacer("|", "||", "'", "|'", "\n", "|n", "\r", "|r", "[", "|[", "]", "|]")
+)
+
+func escapeGitHub(s string) string { return gitHubEscaper.Replace(s) }
+
+func escapeTeamCity(s string) string { return teamCityEscaper.Replace(s) }
@@ -0,0 +1,61 @@
+// Copyright Yahoo. Licensed under the terms of the Apache 2.0 license. See LICENSE in the project root.
+package cmd
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/vespa-engine/vespa/client/go/vespa"
+)
+
+func TestCIAnnotations(t *testing.T) {
+	assertCIError(t, []string{"--ci", "github"}, "::error::Container (query API) at http://127.0.0.1:8080 is not ready: status 500\n")
+	assertCIError(t, []string{"--ci", "teamcity"}, "##teamcity[buildProblem description='Container (query API) at http://127.0.0.1:8080 is not ready: status 500']\n")
+	assertCIError(t, []string{"--ci", "none"}, "Error: Container (query API) at http://127.0.0.1:8080 is not ready: status 500\n")
+
+	assertCISuccess(t, []string{"--ci", "github"}, "::notice::Success: Activated testdata/applications/withTarget/target/application.zip with session 42\n")
+	assertCISuccess(t, []string{"--ci", "teamcity"}, "##teamcity[message text='Success: Activated testdata/applications/withTarget/target/application.zip with session 42' status='NORMAL']\n")
+
+	_, outErr := execute(command{args: []string{"status", "--ci", "jenkins"}}, t, &mockHttpClient{})
+	assert.Contains(t, outErr, "invalid value for ci option")
+}
+
+func TestCIDetection(t *testing.T) {
+	for _, env := range []string{"GITHUB_ACTIONS", "TEAMCITY_VERSION"} {
+		if v, ok := os.LookupEnv(env); ok {
+			defer os.Setenv(env, v)
+		} else {
+			defer os.Unsetenv(env)
+		}
+		os.Unsetenv(env)
+	}
+	assert.Equal(t, "", detectCIFromEnv())
+	os.Setenv("TEAMCITY_VERSION", "2021.2")
+	assert.Equal(t, ciTeamCity, detectCIFromEnv())
+	os.Setenv("GITHUB_ACTIONS", "true")
+	assert.Equal(t, ciGitHub, detectCIFromEnv())
+}
+
+func TestCIEscaping(t *testing.T) {
+	assert.Equal(t, "50%25 done%0Anext line", escapeGitHub("50% done\nnext line"))
+	assert.Equal(t, "it||s |'quoted|' |[x|]|nnext", escapeTeamCity("it|s 'quoted' [x]\nnext"))
+}
+
+func assertCIError(t *testing.T, args []string, expected string) {
+	client := &mockHttpClient{}
+	client.NextStatus(500)
+	_, outErr := execute(command{args: append([]string{"status", "container"}, args...)}, t, client)
+	assert.Equal(t, expected, outErr)
+}
+
+func assertCISuccess(t *testing.T, args []string, expected string) {
+	homeDir := t.TempDir()
+	cfg := Config{Home: filepath.Join(homeDir, ".vespa"), createDirs: true}
+	if err := cfg.WriteSessionID(vespa.DefaultApplication, 42); err != nil {
+		t.Fatal(err)
+	}
+	out, _ := execute(command{args: append([]string{"activate", "testdata/applications/withTarget/target/application.zip"}, args...), homeDir: cfg.Home}, t, &mockHttpClient{})
+	assert.Equal(t, expected, out)
+}
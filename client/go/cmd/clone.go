@@ -139,7 +139,7 @@ func getSampleAppsZip() (*os.File, error) {
 		return f, nil
 	}
 	err = util.Spinner(color.Yellow("Downloading sample apps ...").String(), func() error {
-		request, err := http.NewRequest("GET", "https://github.com/vespa-engine/sample-apps/archive/refs/heads/master.zip", nil)
+		request, err := http.NewRequestWithContext(commandContext, "GET", "https://github.com/vespa-engine/sample-apps/archive/refs/heads/master.zip", nil)
 		if err != nil {
 			return fmt.Errorf("invalid url: %w", err)
 		}
@@ -37,7 +37,7 @@ func listSampleAppsAt(url string) ([]string, error) {
 }
 
 func getRepositoryFiles(url string) ([]repositoryFile, error) {
-	req, err := http.NewRequest("GET", url, nil)
+	req, err := http.NewRequestWithContext(commandContext, "GET", url, nil)
 	if err != nil {
 		return nil, err
 	}
@@ -7,12 +7,14 @@ package cmd
 import (
 	"bytes"
 	"crypto/tls"
+	"crypto/x509"
 	"io"
 	"io/ioutil"
 	"net/http"
 	"os"
 	"path/filepath"
 	"strconv"
+	"sync"
 	"testing"
 	"time"
 
@@ -39,9 +41,16 @@ func resetFlag(f *pflag.Flag) {
 			_ = v.Set(f.DefValue)
 		}
 	}
+	f.Changed = false
 }
 
 func execute(cmd command, t *testing.T, client *mockHttpClient) (string, string) {
+	out, errOut, _ := executeWithError(cmd, t, client)
+	return out, errOut
+}
+
+// executeWithError executes cmd and returns its output, error output and the error returned by the command, if any.
+func executeWithError(cmd command, t *testing.T, client *mockHttpClient) (string, string, error) {
 	if client != nil {
 		util.ActiveHttpClient = client
 	}
@@ -62,6 +71,21 @@ func execute(cmd command, t *testing.T, client *mockHttpClient) (string, string)
 	// their own sub-package
 	rootCmd.Flags().VisitAll(resetFlag)
 	documentCmd.Flags().VisitAll(resetFlag)
+	documentRemoveCmd.Flags().VisitAll(resetFlag)
+	deployCmd.Flags().VisitAll(resetFlag)
+	prodSubmitCmd.Flags().VisitAll(resetFlag)
+	queryCmd.Flags().VisitAll(resetFlag)
+	logCmd.Flags().VisitAll(resetFlag)
+	statusCmd.Flags().VisitAll(resetFlag)
+	statusCmd.PersistentFlags().VisitAll(resetFlag)
+	validateCmd.Flags().VisitAll(resetFlag)
+	reindexCmd.Flags().VisitAll(resetFlag)
+	feedCmd.Flags().VisitAll(resetFlag)
+	authStatusCmd.Flags().VisitAll(resetFlag)
+
+	// Do not detect CI system from the environment running tests
+	detectCI = func() string { return "" }
+	ciMode = ""
 
 	// Capture stdout and execute command
 	var capturedOut bytes.Buffer
@@ -76,8 +100,32 @@ func execute(cmd command, t *testing.T, client *mockHttpClient) (string, string)
 
 	// Execute command and return output
 	rootCmd.SetArgs(append(cmd.args, cmd.moreArgs...))
-	Execute()
-	return capturedOut.String(), capturedErr.String()
+	err := Execute()
+	return capturedOut.String(), capturedErr.String(), err
+}
+
+// setupCloudDeploy configures a cloud target for application t1.a1.i1, with an API key and a certificate stored in a
+// new home directory, which is returned. The working directory is changed to pkgDir for the duration of the test.
+func setupCloudDeploy(t *testing.T, client *mockHttpClient, pkgDir string) string {
+	homeDir := filepath.Join(t.TempDir(), ".vespa")
+	execute(command{homeDir: homeDir, args: []string{"config", "set", "application", "t1.a1.i1"}}, t, client)
+	execute(command{homeDir: homeDir, args: []string{"config", "set", "target", "cloud"}}, t, client)
+	execute(command{homeDir: homeDir, args: []string{"api-key"}}, t, client)
+	execute(command{homeDir: homeDir, args: []string{"cert", pkgDir}}, t, client)
+	client.requests = nil
+	cwd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(pkgDir); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		os.Chdir(cwd)
+		rootCmd.Flags().VisitAll(resetFlag)
+		deployCmd.PersistentFlags().VisitAll(resetFlag)
+	})
+	return homeDir
 }
 
 func executeCommand(t *testing.T, client *mockHttpClient, args []string, moreArgs []string) string {
@@ -89,16 +137,25 @@ type mockHttpClient struct {
 	// The responses to return for future requests. Once a response is consumed, it's removed from this array
 	nextResponses []mockResponse
 
+	// The responses to return for all requests to a given path, taking precedence over nextResponses
+	responsesByPath map[string]mockResponse
+
 	// A recording of the last HTTP request made through this
 	lastRequest *http.Request
 
 	// All requests made through this
 	requests []*http.Request
+
+	// The TLS connection state to include in responses, if any
+	tlsState *tls.ConnectionState
+
+	mu sync.Mutex
 }
 
 type mockResponse struct {
 	status int
 	body   string
+	err    error
 }
 
 func (c *mockHttpClient) NextStatus(status int) { c.NextResponse(status, "") }
@@ -107,21 +164,45 @@ func (c *mockHttpClient) NextResponse(status int, body string) {
 	c.nextResponses = append(c.nextResponses, mockResponse{status: status, body: body})
 }
 
+func (c *mockHttpClient) NextError(err error) {
+	c.nextResponses = append(c.nextResponses, mockResponse{err: err})
+}
+
+// PathResponse sets the response to return for all requests to path.
+func (c *mockHttpClient) PathResponse(path string, status int, body string) {
+	if c.responsesByPath == nil {
+		c.responsesByPath = make(map[string]mockResponse)
+	}
+	c.responsesByPath[path] = mockResponse{status: status, body: body}
+}
+
 func (c *mockHttpClient) Do(request *http.Request, timeout time.Duration) (*http.Response, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
 	response := mockResponse{status: 200}
-	if len(c.nextResponses) > 0 {
+	if r, ok := c.responsesByPath[request.URL.Path]; ok {
+		response = r
+	} else if len(c.nextResponses) > 0 {
 		response = c.nextResponses[0]
 		c.nextResponses = c.nextResponses[1:]
 	}
 	c.lastRequest = request
 	c.requests = append(c.requests, request)
+	if response.err != nil {
+		return nil, response.err
+	}
 	return &http.Response{
 			Status:     "Status " + strconv.Itoa(response.status),
 			StatusCode: response.status,
 			Body:       ioutil.NopCloser(bytes.NewBufferString(response.body)),
 			Header:     make(http.Header),
+			TLS:        c.tlsState,
 		},
 		nil
 }
 
 func (c *mockHttpClient) UseCertificate(certificates []tls.Certificate) {}
+
+func (c *mockHttpClient) UseRootCAs(pool *x509.CertPool) {}
+
+func (c *mockHttpClient) UseTransportOptions(options util.TransportOptions) {}
@@ -11,6 +11,7 @@ import (
 	"log"
 	"os"
 	"path/filepath"
+	"regexp"
 	"sort"
 	"strconv"
 	"strings"
@@ -19,19 +20,55 @@ import (
 	"github.com/spf13/viper"
 	"github.com/vespa-engine/vespa/client/go/util"
 	"github.com/vespa-engine/vespa/client/go/vespa"
+	"github.com/vespa-engine/vespa/client/go/vespa/xml"
 )
 
 const (
 	configName = "config"
 	configType = "yaml"
+
+	// healthPathOption is the prefix of options overriding the health check path of a service, e.g. health-path.query
+	healthPathOption = "health-path"
+
+	// authConfigOption is the prefix of options overriding the OAuth config used for logging in, e.g. auth.client-id
+	authConfigOption = "auth"
+
+	// controlPlaneOption is the base URL of a control plane serving a discovery document
+	controlPlaneOption = "control-plane"
+
+	// selfHostedOption is the prefix of options naming the application managed on a self-hosted Vespa, e.g.
+	// self-hosted.tenant
+	selfHostedOption = "self-hosted"
+
+	// deploymentTemplateOption is the path to the deployment.xml used by 'vespa prod init' for packages having none
+	deploymentTemplateOption = "prod.deployment-template"
+
+	// caCertDirOption is the directory holding the CA certificates used to verify servers, instead of those of the
+	// system
+	caCertDirOption = "ca-cert-dir"
+
+	// syncClockOption is whether the timestamp of signed Vespa Cloud API requests is adjusted by the clock offset from
+	// the API server
+	syncClockOption = "sync-clock"
+
+	// defaultProfile is the profile using the config stored directly in the Vespa CLI home directory
+	defaultProfile = "default"
+
+	// activeProfileFile is the file in the Vespa CLI home directory holding the name of the active profile
+	activeProfileFile = "active-profile"
 )
 
+var profileNamePattern = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9_-]*$`)
+
+var selfHostedNamePattern = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9_-]*$`)
+
 var flagToConfigBindings map[string]*cobra.Command = make(map[string]*cobra.Command)
 
 func init() {
 	rootCmd.AddCommand(configCmd)
 	configCmd.AddCommand(setConfigCmd)
 	configCmd.AddCommand(getConfigCmd)
+	configCmd.AddCommand(useProfileConfigCmd)
 }
 
 var configCmd = &cobra.Command{
@@ -44,7 +81,46 @@ invocations the flag can then be omitted as it is read from the config file
 instead.
 
 Configuration is written to $HOME/.vespa by default. This path can be
-overridden by setting the VESPA_CLI_HOME environment variable.`,
+overridden by setting the VESPA_CLI_HOME environment variable, or for a single
+command with the --config-dir flag. The same directory holds API keys,
+certificates, credentials and deployment session state.
+
+The health check path used when waiting for a service can be overridden per
+service with the options health-path.deploy, health-path.query and
+health-path.document.
+
+The API URL, console URL and system of Vespa Cloud can be read from the
+discovery document of a custom control plane, by setting the control-plane
+option to the base URL of the control plane.
+
+The tenant, application and instance managed by the local and custom targets
+are named "default" by default. These can be overridden with the options
+self-hosted.tenant, self-hosted.application and self-hosted.instance.
+
+The deployment.xml which 'vespa prod init' starts from, when an application
+package has none, can be set to a template file with the
+prod.deployment-template option.
+
+Server certificates are verified using the CA certificates of the system, or
+the .pem and .crt files in the directory given by the ca-cert-dir option. The
+environment variable VESPA_CLI_CA_CERT_DIR overrides this option.
+
+Requests to the Vespa Cloud API are signed with a timestamp. If the local clock
+is skewed, set the sync-clock option to true to adjust this timestamp by the
+clock offset from the API server.
+
+The OAuth config used by 'vespa auth login' is read from the system by default.
+It can be overridden with the options auth.audience, auth.client-id,
+auth.device-code-endpoint and auth.oauth-token-endpoint, or read from a JSON
+file with these keys, given by the auth.config-file option. Options set
+individually take precedence over the file.
+
+Named profiles hold separate sets of configuration, including credentials. This
+allows switching between e.g. a personal development environment and a shared
+staging environment. Each profile is stored in $HOME/.vespa/profiles/<name>,
+while the default profile is stored in $HOME/.vespa. The active profile is
+selected with use-profile, and can be overridden for a single command with the
+--profile flag.`,
 	DisableAutoGenTag: true,
 	SilenceUsage:      false,
 	Args:              cobra.MinimumNArgs(1),
@@ -54,9 +130,16 @@ overridden by setting the VESPA_CLI_HOME environment variable.`,
 }
 
 var setConfigCmd = &cobra.Command{
-	Use:               "set option-name value",
-	Short:             "Set a configuration option.",
-	Example:           "$ vespa config set target cloud",
+	Use:   "set option-name value",
+	Short: "Set a configuration option.",
+	Example: `$ vespa config set target cloud
+$ vespa config set health-path.query /healthz
+$ vespa config set control-plane https://cp.example.com
+$ vespa config set self-hosted.application myapp
+$ vespa config set auth.config-file /etc/vespa/auth.json
+$ vespa config set prod.deployment-template /etc/vespa/deployment.xml
+$ vespa config set ca-cert-dir /etc/vespa/ca
+$ vespa config set sync-clock true`,
 	DisableAutoGenTag: true,
 	SilenceUsage:      true,
 	Args:              cobra.ExactArgs(2),
@@ -101,9 +184,41 @@ $ vespa config get target`,
 	},
 }
 
+var useProfileConfigCmd = &cobra.Command{
+	Use:   "use-profile profile-name",
+	Short: "Switch to the given configuration profile, creating it if necessary",
+	Example: `$ vespa config use-profile staging
+$ vespa config use-profile default`,
+	DisableAutoGenTag: true,
+	SilenceUsage:      true,
+	Args:              cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		home, err := vespaCliHome()
+		if err != nil {
+			return err
+		}
+		profile := args[0]
+		if !profileNamePattern.MatchString(profile) {
+			return fmt.Errorf("invalid profile name: %q", profile)
+		}
+		if err := os.MkdirAll(profileHome(home, profile), 0700); err != nil {
+			return err
+		}
+		if err := ioutil.WriteFile(filepath.Join(home, activeProfileFile), []byte(profile+"\n"), 0600); err != nil {
+			return err
+		}
+		printSuccess("Using profile ", profile)
+		return nil
+	},
+}
+
 type Config struct {
 	Home       string
+	Profile    string
 	createDirs bool
+
+	// values holds the options set through Set, which are persisted by Write
+	values map[string]string
 }
 
 type KeyPair struct {
@@ -117,13 +232,53 @@ func LoadConfig() (*Config, error) {
 	if err != nil {
 		return nil, fmt.Errorf("could not detect config directory: %w", err)
 	}
-	c := &Config{Home: home, createDirs: true}
+	profile, err := activeProfile(home)
+	if err != nil {
+		return nil, err
+	}
+	c := &Config{Home: profileHome(home, profile), Profile: profile, createDirs: true}
 	if err := c.load(); err != nil {
 		return nil, fmt.Errorf("could not load config: %w", err)
 	}
 	return c, nil
 }
 
+// activeProfile returns the profile given by the --profile flag, or the profile selected with use-profile. Profile names
+// must match profileNamePattern, so that the config of a profile is always inside home.
+func activeProfile(home string) (string, error) {
+	if profileArg != "" {
+		if !profileNamePattern.MatchString(profileArg) {
+			return "", fmt.Errorf("invalid profile name: %q", profileArg)
+		}
+		if profileArg != defaultProfile && !util.PathExists(profileHome(home, profileArg)) {
+			return "", errHint(fmt.Errorf("no such profile: %q", profileArg), "Create it with 'vespa config use-profile "+profileArg+"'")
+		}
+		return profileArg, nil
+	}
+	b, err := ioutil.ReadFile(filepath.Join(home, activeProfileFile))
+	if os.IsNotExist(err) {
+		return defaultProfile, nil
+	} else if err != nil {
+		return "", err
+	}
+	profile := strings.TrimSpace(string(b))
+	if profile == "" {
+		return defaultProfile, nil
+	}
+	if !profileNamePattern.MatchString(profile) {
+		return "", errHint(fmt.Errorf("invalid profile name in %s: %q", filepath.Join(home, activeProfileFile), profile), "Remove the file to use the default profile")
+	}
+	return profile, nil
+}
+
+// profileHome returns the directory holding the config of given profile.
+func profileHome(home, profile string) string {
+	if profile == defaultProfile {
+		return home
+	}
+	return filepath.Join(home, "profiles", profile)
+}
+
 func (c *Config) Write() error {
 	if err := os.MkdirAll(c.Home, 0700); err != nil {
 		return err
@@ -134,7 +289,18 @@ func (c *Config) Write() error {
 			return err
 		}
 	}
-	return viper.WriteConfig()
+	// Write only the options read from the config file and the options set explicitly. Options given as flags only
+	// override the config for a single invocation, and must not be persisted
+	v := viper.New()
+	v.SetConfigFile(configFile)
+	v.SetConfigType(configType)
+	if err := v.ReadInConfig(); err != nil {
+		return err
+	}
+	for option, value := range c.values {
+		v.Set(option, value)
+	}
+	return v.WriteConfig()
 }
 
 func (c *Config) CertificatePath(app vespa.ApplicationID) (string, error) {
@@ -151,7 +317,23 @@ func (c *Config) PrivateKeyPath(app vespa.ApplicationID) (string, error) {
 	return c.applicationFilePath(app, "data-plane-private-key.pem")
 }
 
+// CACertificateDir returns the directory holding the CA certificates used to verify servers, as given by
+// VESPA_CLI_CA_CERT_DIR or the ca-cert-dir option. This is empty if servers are verified with the CA certificates of the
+// system.
+func (c *Config) CACertificateDir() string {
+	if override, ok := os.LookupEnv("VESPA_CLI_CA_CERT_DIR"); ok {
+		return override
+	}
+	dir, _ := c.Get(caCertDirOption)
+	return dir
+}
+
 func (c *Config) X509KeyPair(app vespa.ApplicationID) (KeyPair, error) {
+	if pkcs12File, ok := os.LookupEnv("VESPA_CLI_DATA_PLANE_PKCS12_FILE"); ok {
+		// Use key pair from PKCS#12 file
+		kp, err := vespa.LoadPKCS12(pkcs12File, os.Getenv("VESPA_CLI_DATA_PLANE_PKCS12_PASSWORD"))
+		return KeyPair{KeyPair: kp}, err
+	}
 	cert, certOk := os.LookupEnv("VESPA_CLI_DATA_PLANE_CERT")
 	key, keyOk := os.LookupEnv("VESPA_CLI_DATA_PLANE_KEY")
 	if certOk && keyOk {
@@ -216,6 +398,34 @@ func (c *Config) WriteSessionID(app vespa.ApplicationID, sessionID int64) error
 	return ioutil.WriteFile(sessionPath, []byte(fmt.Sprintf("%d\n", sessionID)), 0600)
 }
 
+func (c *Config) ReadPackageHash(deployment vespa.Deployment) (string, error) {
+	hashPath, err := c.packageHashPath(deployment)
+	if err != nil {
+		return "", err
+	}
+	b, err := ioutil.ReadFile(hashPath)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(b)), nil
+}
+
+func (c *Config) WritePackageHash(deployment vespa.Deployment, hash string) error {
+	hashPath, err := c.packageHashPath(deployment)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(hashPath, []byte(hash+"\n"), 0600)
+}
+
+func (c *Config) packageHashPath(deployment vespa.Deployment) (string, error) {
+	if deployment.Application == (vespa.ApplicationID{}) {
+		return c.applicationFilePath(vespa.DefaultApplication, "package_hash")
+	}
+	name := fmt.Sprintf("package_hash.%s.%s", deployment.Zone.Environment, deployment.Zone.Region)
+	return c.applicationFilePath(deployment.Application, name)
+}
+
 func (c *Config) applicationFilePath(app vespa.ApplicationID, name string) (string, error) {
 	appDir := filepath.Join(c.Home, app.String())
 	if c.createDirs {
@@ -227,18 +437,19 @@ func (c *Config) applicationFilePath(app vespa.ApplicationID, name string) (stri
 }
 
 func (c *Config) load() error {
-	viper.SetConfigName(configName)
+	// Set the config file explicitly, as config paths added to viper accumulate when loading config of different
+	// profiles
+	configFile := filepath.Join(c.Home, configName+"."+configType)
+	viper.SetConfigFile(configFile)
 	viper.SetConfigType(configType)
-	viper.AddConfigPath(c.Home)
 	viper.AutomaticEnv()
 	for option, command := range flagToConfigBindings {
 		viper.BindPFlag(option, command.PersistentFlags().Lookup(option))
 	}
-	err := viper.ReadInConfig()
-	if _, ok := err.(viper.ConfigFileNotFoundError); ok {
+	if !util.PathExists(configFile) {
 		return nil
 	}
-	return err
+	return viper.ReadInConfig()
 }
 
 func (c *Config) Get(option string) (string, error) {
@@ -254,41 +465,100 @@ func (c *Config) Set(option, value string) error {
 	case targetFlag:
 		switch value {
 		case "local", "cloud":
-			viper.Set(option, value)
+			c.set(option, value)
 			return nil
 		}
 		if strings.HasPrefix(value, "http://") || strings.HasPrefix(value, "https://") {
-			viper.Set(option, value)
+			c.set(option, value)
 			return nil
 		}
 	case applicationFlag:
-		if _, err := vespa.ApplicationFromString(value); err != nil {
+		application, err := vespa.ApplicationFromString(value)
+		if err != nil {
+			return err
+		}
+		if err := xml.ValidateInstanceName(application.Instance); err != nil {
 			return err
 		}
-		viper.Set(option, value)
+		c.set(option, value)
 		return nil
 	case waitFlag:
 		if _, err := strconv.ParseUint(value, 10, 32); err != nil {
 			return fmt.Errorf("%s option must be an integer >= 0, got %q", option, value)
 		}
-		viper.Set(option, value)
+		c.set(option, value)
 		return nil
 	case colorFlag:
 		switch value {
 		case "auto", "never", "always":
-			viper.Set(option, value)
+			c.set(option, value)
 			return nil
 		}
 	case cloudAuthFlag:
 		switch value {
-		case "access-token", "api-key":
-			viper.Set(option, value)
+		case "access-token", "api-key", "cert":
+			c.set(option, value)
+			return nil
+		}
+	case controlPlaneOption:
+		if !strings.HasPrefix(value, "http://") && !strings.HasPrefix(value, "https://") {
+			return fmt.Errorf("%s option must be an URL, got %q", option, value)
+		}
+		c.set(option, value)
+		return nil
+	case authConfigOption + ".audience", authConfigOption + ".client-id", authConfigOption + ".config-file":
+		if value != "" {
+			c.set(option, value)
 			return nil
 		}
+	case authConfigOption + ".device-code-endpoint", authConfigOption + ".oauth-token-endpoint":
+		if !strings.HasPrefix(value, "http://") && !strings.HasPrefix(value, "https://") {
+			return fmt.Errorf("%s option must be an URL, got %q", option, value)
+		}
+		c.set(option, value)
+		return nil
+	case selfHostedOption + ".tenant", selfHostedOption + ".application", selfHostedOption + ".instance":
+		if !selfHostedNamePattern.MatchString(value) {
+			return fmt.Errorf("%s option must start with a letter or digit, followed by letters, digits, '_' or '-', got %q", option, value)
+		}
+		c.set(option, value)
+		return nil
+	case deploymentTemplateOption:
+		if _, err := readDeploymentTemplate(value); err != nil {
+			return err
+		}
+		c.set(option, value)
+		return nil
+	case caCertDirOption:
+		if !util.IsDirectory(value) {
+			return fmt.Errorf("%s option must be a directory, got %q", option, value)
+		}
+		c.set(option, value)
+		return nil
+	case syncClockOption:
+		if _, err := strconv.ParseBool(value); err != nil {
+			return fmt.Errorf("%s option must be true or false, got %q", option, value)
+		}
+		c.set(option, value)
+		return nil
+	case healthPathOption + ".deploy", healthPathOption + ".query", healthPathOption + ".document":
+		if !strings.HasPrefix(value, "/") {
+			return fmt.Errorf("%s option must start with '/', got %q", option, value)
+		}
+		c.set(option, value)
+		return nil
 	}
 	return fmt.Errorf("invalid option or value: %q: %q", option, value)
 }
 
+func (c *Config) set(option, value string) {
+	if c.values == nil {
+		c.values = make(map[string]string)
+	}
+	c.values[option] = value
+	viper.Set(option, value)
+}
+
 func printOption(cfg *Config, option string) {
 	value, err := cfg.Get(option)
 	if err != nil {
@@ -2,10 +2,14 @@
 package cmd
 
 import (
+	"os"
 	"path/filepath"
 	"testing"
 
+	"github.com/spf13/viper"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/vespa-engine/vespa/client/go/util"
 )
 
 func TestConfig(t *testing.T) {
@@ -20,6 +24,7 @@ func TestConfig(t *testing.T) {
 	assertConfigCommand(t, "target = https://127.0.0.1\n", homeDir, "config", "get", "target")
 
 	assertConfigCommandErr(t, "Error: invalid application: \"foo\"\n", homeDir, "config", "set", "application", "foo")
+	assertConfigCommandErr(t, "Error: invalid instance name \"My-instance\": must be at most 63 lowercase letters, digits or hyphens, starting with a letter and not ending with a hyphen\n", homeDir, "config", "set", "application", "t1.a1.My-instance")
 	assertConfigCommand(t, "application = <unset>\n", homeDir, "config", "get", "application")
 	assertConfigCommand(t, "", homeDir, "config", "set", "application", "t1.a1.i1")
 	assertConfigCommand(t, "application = t1.a1.i1\n", homeDir, "config", "get", "application")
@@ -29,6 +34,119 @@ func TestConfig(t *testing.T) {
 	assertConfigCommand(t, "", homeDir, "config", "set", "wait", "60")
 	assertConfigCommandErr(t, "Error: wait option must be an integer >= 0, got \"foo\"\n", homeDir, "config", "set", "wait", "foo")
 	assertConfigCommand(t, "wait = 60\n", homeDir, "config", "get", "wait")
+
+	assertConfigCommand(t, "", homeDir, "config", "set", "health-path.query", "/healthz")
+	assertConfigCommandErr(t, "Error: health-path.document option must start with '/', got \"healthz\"\n", homeDir, "config", "set", "health-path.document", "healthz")
+	assertConfigCommand(t, "health-path.query = /healthz\n", homeDir, "config", "get", "health-path.query")
+
+	caDir := t.TempDir()
+	assertConfigCommand(t, "", homeDir, "config", "set", "ca-cert-dir", caDir)
+	assertConfigCommandErr(t, "Error: ca-cert-dir option must be a directory, got \"/nonexistent\"\n", homeDir, "config", "set", "ca-cert-dir", "/nonexistent")
+	assertConfigCommand(t, "ca-cert-dir = "+caDir+"\n", homeDir, "config", "get", "ca-cert-dir")
+
+	assertConfigCommand(t, "", homeDir, "config", "set", "sync-clock", "true")
+	assertConfigCommandErr(t, "Error: sync-clock option must be true or false, got \"sometimes\"\n", homeDir, "config", "set", "sync-clock", "sometimes")
+	assertConfigCommand(t, "sync-clock = true\n", homeDir, "config", "get", "sync-clock")
+}
+
+func TestConfigCRLFOutput(t *testing.T) {
+	out, _ := execute(command{args: []string{"config", "get", "target", "--crlf"}}, t, nil)
+	assert.Equal(t, "target = local\r\n", out)
+	out, _ = execute(command{args: []string{"config", "get", "target", "--crlf", "--bom"}}, t, nil)
+	assert.Equal(t, "\xef\xbb\xbftarget = local\r\n", out)
+	out, _ = execute(command{args: []string{"config", "get", "target", "--crlf=false"}}, t, nil)
+	assert.Equal(t, "target = local\n", out)
+	_, outErr := execute(command{args: []string{"config", "set", "foo", "bar", "--crlf"}}, t, nil)
+	assert.Equal(t, "Error: invalid option or value: \"foo\": \"bar\"\r\n", outErr)
+}
+
+func TestConfigProfiles(t *testing.T) {
+	homeDir := filepath.Join(t.TempDir(), ".vespa")
+	defer viper.Reset()
+	// Each command runs with fresh viper state, as it would in a separate invocation
+	run := func(args ...string) (string, string) {
+		viper.Reset()
+		return execute(command{homeDir: homeDir, args: args}, t, nil)
+	}
+	run("config", "set", "target", "cloud")
+
+	// Create and switch to a new profile
+	out, _ := run("config", "use-profile", "staging")
+	assert.Equal(t, "Success: Using profile staging\n", out)
+	assert.True(t, util.PathExists(filepath.Join(homeDir, "profiles", "staging")))
+	out, _ = run("config", "get", "target")
+	assert.Equal(t, "target = local\n", out)
+	run("config", "set", "target", "http://staging.example.com:8080")
+	out, _ = run("config", "get", "target")
+	assert.Equal(t, "target = http://staging.example.com:8080\n", out)
+	assert.True(t, util.PathExists(filepath.Join(homeDir, "profiles", "staging", "config.yaml")))
+
+	// Override active profile for a single command
+	out, _ = run("config", "get", "target", "--profile", "default")
+	assert.Equal(t, "target = cloud\n", out)
+	_, errOut := run("config", "get", "target", "--profile", "nope")
+	assert.Equal(t, "Error: no such profile: \"nope\"\nHint: Create it with 'vespa config use-profile nope'\n", errOut)
+
+	// Switch back to the default profile
+	run("config", "use-profile", "default")
+	out, _ = run("config", "get", "target")
+	assert.Equal(t, "target = cloud\n", out)
+	out, _ = run("config", "get", "target", "--profile", "staging")
+	assert.Equal(t, "target = http://staging.example.com:8080\n", out)
+
+	_, errOut = run("config", "use-profile", "../foo")
+	assert.Equal(t, "Error: invalid profile name: \"../foo\"\n", errOut)
+	_, errOut = run("config", "get", "target", "--profile", "../..")
+	assert.Equal(t, "Error: invalid profile name: \"../..\"\n", errOut)
+
+	// Reject an invalid name in the active profile file
+	activeFile := filepath.Join(homeDir, "active-profile")
+	require.Nil(t, os.WriteFile(activeFile, []byte("../..\n"), 0600))
+	_, errOut = run("config", "get", "target")
+	assert.Equal(t, "Error: invalid profile name in "+activeFile+": \"../..\"\nHint: Remove the file to use the default profile\n", errOut)
+	require.Nil(t, os.Remove(activeFile))
+	out, _ = run("config", "get", "target")
+	assert.Equal(t, "target = cloud\n", out)
+}
+
+func TestConfigDir(t *testing.T) {
+	homeDir := filepath.Join(t.TempDir(), ".vespa")
+	configDir := filepath.Join(t.TempDir(), "vespa-cli")
+	defer viper.Reset()
+	run := func(client *mockHttpClient, args ...string) (string, string) {
+		viper.Reset()
+		return execute(command{homeDir: homeDir, args: append(args, "--config-dir", configDir)}, t, client)
+	}
+
+	_, errOut := run(nil, "config", "set", "application", "t1.a1.i1")
+	assert.Equal(t, "", errOut)
+	_, errOut = run(nil, "api-key")
+	assert.Equal(t, "", errOut)
+	_, errOut = run(nil, "cert", mockApplicationPackage(t, false))
+	assert.Equal(t, "", errOut)
+	client := &mockHttpClient{}
+	client.NextResponse(200, `{"session-id":"42"}`)
+	client.NextResponse(200, `{}`)
+	_, errOut = run(client, "prepare", "testdata/applications/withTarget/target/application.zip")
+	assert.Equal(t, "", errOut)
+
+	for _, path := range []string{
+		"config.yaml",
+		"t1.api-key.pem",
+		filepath.Join("t1.a1.i1", "data-plane-public-cert.pem"),
+		filepath.Join("t1.a1.i1", "data-plane-private-key.pem"),
+		filepath.Join("default.application.default", "session_id"),
+	} {
+		assert.True(t, util.PathExists(filepath.Join(configDir, path)), path)
+	}
+	assert.False(t, util.PathExists(homeDir))
+
+	// Config is read from the same directory
+	out, _ := run(nil, "config", "get", "application")
+	assert.Equal(t, "application = t1.a1.i1\n", out)
+	viper.Reset()
+	out, _ = execute(command{homeDir: homeDir, args: []string{"config", "get", "application"}}, t, nil)
+	assert.Equal(t, "application = <unset>\n", out)
 }
 
 func assertConfigCommand(t *testing.T, expected, homeDir string, args ...string) {
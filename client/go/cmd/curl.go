@@ -11,7 +11,6 @@ import (
 	"github.com/spf13/cobra"
 	"github.com/vespa-engine/vespa/client/go/auth0"
 	"github.com/vespa-engine/vespa/client/go/curl"
-	"github.com/vespa-engine/vespa/client/go/vespa"
 )
 
 var curlDryRun bool
@@ -64,8 +63,16 @@ $ vespa curl -- -v --data-urlencode "yql=select * from music where album contain
 				return err
 			}
 			if t.Type() == "cloud" {
-				if !vespa.Auth0AccessTokenEnabled() {
-					return errors.New("accessing control plane using curl subcommand is only supported for Auth0 device flow")
+				apiKey, _ := cfg.ReadAPIKey(app.Tenant)
+				cloudAuth, err := getCloudAuth(cfg, apiKey)
+				if err != nil {
+					return err
+				}
+				if cloudAuth != "access-token" {
+					return errHint(errors.New("accessing control plane using curl subcommand is only supported with access token authentication"), "Try adding --auth access-token")
+				}
+				if err := checkAuthCredentials(cfg, cloudAuth, apiKey, app.Tenant); err != nil {
+					return err
 				}
 				if err := addCloudAuth0Authentication(cfg, c); err != nil {
 					return err
@@ -98,7 +105,7 @@ $ vespa curl -- -v --data-urlencode "yql=select * from music where album contain
 }
 
 func addCloudAuth0Authentication(cfg *Config, c *curl.Command) error {
-	a, err := auth0.GetAuth0(cfg.AuthConfigPath(), getSystemName(), getApiURL())
+	a, err := getAuth0(cfg)
 	if err != nil {
 		return err
 	}
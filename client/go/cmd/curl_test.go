@@ -12,14 +12,14 @@ import (
 func TestCurl(t *testing.T) {
 	homeDir := filepath.Join(t.TempDir(), ".vespa")
 	httpClient := &mockHttpClient{}
-	out, _ := execute(command{homeDir: homeDir, args: []string{"curl", "-n", "-a", "t1.a1.i1", "--", "-v", "--data-urlencode", "arg=with space", "/search"}}, t, httpClient)
+	out, _ := execute(command{homeDir: homeDir, args: []string{"curl", "-n", "-t", "https://127.0.0.1", "-a", "t1.a1.i1", "--", "-v", "--data-urlencode", "arg=with space", "/search"}}, t, httpClient)
 
 	expected := fmt.Sprintf("curl --key %s --cert %s -v --data-urlencode 'arg=with space' https://127.0.0.1:8080/search\n",
 		filepath.Join(homeDir, "t1.a1.i1", "data-plane-private-key.pem"),
 		filepath.Join(homeDir, "t1.a1.i1", "data-plane-public-cert.pem"))
 	assert.Equal(t, expected, out)
 
-	out, _ = execute(command{homeDir: homeDir, args: []string{"curl", "-s", "deploy", "-n", "/application/v4/tenant/foo"}}, t, httpClient)
+	out, _ = execute(command{homeDir: homeDir, args: []string{"curl", "-s", "deploy", "-n", "-t", "https://127.0.0.1", "-a", "t1.a1.i1", "/application/v4/tenant/foo"}}, t, httpClient)
 	expected = "curl https://127.0.0.1:19071/application/v4/tenant/foo\n"
 	assert.Equal(t, expected, out)
 }
@@ -5,21 +5,53 @@
 package cmd
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"log"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
 
 	"github.com/spf13/cobra"
+	"github.com/vespa-engine/vespa/client/go/util"
+	"github.com/vespa-engine/vespa/client/go/version"
 	"github.com/vespa-engine/vespa/client/go/vespa"
 )
 
 const (
-	zoneFlag     = "zone"
-	logLevelFlag = "log-level"
+	zoneFlag        = "zone"
+	logLevelFlag    = "log-level"
+	deployParamFlag = "deploy-param"
+
+	retryIntervalFlag = "retry-interval"
+	stableForFlag     = "stable-for"
 )
 
 var (
-	zoneArg     string
-	logLevelArg string
+	zoneArg            string
+	logLevelArg        string
+	deployRetriesArg   int
+	ifChangedArg       bool
+	listFilesArg       bool
+	receiptArg         string
+	sampleDocsArg      string
+	deployParamsArg    []string
+	maxDurationArg     string
+	allowDowngrade     bool
+	printEndpointsArg  bool
+	endpointsFormatArg string
+	tailArg            int
+	runLogFileArg      string
+	retryIntervalArg   string
+	stableForArg       string
+
+	// deployRetryInterval is the initial interval between deploy attempts. The interval doubles for each attempt.
+	deployRetryInterval = 2 * time.Second
 )
 
 func init() {
@@ -28,6 +60,21 @@ func init() {
 	rootCmd.AddCommand(activateCmd)
 	deployCmd.PersistentFlags().StringVarP(&zoneArg, zoneFlag, "z", "dev.aws-us-east-1c", "The zone to use for deployment")
 	deployCmd.PersistentFlags().StringVarP(&logLevelArg, logLevelFlag, "l", "error", `Log level for Vespa logs. Must be "error", "warning", "info" or "debug"`)
+	deployCmd.Flags().IntVarP(&deployRetriesArg, "retries", "r", 0, "Number of times to retry the deployment if it fails due to a transient error")
+	deployCmd.Flags().BoolVarP(&ifChangedArg, "if-changed", "", false, "Skip deployment if the application package is unchanged since the last successful deployment")
+	deployCmd.Flags().BoolVarP(&listFilesArg, "list-files", "", false, "List the files in the application package and exit without deploying")
+	deployCmd.Flags().StringVarP(&receiptArg, "receipt", "", "", "Write a JSON receipt of the deployment to this file")
+	deployCmd.Flags().StringArrayVarP(&deployParamsArg, deployParamFlag, "", nil, "Query parameter to add to the deploy request, on the form key=value. The value is URL-encoded. Can be repeated for different keys")
+	prepareCmd.Flags().StringArrayVarP(&deployParamsArg, deployParamFlag, "", nil, "Query parameter to add to the prepare request, on the form key=value. The value is URL-encoded. Can be repeated for different keys")
+	deployCmd.Flags().BoolVarP(&allowDowngrade, "force", "", false, "Deploy even if the application package version is older than the deployed one")
+	deployCmd.Flags().StringVarP(&maxDurationArg, "max-duration", "", "", "Maximum duration of the entire deployment, including waiting, e.g. 10m. The current phase is cancelled when exceeded")
+	deployCmd.Flags().BoolVarP(&printEndpointsArg, "print-endpoints", "", false, "Print the endpoint of each container cluster once the query service is ready. Requires --wait")
+	deployCmd.Flags().StringVarP(&endpointsFormatArg, "format", "", "text", `The format of the endpoints printed with --print-endpoints. Must be "text" or "json"`)
+	deployCmd.Flags().IntVarP(&tailArg, "tail", "", 0, "Keep only the last lines of the deployment run log visible while waiting, when output is a terminal. 0 shows all lines")
+	deployCmd.Flags().StringVarP(&runLogFileArg, "log-file", "", "", "Write the full deployment run log to this file while waiting")
+	deployCmd.Flags().StringVarP(&retryIntervalArg, retryIntervalFlag, "", "", "Interval between requests when waiting for the deployment, e.g. 500ms. Defaults to 2s")
+	deployCmd.Flags().StringVarP(&stableForArg, stableForFlag, "", "", "How long services on a target given by URL must report convergence continuously before they are considered ready, e.g. 10s")
+	deployCmd.Flags().StringVarP(&sampleDocsArg, "sample-docs", "", "", "Validate the documents in this JSONL file against the schemas of the application package before deploying")
 }
 
 var deployCmd = &cobra.Command{
@@ -40,56 +87,414 @@ and activated on config servers. The process of applying it on individual nodes
 has started but may not have completed.
 
 If application directory is not specified, it defaults to working directory.
+If it holds no application package, the directories up to three levels below
+it are searched for one, and then the directories above it. Use --package-root
+to give the directory containing services.xml directly, e.g. when several
+application packages are found.
 
 When deploying to Vespa Cloud the system can be overridden by setting the
 environment variable VESPA_CLI_CLOUD_SYSTEM. This is intended for internal use
-only.`,
+only.
+
+With --if-changed, the deployment is skipped if the application package is
+identical to the one last deployed successfully to the same zone.
+
+With --list-files, the files which would be uploaded are printed with their
+uncompressed sizes, and nothing is deployed.
+
+With --receipt, a JSON receipt of the deployment is written to the given file,
+also if deployment fails. It records the time, user, target, application, the
+SHA-256 hash of the application package, and the run ID and outcome of each
+deployment.
+
+When deploying to Vespa Cloud, multiple zones in the dev or perf environments
+can be given as a comma-separated list. The application package is then
+deployed to all zones in parallel, and the result is reported per zone.
+
+With --sample-docs, the documents in the given file, one JSON feed operation
+per line, are checked against the schemas of the application package before
+deploying. Documents of unknown types, with unknown fields, or with values not
+matching the field types are reported, and nothing is deployed.
+
+With --deploy-param, query parameters are added to the deploy request, with
+keys and values URL-encoded. Each key can be given only once. This is intended
+for parameters specific to the config server or controller, such as
+verbose=true.
+
+If the application package declares its version in build-meta.json, e.g.
+{"version": "1.2.3"}, deployment is refused if the package active in the
+deployment declares a newer version. Use --force to deploy it anyway.
+
+With --max-duration, the entire deployment is bounded by the given duration:
+uploading the application package, waiting for the deployment run (Vespa Cloud)
+or convergence (self-hosted), and waiting for the query service to become ready.
+Each phase gets the time left by the previous ones, and the phase in progress
+is cancelled when the duration is exceeded. The time spent waiting for each
+phase is still bounded by --wait.
+
+With --print-endpoints, the endpoint of each container cluster is printed to
+standard output once the query service is ready, and all other output of the
+command is written to standard error. In the text format, each line holds a
+cluster name and its URL, separated by a space. In the JSON format, a single
+line holds an object mapping cluster names to URLs. When deploying to multiple
+zones, each line of text starts with the zone, and the JSON object maps each
+zone to the endpoints in that zone.
+
+When waiting for a deployment run on Vespa Cloud, its log is printed as it
+progresses. With --tail, only the given number of last lines of the log are
+kept visible, and earlier lines are erased as new ones are printed. This
+applies only when output is a terminal, otherwise all lines are printed. With
+--log-file, the full log is also written to the given file.`,
 	Example: `$ vespa deploy .
 $ vespa deploy -t cloud
 $ vespa deploy -t cloud -z dev.aws-us-east-1c  # -z can be omitted here as this zone is the default
-$ vespa deploy -t cloud -z perf.aws-us-east-1c`,
+$ vespa deploy -t cloud -z perf.aws-us-east-1c
+$ vespa deploy -t cloud -z dev.aws-us-east-1c,dev.aws-us-west-2a
+$ vespa deploy --retries 3
+$ vespa deploy --if-changed
+$ vespa deploy --list-files
+$ vespa deploy --receipt receipt.json
+$ vespa deploy --sample-docs docs.jsonl
+$ vespa deploy --deploy-param verbose=true
+$ vespa deploy --wait 600 --max-duration 15m
+$ vespa deploy -t cloud --wait 600 --tail 20 --log-file deploy.log
+$ vespa deploy -t cloud --wait 600 --print-endpoints --format json | jq -r .default`,
 	Args:              cobra.MaximumNArgs(1),
 	DisableAutoGenTag: true,
 	SilenceUsage:      true,
 	RunE: func(cmd *cobra.Command, args []string) error {
-		pkg, err := vespa.FindApplicationPackage(applicationSource(args), true)
-		if err != nil {
+		if err := checkWaitOutputFlags(); err != nil {
 			return err
 		}
-		cfg, err := LoadConfig()
+		ctx, budget, err := startDurationBudget(commandContext, maxDurationArg)
 		if err != nil {
 			return err
 		}
-		target, err := getTarget()
-		if err != nil {
-			return err
+		defer budget.stop()
+		endpointsOut := stdout
+		if printEndpointsArg {
+			// Keep standard output for the endpoints, so that it can be read by other programs
+			stdout = stderr
+			log.SetOutput(stderr)
+			defer func() {
+				stdout = endpointsOut
+				log.SetOutput(stdout)
+			}()
 		}
-		opts, err := getDeploymentOpts(cfg, pkg, target)
-		if err != nil {
+		return budget.check(deploy(ctx, args, budget, endpointsOut))
+	},
+}
+
+// deploy deploys the application package given by args, and waits for it with ctx. The phases of deployment are
+// recorded in budget, if non-nil. Endpoints are printed to endpointsOut, if requested.
+func deploy(ctx context.Context, args []string, budget *durationBudget, endpointsOut io.Writer) error {
+	pkg, err := findApplicationPackage(args, true)
+	if err != nil {
+		return err
+	}
+	defer pkg.Close()
+	if listFilesArg {
+		return printPackageFiles(pkg)
+	}
+	if sampleDocsArg != "" {
+		if err := validateDocuments(pkg, sampleDocsArg, "sample documents", "Correct the documents or the schemas, and deploy again"); err != nil {
 			return err
 		}
-		sessionOrRunID, err := vespa.Deploy(opts)
+	}
+	cfg, err := LoadConfig()
+	if err != nil {
+		return err
+	}
+	if zones := strings.Split(zoneArg, ","); len(zones) > 1 {
+		return deployToZones(ctx, cfg, pkg, zones, endpointsOut)
+	}
+	// Hash the application package while resolving the target, which may require authentication and discovery. The
+	// package is zipped again while it's uploaded
+	digest := startDigest(pkg)
+	defer digest.wait()
+	target, err := getTarget()
+	if err != nil {
+		return err
+	}
+	opts, err := getDeploymentOpts(cfg, pkg, target)
+	if err != nil {
+		return err
+	}
+	d, err := digest.wait()
+	if err != nil {
+		return err
+	}
+	opts.Digest = &d
+	hash := d.Hash
+	rec := newReceipt(opts, hash)
+	if ifChangedArg {
+		if lastHash, err := cfg.ReadPackageHash(opts.Deployment); err == nil && lastHash == hash {
+			log.Printf("Application package %s is unchanged since last deployment, skipping deployment", color.Cyan(pkg.Name()))
+			rec.Outcome = outcomeSkipped
+			return writeReceipt(receiptArg, rec)
+		}
+	}
+	if err := checkDowngrade(ctx, pkg, target); err != nil {
+		return err
+	}
+	sessionOrRunID, deployErr := deployWithRetries(ctx, opts, deployRetriesArg)
+	rec.addDeployment(opts, sessionOrRunID, deployErr)
+	if err := writeReceipt(receiptArg, rec); err != nil {
+		return err
+	}
+	if deployErr != nil {
+		return deployErr
+	}
+	if err := cfg.WritePackageHash(opts.Deployment, hash); err != nil {
+		return fmt.Errorf("could not write package hash: %w", err)
+	}
+
+	log.Println()
+	if opts.IsCloud() {
+		printSuccess("Triggered deployment of ", color.Cyan(pkg.Name()), " with run ID ", color.Cyan(sessionOrRunID))
+	} else {
+		printSuccess("Deployed ", color.Cyan(pkg.Name()))
+	}
+	if opts.IsCloud() {
+		runURL, err := runConsoleURL(opts.Deployment, sessionOrRunID)
 		if err != nil {
 			return err
 		}
+		log.Printf("\nUse %s for deployment status, or follow this deployment at", color.Cyan("vespa status"))
+		log.Print(color.Cyan(runURL))
+	}
+	runLog, stopRunLog, err := startRunLog()
+	if err != nil {
+		return err
+	}
+	ready, err := waitForQueryService(ctx, sessionOrRunID, budget, runLog)
+	if stopErr := stopRunLog(); err == nil {
+		err = stopErr
+	}
+	if err != nil || !ready || !printEndpointsArg {
+		return err
+	}
+	return printEndpoints(endpointsOut, target)
+}
 
-		fmt.Print("\n")
-		if opts.IsCloud() {
-			printSuccess("Triggered deployment of ", color.Cyan(pkg.Path), " with run ID ", color.Cyan(sessionOrRunID))
-		} else {
-			printSuccess("Deployed ", color.Cyan(pkg.Path))
+// startRunLog returns a writer of deployment run logs to the outputs given by the tail and log file flags. The returned
+// function completes the output.
+func startRunLog() (io.Writer, func() error, error) {
+	var (
+		tail *util.TailWriter
+		file *os.File
+		err  error
+	)
+	writers := []io.Writer{stdout}
+	if tailArg > 0 && isTerminal() {
+		tail = util.NewTailWriter(stdout, tailArg)
+		writers[0] = tail
+	}
+	if runLogFileArg != "" {
+		if file, err = os.Create(runLogFileArg); err != nil {
+			return nil, nil, fmt.Errorf("could not create log file: %w", err)
+		}
+		writers = append(writers, file)
+	}
+	return io.MultiWriter(writers...), func() error {
+		if tail != nil {
+			if err := tail.Flush(); err != nil {
+				return err
+			}
 		}
-		if opts.IsCloud() {
-			log.Printf("\nUse %s for deployment status, or follow this deployment at", color.Cyan("vespa status"))
-			log.Print(color.Cyan(fmt.Sprintf("%s/tenant/%s/application/%s/dev/instance/%s/job/%s-%s/run/%d",
-				getConsoleURL(),
-				opts.Deployment.Application.Tenant, opts.Deployment.Application.Application, opts.Deployment.Application.Instance,
-				opts.Deployment.Zone.Environment, opts.Deployment.Zone.Region,
-				sessionOrRunID)))
+		if file != nil {
+			return file.Close()
 		}
-		waitForQueryService(sessionOrRunID)
 		return nil
-	},
+	}, nil
+}
+
+// checkWaitOutputFlags returns an error if the flags for the output of waiting for a deployment are invalid.
+func checkWaitOutputFlags() error {
+	if endpointsFormatArg != "text" && endpointsFormatArg != "json" {
+		return fmt.Errorf("invalid format: %q: must be \"text\" or \"json\"", endpointsFormatArg)
+	}
+	if tailArg < 0 {
+		return fmt.Errorf("invalid tail: %d: must be 0 or positive", tailArg)
+	}
+	if printEndpointsArg && waitSecsArg == 0 {
+		return errHint(fmt.Errorf("cannot print endpoints without waiting for the deployment"), "Try adding --wait")
+	}
+	return nil
+}
+
+// printEndpoints prints the URL of each container cluster of target to w, in the format given by the format flag.
+func printEndpoints(w io.Writer, target vespa.Target) error {
+	urlsByCluster, err := containerEndpoints(target)
+	if err != nil {
+		return err
+	}
+	if endpointsFormatArg == "json" {
+		return printJSONLine(w, urlsByCluster)
+	}
+	printClusterURLs(w, "", urlsByCluster)
+	return nil
+}
+
+// printZoneEndpoints prints the URL of each container cluster in each zone to w, in the format given by the format
+// flag.
+func printZoneEndpoints(w io.Writer, urlsByZone map[string]map[string]string) error {
+	if endpointsFormatArg == "json" {
+		return printJSONLine(w, urlsByZone)
+	}
+	zones := make([]string, 0, len(urlsByZone))
+	for zone := range urlsByZone {
+		zones = append(zones, zone)
+	}
+	sort.Strings(zones)
+	for _, zone := range zones {
+		printClusterURLs(w, zone+" ", urlsByZone[zone])
+	}
+	return nil
+}
+
+// printClusterURLs prints a line with prefix, cluster name and URL for each cluster, ordered by name.
+func printClusterURLs(w io.Writer, prefix string, urlsByCluster map[string]string) {
+	names := make([]string, 0, len(urlsByCluster))
+	for name := range urlsByCluster {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Fprintln(w, prefix+name, urlsByCluster[name])
+	}
+}
+
+// containerEndpoints returns the URL of each container cluster of target, by cluster name.
+func containerEndpoints(target vespa.Target) (map[string]string, error) {
+	clusters, err := target.Clusters(commandContext, 0)
+	if err != nil {
+		return nil, fmt.Errorf("could not discover endpoints: %w", err)
+	}
+	urlsByCluster := make(map[string]string)
+	for _, c := range clusters {
+		if c.Type == "container" {
+			urlsByCluster[c.Name] = c.Service.BaseURL
+		}
+	}
+	return urlsByCluster, nil
+}
+
+func printJSONLine(w io.Writer, v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintln(w, string(data))
+	return nil
+}
+
+// checkDowngrade returns an error if the version declared by pkg is older than the one declared by the package active on
+// target. If downgrades are allowed, a warning is printed instead. Versions are only compared if both are declared, and
+// are semantic version numbers.
+func checkDowngrade(ctx context.Context, pkg vespa.ApplicationPackage, target vespa.Target) error {
+	declared, err := pkg.DeclaredVersion()
+	if err != nil {
+		return err
+	}
+	newVersion, err := version.Parse(declared)
+	if err != nil {
+		return nil
+	}
+	deployed, err := target.PackageVersion(ctx)
+	if err != nil {
+		fmt.Fprintln(stderr, color.Yellow("Warning:"), fmt.Sprintf("Could not read version of the deployed application package: %s", err))
+		return nil
+	}
+	deployedVersion, err := version.Parse(deployed)
+	if err != nil || !newVersion.Less(deployedVersion) {
+		return nil
+	}
+	msg := fmt.Sprintf("application package version %s is older than deployed version %s", declared, deployed)
+	if !allowDowngrade {
+		return errHint(errors.New(msg), "Use --force to deploy it anyway")
+	}
+	fmt.Fprintln(stderr, color.Yellow("Warning:"), "Downgrading: "+msg)
+	return nil
+}
+
+// durationBudget bounds the total duration of a command consisting of several phases. The context of the budget has
+// its deadline, such that the phase in progress is cancelled once the budget is exhausted.
+type durationBudget struct {
+	max      time.Duration
+	deadline time.Time
+	phase    string
+	cancel   context.CancelFunc
+}
+
+// startDurationBudget starts a budget of the given duration, and returns a context derived from parent which is done
+// when the budget is exhausted. If maxDuration is empty, parent and a nil budget are returned.
+func startDurationBudget(parent context.Context, maxDuration string) (context.Context, *durationBudget, error) {
+	if maxDuration == "" {
+		return parent, nil, nil
+	}
+	max, err := time.ParseDuration(maxDuration)
+	if err != nil || max <= 0 {
+		return nil, nil, fmt.Errorf("invalid max duration: %q: must be a positive duration", maxDuration)
+	}
+	deadline := time.Now().Add(max)
+	ctx, cancel := context.WithDeadline(parent, deadline)
+	return ctx, &durationBudget{max: max, deadline: deadline, phase: "upload", cancel: cancel}, nil
+}
+
+// enter records that the command has entered given phase.
+func (b *durationBudget) enter(phase string) {
+	if b != nil {
+		b.phase = phase
+	}
+}
+
+// exhausted returns whether the duration of this budget has passed.
+func (b *durationBudget) exhausted() bool { return b != nil && !time.Now().Before(b.deadline) }
+
+// check returns an error telling in which phase this budget was exhausted, if err is non-nil and the budget is
+// exhausted. Otherwise err is returned.
+func (b *durationBudget) check(err error) error {
+	if err == nil || !b.exhausted() {
+		return err
+	}
+	return ErrCLI{Status: networkFailureStatus, hints: []string{"Allow more time with --max-duration"},
+		error: fmt.Errorf("exceeded max duration of %s during %s", b.max, b.phase)}
+}
+
+// stop releases the resources of this budget.
+func (b *durationBudget) stop() {
+	if b != nil {
+		b.cancel()
+	}
+}
+
+// digestApplicationPackage computes the digest of pkg. This is a variable so that it can be overridden in tests.
+var digestApplicationPackage = func(pkg vespa.ApplicationPackage) (vespa.PackageDigest, error) {
+	return pkg.Digest()
+}
+
+// packageDigest is the digest of an application package, computed in the background.
+type packageDigest struct {
+	done   chan struct{}
+	digest vespa.PackageDigest
+	err    error
+}
+
+// startDigest starts computing the digest of pkg in the background.
+func startDigest(pkg vespa.ApplicationPackage) *packageDigest {
+	d := &packageDigest{done: make(chan struct{})}
+	go func() {
+		defer close(d.done)
+		d.digest, d.err = digestApplicationPackage(pkg)
+	}()
+	return d
+}
+
+// wait waits for the digest to be computed, and returns it.
+func (d *packageDigest) wait() (vespa.PackageDigest, error) {
+	<-d.done
+	return d.digest, d.err
 }
 
 var prepareCmd = &cobra.Command{
@@ -99,10 +504,11 @@ var prepareCmd = &cobra.Command{
 	DisableAutoGenTag: true,
 	SilenceUsage:      true,
 	RunE: func(cmd *cobra.Command, args []string) error {
-		pkg, err := vespa.FindApplicationPackage(applicationSource(args), true)
+		pkg, err := findApplicationPackage(args, true)
 		if err != nil {
 			return fmt.Errorf("could not find application package: %w", err)
 		}
+		defer pkg.Close()
 		cfg, err := LoadConfig()
 		if err != nil {
 			return err
@@ -111,17 +517,23 @@ var prepareCmd = &cobra.Command{
 		if err != nil {
 			return err
 		}
-		sessionID, err := vespa.Prepare(vespa.DeploymentOpts{
+		params, err := parseDeployParams(deployParamsArg)
+		if err != nil {
+			return err
+		}
+		result, err := vespa.Prepare(commandContext, vespa.DeploymentOpts{
 			ApplicationPackage: pkg,
 			Target:             target,
+			Parameters:         params,
 		})
 		if err != nil {
 			return err
 		}
-		if err := cfg.WriteSessionID(vespa.DefaultApplication, sessionID); err != nil {
+		if err := cfg.WriteSessionID(vespa.DefaultApplication, result.SessionID); err != nil {
 			return fmt.Errorf("could not write session id: %w", err)
 		}
-		printSuccess("Prepared ", color.Cyan(pkg.Path), " with session ", sessionID)
+		printSuccess("Prepared ", color.Cyan(pkg.Name()), " with session ", result.SessionID)
+		printConfigChangeActions(result.ConfigChangeActions)
 		return nil
 	},
 }
@@ -133,10 +545,11 @@ var activateCmd = &cobra.Command{
 	DisableAutoGenTag: true,
 	SilenceUsage:      true,
 	RunE: func(cmd *cobra.Command, args []string) error {
-		pkg, err := vespa.FindApplicationPackage(applicationSource(args), true)
+		pkg, err := findApplicationPackage(args, true)
 		if err != nil {
 			return fmt.Errorf("could not find application package: %w", err)
 		}
+		defer pkg.Close()
 		cfg, err := LoadConfig()
 		if err != nil {
 			return err
@@ -149,22 +562,260 @@ var activateCmd = &cobra.Command{
 		if err != nil {
 			return err
 		}
-		err = vespa.Activate(sessionID, vespa.DeploymentOpts{
+		err = vespa.Activate(commandContext, sessionID, vespa.DeploymentOpts{
 			ApplicationPackage: pkg,
 			Target:             target,
 		})
-		if err != nil {
+		if errors.Is(err, vespa.ErrStaleSession) {
+			return errHint(err, "Try 'vespa prepare' to create a new session")
+		} else if err != nil {
 			return err
 		}
-		printSuccess("Activated ", color.Cyan(pkg.Path), " with session ", sessionID)
-		waitForQueryService(sessionID)
-		return nil
+		printSuccess("Activated ", color.Cyan(pkg.Name()), " with session ", sessionID)
+		_, err = waitForQueryService(commandContext, sessionID, nil, stdout)
+		return err
 	},
 }
 
-func waitForQueryService(sessionOrRunID int64) {
+// printPackageFiles prints the files in pkg with their uncompressed sizes, followed by their total size.
+func printPackageFiles(pkg vespa.ApplicationPackage) error {
+	files, err := pkg.Files()
+	if err != nil {
+		return fmt.Errorf("could not list files in %s: %w", pkg.Name(), err)
+	}
+	var total int64
+	for _, f := range files {
+		fmt.Fprintf(stdout, "%10s  %s\n", vespa.FormatSize(f.Size), f.Path)
+		total += f.Size
+	}
+	noun := "files"
+	if len(files) == 1 {
+		noun = "file"
+	}
+	fmt.Fprintf(stdout, "%d %s in %s, %s in total\n", len(files), noun, color.Cyan(pkg.Name()), vespa.FormatSize(total))
+	return nil
+}
+
+// parseDeployParams parses query parameters of deploy requests on the form key=value.
+func parseDeployParams(args []string) (map[string]string, error) {
+	if len(args) == 0 {
+		return nil, nil
+	}
+	params := make(map[string]string, len(args))
+	for _, arg := range args {
+		parts := strings.SplitN(arg, "=", 2)
+		if len(parts) != 2 {
+			return nil, errHint(fmt.Errorf("invalid deploy parameter: %q", arg), "Deploy parameters must be on the form key=value")
+		}
+		if err := vespa.ValidateDeployParameter(parts[0], parts[1]); err != nil {
+			return nil, err
+		}
+		if _, ok := params[parts[0]]; ok {
+			return nil, fmt.Errorf("deploy parameter %q given more than once", parts[0])
+		}
+		params[parts[0]] = parts[1]
+	}
+	return params, nil
+}
+
+// deployToZones deploys pkg to each of zones in parallel with ctx, and reports the result of each deployment. An error
+// is returned if any deployment fails.
+func deployToZones(ctx context.Context, cfg *Config, pkg vespa.ApplicationPackage, zones []string, endpointsOut io.Writer) error {
+	targetType, err := getTargetType()
+	if err != nil {
+		return err
+	}
+	if targetType != "cloud" {
+		return errHint(fmt.Errorf("cannot deploy to multiple zones with %s target", targetType), "Deployment to multiple zones requires the cloud target")
+	}
+	digest := startDigest(pkg)
+	defer digest.wait()
+	var deployments []vespa.DeploymentOpts
+	var targets []vespa.Target
+	for _, name := range zones {
+		name = strings.TrimSpace(name)
+		zone, err := vespa.ZoneFromString(name)
+		if err != nil {
+			return err
+		}
+		if zone.Environment != "dev" && zone.Environment != "perf" {
+			return errHint(fmt.Errorf("cannot deploy to multiple zones in %s environment: %s", zone.Environment, zone),
+				"Deployment to multiple zones is only supported in dev and perf environments")
+		}
+		target, err := getTargetInZone(name)
+		if err != nil {
+			return err
+		}
+		opts, err := getDeploymentOptsInZone(cfg, pkg, target, name)
+		if err != nil {
+			return err
+		}
+		deployments = append(deployments, opts)
+		targets = append(targets, target)
+	}
+	d, err := digest.wait()
+	if err != nil {
+		return err
+	}
+	hash := d.Hash
+	rec := newReceipt(deployments[0], hash)
+	changed := deployments[:0]
+	for i, opts := range deployments {
+		if ifChangedArg {
+			if lastHash, err := cfg.ReadPackageHash(opts.Deployment); err == nil && lastHash == hash {
+				log.Printf("Application package %s is unchanged since last deployment to %s, skipping deployment", color.Cyan(pkg.Name()), color.Cyan(opts.Deployment.Zone))
+				continue
+			}
+		}
+		if err := checkDowngrade(ctx, pkg, targets[i]); err != nil {
+			return err
+		}
+		opts.Digest = &d
+		changed = append(changed, opts)
+	}
+	deployments = changed
+	runIDs := make([]int64, len(deployments))
+	errs := make([]error, len(deployments))
+	var wg sync.WaitGroup
+	for i, opts := range deployments {
+		wg.Add(1)
+		go func(i int, opts vespa.DeploymentOpts) {
+			defer wg.Done()
+			runIDs[i], errs[i] = deployWithRetries(ctx, opts, deployRetriesArg)
+		}(i, opts)
+	}
+	wg.Wait()
+	for i, opts := range deployments {
+		rec.addDeployment(opts, runIDs[i], errs[i])
+	}
+	if len(deployments) == 0 {
+		rec.Outcome = outcomeSkipped
+	}
+	if err := writeReceipt(receiptArg, rec); err != nil {
+		return err
+	}
+
+	failed := 0
+	for i, opts := range deployments {
+		if errs[i] != nil {
+			failed++
+			printErr(fmt.Errorf("deployment to %s failed: %w", opts.Deployment.Zone, errs[i]))
+			continue
+		}
+		if err := cfg.WritePackageHash(opts.Deployment, hash); err != nil {
+			return fmt.Errorf("could not write package hash: %w", err)
+		}
+		runURL, err := runConsoleURL(opts.Deployment, runIDs[i])
+		if err != nil {
+			return err
+		}
+		printSuccess("Triggered deployment of ", color.Cyan(pkg.Name()), " to ", color.Cyan(opts.Deployment.Zone), " with run ID ", color.Cyan(runIDs[i]))
+		log.Print(color.Cyan(runURL))
+	}
+	urlsByZone := make(map[string]map[string]string)
 	if waitSecsArg > 0 {
-		log.Println()
-		waitForService("query", sessionOrRunID)
+		for i, opts := range deployments {
+			if errs[i] != nil {
+				continue
+			}
+			log.Println()
+			s, err := opts.Target.Service(ctx, "query", time.Duration(waitSecsArg)*time.Second, runIDs[i], "")
+			if err == nil {
+				err = waitForServiceReady(ctx, s)
+			}
+			if err != nil {
+				printErr(fmt.Errorf("query service in %s is not ready: %w", opts.Deployment.Zone, err))
+				continue
+			}
+			if printEndpointsArg {
+				urls, err := containerEndpoints(opts.Target)
+				if err != nil {
+					return err
+				}
+				urlsByZone[opts.Deployment.Zone.String()] = urls
+			}
+		}
+	}
+	if printEndpointsArg && len(urlsByZone) > 0 {
+		if err := printZoneEndpoints(endpointsOut, urlsByZone); err != nil {
+			return err
+		}
+	}
+	if failed > 0 {
+		return ErrCLI{Status: 1, error: fmt.Errorf("deployment failed in %d of %d zones", failed, len(deployments))}
+	}
+	return nil
+}
+
+// runConsoleURL returns the URL of the given deployment run in the console.
+func runConsoleURL(deployment vespa.Deployment, runID int64) (string, error) {
+	consoleURL, err := getConsoleURL()
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%s/tenant/%s/application/%s/dev/instance/%s/job/%s-%s/run/%d",
+		consoleURL,
+		deployment.Application.Tenant, deployment.Application.Application, deployment.Application.Instance,
+		deployment.Zone.Environment, deployment.Zone.Region,
+		runID), nil
+}
+
+// deployWithRetries deploys using opts and ctx, retrying up to retries times if deployment fails with a transient error.
+func deployWithRetries(ctx context.Context, opts vespa.DeploymentOpts, retries int) (int64, error) {
+	interval := deployRetryInterval
+	for attempt := 0; ; attempt++ {
+		sessionOrRunID, err := vespa.Deploy(ctx, opts)
+		if err == nil || attempt >= retries || !vespa.IsTransient(err) {
+			return sessionOrRunID, err
+		}
+		fmt.Fprintln(stderr, color.Yellow("Warning:"), fmt.Sprintf("Deployment attempt %d of %d failed: %s", attempt+1, retries+1, err))
+		fmt.Fprintf(stderr, "Retrying in %s ...\n", interval)
+		select {
+		case <-time.After(interval):
+		case <-ctx.Done():
+			return sessionOrRunID, fmt.Errorf("stopped retrying deployment: %w", ctx.Err())
+		}
+		interval *= 2
+	}
+}
+
+func printConfigChangeActions(actions []vespa.ConfigChangeAction) {
+	for _, action := range actions {
+		target := "cluster " + action.Cluster
+		if action.DocumentType != "" {
+			target += ", document type " + action.DocumentType
+		}
+		fmt.Fprintln(stderr, color.Yellow("Warning:"), fmt.Sprintf("Change requires %s of %s", action.Type, target))
+		for _, message := range action.Messages {
+			fmt.Fprintf(stderr, "  %s\n", message)
+		}
+	}
+}
+
+// waitForQueryService waits for the query service of the deployment given by sessionOrRunID to become ready, if waiting
+// is requested, and returns whether it became ready. The log of the deployment run is written to runLog. The phases of
+// waiting are recorded in budget, if non-nil. A failure to become ready is not an error, unless the deployment run
+// failed, the application is not deployed, or budget is exhausted.
+func waitForQueryService(ctx context.Context, sessionOrRunID int64, budget *durationBudget, runLog io.Writer) (bool, error) {
+	if waitSecsArg == 0 {
+		return false, nil
+	}
+	log.Println()
+	if targetType, err := getTargetType(); err == nil && targetType == "cloud" {
+		budget.enter("deployment run")
+	} else {
+		budget.enter("convergence")
+	}
+	s, err := getServiceWithRunLog(ctx, "query", sessionOrRunID, "", runLog)
+	if err == nil {
+		budget.enter("query readiness")
+		err = waitForServiceReady(ctx, s)
+	}
+	if err != nil && budget.exhausted() {
+		return false, budget.check(err)
+	}
+	if errors.Is(err, vespa.ErrRunFailed) || errors.Is(err, vespa.ErrNotDeployed) {
+		return false, err
 	}
+	return err == nil, nil
 }
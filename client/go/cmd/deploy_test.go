@@ -5,9 +5,20 @@
 package cmd
 
 import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
 	"path/filepath"
 	"strconv"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/vespa-engine/vespa/client/go/vespa"
@@ -18,6 +29,66 @@ func TestPrepareZip(t *testing.T) {
 		[]string{"prepare", "testdata/applications/withTarget/target/application.zip"}, t)
 }
 
+func TestPrepareWithConfigChangeActions(t *testing.T) {
+	client := &mockHttpClient{}
+	client.NextResponse(200, `{"session-id":"42"}`)
+	client.NextResponse(200, `{"configChangeActions": {
+  "restart": [{"clusterName": "music", "clusterType": "container", "serviceType": "container",
+               "messages": ["Change in JVM options"], "services": []}],
+  "refeed": [],
+  "reindex": [{"name": "indexing-change", "documentType": "music", "clusterName": "content",
+               "messages": ["Field 'title' changed: add attribute aspect"], "services": []}]}}`)
+	out, outErr := execute(command{args: []string{"prepare", "testdata/applications/withTarget/target/application.zip"}}, t, client)
+	assert.Equal(t, "Success: Prepared testdata/applications/withTarget/target/application.zip with session 42\n", out)
+	assert.Equal(t, "Warning: Change requires restart of cluster music\n"+
+		"  Change in JVM options\n"+
+		"Warning: Change requires reindex of cluster content, document type music\n"+
+		"  Field 'title' changed: add attribute aspect\n", outErr)
+}
+
+func TestPrepareDeadlineExceeded(t *testing.T) {
+	client := &mockHttpClient{}
+	_, errOut := execute(command{args: []string{"prepare", "--deadline", "2000-01-01T00:00:00Z", "testdata/applications/withTarget/target/application.zip"}}, t, client)
+	assert.Contains(t, errOut, "deadline exceeded: command did not complete by 2000-01-01T00:00:00Z")
+	assert.Empty(t, client.requests)
+}
+
+func TestDeployParams(t *testing.T) {
+	pkgPath := "testdata/applications/withTarget/target/application.zip"
+	client := &mockHttpClient{}
+	client.NextResponse(200, `{"session-id":"42"}`)
+	_, outErr := execute(command{args: []string{"deploy", "--deploy-param", "verbose=true", "--deploy-param", "feature.x=a b", pkgPath}}, t, client)
+	assert.Equal(t, "", outErr)
+	assert.Equal(t, "/application/v2/tenant/default/prepareandactivate", client.lastRequest.URL.Path)
+	assert.Equal(t, "feature.x=a+b&verbose=true", client.lastRequest.URL.RawQuery)
+
+	client.NextResponse(200, `{"session-id":"42"}`)
+	client.NextResponse(200, `{}`)
+	_, outErr = execute(command{args: []string{"prepare", "--deploy-param", "verbose=true", pkgPath}}, t, client)
+	assert.Equal(t, "", outErr)
+	assert.Equal(t, "/application/v2/tenant/default/session/42/prepared", client.lastRequest.URL.Path)
+	assert.Equal(t, "verbose=true", client.lastRequest.URL.RawQuery)
+
+	client.requests = nil
+	_, outErr = execute(command{args: []string{"deploy", "--deploy-param", "verbose", pkgPath}}, t, client)
+	assert.Equal(t, "Error: invalid deploy parameter: \"verbose\"\nHint: Deploy parameters must be on the form key=value\n", outErr)
+	_, outErr = execute(command{args: []string{"deploy", "--deploy-param", "-x=1", pkgPath}}, t, client)
+	assert.Equal(t, "Error: invalid deploy parameter name: \"-x\"\n", outErr)
+	_, outErr = execute(command{args: []string{"deploy", "--deploy-param", "x=1", "--deploy-param", "x=2", pkgPath}}, t, client)
+	assert.Equal(t, "Error: deploy parameter \"x\" given more than once\n", outErr)
+	assert.Empty(t, client.requests)
+}
+
+func TestDeployPrintRequests(t *testing.T) {
+	client := &mockHttpClient{}
+	client.NextResponse(200, `{"session-id":"42"}`)
+	client.NextResponse(200, `{"converged":true}`)
+	_, errOut := execute(command{args: []string{"deploy", "--print-requests", "--wait", "60", "testdata/applications/withTarget/target/application.zip"}}, t, client)
+	assert.Equal(t, "> POST http://127.0.0.1:19071/application/v2/tenant/default/prepareandactivate\n"+
+		"> GET http://127.0.0.1:19071/application/v2/tenant/default/application/default/environment/prod/region/default/instance/default/serviceconverge\n"+
+		"> GET http://127.0.0.1:8080/ApplicationStatus\n", errOut)
+}
+
 func TestActivateZip(t *testing.T) {
 	assertActivate("testdata/applications/withTarget/target/application.zip",
 		[]string{"activate", "testdata/applications/withTarget/target/application.zip"}, t)
@@ -34,7 +105,7 @@ func TestDeployZipWithURLTargetArgument(t *testing.T) {
 
 	client := &mockHttpClient{}
 	assert.Equal(t,
-		"Success: Deployed "+applicationPackage+"\n",
+		"\nSuccess: Deployed "+applicationPackage+"\n",
 		executeCommand(t, client, arguments, []string{}))
 	assertDeployRequestMade("http://target:19071", client, t)
 }
@@ -44,6 +115,80 @@ func TestDeployZipWitLocalTargetArgument(t *testing.T) {
 		[]string{"deploy", "testdata/applications/withTarget/target/application.zip", "-t", "local"}, t)
 }
 
+func TestDeployTarGz(t *testing.T) {
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gz)
+	services := []byte("<services/>")
+	assert.Nil(t, tw.WriteHeader(&tar.Header{Name: "services.xml", Mode: 0644, Size: int64(len(services)), Typeflag: tar.TypeReg}))
+	_, err := tw.Write(services)
+	assert.Nil(t, err)
+	assert.Nil(t, tw.Close())
+	assert.Nil(t, gz.Close())
+	pkgPath := filepath.Join(t.TempDir(), "application.tar.gz")
+	assert.Nil(t, ioutil.WriteFile(pkgPath, buf.Bytes(), 0644))
+
+	assertDeploy(pkgPath, []string{"deploy", pkgPath}, t)
+}
+
+func TestDeployHashesPackageWhileResolvingTarget(t *testing.T) {
+	defer func(digest func(vespa.ApplicationPackage) (vespa.PackageDigest, error)) {
+		digestApplicationPackage = digest
+		discoveryCurrent = nil
+	}(digestApplicationPackage)
+	client := &mockHttpClient{}
+	homeDir := setupCloudDeploy(t, client, mockApplicationPackage(t, false))
+	execute(command{homeDir: homeDir, args: []string{"config", "set", "control-plane", "https://cp.example.com"}}, t, client)
+	discoveryCurrent = nil
+	client.PathResponse("/.well-known/vespa-cloud.json", 200,
+		`{"apiUrl": "https://api.cp.example.com:4443", "consoleUrl": "https://console.cp.example.com", "system": "public"}`)
+	client.PathResponse("/application/v4/tenant/t1/application/a1/instance/i1/deploy/dev-aws-us-east-1c", 200, `{"run":42}`)
+
+	// The digest completes only once the target is being resolved, i.e. the control plane is being discovered
+	discovering := func() bool {
+		client.mu.Lock()
+		defer client.mu.Unlock()
+		for _, req := range client.requests {
+			if req.URL.Path == "/.well-known/vespa-cloud.json" {
+				return true
+			}
+		}
+		return false
+	}
+	overlapped := false
+	digests := 0
+	digestApplicationPackage = func(pkg vespa.ApplicationPackage) (vespa.PackageDigest, error) {
+		digests++
+		for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+			if discovering() {
+				overlapped = true
+				break
+			}
+		}
+		return pkg.Digest()
+	}
+	out, errOut := execute(command{homeDir: homeDir, args: []string{"deploy"}}, t, client)
+	assert.Equal(t, "", errOut)
+	assert.Contains(t, out, "Success: Triggered deployment of src/main/application with run ID 42\n")
+	assert.True(t, overlapped, "package is hashed while the control plane is discovered")
+	assert.Equal(t, 1, digests)
+	assert.Equal(t, "https://cp.example.com/.well-known/vespa-cloud.json", client.requests[0].URL.String())
+	assertPackageUpload(-1, "https://api.cp.example.com:4443/application/v4/tenant/t1/application/a1/instance/i1/deploy/dev-aws-us-east-1c", client, t)
+}
+
+func TestDeployListFiles(t *testing.T) {
+	pkgDir := mockApplicationPackage(t, false)
+	appDir := filepath.Join(pkgDir, "src", "main", "application")
+	assert.Nil(t, os.MkdirAll(filepath.Join(appDir, "schemas"), 0755))
+	assert.Nil(t, ioutil.WriteFile(filepath.Join(appDir, "schemas", "music.sd"), make([]byte, 2048), 0644))
+	client := &mockHttpClient{}
+	out, _ := execute(command{args: []string{"deploy", "--list-files", pkgDir}}, t, client)
+	assert.Equal(t, "   2.0 KiB  schemas/music.sd\n"+
+		"       0 B  services.xml\n"+
+		"2 files in "+appDir+", 2.0 KiB in total\n", out)
+	assert.Empty(t, client.requests)
+}
+
 func TestDeploySourceDirectory(t *testing.T) {
 	assertDeploy("testdata/applications/withSource/src/main/application",
 		[]string{"deploy", "testdata/applications/withSource/src/main/application"}, t)
@@ -68,13 +213,13 @@ func TestDeployApplicationDirectoryWithPomAndEmptyTarget(t *testing.T) {
 }
 
 func TestDeployApplicationPackageErrorWithUnexpectedNonJson(t *testing.T) {
-	assertApplicationPackageError(t, "deploy", 401,
+	assertApplicationPackageError(t, "deploy", 400,
 		"Raw text error",
 		"Raw text error")
 }
 
 func TestDeployApplicationPackageErrorWithUnexpectedJson(t *testing.T) {
-	assertApplicationPackageError(t, "deploy", 401,
+	assertApplicationPackageError(t, "deploy", 400,
 		`{
     "some-unexpected-json": "Invalid XML, error in services.xml: element \"nosuch\" not allowed here"
 }`,
@@ -99,14 +244,392 @@ func TestPrepareApplicationPackageErrorWithExpectedFormat(t *testing.T) {
      }`)
 }
 
+func TestDeployUnauthorized(t *testing.T) {
+	client := &mockHttpClient{}
+	client.NextResponse(401, "Invalid API key")
+	_, outErr := execute(command{args: []string{"deploy", "testdata/applications/withTarget/target/application.zip"}}, t, client)
+	assert.Equal(t, "Error: authentication with deploy service failed (Status 401)\nInvalid API key\n", outErr)
+
+	client.NextResponse(403, "Access denied")
+	_, outErr = execute(command{args: []string{"deploy", "testdata/applications/withTarget/target/application.zip"}}, t, client)
+	assert.Equal(t, "Error: not authorized by deploy service (Status 403)\nAccess denied\n", outErr)
+}
+
 func TestDeployError(t *testing.T) {
 	assertDeployServerError(t, 501, "Deploy service error")
 }
 
+func TestDeployRetriesTransientErrors(t *testing.T) {
+	defer func(interval time.Duration) { deployRetryInterval = interval }(deployRetryInterval)
+	deployRetryInterval = 0
+	client := &mockHttpClient{}
+	client.NextError(errors.New("connection refused"))
+	client.NextError(errors.New("connection refused"))
+	out, outErr := execute(command{args: []string{"deploy", "--retries", "2", "testdata/applications/withTarget/target/application.zip"}}, t, client)
+	assert.Equal(t, "\nSuccess: Deployed testdata/applications/withTarget/target/application.zip\n", out)
+	assert.Contains(t, outErr, "Warning: Deployment attempt 1 of 3 failed: connection refused\n")
+	assert.Contains(t, outErr, "Warning: Deployment attempt 2 of 3 failed: connection refused\n")
+	assert.Equal(t, 3, len(client.requests))
+	assertDeployRequestMade("http://127.0.0.1:19071", client, t)
+}
+
+func TestDeployRetriesCancelled(t *testing.T) {
+	defer func(interval time.Duration) { deployRetryInterval = interval }(deployRetryInterval)
+	deployRetryInterval = time.Hour
+	client := &mockHttpClient{}
+	client.NextError(errors.New("connection refused"))
+	start := time.Now()
+	_, outErr, err := executeWithError(command{args: []string{"deploy", "--retries", "1", "--max-duration", "200ms", "testdata/applications/withTarget/target/application.zip"}}, t, client)
+	assert.True(t, time.Since(start) < 10*time.Second, "retrying is cancelled when duration is exceeded")
+	assert.Contains(t, outErr, "Error: exceeded max duration of 200ms during upload\n")
+	assert.Equal(t, networkFailureStatus, err.(ErrCLI).Status)
+	assert.Equal(t, 1, len(client.requests))
+}
+
+func TestDeployRetriesExhausted(t *testing.T) {
+	defer func(interval time.Duration) { deployRetryInterval = interval }(deployRetryInterval)
+	deployRetryInterval = 0
+	client := &mockHttpClient{}
+	client.NextResponse(503, "Unavailable")
+	client.NextResponse(503, "Unavailable")
+	_, outErr := execute(command{args: []string{"deploy", "--retries", "1", "testdata/applications/withTarget/target/application.zip"}}, t, client)
+	assert.Contains(t, outErr, "Error: error from deploy service at 127.0.0.1:19071 (Status 503):\nUnavailable\n")
+	assert.Equal(t, 2, len(client.requests))
+}
+
+func TestDeployDoesNotRetryInvalidApplicationPackage(t *testing.T) {
+	client := &mockHttpClient{}
+	client.NextResponse(400, "Invalid package")
+	_, outErr := execute(command{args: []string{"deploy", "--retries", "2", "testdata/applications/withTarget/target/application.zip"}}, t, client)
+	assert.Equal(t, "Error: invalid application package (Status 400)\nInvalid package\n", outErr)
+	assert.Equal(t, 1, len(client.requests))
+}
+
+func TestDeployRetriesTooManyRequests(t *testing.T) {
+	defer func(interval time.Duration) { deployRetryInterval = interval }(deployRetryInterval)
+	deployRetryInterval = 0
+	client := &mockHttpClient{}
+	client.NextResponse(429, "Too many requests")
+	out, outErr := execute(command{args: []string{"deploy", "--retries", "1", "testdata/applications/withTarget/target/application.zip"}}, t, client)
+	assert.Equal(t, "\nSuccess: Deployed testdata/applications/withTarget/target/application.zip\n", out)
+	assert.Contains(t, outErr, "Warning: Deployment attempt 1 of 2 failed: request to deploy service at 127.0.0.1:19071 failed (Status 429)\n")
+	assert.Equal(t, 2, len(client.requests))
+}
+
+func TestDeployIfChanged(t *testing.T) {
+	pkgPath := "testdata/applications/withSource/src/main/application"
+	homeDir := filepath.Join(t.TempDir(), ".vespa")
+	client := &mockHttpClient{}
+	out, _ := execute(command{args: []string{"deploy", "--if-changed", pkgPath}, homeDir: homeDir}, t, client)
+	assert.Equal(t, "\nSuccess: Deployed "+pkgPath+"\n", out)
+	assert.Equal(t, 1, len(client.requests))
+
+	// Unchanged package is not deployed again
+	out, _ = execute(command{args: []string{"deploy", "--if-changed", pkgPath}, homeDir: homeDir}, t, client)
+	assert.Equal(t, "Application package "+pkgPath+" is unchanged since last deployment, skipping deployment\n", out)
+	assert.Equal(t, 1, len(client.requests))
+
+	// Deploys if --if-changed is not given
+	out, _ = execute(command{args: []string{"deploy", pkgPath}, homeDir: homeDir}, t, client)
+	assert.Equal(t, "\nSuccess: Deployed "+pkgPath+"\n", out)
+	assert.Equal(t, 2, len(client.requests))
+}
+
+func TestDeployIfChangedWithChangedPackage(t *testing.T) {
+	pkgPath := "testdata/applications/withSource/src/main/application"
+	homeDir := filepath.Join(t.TempDir(), ".vespa")
+	cfg := Config{Home: homeDir, createDirs: true}
+	if err := cfg.WritePackageHash(vespa.Deployment{}, "0000000000000000000000000000000000000000000000000000000000000000"); err != nil {
+		t.Fatal(err)
+	}
+	client := &mockHttpClient{}
+	out, _ := execute(command{args: []string{"deploy", "--if-changed", pkgPath}, homeDir: homeDir}, t, client)
+	assert.Equal(t, "\nSuccess: Deployed "+pkgPath+"\n", out)
+	assertDeployRequestMade("http://127.0.0.1:19071", client, t)
+
+	pkg := vespa.ApplicationPackage{Path: pkgPath}
+	hash, err := pkg.Hash()
+	assert.Nil(t, err)
+	storedHash, err := cfg.ReadPackageHash(vespa.Deployment{})
+	assert.Nil(t, err)
+	assert.Equal(t, hash, storedHash)
+}
+
+func TestActivateStaleSession(t *testing.T) {
+	pkgPath := "testdata/applications/withTarget/target/application.zip"
+	homeDir := filepath.Join(t.TempDir(), ".vespa")
+	client := &mockHttpClient{}
+	client.NextResponse(200, `{"session-id":"42"}`)
+	out, _ := execute(command{homeDir: homeDir, args: []string{"prepare", pkgPath}}, t, client)
+	assert.Equal(t, "Success: Prepared "+pkgPath+" with session 42\n", out)
+
+	client.NextResponse(404, `{"error-code":"NOT_FOUND","message":"Session 42 for tenant 'default' was not found"}`)
+	_, outErr := execute(command{homeDir: homeDir, args: []string{"activate", pkgPath}}, t, client)
+	assert.Equal(t, "Error: session 42 is unknown or has expired (Status 404): stale session\n"+
+		"Hint: Try 'vespa prepare' to create a new session\n", outErr)
+
+	client.NextResponse(400, `{"error-code":"BAD_REQUEST","message":"Session 42 is not prepared"}`)
+	_, outErr = execute(command{homeDir: homeDir, args: []string{"activate", pkgPath}}, t, client)
+	assert.Contains(t, outErr, "stale session\n")
+
+	// Other errors are not stale sessions
+	client.NextResponse(400, `{"error-code":"BAD_REQUEST","message":"Invalid application"}`)
+	_, outErr = execute(command{homeDir: homeDir, args: []string{"activate", pkgPath}}, t, client)
+	assert.NotContains(t, outErr, "stale session")
+}
+
+func TestDeployReceipt(t *testing.T) {
+	pkgPath := "testdata/applications/withSource/src/main/application"
+	receiptFile := filepath.Join(t.TempDir(), "receipt.json")
+	client := &mockHttpClient{}
+	client.NextResponse(200, `{"session-id":"42"}`)
+	_, outErr := execute(command{args: []string{"deploy", "--receipt", receiptFile, pkgPath}}, t, client)
+	assert.Equal(t, "", outErr)
+
+	pkg := vespa.ApplicationPackage{Path: pkgPath}
+	hash, err := pkg.Hash()
+	assert.Nil(t, err)
+	var r receipt
+	data, err := ioutil.ReadFile(receiptFile)
+	assert.Nil(t, err)
+	assert.Nil(t, json.Unmarshal(data, &r))
+	assert.WithinDuration(t, time.Now(), r.Time, time.Minute)
+	assert.NotEqual(t, "", r.User)
+	assert.Equal(t, "local", r.Target)
+	assert.Equal(t, hash, r.PackageSHA256)
+	assert.Equal(t, []receiptDeployment{{SessionID: 42, Outcome: "success"}}, r.Deployments)
+	assert.Equal(t, "success", r.Outcome)
+
+	// Receipt is also written when deployment fails
+	client.NextResponse(400, "Invalid package")
+	_, outErr = execute(command{args: []string{"deploy", "--receipt", receiptFile, pkgPath}}, t, client)
+	assert.Equal(t, "Error: invalid application package (Status 400)\nInvalid package\n", outErr)
+	data, err = ioutil.ReadFile(receiptFile)
+	assert.Nil(t, err)
+	r = receipt{}
+	assert.Nil(t, json.Unmarshal(data, &r))
+	assert.Equal(t, []receiptDeployment{{Outcome: "failure", Error: "invalid application package (Status 400)\nInvalid package"}}, r.Deployments)
+	assert.Equal(t, "failure", r.Outcome)
+}
+
+func TestDeployMultipleZones(t *testing.T) {
+	client := &mockHttpClient{}
+	homeDir := setupCloudDeploy(t, client, mockApplicationPackage(t, false))
+
+	deployPath := "/application/v4/tenant/t1/application/a1/instance/i1/deploy/"
+	client.PathResponse(deployPath+"dev-region1", 200, `{"run":42}`)
+	client.PathResponse(deployPath+"dev-region2", 400, "Invalid package")
+	args := []string{"deploy", "-t", "cloud", "-a", "t1.a1.i1", "-z", "dev.region1,dev.region2"}
+	out, outErr, err := executeWithError(command{homeDir: homeDir, args: args}, t, client)
+	assert.Contains(t, out, "Success: Triggered deployment of src/main/application to dev.region1 with run ID 42\n")
+	assert.Contains(t, out, "/tenant/t1/application/a1/dev/instance/i1/job/dev-region1/run/42\n")
+	assert.Equal(t, "Error: deployment to dev.region2 failed: invalid application package (Status 400)\nInvalid package\n"+
+		"Error: deployment failed in 1 of 2 zones\n", outErr)
+	assert.Equal(t, 1, err.(ErrCLI).Status)
+	assert.Equal(t, 2, len(client.requests))
+
+	// Only dev-like environments are supported
+	_, outErr = execute(command{homeDir: homeDir, args: []string{"deploy", "-t", "cloud", "-a", "t1.a1.i1", "-z", "dev.region1,prod.region2"}}, t, client)
+	assert.Equal(t, "Error: cannot deploy to multiple zones in prod environment: prod.region2\n"+
+		"Hint: Deployment to multiple zones is only supported in dev and perf environments\n", outErr)
+}
+
+func TestDeployRunFailed(t *testing.T) {
+	client := &mockHttpClient{}
+	homeDir := setupCloudDeploy(t, client, mockApplicationPackage(t, false))
+
+	client.PathResponse("/application/v4/tenant/t1/application/a1/instance/i1/deploy/dev-region1", 200, `{"run":42}`)
+	client.PathResponse("/application/v4/tenant/t1/application/a1/instance/i1/job/dev-region1/run/42", 200, `{"active": false, "status": "error"}`)
+	args := []string{"deploy", "-t", "cloud", "-a", "t1.a1.i1", "-z", "dev.region1", "--wait", "60"}
+	out, errOut, err := executeWithError(command{homeDir: homeDir, args: args}, t, client)
+	assert.Contains(t, out, "Success: Triggered deployment of src/main/application with run ID 42\n")
+	assert.Equal(t, "Error: service query not found: run 42 ended with unsuccessful status: error\n", errOut)
+	assert.Equal(t, deploymentFailureStatus, err.(ErrCLI).Status)
+}
+
+func TestDeployMaxDuration(t *testing.T) {
+	client := &mockHttpClient{}
+	homeDir := setupCloudDeploy(t, client, mockApplicationPackage(t, false))
+
+	client.PathResponse("/application/v4/tenant/t1/application/a1/instance/i1/deploy/dev-region1", 200, `{"run":42}`)
+	client.PathResponse("/application/v4/tenant/t1/application/a1/instance/i1/job/dev-region1/run/42", 200, `{"active": true, "status": "running"}`)
+	args := []string{"deploy", "-t", "cloud", "-a", "t1.a1.i1", "-z", "dev.region1", "--wait", "60", "--max-duration", "500ms"}
+	start := time.Now()
+	out, errOut, err := executeWithError(command{homeDir: homeDir, args: args}, t, client)
+	assert.True(t, time.Since(start) < 10*time.Second, "run wait is cancelled when duration is exceeded")
+	assert.Contains(t, out, "Success: Triggered deployment of src/main/application with run ID 42\n")
+	assert.Equal(t, "Error: exceeded max duration of 500ms during deployment run\n"+
+		"Hint: Allow more time with --max-duration\n", errOut)
+	assert.Equal(t, networkFailureStatus, err.(ErrCLI).Status)
+
+	_, errOut = execute(command{homeDir: homeDir, args: []string{"deploy", "--max-duration", "soon"}}, t, client)
+	assert.Equal(t, "Error: invalid max duration: \"soon\": must be a positive duration\n", errOut)
+}
+
+func TestDurationBudgetContext(t *testing.T) {
+	parent := context.Background()
+	ctx, budget, err := startDurationBudget(parent, "")
+	assert.Nil(t, err)
+	assert.Nil(t, budget)
+	assert.Equal(t, parent, ctx)
+
+	ctx, budget, err = startDurationBudget(parent, "1h")
+	assert.Nil(t, err)
+	_, ok := ctx.Deadline()
+	assert.True(t, ok, "budget context has a deadline")
+	budget.stop()
+	assert.Equal(t, context.Canceled, ctx.Err())
+}
+
+func TestDeployPrintEndpoints(t *testing.T) {
+	client := &mockHttpClient{}
+	homeDir := setupCloudDeploy(t, client, mockApplicationPackage(t, false))
+
+	client.PathResponse("/application/v4/tenant/t1/application/a1/instance/i1/deploy/dev-region1", 200, `{"run":42}`)
+	client.PathResponse("/application/v4/tenant/t1/application/a1/instance/i1/job/dev-region1/run/42", 200, `{"active": false, "status": "success"}`)
+	client.PathResponse("/application/v4/tenant/t1/application/a1/instance/i1/environment/dev/region/region1", 200,
+		`{"endpoints": [{"cluster": "default", "url": "https://default.example.com", "scope": "zone"}]}`)
+	args := []string{"deploy", "-t", "cloud", "-a", "t1.a1.i1", "-z", "dev.region1", "--wait", "60", "--print-endpoints"}
+	processOut := captureProcessStdout(t)
+	out, errOut := execute(command{homeDir: homeDir, args: args}, t, client)
+	assert.Equal(t, "default https://default.example.com\n", out, "only endpoints are written to stdout")
+	assert.True(t, strings.HasSuffix(errOut, "is ready\n"), errOut)
+	assert.Equal(t, "", processOut(), "nothing is written to standard output of the process")
+
+	out, _ = execute(command{homeDir: homeDir, args: append(args, "--format", "json")}, t, client)
+	assert.Equal(t, `{"default":"https://default.example.com"}`+"\n", out)
+
+	// Endpoints are printed by zone when deploying to multiple zones
+	client.PathResponse("/application/v4/tenant/t1/application/a1/instance/i1/deploy/dev-region2", 200, `{"run":43}`)
+	client.PathResponse("/application/v4/tenant/t1/application/a1/instance/i1/job/dev-region2/run/43", 200, `{"active": false, "status": "success"}`)
+	client.PathResponse("/application/v4/tenant/t1/application/a1/instance/i1/environment/dev/region/region2", 200,
+		`{"endpoints": [{"cluster": "default", "url": "https://default.region2.example.com", "scope": "zone"}]}`)
+	args[6] = "dev.region1,dev.region2"
+	out, _ = execute(command{homeDir: homeDir, args: args}, t, client)
+	assert.Equal(t, "dev.region1 default https://default.example.com\n"+
+		"dev.region2 default https://default.region2.example.com\n", out)
+	out, _ = execute(command{homeDir: homeDir, args: append(args, "--format", "json")}, t, client)
+	assert.Equal(t, `{"dev.region1":{"default":"https://default.example.com"},"dev.region2":{"default":"https://default.region2.example.com"}}`+"\n", out)
+
+	_, errOut = execute(command{homeDir: homeDir, args: []string{"deploy", "--print-endpoints"}}, t, client)
+	assert.Equal(t, "Error: cannot print endpoints without waiting for the deployment\nHint: Try adding --wait\n", errOut)
+	_, errOut = execute(command{homeDir: homeDir, args: []string{"deploy", "--format", "yaml"}}, t, client)
+	assert.Equal(t, "Error: invalid format: \"yaml\": must be \"text\" or \"json\"\n", errOut)
+}
+
+func TestDeployRunLog(t *testing.T) {
+	client := &mockHttpClient{}
+	homeDir := setupCloudDeploy(t, client, mockApplicationPackage(t, false))
+
+	client.PathResponse("/application/v4/tenant/t1/application/a1/instance/i1/deploy/dev-region1", 200, `{"run":42}`)
+	client.PathResponse("/application/v4/tenant/t1/application/a1/instance/i1/job/dev-region1/run/42", 200,
+		`{"active": false, "status": "success", "lastId": 3,
+          "log": {"deployReal": [{"at": 1631707708431, "type": "info", "message": "Deploying ..."},
+                                 {"at": 1631707708432, "type": "info", "message": "Deployed"},
+                                 {"at": 1631707708433, "type": "info", "message": "Installation succeeded"}]}}`)
+	client.PathResponse("/application/v4/tenant/t1/application/a1/instance/i1/environment/dev/region/region1", 200,
+		`{"endpoints": [{"cluster": "default", "url": "https://default.example.com", "scope": "zone"}]}`)
+	logFile := filepath.Join(t.TempDir(), "deploy.log")
+	args := []string{"deploy", "-t", "cloud", "-a", "t1.a1.i1", "-z", "dev.region1", "--wait", "60", "--tail", "1", "--log-file", logFile}
+	out, errOut := execute(command{homeDir: homeDir, args: args}, t, client)
+	assert.Equal(t, "", errOut)
+	runLog := "Deploying ...\n" +
+		"Deployed\n" +
+		"Installation succeeded\n"
+	logLines := func(s string) string {
+		var lines []string
+		for _, line := range strings.SplitAfter(s, "\n") {
+			if strings.Contains(line, "] info    ") {
+				lines = append(lines, line[strings.Index(line, "info    ")+len("info    "):])
+			}
+		}
+		return strings.Join(lines, "")
+	}
+	assert.Equal(t, runLog, logLines(out), "all lines are printed when output is not a terminal")
+	data, err := ioutil.ReadFile(logFile)
+	assert.Nil(t, err)
+	assert.Equal(t, runLog, logLines(string(data)))
+
+	_, errOut = execute(command{homeDir: homeDir, args: []string{"deploy", "--tail", "-1"}}, t, client)
+	assert.Equal(t, "Error: invalid tail: -1: must be 0 or positive\n", errOut)
+}
+
+func TestDeployDowngrade(t *testing.T) {
+	pkgDir := mockApplicationPackage(t, false)
+	appDir := filepath.Join(pkgDir, "src", "main", "application")
+	cwd, err := os.Getwd()
+	assert.Nil(t, err)
+	defer os.Chdir(cwd)
+	assert.Nil(t, os.Chdir(pkgDir))
+	client := &mockHttpClient{}
+	client.PathResponse("/application/v2/tenant/default/application/default/environment/prod/region/default/instance/default/content/build-meta.json",
+		200, `{"version": "1.2.0"}`)
+	for _, tt := range []struct {
+		version string
+		args    []string
+		errOut  string
+	}{
+		{"1.3.0", nil, ""},
+		{"1.2.0", nil, ""},
+		{"1.1.0", nil, "Error: application package version 1.1.0 is older than deployed version 1.2.0\nHint: Use --force to deploy it anyway\n"},
+		{"1.1.0", []string{"--force"}, "Warning: Downgrading: application package version 1.1.0 is older than deployed version 1.2.0\n"},
+	} {
+		assert.Nil(t, ioutil.WriteFile(filepath.Join(appDir, "build-meta.json"), []byte(`{"version": "`+tt.version+`"}`), 0644))
+		client.requests = nil
+		out, errOut := execute(command{args: append([]string{"deploy"}, tt.args...)}, t, client)
+		assert.Equal(t, tt.errOut, errOut, tt.version)
+		if strings.HasPrefix(tt.errOut, "Error:") {
+			assert.Equal(t, 1, len(client.requests), "nothing is deployed")
+		} else {
+			assert.Equal(t, "\nSuccess: Deployed src/main/application\n", out)
+		}
+	}
+}
+
+func TestDeployMultipleZonesDowngrade(t *testing.T) {
+	client := &mockHttpClient{}
+	pkgDir := mockApplicationPackage(t, false)
+	homeDir := setupCloudDeploy(t, client, pkgDir)
+	assert.Nil(t, ioutil.WriteFile(filepath.Join(pkgDir, "src", "main", "application", "build-meta.json"), []byte(`{"version": "1.1.0"}`), 0644))
+
+	client.PathResponse("/application/v4/tenant/t1/application/a1/instance/i1/environment/dev/region/region1/content/build-meta.json", 200, `{"version": "1.0.0"}`)
+	client.PathResponse("/application/v4/tenant/t1/application/a1/instance/i1/environment/dev/region/region2/content/build-meta.json", 200, `{"version": "1.2.0"}`)
+	args := []string{"deploy", "-t", "cloud", "-a", "t1.a1.i1", "-z", "dev.region1,dev.region2"}
+	_, errOut := execute(command{homeDir: homeDir, args: args}, t, client)
+	assert.Equal(t, "Error: application package version 1.1.0 is older than deployed version 1.2.0\nHint: Use --force to deploy it anyway\n", errOut)
+	for _, req := range client.requests {
+		assert.Equal(t, "GET", req.Method, "nothing is deployed")
+	}
+}
+
+// captureProcessStdout replaces the standard output of the process with a pipe, until the returned function is called.
+// The function returns what was written to the pipe.
+func captureProcessStdout(t *testing.T) func() string {
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	realStdout := os.Stdout
+	os.Stdout = w
+	var buf bytes.Buffer
+	done := make(chan struct{})
+	go func() {
+		io.Copy(&buf, r)
+		close(done)
+	}()
+	return func() string {
+		os.Stdout = realStdout
+		w.Close()
+		<-done
+		r.Close()
+		return buf.String()
+	}
+}
+
 func assertDeploy(applicationPackage string, arguments []string, t *testing.T) {
 	client := &mockHttpClient{}
 	assert.Equal(t,
-		"Success: Deployed "+applicationPackage+"\n",
+		"\nSuccess: Deployed "+applicationPackage+"\n",
 		executeCommand(t, client, arguments, []string{}))
 	assertDeployRequestMade("http://127.0.0.1:19071", client, t)
 }
@@ -176,3 +699,25 @@ func assertDeployServerError(t *testing.T, status int, errorMessage string) {
 		"Error: error from deploy service at 127.0.0.1:19071 (Status "+strconv.Itoa(status)+"):\n"+errorMessage+"\n",
 		outErr)
 }
+
+func TestDeploySampleDocs(t *testing.T) {
+	pkgPath := "testdata/applications/withSource/src/main/application"
+	docsFile := filepath.Join(t.TempDir(), "docs.jsonl")
+	docs := `{"put": "id:ns:msmarco::1", "fields": {"id": "1", "title": "foo", "title_bert": [1.0, 2.0]}}
+{"put": "id:ns:msmarco::2", "fields": {"title": 42, "author": "bar"}}
+`
+	assert.Nil(t, ioutil.WriteFile(docsFile, []byte(docs), 0644))
+	client := &mockHttpClient{}
+	_, outErr := execute(command{args: []string{"deploy", "--sample-docs", docsFile, pkgPath}}, t, client)
+	assert.Equal(t, docsFile+`: line 2: id:ns:msmarco::2: unknown field "author" in schema msmarco`+"\n"+
+		docsFile+`: line 2: id:ns:msmarco::2: field "title" of type string: expected a string, got a number`+"\n"+
+		"Error: 1 of 2 sample documents in "+docsFile+" are invalid\n"+
+		"Hint: Correct the documents or the schemas, and deploy again\n", outErr)
+	assert.Nil(t, client.lastRequest, "nothing is deployed")
+
+	assert.Nil(t, ioutil.WriteFile(docsFile, []byte(strings.SplitAfter(docs, "\n")[0]), 0644))
+	client.NextResponse(200, `{"session-id":"42"}`)
+	out, outErr := execute(command{args: []string{"deploy", "--sample-docs", docsFile, pkgPath}}, t, client)
+	assert.Equal(t, "", outErr)
+	assert.True(t, strings.HasPrefix(out, "Validated 1 sample documents in "+docsFile+" against the schemas of "+pkgPath+"\n"), out)
+}
@@ -5,6 +5,7 @@
 package cmd
 
 import (
+	"bufio"
 	"fmt"
 	"io"
 	"io/ioutil"
@@ -19,6 +20,13 @@ import (
 var (
 	printCurl      bool
 	docTimeoutSecs int
+	compressArg    bool
+	selectionArg   string
+	clusterArg     string
+	docRetriesArg  int
+
+	// documentRetryInterval is the interval between attempts of a document operation.
+	documentRetryInterval = time.Second
 )
 
 func init() {
@@ -29,6 +37,11 @@ func init() {
 	documentCmd.AddCommand(documentGetCmd)
 	documentCmd.PersistentFlags().BoolVarP(&printCurl, "verbose", "v", false, "Print the equivalent curl command for the document operation")
 	documentCmd.PersistentFlags().IntVarP(&docTimeoutSecs, "timeout", "T", 60, "Timeout for the document request in seconds")
+	documentCmd.PersistentFlags().BoolVarP(&compressArg, "compress", "", false, "Compress large document operations using gzip")
+	documentCmd.PersistentFlags().IntVarP(&docRetriesArg, "retries", "r", 0, "Number of times to retry the document operation if it fails to connect, or the service is unavailable")
+	documentRemoveCmd.Flags().StringVarP(&selectionArg, "selection", "s", "", "Remove all documents matching this document selection")
+	documentRemoveCmd.Flags().StringVarP(&clusterArg, "cluster", "", "", "The content cluster to remove documents from, when using --selection")
+	documentRemoveCmd.Flags().StringVarP(&zoneArg, zoneFlag, "", "dev.aws-us-east-1c", "The zone to remove documents from")
 }
 
 var documentCmd = &cobra.Command{
@@ -108,13 +121,27 @@ var documentRemoveCmd = &cobra.Command{
 	Use:   "remove id | json-file",
 	Short: "Removes a document from Vespa",
 	Long: `Removes the document specified either as a document id or given in the json file.
-If the document id is specified both as an argument and in the file the argument takes precedence.`,
-	Args: cobra.ExactArgs(1),
+If the document id is specified both as an argument and in the file the argument takes precedence.
+
+All documents matching a document selection can be removed with --selection,
+see https://docs.vespa.ai/en/reference/document-select-language.html.
+Removing documents from a production zone requires confirmation.`,
+	Args: cobra.MaximumNArgs(1),
 	Example: `$ vespa document remove src/test/resources/A-Head-Full-of-Dreams-Remove.json
-$ vespa document remove id:mynamespace:music::a-head-full-of-dreams`,
+$ vespa document remove id:mynamespace:music::a-head-full-of-dreams
+$ vespa document remove --selection 'music.year < 2000' --cluster music`,
 	DisableAutoGenTag: true,
 	SilenceUsage:      true,
 	RunE: func(cmd *cobra.Command, args []string) error {
+		if selectionArg != "" {
+			if len(args) > 0 {
+				return fmt.Errorf("cannot combine --selection with document id or file: %s", args[0])
+			}
+			return removeWhere(selectionArg, clusterArg)
+		}
+		if len(args) == 0 {
+			return errHint(fmt.Errorf("no document id or file given"), "Try giving a document id, a json file or --selection")
+		}
 		service, err := documentService()
 		if err != nil {
 			return err
@@ -143,12 +170,48 @@ var documentGetCmd = &cobra.Command{
 	},
 }
 
+func removeWhere(selection, cluster string) error {
+	targetType, err := getTargetType()
+	if err != nil {
+		return err
+	}
+	zone, err := vespa.ZoneFromString(zoneArg)
+	if err != nil {
+		return err
+	}
+	if targetType == "cloud" && zone.Environment == "prod" {
+		question := fmt.Sprintf("Remove all documents matching '%s' in %s? (y/n)", selection, zoneArg)
+		answer, err := prompt(bufio.NewReader(stdin), question, "n", func(input string) error {
+			if input != "y" && input != "n" {
+				return fmt.Errorf("please answer y or n")
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		if answer != "y" {
+			return fmt.Errorf("removal of documents in %s cancelled", zoneArg)
+		}
+	}
+	service, err := documentService()
+	if err != nil {
+		return err
+	}
+	return printResult(vespa.RemoveWhere(selection, cluster, service, operationOptions()), false)
+}
+
 func documentService() (*vespa.Service, error) { return getService("document", 0, "") }
 
 func operationOptions() vespa.OperationOptions {
 	return vespa.OperationOptions{
 		CurlOutput: curlOutput(),
 		Timeout:    time.Second * time.Duration(docTimeoutSecs),
+		Compress:   compressArg,
+
+		Retries:       docRetriesArg,
+		RetryInterval: documentRetryInterval,
+		Context:       commandContext,
 	}
 }
 
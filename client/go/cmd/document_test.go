@@ -5,9 +5,17 @@
 package cmd
 
 import (
+	"bytes"
+	"compress/gzip"
+	"errors"
 	"io/ioutil"
+	"net"
+	"net/url"
+	"path/filepath"
 	"strconv"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/vespa-engine/vespa/client/go/util"
@@ -95,6 +103,110 @@ func TestDocumentGet(t *testing.T) {
 		"id:mynamespace:music::a-head-full-of-dreams", t)
 }
 
+func TestDocumentPutCompressed(t *testing.T) {
+	largeDoc := `{"put": "id:mynamespace:music::large", "fields": {"text": "` + strings.Repeat("lorem ipsum ", 200) + `"}}`
+	docFile := filepath.Join(t.TempDir(), "large.json")
+	if err := ioutil.WriteFile(docFile, []byte(largeDoc), 0644); err != nil {
+		t.Fatal(err)
+	}
+	client := &mockHttpClient{}
+	out, _ := execute(command{args: []string{"document", "put", "--compress", docFile}}, t, client)
+	assert.Equal(t, "Success: put id:mynamespace:music::large\n", out)
+	assert.Equal(t, "gzip", client.lastRequest.Header.Get("Content-Encoding"))
+	assert.True(t, client.lastRequest.ContentLength < int64(len(largeDoc)))
+	r, err := gzip.NewReader(client.lastRequest.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, largeDoc, util.ReaderToString(r))
+
+	// Small documents are not compressed
+	execute(command{args: []string{"document", "put", "--compress", "testdata/A-Head-Full-of-Dreams-Put.json"}}, t, client)
+	assert.Equal(t, "", client.lastRequest.Header.Get("Content-Encoding"))
+	expectedPayload, _ := ioutil.ReadFile("testdata/A-Head-Full-of-Dreams-Put.json")
+	assert.Equal(t, string(expectedPayload), util.ReaderToString(client.lastRequest.Body))
+}
+
+func TestDocumentPutRetries(t *testing.T) {
+	defer func(interval time.Duration) { documentRetryInterval = interval }(documentRetryInterval)
+	documentRetryInterval = 0
+	client := &mockHttpClient{}
+	client.NextResponse(503, "Unavailable")
+	client.NextError(&url.Error{Op: "Post", URL: "http://127.0.0.1:8080/document/v1/", Err: &net.OpError{Op: "dial", Err: errors.New("connection refused")}})
+	out, _ := execute(command{args: []string{"document", "put", "--retries", "2", "testdata/A-Head-Full-of-Dreams-Put.json"}}, t, client)
+	assert.Equal(t, "Success: put id:mynamespace:music::a-head-full-of-dreams\n", out)
+	assert.Equal(t, 3, len(client.requests))
+
+	payload, err := ioutil.ReadFile("testdata/A-Head-Full-of-Dreams-Put.json")
+	assert.Nil(t, err)
+	for _, req := range client.requests {
+		assert.Equal(t, string(payload), util.ReaderToString(req.Body))
+	}
+
+	// Retrying stops when the command is cancelled
+	documentRetryInterval = time.Hour
+	client.requests = nil
+	client.NextResponse(503, "Unavailable")
+	deadline := time.Now().Add(time.Second).Format(time.RFC3339)
+	start := time.Now()
+	_, outErr := execute(command{args: []string{"document", "put", "--retries", "2", "--deadline", deadline, "testdata/A-Head-Full-of-Dreams-Put.json"}}, t, client)
+	assert.True(t, time.Since(start) < 10*time.Second, "retrying is cancelled")
+	assert.Contains(t, outErr, "deadline exceeded")
+	assert.Equal(t, 1, len(client.requests))
+	documentRetryInterval = 0
+
+	// Acknowledged operations are not retried
+	client.requests = nil
+	execute(command{args: []string{"document", "put", "--retries", "2", "testdata/A-Head-Full-of-Dreams-Put.json"}}, t, client)
+	assert.Equal(t, 1, len(client.requests))
+
+	// Operations which may have been applied are not retried
+	for _, status := range []int{400, 500, 504} {
+		client.requests = nil
+		client.NextResponse(status, "Failed")
+		execute(command{args: []string{"document", "put", "--retries", "2", "testdata/A-Head-Full-of-Dreams-Put.json"}}, t, client)
+		assert.Equal(t, 1, len(client.requests), "status %d is not retried", status)
+	}
+	client.requests = nil
+	client.NextError(&url.Error{Op: "Post", URL: "http://127.0.0.1:8080/document/v1/", Err: errors.New("timeout awaiting response headers")})
+	execute(command{args: []string{"document", "put", "--retries", "2", "testdata/A-Head-Full-of-Dreams-Put.json"}}, t, client)
+	assert.Equal(t, 1, len(client.requests))
+}
+
+func TestDocumentRemoveWhere(t *testing.T) {
+	client := &mockHttpClient{}
+	client.NextResponse(200, `{"pathId": "/document/v1/", "documentCount": 2, "continuation": "AAAA"}`)
+	client.NextResponse(200, `{"pathId": "/document/v1/", "documentCount": 1}`)
+	out, errOut := execute(command{args: []string{"document", "remove", "--selection", "music.year < 2000", "--cluster", "music"}}, t, client)
+	assert.Equal(t, "", errOut)
+	assert.Equal(t, "Success: Removed 3 documents matching 'music.year < 2000'\n", out)
+	assert.Equal(t, 2, len(client.requests))
+	for i, req := range client.requests {
+		assert.Equal(t, "DELETE", req.Method)
+		assert.Equal(t, "/document/v1/", req.URL.Path)
+		assert.Equal(t, "music.year < 2000", req.URL.Query().Get("selection"))
+		assert.Equal(t, "music", req.URL.Query().Get("cluster"))
+		if i == 0 {
+			assert.Equal(t, "", req.URL.Query().Get("continuation"))
+		} else {
+			assert.Equal(t, "AAAA", req.URL.Query().Get("continuation"))
+		}
+	}
+
+	_, errOut = execute(command{args: []string{"document", "remove", "--selection", "true", "id:mynamespace:music::a"}}, t, client)
+	assert.Equal(t, "Error: cannot combine --selection with document id or file: id:mynamespace:music::a\n", errOut)
+}
+
+func TestDocumentRemoveWhereInProd(t *testing.T) {
+	client := &mockHttpClient{}
+	var buf bytes.Buffer
+	buf.WriteString("n\n")
+	out, errOut := execute(command{stdin: &buf, args: []string{"document", "remove", "-t", "cloud", "-a", "t1.a1.i1", "--zone", "prod.aws-us-east-1c", "--selection", "true"}}, t, client)
+	assert.Equal(t, "Remove all documents matching 'true' in prod.aws-us-east-1c? (y/n) [n] ", out)
+	assert.Equal(t, "Error: removal of documents in prod.aws-us-east-1c cancelled\n", errOut)
+	assert.Equal(t, 0, len(client.requests))
+}
+
 func assertDocumentSend(arguments []string, expectedOperation string, expectedMethod string, expectedDocumentId string, expectedPayloadFile string, t *testing.T) {
 	client := &mockHttpClient{}
 	documentURL, err := documentServiceURL(client)
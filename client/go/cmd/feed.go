@@ -0,0 +1,84 @@
+// Copyright Yahoo. Licensed under the terms of the Apache 2.0 license. See LICENSE in the project root.
+// vespa feed command
+package cmd
+
+import (
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/spf13/cobra"
+	"github.com/vespa-engine/vespa/client/go/vespa"
+)
+
+var feedDryRunArg bool
+
+func init() {
+	rootCmd.AddCommand(feedCmd)
+	feedCmd.Flags().BoolVarP(&feedDryRunArg, "dry-run", "", false, "Validate the document operations against the schemas of the application package, without sending them")
+	feedCmd.MarkFlagRequired("dry-run")
+}
+
+var feedCmd = &cobra.Command{
+	Use:   "feed --dry-run jsonl-file [application-directory]",
+	Short: "Validate document operations before feeding them to Vespa",
+	Long: `Validate document operations before feeding them to Vespa.
+
+The file must contain one document operation per line, on the format documented in
+https://docs.vespa.ai/en/reference/document-json-format.html#document-operations
+
+Nothing is sent, and --dry-run is required. Each operation is checked to be
+valid JSON with a valid document ID, of a document type which has a schema in
+the application package, and with fields declared in that schema. The number
+of operations and each invalid operation are reported, with its line number.
+
+To feed the operations, https://docs.vespa.ai/en/vespa-feed-client.html should
+be used.`,
+	Example: `$ vespa feed --dry-run docs.jsonl
+$ vespa feed --dry-run docs.jsonl src/main/application`,
+	DisableAutoGenTag: true,
+	SilenceUsage:      true,
+	Args:              cobra.RangeArgs(1, 2),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if !feedDryRunArg {
+			return errHint(fmt.Errorf("feeding is not supported, only validation with --dry-run"),
+				"Feed with vespa-feed-client: https://docs.vespa.ai/en/vespa-feed-client.html")
+		}
+		pkg, err := findApplicationPackage(args[1:], false)
+		if err != nil {
+			return err
+		}
+		defer pkg.Close()
+		return validateDocuments(pkg, args[0], "document operations", "Correct the document operations or the schemas, and try again")
+	},
+}
+
+// validateDocuments validates the document operations in the JSONL file docsFile against the schemas of pkg, and reports
+// each invalid operation. Noun names the operations in messages, and hint tells how to proceed when any are invalid.
+func validateDocuments(pkg vespa.ApplicationPackage, docsFile, noun, hint string) error {
+	schemas, err := pkg.Schemas()
+	if err != nil {
+		return fmt.Errorf("could not read schemas of %s: %w", pkg.Name(), err)
+	}
+	f, err := os.Open(docsFile)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	issues, count, err := vespa.ValidateSampleDocuments(f, schemas)
+	if err != nil {
+		return fmt.Errorf("could not read %s from %s: %w", noun, docsFile, err)
+	}
+	if len(issues) == 0 {
+		log.Printf("Validated %d %s in %s against the schemas of %s", count, noun, color.Cyan(docsFile), color.Cyan(pkg.Name()))
+		return nil
+	}
+	invalid := make(map[int]bool)
+	for _, issue := range issues {
+		fmt.Fprintf(stderr, "%s: %s\n", docsFile, issue)
+		invalid[issue.Line] = true
+	}
+	return ErrCLI{Status: validationFailureStatus,
+		hints: []string{hint},
+		error: fmt.Errorf("%d of %d %s in %s are invalid", len(invalid), count, noun, docsFile)}
+}
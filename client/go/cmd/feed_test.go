@@ -0,0 +1,49 @@
+// Copyright Yahoo. Licensed under the terms of the Apache 2.0 license. See LICENSE in the project root.
+package cmd
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFeedDryRun(t *testing.T) {
+	pkgPath := "testdata/applications/withSource/src/main/application"
+	feedFile := filepath.Join(t.TempDir(), "docs.jsonl")
+	docs := `{"put": "id:ns:msmarco::1", "fields": {"id": "1", "title": "foo"}}
+{"put": "id:ns:msmarco::2", "fields": {"title": "bar"
+
+{"put": "id:ns:album::3", "fields": {}}
+{"remove": "id:ns:msmarco::4"}
+{"put": "msmarco::5", "fields": {}}
+`
+	assert.Nil(t, ioutil.WriteFile(feedFile, []byte(docs), 0644))
+	client := &mockHttpClient{}
+	_, outErr := execute(command{args: []string{"feed", "--dry-run", feedFile, pkgPath}}, t, client)
+	assert.Equal(t, feedFile+": line 2: invalid JSON: unexpected end of JSON input\n"+
+		feedFile+`: line 4: id:ns:album::3: no schema for document type "album"`+"\n"+
+		feedFile+": line 6: msmarco::5: invalid document ID: must be on the form id:<namespace>:<document-type>:<key/value-pairs>:<user-specified>\n"+
+		"Error: 3 of 5 document operations in "+feedFile+" are invalid\n"+
+		"Hint: Correct the document operations or the schemas, and try again\n", outErr)
+	assert.Nil(t, client.lastRequest, "nothing is sent")
+
+	assert.Nil(t, ioutil.WriteFile(feedFile, []byte(`{"put": "id:ns:msmarco::1", "fields": {"id": "1"}}`+"\n"), 0644))
+	out, outErr := execute(command{args: []string{"feed", "--dry-run", feedFile, pkgPath}}, t, client)
+	assert.Equal(t, "", outErr)
+	assert.Equal(t, "Validated 1 document operations in "+feedFile+" against the schemas of "+pkgPath+"\n", out)
+	assert.Nil(t, client.lastRequest, "nothing is sent")
+}
+
+func TestFeedRequiresDryRun(t *testing.T) {
+	feedFile := filepath.Join(t.TempDir(), "docs.jsonl")
+	assert.Nil(t, ioutil.WriteFile(feedFile, []byte(`{"put": "id:ns:music::1", "fields": {"title": "foo"}}`+"\n"), 0644))
+	client := &mockHttpClient{}
+	_, outErr := execute(command{args: []string{"feed", feedFile}}, t, client)
+	assert.Equal(t, "Error: required flag(s) \"dry-run\" not set\n", outErr)
+	_, outErr = execute(command{args: []string{"feed", "--dry-run=false", feedFile}}, t, client)
+	assert.Equal(t, "Error: feeding is not supported, only validation with --dry-run\n"+
+		"Hint: Feed with vespa-feed-client: https://docs.vespa.ai/en/vespa-feed-client.html\n", outErr)
+	assert.Nil(t, client.lastRequest, "nothing is sent")
+}
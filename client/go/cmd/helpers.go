@@ -5,15 +5,24 @@
 package cmd
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
+	"io/ioutil"
 	"log"
 	"os"
 	"path/filepath"
+	"strconv"
 	"strings"
+	"sync"
 	"time"
 
+	"github.com/vespa-engine/vespa/client/go/auth0"
+	"github.com/vespa-engine/vespa/client/go/util"
 	"github.com/vespa-engine/vespa/client/go/vespa"
+	"github.com/vespa-engine/vespa/client/go/vespa/xml"
 )
 
 func printErrHint(err error, hints ...string) {
@@ -24,15 +33,29 @@ func printErrHint(err error, hints ...string) {
 }
 
 func printErr(err error) {
-	fmt.Fprintln(stderr, color.Red("Error:"), err)
+	msg := util.Redact(err.Error())
+	if annotation, ok := ciError(msg); ok {
+		fmt.Fprintln(stderr, annotation)
+		return
+	}
+	fmt.Fprintln(stderr, color.Red("Error:"), msg)
 }
 
 func printSuccess(msg ...interface{}) {
+	if annotation, ok := ciNotice("Success: " + fmt.Sprint(msg...)); ok {
+		log.Print(annotation)
+		return
+	}
 	log.Print(color.Green("Success: "), fmt.Sprint(msg...))
 }
 
+// vespaCliHome returns the directory holding config, credentials and session state, creating it if necessary. This is
+// given by the --config-dir flag, the VESPA_CLI_HOME environment variable or $HOME/.vespa, in that order.
 func vespaCliHome() (string, error) {
-	home := os.Getenv("VESPA_CLI_HOME")
+	home := configDirArg
+	if home == "" {
+		home = os.Getenv("VESPA_CLI_HOME")
+	}
 	if home == "" {
 		userHome, err := os.UserHomeDir()
 		if err != nil {
@@ -61,8 +84,9 @@ func vespaCliCacheDir() (string, error) {
 	return cacheDir, nil
 }
 
-func deploymentFromArgs() (vespa.Deployment, error) {
-	zone, err := vespa.ZoneFromString(zoneArg)
+// deploymentInZone returns the deployment of the configured application in zone.
+func deploymentInZone(zoneName string) (vespa.Deployment, error) {
+	zone, err := vespa.ZoneFromString(zoneName)
 	if err != nil {
 		return vespa.Deployment{}, err
 	}
@@ -80,6 +104,22 @@ func applicationSource(args []string) string {
 	return "."
 }
 
+// findApplicationPackage finds the application package in the directory given by args, or at the root given by the
+// package root flag.
+func findApplicationPackage(args []string, requirePackaging bool) (vespa.ApplicationPackage, error) {
+	if packageRootArg != "" {
+		if len(args) > 0 {
+			return vespa.ApplicationPackage{}, fmt.Errorf("cannot combine --%s with application directory %s", packageRootFlag, args[0])
+		}
+		return vespa.ApplicationPackageAt(packageRootArg)
+	}
+	pkg, err := vespa.FindApplicationPackage(applicationSource(args), requirePackaging)
+	if errors.Is(err, vespa.ErrAmbiguousApplicationPackage) {
+		return pkg, errHint(err, "Select one with --"+packageRootFlag+", or give its directory as argument")
+	}
+	return pkg, err
+}
+
 func getApplication() (vespa.ApplicationID, error) {
 	cfg, err := LoadConfig()
 	if err != nil {
@@ -93,6 +133,9 @@ func getApplication() (vespa.ApplicationID, error) {
 	if err != nil {
 		return vespa.ApplicationID{}, errHint(err, "application format is <tenant>.<app>.<instance>")
 	}
+	if err := xml.ValidateInstanceName(application.Instance); err != nil {
+		return vespa.ApplicationID{}, err
+	}
 	return application, nil
 }
 
@@ -109,64 +152,396 @@ func getTargetType() (string, error) {
 }
 
 func getService(service string, sessionOrRunID int64, cluster string) (*vespa.Service, error) {
+	return getServiceWithRunLog(commandContext, service, sessionOrRunID, cluster, stdout)
+}
+
+// getServiceWithRunLog works like getService, but waits with ctx, and writes the log of deployment run sessionOrRunID to
+// runLog while waiting for the run to complete.
+func getServiceWithRunLog(ctx context.Context, service string, sessionOrRunID int64, cluster string, runLog io.Writer) (*vespa.Service, error) {
 	t, err := getTarget()
 	if err != nil {
 		return nil, err
 	}
+	t.SetRunLogWriter(runLog)
 	timeout := time.Duration(waitSecsArg) * time.Second
 	if timeout > 0 {
 		log.Printf("Waiting up to %d %s for %s service to become available ...", color.Cyan(waitSecsArg), color.Cyan("seconds"), color.Cyan(service))
 	}
-	s, err := t.Service(service, timeout, sessionOrRunID, cluster)
-	if err != nil {
+	s, err := t.Service(ctx, service, timeout, sessionOrRunID, cluster)
+	if errors.Is(err, vespa.ErrNotDeployed) {
+		return nil, errHint(fmt.Errorf("service %s not found: %w", service, err), "Try 'vespa deploy'")
+	} else if err != nil {
 		return nil, fmt.Errorf("service %s not found: %w", service, err)
 	}
+	cfg, err := LoadConfig()
+	if err != nil {
+		return nil, err
+	}
+	if healthPath, err := cfg.Get(healthPathOption + "." + service); err == nil {
+		s.HealthPath = healthPath
+	}
 	return s, nil
 }
 
+var (
+	// newAuth0 creates an Auth0 instance. This is a variable so that it can be overridden in tests.
+	newAuth0     = auth0.GetAuth0WithConfig
+	auth0Mu      sync.Mutex
+	auth0Current *auth0.Auth0
+	auth0Key     string
+)
+
+// getAuth0 returns the Auth0 instance for the auth config and system of cfg. The instance is created once and shared by
+// all commands in this process, so that its config is read only once.
+func getAuth0(cfg *Config) (*auth0.Auth0, error) {
+	auth0Mu.Lock()
+	defer auth0Mu.Unlock()
+	override, err := getDeviceFlowConfig(cfg)
+	if err != nil {
+		return nil, err
+	}
+	system, err := getCloudSystem()
+	if err != nil {
+		return nil, err
+	}
+	key := strings.Join([]string{cfg.AuthConfigPath(), system.Name, system.APIURL,
+		override.Audience, override.ClientID, override.DeviceCodeEndpoint, override.OauthTokenEndpoint}, "\x00")
+	if auth0Current != nil && auth0Key == key {
+		return auth0Current, nil
+	}
+	a, err := newAuth0(cfg.AuthConfigPath(), system.Name, system.APIURL, override)
+	if err != nil {
+		return nil, err
+	}
+	auth0Current = a
+	auth0Key = key
+	return a, nil
+}
+
+// getDeviceFlowConfig returns the OAuth device flow config overriding the one of the system, if any. Options set with
+// auth.audience, auth.client-id, auth.device-code-endpoint and auth.oauth-token-endpoint take precedence over the
+// file given by auth.config-file.
+func getDeviceFlowConfig(cfg *Config) (auth0.DeviceFlowConfig, error) {
+	var c auth0.DeviceFlowConfig
+	if path, err := cfg.Get(authConfigOption + ".config-file"); err == nil {
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			return auth0.DeviceFlowConfig{}, errHint(fmt.Errorf("could not read auth config: %w", err), "Verify the value of the auth.config-file option")
+		}
+		if err := json.Unmarshal(data, &c); err != nil {
+			return auth0.DeviceFlowConfig{}, errHint(fmt.Errorf("invalid auth config in %s: %w", path, err), "Verify the value of the auth.config-file option")
+		}
+	}
+	options := map[string]*string{
+		"audience":             &c.Audience,
+		"client-id":            &c.ClientID,
+		"device-code-endpoint": &c.DeviceCodeEndpoint,
+		"oauth-token-endpoint": &c.OauthTokenEndpoint,
+	}
+	for name, field := range options {
+		if value, err := cfg.Get(authConfigOption + "." + name); err == nil {
+			*field = value
+		}
+	}
+	return c, nil
+}
+
 func getEndpointsOverride() string { return os.Getenv("VESPA_CLI_ENDPOINTS") }
 
+func getMaxConcurrency() (int, error) {
+	s := os.Getenv("VESPA_CLI_MAX_CONCURRENCY")
+	if s == "" {
+		return 0, nil
+	}
+	n, err := strconv.Atoi(s)
+	if err != nil || n < 0 {
+		return 0, errHint(fmt.Errorf("invalid value for VESPA_CLI_MAX_CONCURRENCY: %q", s), "Must be an integer >= 0")
+	}
+	return n, nil
+}
+
+// getConvergenceStableFor returns how long services on a custom target must report convergence before they are
+// considered ready.
+func getConvergenceStableFor() (time.Duration, error) {
+	if stableForArg == "" {
+		return 0, nil
+	}
+	d, err := time.ParseDuration(stableForArg)
+	if err != nil || d < 0 {
+		return 0, fmt.Errorf("invalid stable duration: %q: must be a non-negative duration", stableForArg)
+	}
+	return d, nil
+}
+
+// getRetryInterval returns the interval between requests when waiting for a target, or 0 to use the default.
+func getRetryInterval() (time.Duration, error) {
+	if retryIntervalArg == "" {
+		return 0, nil
+	}
+	d, err := time.ParseDuration(retryIntervalArg)
+	if err != nil || d <= 0 {
+		return 0, fmt.Errorf("invalid retry interval: %q: must be a positive duration", retryIntervalArg)
+	}
+	return d, nil
+}
+
+// getSyncClock returns whether the clock used for signing Vespa Cloud API requests is synchronized with the API server,
+// as given by the sync-clock option.
+func getSyncClock(cfg *Config) (bool, error) {
+	s, err := cfg.Get(syncClockOption)
+	if err != nil {
+		return false, nil
+	}
+	syncClock, err := strconv.ParseBool(s)
+	if err != nil {
+		return false, errHint(fmt.Errorf("invalid value for %s option: %q", syncClockOption, s), "Must be \"true\" or \"false\"")
+	}
+	return syncClock, nil
+}
+
 func getSystem() string { return os.Getenv("VESPA_CLI_CLOUD_SYSTEM") }
 
-func getSystemName() string {
-	if getSystem() == "publiccd" {
-		return "publiccd"
+// getCloudSystem returns the Vespa Cloud system to use. This is the system described by the discovery document of
+// the configured control plane, if any, and otherwise the known system named by VESPA_CLI_CLOUD_SYSTEM.
+func getCloudSystem() (vespa.System, error) {
+	d, err := getControlPlane()
+	if err != nil {
+		return vespa.System{}, err
 	}
-	return "public"
+	if d != nil {
+		return d.AsSystem(), nil
+	}
+	if s, err := vespa.GetSystem(getSystem()); err == nil {
+		return s, nil
+	}
+	return vespa.PublicSystem, nil
 }
 
-func getConsoleURL() string {
-	if getSystem() == "publiccd" {
-		return "https://console-cd.vespa.oath.cloud"
+func getConsoleURL() (string, error) {
+	system, err := getCloudSystem()
+	return system.ConsoleURL, err
+}
+
+func getApiURL() (string, error) {
+	system, err := getCloudSystem()
+	return system.APIURL, err
+}
+
+// discoveryTTL is the duration for which a discovery document cached on disk is used.
+const discoveryTTL = 24 * time.Hour
+
+var (
+	discoveryMu      sync.Mutex
+	discoveryCurrent *vespa.Discovery
+	discoveryURL     string
+)
+
+// getControlPlane returns the discovery document of the configured control plane, or nil if no control plane is
+// configured. The document is cached for the lifetime of this process, and on disk for discoveryTTL.
+func getControlPlane() (*vespa.Discovery, error) {
+	cfg, err := LoadConfig()
+	if err != nil {
+		return nil, err
+	}
+	baseURL, err := cfg.Get(controlPlaneOption)
+	if err != nil {
+		return nil, nil // Not configured
+	}
+	discoveryMu.Lock()
+	defer discoveryMu.Unlock()
+	if discoveryCurrent != nil && discoveryURL == baseURL {
+		return discoveryCurrent, nil
+	}
+	cachePath := ""
+	if cacheDir, err := vespaCliCacheDir(); err == nil {
+		cachePath = filepath.Join(cacheDir, "discovery.json")
+	}
+	d, err := readCachedDiscovery(cachePath, baseURL)
+	if err != nil {
+		discovered, err := vespa.Discover(baseURL)
+		if err != nil {
+			return nil, errHint(fmt.Errorf("could not discover control plane at %s: %w", baseURL, err),
+				"Verify the value of the control-plane option")
+		}
+		d = discovered
+		writeCachedDiscovery(cachePath, baseURL, d)
 	}
-	return "https://console.vespa.oath.cloud"
+	discoveryCurrent = &d
+	discoveryURL = baseURL
+	return discoveryCurrent, nil
+}
 
+type cachedDiscovery struct {
+	URL       string          `json:"url"`
+	Discovery vespa.Discovery `json:"discovery"`
 }
 
-func getApiURL() string {
-	if getSystem() == "publiccd" {
-		return "https://api.vespa-external-cd.aws.oath.cloud:4443"
+func readCachedDiscovery(path, baseURL string) (vespa.Discovery, error) {
+	if path == "" {
+		return vespa.Discovery{}, fmt.Errorf("no cache")
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return vespa.Discovery{}, err
+	}
+	if time.Since(info.ModTime()) > discoveryTTL {
+		return vespa.Discovery{}, fmt.Errorf("cache expired")
+	}
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return vespa.Discovery{}, err
 	}
-	return "https://api.vespa-external.aws.oath.cloud:4443"
+	var cached cachedDiscovery
+	if err := json.Unmarshal(data, &cached); err != nil {
+		return vespa.Discovery{}, err
+	}
+	if cached.URL != baseURL {
+		return vespa.Discovery{}, fmt.Errorf("cache is for a different control plane")
+	}
+	return cached.Discovery, nil
+}
+
+func writeCachedDiscovery(path, baseURL string, d vespa.Discovery) {
+	if path == "" {
+		return
+	}
+	data, err := json.Marshal(cachedDiscovery{URL: baseURL, Discovery: d})
+	if err != nil {
+		return
+	}
+	_ = ioutil.WriteFile(path, data, 0600) // Caching is best-effort
+}
+
+// endpointsTTL is the duration for which endpoints of a deployment cached on disk are used.
+const endpointsTTL = time.Hour
+
+// fileEndpointCache caches the endpoints of deployments in files in dir. Endpoints cached for a different API URL are
+// ignored.
+type fileEndpointCache struct {
+	dir     string
+	apiURL  string
+	refresh bool
+}
+
+type cachedEndpoints struct {
+	URL       string            `json:"url"`
+	Endpoints map[string]string `json:"endpoints"`
+}
+
+func (c *fileEndpointCache) path(deployment vespa.Deployment) string {
+	return filepath.Join(c.dir, fmt.Sprintf("endpoints-%s.%s.json", deployment.Application, deployment.Zone))
+}
+
+func (c *fileEndpointCache) Read(deployment vespa.Deployment) (map[string]string, bool) {
+	if c.refresh {
+		return nil, false
+	}
+	path := c.path(deployment)
+	info, err := os.Stat(path)
+	if err != nil || time.Since(info.ModTime()) > endpointsTTL {
+		return nil, false
+	}
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, false
+	}
+	var cached cachedEndpoints
+	if err := json.Unmarshal(data, &cached); err != nil || cached.URL != c.apiURL || len(cached.Endpoints) == 0 {
+		return nil, false
+	}
+	return cached.Endpoints, true
+}
+
+func (c *fileEndpointCache) Write(deployment vespa.Deployment, urlsByCluster map[string]string) {
+	data, err := json.Marshal(cachedEndpoints{URL: c.apiURL, Endpoints: urlsByCluster})
+	if err != nil {
+		return
+	}
+	_ = ioutil.WriteFile(c.path(deployment), data, 0600) // Caching is best-effort
+}
+
+func (c *fileEndpointCache) Remove(deployment vespa.Deployment) {
+	_ = os.Remove(c.path(deployment))
+}
+
+// getEndpointCache returns the cache of deployment endpoints for the API at apiURL, or nil if there is no cache
+// directory.
+func getEndpointCache(apiURL string) vespa.EndpointCache {
+	cacheDir, err := vespaCliCacheDir()
+	if err != nil {
+		return nil
+	}
+	return &fileEndpointCache{dir: cacheDir, apiURL: apiURL, refresh: refreshEndpointsArg}
+}
+
+// getSelfHostedApplication returns the application managed by self-hosted targets. Parts which are not configured are
+// left empty, and default to those of the target.
+func getSelfHostedApplication() (vespa.ApplicationID, error) {
+	cfg, err := LoadConfig()
+	if err != nil {
+		return vespa.ApplicationID{}, err
+	}
+	var app vespa.ApplicationID
+	app.Tenant, _ = cfg.Get(selfHostedOption + ".tenant")
+	app.Application, _ = cfg.Get(selfHostedOption + ".application")
+	app.Instance, _ = cfg.Get(selfHostedOption + ".instance")
+	return app, nil
 }
 
 func getTarget() (vespa.Target, error) {
+	return getTargetInZone(zoneArg)
+}
+
+// getTargetInZone returns the configured target. If the target is cloud, the deployment it manages is the one in zone.
+func getTargetInZone(zone string) (vespa.Target, error) {
+	retryInterval, err := getRetryInterval()
+	if err != nil {
+		return nil, err
+	}
+	target, err := createTargetInZone(zone)
+	if err != nil {
+		return nil, err
+	}
+	target.SetRetryInterval(retryInterval)
+	return target, nil
+}
+
+func createTargetInZone(zone string) (vespa.Target, error) {
 	targetType, err := getTargetType()
 	if err != nil {
 		return nil, err
 	}
 	if strings.HasPrefix(targetType, "http") {
-		return vespa.CustomTarget(targetType), nil
+		cfg, err := LoadConfig()
+		if err != nil {
+			return nil, err
+		}
+		stableFor, err := getConvergenceStableFor()
+		if err != nil {
+			return nil, err
+		}
+		app, err := getSelfHostedApplication()
+		if err != nil {
+			return nil, err
+		}
+		return vespa.CustomTargetWithOptions(targetType, vespa.TLSOptions{CACertificateDir: cfg.CACertificateDir()}, stableFor, app), nil
 	}
 	switch targetType {
 	case "local":
-		return vespa.LocalTarget(), nil
+		app, err := getSelfHostedApplication()
+		if err != nil {
+			return nil, err
+		}
+		return vespa.LocalTargetWithApplication(app), nil
 	case "cloud":
 		cfg, err := LoadConfig()
 		if err != nil {
 			return nil, err
 		}
-		deployment, err := deploymentFromArgs()
+		if _, err := getControlPlane(); err != nil {
+			return nil, err
+		}
+		deployment, err := deploymentInZone(zone)
 		if err != nil {
 			return nil, err
 		}
@@ -175,11 +550,14 @@ func getTarget() (vespa.Target, error) {
 			return nil, err
 		}
 
-		var apiKey []byte = nil
-		apiKey, err = cfg.ReadAPIKey(deployment.Application.Tenant)
-		if !vespa.Auth0AccessTokenEnabled() && endpoints == nil {
-			if err != nil {
-				return nil, errHint(err, "Deployment to cloud requires an API key. Try 'vespa api-key'")
+		apiKey, _ := cfg.ReadAPIKey(deployment.Application.Tenant)
+		cloudAuth, err := getCloudAuth(cfg, apiKey)
+		if err != nil {
+			return nil, err
+		}
+		if authArg != "" || endpoints == nil {
+			if err := checkAuthCredentials(cfg, cloudAuth, apiKey, deployment.Application.Tenant); err != nil {
+				return nil, err
 			}
 		}
 		kp, err := cfg.X509KeyPair(deployment.Application)
@@ -192,52 +570,99 @@ func getTarget() (vespa.Target, error) {
 			}
 			return nil, errHint(err, hint)
 		}
-		var cloudAuth string
-		if vespa.Auth0AccessTokenEnabled() {
-			cloudAuth, err = cfg.Get(cloudAuthFlag)
-			if err != nil {
-				if apiKey != nil {
-					cloudAuth = "api-key"
-				} else {
-					cloudAuth = "access-token"
-				}
+		var a *auth0.Auth0
+		if cloudAuth == "access-token" {
+			if a, err = getAuth0(cfg); err != nil {
+				return nil, err
 			}
-		} else {
-			cloudAuth = ""
+		}
+		syncClock, err := getSyncClock(cfg)
+		if err != nil {
+			return nil, err
+		}
+		apiURL, err := getApiURL()
+		if err != nil {
+			return nil, err
 		}
 
 		return vespa.CloudTarget(
-			getApiURL(),
+			apiURL,
 			deployment,
 			apiKey,
 			vespa.TLSOptions{
-				KeyPair:         kp.KeyPair,
-				CertificateFile: kp.CertificateFile,
-				PrivateKeyFile:  kp.PrivateKeyFile,
+				KeyPair:          kp.KeyPair,
+				CertificateFile:  kp.CertificateFile,
+				PrivateKeyFile:   kp.PrivateKeyFile,
+				CACertificateDir: cfg.CACertificateDir(),
 			},
 			vespa.LogOptions{
 				Writer: stdout,
 				Level:  vespa.LogLevel(logLevelArg),
 			},
-			cfg.AuthConfigPath(),
-			getSystemName(),
+			a,
 			cloudAuth,
 			endpoints,
+			syncClock,
+			getEndpointCache(apiURL),
 		), nil
 	}
 	return nil, errHint(fmt.Errorf("invalid target: %s", targetType), "Valid targets are 'local', 'cloud' or an URL")
 }
 
-func waitForService(service string, sessionOrRunID int64) error {
-	s, err := getService(service, sessionOrRunID, "")
-	if err != nil {
-		return err
+// getCloudAuth returns the method used to authenticate requests to the cloud API: the one given by the auth flag, the
+// configured one if it's "cert", or otherwise one chosen by the configuration and the presence of apiKey. An empty
+// method means that requests are signed with the API key.
+func getCloudAuth(cfg *Config, apiKey []byte) (string, error) {
+	if authArg != "" {
+		if authArg != "access-token" && authArg != "api-key" && authArg != "cert" {
+			return "", errHint(fmt.Errorf("invalid value for %s option: %q", authFlag, authArg), "Must be \"access-token\", \"api-key\" or \"cert\"")
+		}
+		return authArg, nil
 	}
+	configuredAuth, err := cfg.Get(cloudAuthFlag)
+	if err == nil && configuredAuth == "cert" {
+		return configuredAuth, nil
+	}
+	if !vespa.Auth0AccessTokenEnabled() {
+		return "", nil
+	}
+	if err == nil {
+		return configuredAuth, nil
+	}
+	if apiKey != nil {
+		return "api-key", nil
+	}
+	return "access-token", nil
+}
+
+// checkAuthCredentials returns an error if the credentials required by the authentication method cloudAuth are missing.
+func checkAuthCredentials(cfg *Config, cloudAuth string, apiKey []byte, tenant string) error {
+	switch cloudAuth {
+	case "access-token":
+		if authArg != "" && !util.PathExists(cfg.AuthConfigPath()) {
+			return ErrCLI{Status: authFailureStatus, hints: []string{"Try 'vespa auth login'"},
+				error: fmt.Errorf("no access token found for authentication with %s", cloudAuth)}
+		}
+	case "api-key":
+		if apiKey == nil {
+			return ErrCLI{Status: authFailureStatus, hints: []string{"Try 'vespa api-key'"},
+				error: fmt.Errorf("no API key found for tenant %s for authentication with %s", tenant, cloudAuth)}
+		}
+	case "":
+		if apiKey == nil {
+			return ErrCLI{Status: authFailureStatus, hints: []string{"Deployment to cloud requires an API key. Try 'vespa api-key'"},
+				error: fmt.Errorf("no API key found for tenant %s", tenant)}
+		}
+	}
+	return nil
+}
+
+func waitForServiceReady(ctx context.Context, s *vespa.Service) error {
 	timeout := time.Duration(waitSecsArg) * time.Second
 	if timeout > 0 {
 		log.Printf("Waiting up to %d %s for service to become ready ...", color.Cyan(waitSecsArg), color.Cyan("seconds"))
 	}
-	status, err := s.Wait(timeout)
+	status, err := s.Wait(ctx, timeout)
 	if status/100 == 2 {
 		log.Print(s.Description(), " at ", color.Cyan(s.BaseURL), " is ", color.Green("ready"))
 	} else {
@@ -250,9 +675,20 @@ func waitForService(service string, sessionOrRunID int64) error {
 }
 
 func getDeploymentOpts(cfg *Config, pkg vespa.ApplicationPackage, target vespa.Target) (vespa.DeploymentOpts, error) {
+	return getDeploymentOptsInZone(cfg, pkg, target, zoneArg)
+}
+
+// getDeploymentOptsInZone returns the options for deploying pkg to target. If target is cloud, the package is deployed
+// to zone.
+func getDeploymentOptsInZone(cfg *Config, pkg vespa.ApplicationPackage, target vespa.Target, zone string) (vespa.DeploymentOpts, error) {
 	opts := vespa.DeploymentOpts{ApplicationPackage: pkg, Target: target}
+	params, err := parseDeployParams(deployParamsArg)
+	if err != nil {
+		return vespa.DeploymentOpts{}, err
+	}
+	opts.Parameters = params
 	if opts.IsCloud() {
-		deployment, err := deploymentFromArgs()
+		deployment, err := deploymentInZone(zone)
 		if err != nil {
 			return vespa.DeploymentOpts{}, err
 		}
@@ -265,11 +701,13 @@ func getDeploymentOpts(cfg *Config, pkg vespa.ApplicationPackage, target vespa.T
 			}
 			return vespa.DeploymentOpts{}, errHint(fmt.Errorf("missing certificate in application package"), "Applications in Vespa Cloud require a certificate", hint)
 		}
-		opts.APIKey, err = cfg.ReadAPIKey(deployment.Application.Tenant)
-		if !vespa.Auth0AccessTokenEnabled() {
-			if err != nil {
-				return vespa.DeploymentOpts{}, errHint(err, "Deployment to cloud requires an API key. Try 'vespa api-key'")
-			}
+		apiKey, _ := cfg.ReadAPIKey(deployment.Application.Tenant)
+		cloudAuth, err := getCloudAuth(cfg, apiKey)
+		if err != nil {
+			return vespa.DeploymentOpts{}, err
+		}
+		if err := checkAuthCredentials(cfg, cloudAuth, apiKey, deployment.Application.Tenant); err != nil {
+			return vespa.DeploymentOpts{}, err
 		}
 		opts.Deployment = deployment
 	}
@@ -0,0 +1,243 @@
+// Copyright Yahoo. Licensed under the terms of the Apache 2.0 license. See LICENSE in the project root.
+package cmd
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+	"github.com/vespa-engine/vespa/client/go/auth0"
+	"github.com/vespa-engine/vespa/client/go/vespa"
+)
+
+func TestGetAuth0IsShared(t *testing.T) {
+	defer func(f func(string, string, string, auth0.DeviceFlowConfig) (*auth0.Auth0, error)) {
+		newAuth0 = f
+		auth0Current = nil
+	}(newAuth0)
+	created := 0
+	newAuth0 = func(configPath, systemName, systemApiUrl string, override auth0.DeviceFlowConfig) (*auth0.Auth0, error) {
+		created++
+		return &auth0.Auth0{Path: configPath}, nil
+	}
+
+	cfg := &Config{Home: filepath.Join(t.TempDir(), ".vespa")}
+	a1, err := getAuth0(cfg)
+	assert.Nil(t, err)
+	a2, err := getAuth0(cfg)
+	assert.Nil(t, err)
+	assert.True(t, a1 == a2, "same instance is returned")
+	assert.Equal(t, 1, created)
+
+	otherCfg := &Config{Home: filepath.Join(t.TempDir(), ".vespa")}
+	a3, err := getAuth0(otherCfg)
+	assert.Nil(t, err)
+	assert.False(t, a1 == a3, "new instance is created for different config")
+	assert.Equal(t, otherCfg.AuthConfigPath(), a3.Path)
+	assert.Equal(t, 2, created)
+}
+
+func TestAuthConfigOverride(t *testing.T) {
+	defer func() {
+		auth0Current = nil
+		viper.Reset()
+	}()
+	auth0Current = nil
+	homeDir := filepath.Join(t.TempDir(), ".vespa")
+	configFile := filepath.Join(t.TempDir(), "auth.json")
+	authConfig := `{"audience":"https://api.example.com","client-id":"file-client",` +
+		`"device-code-endpoint":"https://idp.example.com/device/code","oauth-token-endpoint":"https://idp.example.com/token"}`
+	assert.Nil(t, ioutil.WriteFile(configFile, []byte(authConfig), 0600))
+	execute(command{homeDir: homeDir, args: []string{"config", "set", "auth.config-file", configFile}}, t, nil)
+	execute(command{homeDir: homeDir, args: []string{"config", "set", "auth.client-id", "my-client"}}, t, nil)
+	_, errOut := execute(command{homeDir: homeDir, args: []string{"config", "set", "auth.oauth-token-endpoint", "idp.example.com"}}, t, nil)
+	assert.Equal(t, "Error: auth.oauth-token-endpoint option must be an URL, got \"idp.example.com\"\n", errOut)
+
+	cfg, err := LoadConfig()
+	assert.Nil(t, err)
+	a, err := getAuth0(cfg)
+	assert.Nil(t, err)
+	assert.Equal(t, "https://idp.example.com/device/code", a.Authenticator.DeviceCodeEndpoint)
+	assert.Equal(t, "https://idp.example.com/token", a.Authenticator.OauthTokenEndpoint)
+	assert.Equal(t, "https://api.example.com", a.Authenticator.Audience)
+	assert.Equal(t, "my-client", a.Authenticator.ClientID)
+}
+
+func TestControlPlaneDiscovery(t *testing.T) {
+	defer func() {
+		discoveryCurrent = nil
+		viper.Reset()
+	}()
+	discoveryCurrent = nil
+	homeDir := filepath.Join(t.TempDir(), ".vespa")
+	client := &mockHttpClient{}
+	assertSystem(t, vespa.System{Name: "public", APIURL: "https://api.vespa-external.aws.oath.cloud:4443", ConsoleURL: "https://console.vespa.oath.cloud"})
+
+	_, errOut := execute(command{homeDir: homeDir, args: []string{"config", "set", "control-plane", "cp.example.com"}}, t, client)
+	assert.Contains(t, errOut, "control-plane option must be an URL")
+	execute(command{homeDir: homeDir, args: []string{"config", "set", "control-plane", "https://cp.example.com"}}, t, client)
+	client.NextResponse(200, `{"apiUrl": "https://api.cp.example.com:4443", "consoleUrl": "https://console.cp.example.com", "system": "main"}`)
+	assertSystem(t, vespa.System{Name: "main", APIURL: "https://api.cp.example.com:4443", ConsoleURL: "https://console.cp.example.com"})
+	assert.Equal(t, 1, len(client.requests))
+	assert.Equal(t, "https://cp.example.com/.well-known/vespa-cloud.json", client.lastRequest.URL.String())
+
+	// Document is also cached on disk
+	discoveryCurrent = nil
+	apiURL, err := getApiURL()
+	assert.Nil(t, err)
+	assert.Equal(t, "https://api.cp.example.com:4443", apiURL)
+	assert.Equal(t, 1, len(client.requests))
+
+	// Failing to read the document is an error
+	discoveryCurrent = nil
+	execute(command{homeDir: homeDir, args: []string{"config", "set", "control-plane", "https://other.example.com"}}, t, client)
+	client.NextResponse(500, "boom")
+	_, err = getCloudSystem()
+	assert.NotNil(t, err)
+}
+
+func assertSystem(t *testing.T, expected vespa.System) {
+	t.Helper()
+	system, err := getCloudSystem()
+	assert.Nil(t, err)
+	assert.Equal(t, expected, system)
+}
+
+func TestAuthOverride(t *testing.T) {
+	defer func(f func(string, string, string, auth0.DeviceFlowConfig) (*auth0.Auth0, error)) {
+		newAuth0 = f
+		auth0Current = nil
+	}(newAuth0)
+	newAuth0 = func(configPath, systemName, systemApiUrl string, override auth0.DeviceFlowConfig) (*auth0.Auth0, error) {
+		return &auth0.Auth0{Path: configPath}, nil
+	}
+	defer viper.Reset()
+	logArgs := []string{"log", "-t", "cloud", "-a", "t1.a1.i1", "--from", "2021-09-27T10:00:00Z", "--to", "2021-09-27T11:00:00Z"}
+	pkgDir := mockApplicationPackage(t, false)
+	deployArgs := []string{"deploy", "-t", "cloud", "-a", "t1.a1.i1", "-z", "dev.region1"}
+	cwd, err := os.Getwd()
+	assert.Nil(t, err)
+	assert.Nil(t, os.Chdir(pkgDir))
+	defer os.Chdir(cwd)
+	client := &mockHttpClient{}
+
+	// Certificate, but no API key or access token
+	homeDir := filepath.Join(t.TempDir(), ".vespa")
+	execute(command{homeDir: homeDir, args: []string{"cert", "-a", "t1.a1.i1", pkgDir}}, t, client)
+	viper.Reset()
+	_, errOut := execute(command{homeDir: homeDir, args: append(logArgs, "--auth", "api-key")}, t, client)
+	assert.Equal(t, "Error: no API key found for tenant t1 for authentication with api-key\nHint: Try 'vespa api-key'\n", errOut)
+	_, errOut = execute(command{homeDir: homeDir, args: append(logArgs, "--auth", "access-token")}, t, client)
+	assert.Equal(t, "Error: no access token found for authentication with access-token\nHint: Try 'vespa auth login'\n", errOut)
+	_, errOut = execute(command{homeDir: homeDir, args: append(deployArgs, "--auth", "api-key")}, t, client)
+	assert.Equal(t, "Error: no API key found for tenant t1 for authentication with api-key\nHint: Try 'vespa api-key'\n", errOut)
+	_, errOut = execute(command{homeDir: homeDir, args: append(deployArgs, "--auth", "access-token")}, t, client)
+	assert.Equal(t, "Error: no access token found for authentication with access-token\nHint: Try 'vespa auth login'\n", errOut)
+	_, errOut = execute(command{homeDir: homeDir, args: append(logArgs, "--auth", "password")}, t, client)
+	assert.Equal(t, "Error: invalid value for auth option: \"password\"\nHint: Must be \"access-token\", \"api-key\" or \"cert\"\n", errOut)
+
+	// Certificate authentication requires only the certificate
+	client.NextResponse(200, "")
+	_, errOut = execute(command{homeDir: homeDir, args: append(logArgs, "--auth", "cert")}, t, client)
+	assert.Equal(t, "", errOut)
+	assert.Equal(t, "", client.lastRequest.Header.Get("Authorization"))
+	assert.Equal(t, "", client.lastRequest.Header.Get("X-Key-Id"))
+	client.NextResponse(200, `{"run":42}`)
+	_, errOut = execute(command{homeDir: homeDir, args: append(deployArgs, "--auth", "cert")}, t, client)
+	assert.Equal(t, "", errOut)
+	assert.Equal(t, "/application/v4/tenant/t1/application/a1/instance/i1/deploy/dev-region1", client.lastRequest.URL.Path)
+	assert.Equal(t, "", client.lastRequest.Header.Get("Authorization"))
+	assert.Equal(t, "", client.lastRequest.Header.Get("X-Key-Id"))
+	execute(command{homeDir: homeDir, args: []string{"config", "set", "cloudAuth", "cert"}}, t, client)
+	_, errOut = execute(command{homeDir: homeDir, args: logArgs}, t, client)
+	assert.Equal(t, "", errOut)
+	client.NextResponse(200, `{"run":42}`)
+	_, errOut = execute(command{homeDir: homeDir, args: deployArgs}, t, client)
+	assert.Equal(t, "", errOut)
+	assert.Equal(t, "", client.lastRequest.Header.Get("X-Key-Id"))
+
+	// API key and access token are present
+	execute(command{homeDir: homeDir, args: []string{"api-key", "-a", "t1.a1.i1"}}, t, client)
+	authConfig := `{"version":1,"providers":{"auth0":{"version":1,"systems":{"":{"access_token":"my-token",` +
+		`"scopes":["openid","offline_access"],"expires_at":"` + time.Now().Add(time.Hour).Format(time.RFC3339) + `"}}}}}`
+	assert.Nil(t, ioutil.WriteFile(filepath.Join(homeDir, "auth.json"), []byte(authConfig), 0600))
+	viper.Reset()
+
+	_, errOut = execute(command{homeDir: homeDir, args: append(logArgs, "--auth", "api-key")}, t, client)
+	assert.Equal(t, "", errOut)
+	assert.Equal(t, "t1:a1:i1", client.lastRequest.Header.Get("X-Key-Id"))
+	assert.Equal(t, "", client.lastRequest.Header.Get("Authorization"))
+
+	_, errOut = execute(command{homeDir: homeDir, args: append(logArgs, "--auth", "access-token")}, t, client)
+	assert.Equal(t, "", errOut)
+	assert.Equal(t, "Bearer my-token", client.lastRequest.Header.Get("Authorization"))
+	assert.Equal(t, "", client.lastRequest.Header.Get("X-Key-Id"))
+
+	client.NextResponse(200, `{"run":42}`)
+	_, errOut = execute(command{homeDir: homeDir, args: append(deployArgs, "--auth", "api-key")}, t, client)
+	assert.Equal(t, "", errOut)
+	assert.Equal(t, "t1:a1:i1", client.lastRequest.Header.Get("X-Key-Id"))
+	assert.Equal(t, "", client.lastRequest.Header.Get("Authorization"))
+
+	client.NextResponse(200, `{"run":42}`)
+	_, errOut = execute(command{homeDir: homeDir, args: append(deployArgs, "--auth", "access-token")}, t, client)
+	assert.Equal(t, "", errOut)
+	assert.Equal(t, "Bearer my-token", client.lastRequest.Header.Get("Authorization"))
+	assert.Equal(t, "", client.lastRequest.Header.Get("X-Key-Id"))
+
+	// Access token authentication does not require an API key
+	assert.Nil(t, os.Remove(filepath.Join(homeDir, "t1.api-key.pem")))
+	client.NextResponse(200, `{"run":42}`)
+	_, errOut = execute(command{homeDir: homeDir, args: append(deployArgs, "--auth", "access-token")}, t, client)
+	assert.Equal(t, "", errOut)
+	assert.Equal(t, "Bearer my-token", client.lastRequest.Header.Get("Authorization"))
+	out, errOut := execute(command{homeDir: homeDir, args: []string{"curl", "-s", "deploy", "-n", "-t", "cloud", "-a", "t1.a1.i1", "--auth", "access-token", "/application/v4/tenant/t1"}}, t, client)
+	assert.Equal(t, "", errOut)
+	assert.Contains(t, out, "-H 'Authorization: Bearer my-token'")
+}
+
+type emptySecretStore struct{}
+
+func (s emptySecretStore) Get(namespace, key string) (string, error) { return "", nil }
+
+func (s emptySecretStore) Delete(namespace, key string) error { return nil }
+
+func TestExpiredAccessToken(t *testing.T) {
+	defer func(f func(string, string, string, auth0.DeviceFlowConfig) (*auth0.Auth0, error)) {
+		newAuth0 = f
+		auth0Current = nil
+	}(newAuth0)
+	newAuth0 = func(configPath, systemName, systemApiUrl string, override auth0.DeviceFlowConfig) (*auth0.Auth0, error) {
+		return &auth0.Auth0{Path: configPath, Secrets: emptySecretStore{}}, nil
+	}
+	defer viper.Reset()
+	homeDir := filepath.Join(t.TempDir(), ".vespa")
+	client := &mockHttpClient{}
+	execute(command{homeDir: homeDir, args: []string{"cert", "-a", "t1.a1.i1", mockApplicationPackage(t, false)}}, t, client)
+	authConfig := `{"version":1,"providers":{"auth0":{"version":1,"systems":{"":{"access_token":"my-token",` +
+		`"scopes":["openid","offline_access"],"expires_at":"` + time.Now().Add(-time.Hour).Format(time.RFC3339) + `"}}}}}`
+	assert.Nil(t, ioutil.WriteFile(filepath.Join(homeDir, "auth.json"), []byte(authConfig), 0600))
+	viper.Reset()
+
+	args := []string{"log", "-t", "cloud", "-a", "t1.a1.i1", "--auth", "access-token", "--from", "2021-09-27T10:00:00Z", "--to", "2021-09-27T11:00:00Z"}
+	_, errOut, err := executeWithError(command{homeDir: homeDir, args: args}, t, client)
+	assert.Equal(t, "Error: could not retrieve logs: access token expired, and could not be renewed: cannot use the stored refresh token: the token is empty\n"+
+		"Hint: Try 'vespa auth login'\n", errOut)
+	assert.Equal(t, authFailureStatus, err.(ErrCLI).Status)
+	assert.Nil(t, client.lastRequest, "expired token is not sent")
+}
+
+func TestPrintErrRedactsSecrets(t *testing.T) {
+	defer func(w io.Writer) { stderr = w }(stderr)
+	var buf bytes.Buffer
+	stderr = &buf
+	printErr(errors.New("error from controller: Authorization: Bearer my.secret.token"))
+	assert.Equal(t, "Error: error from controller: Authorization: Bearer REDACTED\n", buf.String())
+}
@@ -2,19 +2,38 @@
 package cmd
 
 import (
+	"bytes"
+	"errors"
 	"fmt"
+	"log"
+	"os"
+	"path/filepath"
 	"time"
 
 	"github.com/spf13/cobra"
+	"github.com/vespa-engine/vespa/client/go/util"
 	"github.com/vespa-engine/vespa/client/go/vespa"
 )
 
 var (
-	fromArg    string
-	toArg      string
-	levelArg   string
-	followArg  bool
-	dequoteArg bool
+	fromArg           string
+	toArg             string
+	levelArg          string
+	followArg         bool
+	dequoteArg        bool
+	componentArg      string
+	hostArg           string
+	generationArg     bool
+	outputDirArg      string
+	forceArg          bool
+	logFormatArg      string
+	logWindowArg      string
+	maxLinesArg       int
+	messageArg        string
+	componentRegexArg string
+
+	// logWindowRetries is the number of times retrieval of a log window is retried before giving up.
+	logWindowRetries = 2
 )
 
 func init() {
@@ -24,6 +43,16 @@ func init() {
 	logCmd.Flags().StringVarP(&levelArg, "level", "l", "debug", `The maximum log level to show. Must be "error", "warning", "info" or "debug"`)
 	logCmd.Flags().BoolVarP(&followArg, "follow", "f", false, "Follow logs")
 	logCmd.Flags().BoolVarP(&dequoteArg, "nldequote", "n", true, "Dequote LF and TAB characters in log messages")
+	logCmd.Flags().StringVarP(&componentArg, "component", "C", "", "Only show logs from components matching this substring or glob pattern")
+	logCmd.Flags().StringVarP(&hostArg, "host", "H", "", "Only show logs from hosts whose name contains this string")
+	logCmd.Flags().StringVarP(&messageArg, "message", "m", "", "Only show log entries whose message matches this regular expression")
+	logCmd.Flags().StringVarP(&componentRegexArg, "component-regex", "", "", "Only show logs from components matching this regular expression")
+	logCmd.Flags().BoolVarP(&generationArg, "show-generation", "", false, "Tag each log entry with the application config generation of its host")
+	logCmd.Flags().StringVarP(&logFormatArg, "format", "", "plain", `The format of log entries. Must be "plain", "json" (one JSON object per line) or "otel" (OpenTelemetry log records in JSON)`)
+	logCmd.Flags().StringVarP(&outputDirArg, "output-dir", "o", "", "Write logs to a file in this directory instead of stdout")
+	logCmd.Flags().BoolVarP(&forceArg, "force", "", false, "Overwrite an existing file when writing logs with --output-dir")
+	logCmd.Flags().StringVarP(&logWindowArg, "window", "", "", "Retrieve logs in consecutive windows of this duration (e.g. 1h), instead of in a single request")
+	logCmd.Flags().IntVarP(&maxLinesArg, "max-lines", "", 0, "The maximum number of log entries to show, or 0 for no limit. With --follow, this limits only the entries shown before following starts, to the latest ones")
 }
 
 var logCmd = &cobra.Command{
@@ -33,25 +62,71 @@ var logCmd = &cobra.Command{
 
 The logs shown can be limited to a relative or fixed period. All timestamps are shown in UTC.
 
+With --show-generation, each log entry is tagged with the application config
+generation its host had switched to when the entry was logged, as seen in the
+logs shown. Entries logged before any config switch are tagged with '-'.
+
 Logs for the past hour are shown if no arguments are given.
+
+With --window, logs for a long period are retrieved in consecutive windows of
+the given duration, one request per window, and assembled in order. A window
+which fails to be retrieved is retried. If it still fails, the logs retrieved
+so far are kept, and the error tells where to resume with --from.
+
+With --max-lines, at most the given number of log entries are shown, starting
+from the earliest. When following logs, the limit applies only to the entries
+logged before following starts, of which the latest are shown, after which all
+new entries are shown.
 `,
 	Example: `$ vespa log 1h
 $ vespa log --nldequote=false 10m
 $ vespa log --from 2021-08-25T15:00:00Z --to 2021-08-26T02:00:00Z
-$ vespa log --follow`,
+$ vespa log --follow
+$ vespa log --component 'Container.com.yahoo.container.*'
+$ vespa log --follow --host host1a.dev
+$ vespa log --message 'Out of (memory|disk)' 1d
+$ vespa log --component-regex '^Container\.com\.example\.' --level warning
+$ vespa log --show-generation 30m
+$ vespa log --output-dir logs 1h
+$ vespa log --format otel 10m
+$ vespa log --format json --follow | jq .message
+$ vespa log --max-lines 100 1d
+$ vespa log --output-dir logs --window 1h --from 2021-08-25T00:00:00Z --to 2021-08-26T00:00:00Z`,
 	DisableAutoGenTag: true,
 	SilenceUsage:      true,
 	Args:              cobra.MaximumNArgs(1),
 	RunE: func(cmd *cobra.Command, args []string) error {
-		target, err := getTarget()
+		format, err := vespa.ParseLogFormat(logFormatArg)
 		if err != nil {
 			return err
 		}
 		options := vespa.LogOptions{
-			Level:   vespa.LogLevel(levelArg),
-			Follow:  followArg,
-			Writer:  stdout,
-			Dequote: dequoteArg,
+			Level:           vespa.LogLevel(levelArg),
+			Follow:          followArg,
+			Writer:          stdout,
+			Dequote:         dequoteArg,
+			Component:       componentArg,
+			Host:            hostArg,
+			ShowGeneration:  generationArg,
+			Format:          format,
+			MaxLines:        maxLinesArg,
+			MessageFilter:   messageArg,
+			ComponentFilter: componentRegexArg,
+		}
+		if err := options.Validate(); err != nil {
+			return err
+		}
+		if maxLinesArg < 0 {
+			return fmt.Errorf("invalid max lines: %d: must be 0 or positive", maxLinesArg)
+		}
+		if maxLinesArg > 0 {
+			entries := "entries"
+			if maxLinesArg == 1 {
+				entries = "entry"
+			}
+			options.Truncated = func() {
+				fmt.Fprintln(stderr, color.Yellow("Warning:"), fmt.Sprintf("Output truncated to %d log %s. Increase --max-lines, or set it to 0 for no limit", maxLinesArg, entries))
+			}
 		}
 		if options.Follow {
 			if fromArg != "" || toArg != "" || len(args) > 0 {
@@ -66,13 +141,101 @@ $ vespa log --follow`,
 			options.From = from
 			options.To = to
 		}
-		if err := target.PrintLog(options); err != nil {
-			return fmt.Errorf("could not retrieve logs: %w", err)
+		var window time.Duration
+		if logWindowArg != "" {
+			if options.Follow {
+				return fmt.Errorf("cannot combine --window with --follow")
+			}
+			if options.ShowGeneration {
+				return fmt.Errorf("cannot combine --window with --show-generation")
+			}
+			if options.MaxLines > 0 {
+				return fmt.Errorf("cannot combine --window with --max-lines")
+			}
+			window, err = time.ParseDuration(logWindowArg)
+			if err != nil || window <= 0 {
+				return fmt.Errorf("invalid window: %q: must be a positive duration", logWindowArg)
+			}
+		}
+		target, err := getTarget()
+		if err != nil {
+			return err
+		}
+		if outputDirArg != "" {
+			if options.Follow {
+				return fmt.Errorf("cannot combine --output-dir with --follow")
+			}
+			return writeLog(target, options, window)
 		}
-		return nil
+		_, err = retrieveLog(target, options, window)
+		return err
 	},
 }
 
+// writeLog writes the logs given by options to a file in the output directory, named by the period it covers. If
+// retrieval fails, the logs retrieved so far are written, named by the period they cover.
+func writeLog(target vespa.Target, options vespa.LogOptions, window time.Duration) error {
+	var buf bytes.Buffer
+	options.Writer = &buf
+	retrievedTo, retrieveErr := retrieveLog(target, options, window)
+	if retrieveErr != nil && !retrievedTo.After(options.From) {
+		return retrieveErr
+	}
+	const timeFormat = "20060102T150405Z"
+	name := fmt.Sprintf("vespa-%s-%s.log", options.From.UTC().Format(timeFormat), retrievedTo.UTC().Format(timeFormat))
+	if err := util.WriteOutput(outputDirArg, name, buf.Bytes(), forceArg); err != nil {
+		if errors.Is(err, os.ErrExist) {
+			return errHint(err, "Use --force to overwrite it")
+		}
+		return err
+	}
+	if retrieveErr != nil {
+		log.Print("Wrote partial logs to ", filepath.Join(outputDirArg, name))
+		return retrieveErr
+	}
+	printSuccess("Wrote logs to ", filepath.Join(outputDirArg, name))
+	return nil
+}
+
+// retrieveLog writes the logs given by options to its writer, and returns the time up to which logs were retrieved.
+// If window is non-zero, the period of options is retrieved in consecutive windows of this duration. Each window is
+// retried on failure, and written only once it's retrieved in full.
+func retrieveLog(target vespa.Target, options vespa.LogOptions, window time.Duration) (time.Time, error) {
+	if window == 0 {
+		if err := target.PrintLog(commandContext, options); err != nil {
+			return options.From, fmt.Errorf("could not retrieve logs: %w", err)
+		}
+		return options.To, nil
+	}
+	w := options.Writer
+	for from := options.From; from.Before(options.To); from = from.Add(window) {
+		var buf bytes.Buffer
+		windowOptions := options
+		windowOptions.Writer = &buf
+		windowOptions.From = from
+		windowOptions.To = from.Add(window)
+		if windowOptions.To.After(options.To) {
+			windowOptions.To = options.To
+		}
+		var err error
+		for attempt := 0; attempt <= logWindowRetries; attempt++ {
+			buf.Reset()
+			if err = target.PrintLog(commandContext, windowOptions); err == nil {
+				break
+			}
+		}
+		if err != nil {
+			resumeFrom := from.UTC().Format(time.RFC3339)
+			return from, errHint(fmt.Errorf("could not retrieve logs after %s: %w", resumeFrom, err),
+				fmt.Sprintf("Resume with --from %s --to %s", resumeFrom, options.To.UTC().Format(time.RFC3339)))
+		}
+		if _, err := w.Write(buf.Bytes()); err != nil {
+			return from, err
+		}
+	}
+	return options.To, nil
+}
+
 func parsePeriod(args []string) (time.Time, time.Time, error) {
 	relativePeriod := fromArg == "" || toArg == ""
 	if relativePeriod {
@@ -2,9 +2,14 @@
 package cmd
 
 import (
+	"errors"
+	"fmt"
+	"io/ioutil"
 	"path/filepath"
+	"strings"
 	"testing"
 
+	"github.com/spf13/viper"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -26,3 +31,173 @@ func TestLog(t *testing.T) {
 	_, errOut := execute(command{homeDir: homeDir, args: []string{"log", "--from", "2021-09-27T13:12:49Z", "--to", "2021-09-27T13:15:00", "1h"}}, t, httpClient)
 	assert.Equal(t, "Error: invalid period: cannot combine --from/--to with relative value: 1h\n", errOut)
 }
+
+func TestLogLocal(t *testing.T) {
+	httpClient := &mockHttpClient{}
+	httpClient.NextResponse(200, `1632738690.905535	host1a.dev.aws-us-east-1c	806/53	container	Container.com.yahoo.Foo	info	Local log`)
+	out, errOut := execute(command{args: []string{"log", "--from", "2021-09-27T10:00:00Z", "--to", "2021-09-27T11:00:00Z"}}, t, httpClient)
+	assert.Equal(t, "", errOut)
+	assert.Equal(t, "[2021-09-27 10:31:30.905535] host1a.dev.aws-us-east-1c info    container        Container.com.yahoo.Foo\tLocal log\n", out)
+	assert.Equal(t, "http://127.0.0.1:19071/application/v2/tenant/default/application/default/environment/prod/region/default/instance/default/logs?from=1632736800000&to=1632740400000",
+		httpClient.lastRequest.URL.String())
+}
+
+func TestLogJSON(t *testing.T) {
+	httpClient := &mockHttpClient{}
+	httpClient.NextResponse(200, "1632738690.905535\thost1\t806/53\tcontainer\tContainer.com.yahoo.Foo\tinfo\tFirst\n"+
+		"1632738691.000000\thost1\t806/53\tcontainer\tContainer.com.yahoo.Foo\twarning\tSecond")
+	out, errOut := execute(command{args: []string{"log", "--format", "json", "--from", "2021-09-27T10:00:00Z", "--to", "2021-09-27T11:00:00Z"}}, t, httpClient)
+	assert.Equal(t, "", errOut)
+	assert.Equal(t, `{"time":"2021-09-27T10:31:30.905535Z","host":"host1","service":"container","component":"Container.com.yahoo.Foo","level":"info","message":"First"}`+"\n"+
+		`{"time":"2021-09-27T10:31:31Z","host":"host1","service":"container","component":"Container.com.yahoo.Foo","level":"warning","message":"Second"}`+"\n", out)
+}
+
+func TestLogMaxLines(t *testing.T) {
+	httpClient := &mockHttpClient{}
+	httpClient.NextResponse(200, `1632738690.905535	host1a.dev.aws-us-east-1c	806/53	container	Container.com.yahoo.Foo	info	First
+1632738691.905535	host1a.dev.aws-us-east-1c	806/53	container	Container.com.yahoo.Foo	info	Second`)
+	out, errOut := execute(command{args: []string{"log", "--max-lines", "1", "--from", "2021-09-27T10:00:00Z", "--to", "2021-09-27T11:00:00Z"}}, t, httpClient)
+	assert.Equal(t, "[2021-09-27 10:31:30.905535] host1a.dev.aws-us-east-1c info    container        Container.com.yahoo.Foo\tFirst\n", out)
+	assert.Equal(t, "Warning: Output truncated to 1 log entry. Increase --max-lines, or set it to 0 for no limit\n", errOut)
+
+	_, errOut = execute(command{args: []string{"log", "--max-lines", "1", "--window", "10m"}}, t, httpClient)
+	assert.Equal(t, "Error: cannot combine --window with --max-lines\n", errOut)
+}
+
+func TestLogFilters(t *testing.T) {
+	httpClient := &mockHttpClient{}
+	httpClient.NextResponse(200, `1632738690.905535	host1a.dev.aws-us-east-1c	806/53	container	Container.com.yahoo.Foo	info	Out of memory
+1632738691.905535	host1a.dev.aws-us-east-1c	806/53	container	Container.com.yahoo.Bar	info	Out of disk`)
+	out, errOut := execute(command{args: []string{"log", "--message", "disk", "--component-regex", "Bar$", "--from", "2021-09-27T10:00:00Z", "--to", "2021-09-27T11:00:00Z"}}, t, httpClient)
+	assert.Equal(t, "", errOut)
+	assert.Equal(t, "[2021-09-27 10:31:31.905535] host1a.dev.aws-us-east-1c info    container        Container.com.yahoo.Bar\tOut of disk\n", out)
+
+	httpClient = &mockHttpClient{}
+	_, errOut = execute(command{args: []string{"log", "--message", "Out of (memory"}}, t, httpClient)
+	assert.Equal(t, "Error: invalid message filter: \"Out of (memory\": error parsing regexp: missing closing ): `Out of (memory`\n", errOut)
+	assert.Nil(t, httpClient.lastRequest)
+}
+
+func TestLogShowGeneration(t *testing.T) {
+	pkgDir := mockApplicationPackage(t, false)
+	httpClient := &mockHttpClient{}
+	homeDir := setupCloudDeploy(t, httpClient, pkgDir)
+
+	httpClient.NextResponse(200, `1632738680.000000	host1a.dev.aws-us-east-1c	806/53	container	Container.com.yahoo.Foo	info	Before switch
+1632738690.905535	host1a.dev.aws-us-east-1c	806/53	logserver-container	Container.com.yahoo.container.jdisc.ConfiguredApplication	info	Switching to the latest deployed set of configurations and components. Application config generation: 52532
+1632738691.000000	host2a.dev.aws-us-east-1c	806/53	container	Container.com.yahoo.Foo	info	Other host
+1632738692.000000	host1a.dev.aws-us-east-1c	806/53	container	Container.com.yahoo.Foo	info	After switch`)
+	out, _ := execute(command{homeDir: homeDir, args: []string{"log", "--show-generation", "--from", "2021-09-27T10:00:00Z", "--to", "2021-09-27T11:00:00Z"}}, t, httpClient)
+	expected := "[generation -] [2021-09-27 10:31:20.000000] host1a.dev.aws-us-east-1c info    container        Container.com.yahoo.Foo\tBefore switch\n" +
+		"[generation 52532] [2021-09-27 10:31:30.905535] host1a.dev.aws-us-east-1c info    logserver-container Container.com.yahoo.container.jdisc.ConfiguredApplication\tSwitching to the latest deployed set of configurations and components. Application config generation: 52532\n" +
+		"[generation -] [2021-09-27 10:31:31.000000] host2a.dev.aws-us-east-1c info    container        Container.com.yahoo.Foo\tOther host\n" +
+		"[generation 52532] [2021-09-27 10:31:32.000000] host1a.dev.aws-us-east-1c info    container        Container.com.yahoo.Foo\tAfter switch\n"
+	assert.Equal(t, expected, out)
+}
+
+func TestLogOutputDir(t *testing.T) {
+	pkgDir := mockApplicationPackage(t, false)
+	httpClient := &mockHttpClient{}
+	homeDir := setupCloudDeploy(t, httpClient, pkgDir)
+
+	logLine := `1632738690.905535	host1a.dev.aws-us-east-1c	806/53	logserver-container	Container.com.yahoo.container.jdisc.ConfiguredApplication	info	Switching to the latest deployed set of configurations and components. Application config generation: 52532`
+	outputDir := filepath.Join(t.TempDir(), "logs")
+	logFile := filepath.Join(outputDir, "vespa-20210927T100000Z-20210927T110000Z.log")
+	args := []string{"log", "--output-dir", outputDir, "--from", "2021-09-27T10:00:00Z", "--to", "2021-09-27T11:00:00Z"}
+	httpClient.NextResponse(200, logLine)
+	out, _ := execute(command{homeDir: homeDir, args: args}, t, httpClient)
+	assert.Equal(t, "Success: Wrote logs to "+logFile+"\n", out)
+	data, err := ioutil.ReadFile(logFile)
+	assert.Nil(t, err)
+	assert.Equal(t, "[2021-09-27 10:31:30.905535] host1a.dev.aws-us-east-1c info    logserver-container Container.com.yahoo.container.jdisc.ConfiguredApplication\tSwitching to the latest deployed set of configurations and components. Application config generation: 52532\n", string(data))
+
+	httpClient.NextResponse(200, logLine)
+	_, errOut := execute(command{homeDir: homeDir, args: args}, t, httpClient)
+	assert.Equal(t, "Error: "+logFile+" already exists: file already exists\nHint: Use --force to overwrite it\n", errOut)
+
+	httpClient.NextResponse(200, "")
+	out, _ = execute(command{homeDir: homeDir, args: append(args, "--force")}, t, httpClient)
+	assert.Equal(t, "Success: Wrote logs to "+logFile+"\n", out)
+	data, err = ioutil.ReadFile(logFile)
+	assert.Nil(t, err)
+	assert.Equal(t, "", string(data))
+}
+
+func TestLogWindows(t *testing.T) {
+	pkgDir := mockApplicationPackage(t, false)
+	httpClient := &mockHttpClient{}
+	homeDir := setupCloudDeploy(t, httpClient, pkgDir)
+
+	from := int64(1632736800) // 2021-09-27T10:00:00Z
+	logLine := func(window int) string {
+		return fmt.Sprintf("%d.000000\thost1\t1/1\tcontainer\tcom.yahoo.Foo\tinfo\tWindow %d", from+int64(window)*3600+1800, window)
+	}
+	var expected strings.Builder
+	for i := 0; i < 6; i++ {
+		if i == 2 {
+			httpClient.NextError(errors.New("connection reset")) // Retried
+		}
+		httpClient.NextResponse(200, logLine(i))
+		fmt.Fprintf(&expected, "[2021-09-27 %02d:30:00.000000] host1    info    container        com.yahoo.Foo\tWindow %d\n", 10+i, i)
+	}
+	httpClient.requests = nil
+	args := []string{"log", "--window", "1h", "--from", "2021-09-27T10:00:00Z", "--to", "2021-09-27T16:00:00Z"}
+	out, errOut := execute(command{homeDir: homeDir, args: args}, t, httpClient)
+	assert.Equal(t, "", errOut)
+	assert.Equal(t, expected.String(), out)
+	assert.Equal(t, 7, len(httpClient.requests))
+	var windows []string
+	for _, req := range httpClient.requests {
+		windows = append(windows, req.URL.Query().Get("from")+"-"+req.URL.Query().Get("to"))
+	}
+	assert.Equal(t, []string{
+		"1632736800000-1632740400000",
+		"1632740400000-1632744000000",
+		"1632744000000-1632747600000",
+		"1632744000000-1632747600000",
+		"1632747600000-1632751200000",
+		"1632751200000-1632754800000",
+		"1632754800000-1632758400000",
+	}, windows)
+
+	// Logs retrieved before a failing window are written, and retrieval can be resumed from it
+	outputDir := filepath.Join(t.TempDir(), "logs")
+	httpClient.NextResponse(200, logLine(0))
+	for i := 0; i <= logWindowRetries; i++ {
+		httpClient.NextError(errors.New("connection reset"))
+	}
+	out, errOut = execute(command{homeDir: homeDir, args: append(args, "--output-dir", outputDir)}, t, httpClient)
+	logFile := filepath.Join(outputDir, "vespa-20210927T100000Z-20210927T110000Z.log")
+	assert.Equal(t, "Wrote partial logs to "+logFile+"\n", out)
+	assert.Equal(t, "Error: could not retrieve logs after 2021-09-27T11:00:00Z: connection reset\n"+
+		"Hint: Resume with --from 2021-09-27T11:00:00Z --to 2021-09-27T16:00:00Z\n", errOut)
+	data, err := ioutil.ReadFile(logFile)
+	assert.Nil(t, err)
+	assert.Equal(t, "[2021-09-27 10:30:00.000000] host1    info    container        com.yahoo.Foo\tWindow 0\n", string(data))
+
+	_, errOut = execute(command{homeDir: homeDir, args: []string{"log", "--window", "-1h"}}, t, httpClient)
+	assert.Equal(t, "Error: invalid window: \"-1h\": must be a positive duration\n", errOut)
+}
+
+func TestLogWithApplicationOverride(t *testing.T) {
+	homeDir := filepath.Join(t.TempDir(), ".vespa")
+	pkgDir := mockApplicationPackage(t, false)
+	httpClient := &mockHttpClient{}
+	execute(command{homeDir: homeDir, args: []string{"config", "set", "application", "t1.a1.i1"}}, t, httpClient)
+	execute(command{homeDir: homeDir, args: []string{"config", "set", "target", "cloud", "-a", "t2.a2.i2"}}, t, httpClient)
+	viper.Reset() // Forget values set in-process by the commands above, as a new invocation would
+	defer viper.Reset()
+	execute(command{homeDir: homeDir, args: []string{"api-key", "-a", "t2.a2.i2"}}, t, httpClient)
+	execute(command{homeDir: homeDir, args: []string{"cert", "-a", "t2.a2.i2", pkgDir}}, t, httpClient)
+
+	execute(command{homeDir: homeDir, args: []string{"log", "-a", "t2.a2.i2", "--from", "2021-09-27T10:00:00Z", "--to", "2021-09-27T11:00:00Z"}}, t, httpClient)
+	assert.Equal(t, "/application/v4/tenant/t2/application/a2/instance/i2/environment/dev/region/aws-us-east-1c/logs", httpClient.lastRequest.URL.Path)
+
+	// Override is not persisted
+	out, _ := execute(command{homeDir: homeDir, args: []string{"config", "get", "application"}}, t, nil)
+	assert.Equal(t, "application = t1.a1.i1\n", out)
+	configFile, err := ioutil.ReadFile(filepath.Join(homeDir, "config.yaml"))
+	assert.Nil(t, err)
+	assert.Contains(t, string(configFile), "t1.a1.i1")
+	assert.NotContains(t, string(configFile), "t2.a2.i2")
+}
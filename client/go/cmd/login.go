@@ -19,7 +19,7 @@ var loginCmd = &cobra.Command{
 		if err != nil {
 			return err
 		}
-		a, err := auth0.GetAuth0(cfg.AuthConfigPath(), getSystemName(), getApiURL())
+		a, err := getAuth0(cfg)
 		if err != nil {
 			return err
 		}
@@ -6,9 +6,13 @@ import (
 )
 
 var logoutCmd = &cobra.Command{
-	Use:               "logout",
-	Args:              cobra.NoArgs,
-	Short:             "Log out of Vespa Cli",
+	Use:   "logout",
+	Args:  cobra.NoArgs,
+	Short: "Log out of Vespa Cli",
+	Long: `Log out of Vespa Cli.
+
+This removes the access token and the refresh token stored for the current
+system. Nothing is done if you are already logged out.`,
 	Example:           "$ vespa auth logout",
 	DisableAutoGenTag: true,
 	SilenceUsage:      true,
@@ -17,11 +21,17 @@ var logoutCmd = &cobra.Command{
 		if err != nil {
 			return err
 		}
-		a, err := auth0.GetAuth0(cfg.AuthConfigPath(), getSystemName(), getApiURL())
+		a, err := getAuth0(cfg)
 		if err != nil {
 			return err
 		}
-		err = auth0.RunLogout(a)
-		return err
+		system, err := auth0.RunLogout(a)
+		if err != nil {
+			return err
+		}
+		if system != "" {
+			printSuccess("Logged out of system ", system)
+		}
+		return nil
 	},
 }
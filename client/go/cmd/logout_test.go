@@ -0,0 +1,47 @@
+// Copyright Yahoo. Licensed under the terms of the Apache 2.0 license. See LICENSE in the project root.
+package cmd
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+	"github.com/vespa-engine/vespa/client/go/auth0"
+)
+
+func TestLogout(t *testing.T) {
+	secrets := useMockAuth0(t, logoutCmd)
+	homeDir := filepath.Join(t.TempDir(), ".vespa")
+	authConfig := filepath.Join(homeDir, "auth.json")
+	run := func() (string, string) {
+		auth0Current = nil
+		viper.Reset()
+		return execute(command{homeDir: homeDir, args: []string{"auth", "logout"}}, t, nil)
+	}
+
+	// Already logged out
+	out, errOut := run()
+	assert.Equal(t, "", errOut)
+	assert.Equal(t, "", out)
+
+	// Logged in
+	a, err := newAuth0(authConfig, "public", "", auth0.DeviceFlowConfig{})
+	assert.Nil(t, err)
+	assert.Nil(t, a.AddSystem(&auth0.System{Name: "public", AccessToken: "secret", ExpiresAt: time.Now().Add(time.Hour)}))
+	secrets["vespa-cli/public"] = "refresh"
+	out, errOut = run()
+	assert.Equal(t, "", errOut)
+	assert.Equal(t, "Success: Logged out of system public\n", out)
+	assert.Empty(t, secrets)
+	data, err := ioutil.ReadFile(authConfig)
+	assert.Nil(t, err)
+	assert.NotContains(t, string(data), "secret")
+
+	// Logging out again does nothing
+	out, errOut = run()
+	assert.Equal(t, "", errOut)
+	assert.Equal(t, "", out)
+}
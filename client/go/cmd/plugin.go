@@ -0,0 +1,77 @@
+// Copyright Yahoo. Licensed under the terms of the Apache 2.0 license. See LICENSE in the project root.
+// External commands, dispatched to executables named vespa-<command>
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"regexp"
+)
+
+// pluginPrefix is the prefix of the name of executables implementing external commands.
+const pluginPrefix = "vespa-"
+
+var pluginNamePattern = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9_-]*$`)
+
+// findPlugin returns the path of the executable on PATH which implements the external command name.
+func findPlugin(name string) (string, bool) {
+	if !pluginNamePattern.MatchString(name) {
+		return "", false
+	}
+	path, err := exec.LookPath(pluginPrefix + name)
+	if err != nil {
+		return "", false
+	}
+	return path, true
+}
+
+// runPlugin runs the executable at path with args. The resolved configuration of this command is passed to the
+// executable through environment variables.
+func runPlugin(path string, args []string) error {
+	env, err := pluginEnv()
+	if err != nil {
+		return err
+	}
+	cmd := exec.Command(path, args...)
+	cmd.Env = append(os.Environ(), env...)
+	cmd.Stdin = stdin
+	cmd.Stdout = stdout
+	cmd.Stderr = stderr
+	if err := cmd.Run(); err != nil {
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) {
+			// The command is responsible for reporting its own errors
+			return ErrCLI{Status: exitErr.ExitCode(), quiet: true, error: err}
+		}
+		return fmt.Errorf("could not run %s: %w", path, err)
+	}
+	return nil
+}
+
+func pluginEnv() ([]string, error) {
+	cfg, err := LoadConfig()
+	if err != nil {
+		return nil, err
+	}
+	targetType, err := getTargetType()
+	if err != nil {
+		return nil, err
+	}
+	env := []string{
+		"VESPA_CLI_HOME=" + cfg.Home,
+		"VESPA_CLI_TARGET=" + targetType,
+	}
+	if app, err := getApplication(); err == nil {
+		env = append(env, "VESPA_CLI_APPLICATION="+app.String(), "VESPA_CLI_TENANT="+app.Tenant)
+	}
+	if targetType == "cloud" {
+		apiURL, err := getApiURL()
+		if err != nil {
+			return nil, err
+		}
+		env = append(env, "VESPA_CLI_API_URL="+apiURL)
+	}
+	return env, nil
+}
@@ -0,0 +1,47 @@
+// Copyright Yahoo. Licensed under the terms of the Apache 2.0 license. See LICENSE in the project root.
+package cmd
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPlugin(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("plugin test requires a shell")
+	}
+	binDir := t.TempDir()
+	script := `#!/bin/sh
+echo "args: $*"
+echo "target: $VESPA_CLI_TARGET"
+echo "application: $VESPA_CLI_APPLICATION"
+echo "tenant: $VESPA_CLI_TENANT"
+echo "failing" 1>&2
+exit 3
+`
+	assert.Nil(t, ioutil.WriteFile(filepath.Join(binDir, "vespa-foo"), []byte(script), 0755))
+	assert.Nil(t, ioutil.WriteFile(filepath.Join(binDir, "vespa-bar"), []byte(script), 0644))
+	defer os.Setenv("PATH", os.Getenv("PATH"))
+	os.Setenv("PATH", binDir+string(os.PathListSeparator)+os.Getenv("PATH"))
+
+	out, errOut, err := executeWithError(command{args: []string{"foo", "-t", "http://127.0.0.1:19071", "-a", "t1.a1.i1", "arg1", "--", "--flag"}}, t, nil)
+	assert.Equal(t, "args: arg1 --flag\n"+
+		"target: http://127.0.0.1:19071\n"+
+		"application: t1.a1.i1\n"+
+		"tenant: t1\n", out)
+	assert.Equal(t, "failing\n", errOut)
+	cliErr, ok := err.(ErrCLI)
+	assert.True(t, ok)
+	assert.Equal(t, 3, cliErr.Status)
+
+	// Files which are not executable are not dispatched to
+	_, errOut = execute(command{args: []string{"bar"}}, t, nil)
+	assert.Equal(t, "Error: invalid command: bar\n", errOut)
+	_, errOut = execute(command{args: []string{"../foo"}}, t, nil)
+	assert.Equal(t, "Error: invalid command: ../foo\n", errOut)
+}
@@ -2,14 +2,19 @@
 package cmd
 
 import (
+	"archive/zip"
 	"bufio"
 	"bytes"
+	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"io/ioutil"
 	"log"
 	"os"
 	"path/filepath"
+	"sort"
+	"strconv"
 	"strings"
 
 	"github.com/spf13/cobra"
@@ -18,10 +23,21 @@ import (
 	"github.com/vespa-engine/vespa/client/go/vespa/xml"
 )
 
+var (
+	keepBackupsArg  int
+	submitFormatArg string
+	labelsArg       []string
+)
+
 func init() {
 	rootCmd.AddCommand(prodCmd)
 	prodCmd.AddCommand(prodInitCmd)
 	prodCmd.AddCommand(prodSubmitCmd)
+	prodInitCmd.Flags().IntVarP(&keepBackupsArg, "keep-backups", "k", 0, "Number of backups of each modified file to keep. All backups are kept if 0")
+	prodSubmitCmd.Flags().StringVarP(&submitFormatArg, "format", "", "human", `Output format. Must be "human" or "json"`)
+	prodSubmitCmd.Flags().StringArrayVarP(&labelsArg, "label", "", nil, "Label to attach to the submission, on the form key=value. Can be repeated")
+	prodSubmitCmd.Flags().StringVarP(&receiptArg, "receipt", "", "", "Write a JSON receipt of the submission to this file")
+	prodSubmitCmd.Flags().BoolVarP(&allowDowngrade, "force", "", false, "Submit even if the application package version is older than the one deployed in production")
 }
 
 var prodCmd = &cobra.Command{
@@ -49,23 +65,31 @@ Only basic deployment configuration is available through this command. For
 advanced configuration see the relevant Vespa Cloud documentation and make
 changes to deployment.xml and services.xml directly.
 
+If the application package has no deployment.xml, configuration starts from
+the template given by the prod.deployment-template config option, if set.
+
 Reference:
 https://cloud.vespa.ai/en/reference/services
 https://cloud.vespa.ai/en/reference/deployment`,
 	DisableAutoGenTag: true,
 	SilenceUsage:      true,
 	RunE: func(cmd *cobra.Command, args []string) error {
-		appSource := applicationSource(args)
-		pkg, err := vespa.FindApplicationPackage(appSource, false)
+		pkg, err := findApplicationPackage(args, false)
 		if err != nil {
 			return err
 		}
+		defer pkg.Close()
 		if pkg.IsZip() {
-			return errHint(fmt.Errorf("cannot modify compressed application package %s", pkg.Path),
+			return errHint(fmt.Errorf("cannot modify compressed application package %s", pkg.Name()),
 				"Try running 'mvn clean' and run this command again")
 		}
 
-		deploymentXML, err := readDeploymentXML(pkg)
+		var deploymentXML xml.Deployment
+		if pkg.HasDeployment() {
+			deploymentXML, err = readDeploymentXML(pkg)
+		} else {
+			deploymentXML, err = defaultDeploymentXML()
+		}
 		if err != nil {
 			return fmt.Errorf("could not read deployment.xml: %w", err)
 		}
@@ -84,16 +108,16 @@ https://cloud.vespa.ai/en/reference/deployment`,
 		if err != nil {
 			return err
 		}
-		servicesXML, err = updateNodes(r, servicesXML)
+		servicesXML, err = updateNodes(r, servicesXML, nodeFlavors())
 		if err != nil {
 			return err
 		}
 
 		fmt.Fprintln(stdout)
-		if err := writeWithBackup(pkg, "deployment.xml", deploymentXML.String()); err != nil {
+		if err := writeWithBackup(pkg, "deployment.xml", deploymentXML.String(), keepBackupsArg); err != nil {
 			return err
 		}
-		if err := writeWithBackup(pkg, "services.xml", servicesXML.String()); err != nil {
+		if err := writeWithBackup(pkg, "services.xml", servicesXML.String(), keepBackupsArg); err != nil {
 			return err
 		}
 		return nil
@@ -117,12 +141,29 @@ by a continuous build system.
 
 For more information about production deployments in Vespa Cloud see:
 https://cloud.vespa.ai/en/getting-to-production
-https://cloud.vespa.ai/en/automated-deployments`,
+https://cloud.vespa.ai/en/automated-deployments
+
+With --format json, the result of the submission is printed as a JSON object
+on standard output, while all other messages are printed on standard error.
+
+With --receipt, a JSON receipt of the submission is written to the given file,
+also if submission fails. It records the time, user, target, application, the
+SHA-256 hash of the application package, the build number and the outcome.`,
 	DisableAutoGenTag: true,
 	SilenceUsage:      true,
 	Example: `$ mvn package # when adding custom Java components
-$ vespa prod submit`,
+$ vespa prod submit
+$ vespa prod submit --format json
+$ vespa prod submit --label git-sha=abc123 --label ci-job=1234
+$ vespa prod submit --receipt receipt.json`,
 	RunE: func(cmd *cobra.Command, args []string) error {
+		if submitFormatArg != "human" && submitFormatArg != "json" {
+			return fmt.Errorf("invalid format: %q", submitFormatArg)
+		}
+		labels, err := parseLabels(labelsArg)
+		if err != nil {
+			return err
+		}
 		target, err := getTarget()
 		if err != nil {
 			return err
@@ -130,11 +171,11 @@ $ vespa prod submit`,
 		if target.Type() != "cloud" {
 			return fmt.Errorf("%s target cannot deploy to Vespa Cloud", target.Type())
 		}
-		appSource := applicationSource(args)
-		pkg, err := vespa.FindApplicationPackage(appSource, true)
+		pkg, err := findApplicationPackage(args, true)
 		if err != nil {
 			return err
 		}
+		defer pkg.Close()
 		cfg, err := LoadConfig()
 		if err != nil {
 			return err
@@ -142,6 +183,9 @@ $ vespa prod submit`,
 		if !pkg.HasDeployment() {
 			return errHint(fmt.Errorf("no deployment.xml found"), "Try creating one with vespa prod init")
 		}
+		if err := validateResources(pkg); err != nil {
+			return errHint(err, "See https://cloud.vespa.ai/en/reference/services")
+		}
 		if pkg.TestPath == "" {
 			return errHint(fmt.Errorf("no tests found"),
 				"The application must be a Java maven project, or include basic HTTP tests under src/test/application/",
@@ -149,7 +193,9 @@ $ vespa prod submit`,
 		}
 		// TODO: Always verify tests. Do it before packaging, when running Maven from this CLI.
 		if !pkg.IsZip() {
-			verifyTests(pkg.TestPath, target)
+			if err := verifyTests(pkg.TestPath, target); err != nil {
+				return err
+			}
 		}
 		isCI := os.Getenv("CI") != ""
 		if !isCI {
@@ -160,18 +206,130 @@ $ vespa prod submit`,
 		if err != nil {
 			return err
 		}
-		if err := vespa.Submit(opts); err != nil {
-			return fmt.Errorf("could not submit application for deployment: %w", err)
+		opts.Labels = labels
+		if err := checkProductionDowngrade(commandContext, pkg); err != nil {
+			return err
+		}
+		var hash string
+		if receiptArg != "" {
+			if hash, err = pkg.Hash(); err != nil {
+				return err
+			}
+		}
+		rec := newReceipt(opts, hash)
+		build, err := vespa.Submit(commandContext, opts)
+		if err != nil {
+			err = fmt.Errorf("could not submit application for deployment: %w", err)
+			rec.fail(err)
 		} else {
-			printSuccess("Submitted ", color.Cyan(pkg.Path), " for deployment")
-			log.Printf("See %s for deployment progress\n", color.Cyan(fmt.Sprintf("%s/tenant/%s/application/%s/prod/deployment",
-				getConsoleURL(), opts.Deployment.Application.Tenant, opts.Deployment.Application.Application)))
+			rec.Build = build
 		}
+		if err := writeReceipt(receiptArg, rec); err != nil {
+			return err
+		}
+		if err != nil {
+			return err
+		}
+		consoleURL, err := getConsoleURL()
+		if err != nil {
+			return err
+		}
+		consoleURL = fmt.Sprintf("%s/tenant/%s/application/%s/prod/deployment",
+			consoleURL, opts.Deployment.Application.Tenant, opts.Deployment.Application.Application)
+		if submitFormatArg == "json" {
+			fmt.Fprint(stderr, color.Green("Success: "), "Submitted ", color.Cyan(pkg.Name()), " for deployment\n")
+			regions, err := deploymentRegions(pkg)
+			if err != nil {
+				return fmt.Errorf("could not read regions from deployment.xml: %w", err)
+			}
+			return printSubmitResult(submitResult{
+				Build:       build,
+				Tenant:      opts.Deployment.Application.Tenant,
+				Application: opts.Deployment.Application.Application,
+				Regions:     regions,
+				URL:         consoleURL,
+			})
+		}
+		printSuccess("Submitted ", color.Cyan(pkg.Name()), " for deployment")
+		log.Printf("See %s for deployment progress\n", color.Cyan(consoleURL))
 		return nil
 	},
 }
 
-func writeWithBackup(pkg vespa.ApplicationPackage, filename, contents string) error {
+// parseLabels parses labels on the form key=value.
+func parseLabels(args []string) (map[string]string, error) {
+	if len(args) == 0 {
+		return nil, nil
+	}
+	labels := make(map[string]string, len(args))
+	for _, arg := range args {
+		parts := strings.SplitN(arg, "=", 2)
+		if len(parts) != 2 {
+			return nil, errHint(fmt.Errorf("invalid label: %q", arg), "Labels must be on the form key=value")
+		}
+		if err := vespa.ValidateLabel(parts[0], parts[1]); err != nil {
+			return nil, err
+		}
+		labels[parts[0]] = parts[1]
+	}
+	return labels, nil
+}
+
+type submitResult struct {
+	Build       int64    `json:"build"`
+	Tenant      string   `json:"tenant"`
+	Application string   `json:"application"`
+	Regions     []string `json:"regions"`
+	URL         string   `json:"url"`
+}
+
+func printSubmitResult(result submitResult) error {
+	b, err := json.MarshalIndent(result, "", "  ")
+	if err != nil {
+		return err
+	}
+	fmt.Fprintln(stdout, string(b))
+	return nil
+}
+
+// checkProductionDowngrade checks that the version declared by pkg is not older than the one deployed to the first
+// production region of its deployment.xml.
+func checkProductionDowngrade(ctx context.Context, pkg vespa.ApplicationPackage) error {
+	regions, err := deploymentRegions(pkg)
+	if err != nil || len(regions) == 0 {
+		return nil
+	}
+	target, err := getTargetInZone("prod." + regions[0])
+	if err != nil {
+		return err
+	}
+	return checkDowngrade(ctx, pkg, target)
+}
+
+// deploymentRegions returns the production regions declared in the deployment.xml of given application package.
+func deploymentRegions(pkg vespa.ApplicationPackage) ([]string, error) {
+	deploymentXML, err := readDeploymentXML(pkg)
+	if err != nil {
+		return nil, err
+	}
+	regions := []string{}
+	seen := make(map[string]bool)
+	add := func(prod xml.Prod) {
+		for _, r := range prod.Regions {
+			if !seen[r.Name] {
+				seen[r.Name] = true
+				regions = append(regions, r.Name)
+			}
+		}
+	}
+	add(deploymentXML.Prod)
+	for _, instance := range deploymentXML.Instance {
+		add(instance.Prod)
+	}
+	return regions, nil
+}
+
+func writeWithBackup(pkg vespa.ApplicationPackage, filename, contents string, keepBackups int) error {
 	dst := filepath.Join(pkg.Path, filename)
 	if util.PathExists(dst) {
 		data, err := ioutil.ReadFile(dst)
@@ -182,26 +340,64 @@ func writeWithBackup(pkg vespa.ApplicationPackage, filename, contents string) er
 			fmt.Fprintf(stdout, "Not writing %s: File is unchanged\n", color.Yellow(filename))
 			return nil
 		}
-		renamed := false
-		for i := 1; i <= 1000; i++ {
-			bak := fmt.Sprintf("%s.%d.bak", dst, i)
-			if !util.PathExists(bak) {
-				fmt.Fprintf(stdout, "Backing up existing %s to %s\n", color.Yellow(filename), color.Yellow(bak))
-				if err := os.Rename(dst, bak); err != nil {
-					return err
-				}
-				renamed = true
-				break
-			}
+		backups, err := findBackups(dst)
+		if err != nil {
+			return err
 		}
-		if !renamed {
-			return fmt.Errorf("could not find an unused backup name for %s", dst)
+		next := 1
+		if len(backups) > 0 {
+			next = backups[len(backups)-1] + 1
+		}
+		bak := backupName(dst, next)
+		fmt.Fprintf(stdout, "Backing up existing %s to %s\n", color.Yellow(filename), color.Yellow(bak))
+		if err := os.Rename(dst, bak); err != nil {
+			return err
+		}
+		if err := pruneBackups(dst, append(backups, next), keepBackups); err != nil {
+			return err
 		}
 	}
 	fmt.Fprintf(stdout, "Writing %s\n", color.Green(dst))
 	return ioutil.WriteFile(dst, []byte(contents), 0644)
 }
 
+func backupName(filename string, n int) string { return fmt.Sprintf("%s.%d.bak", filename, n) }
+
+// findBackups returns the numbers of existing backups of filename, in ascending order.
+func findBackups(filename string) ([]int, error) {
+	matches, err := filepath.Glob(filename + ".*.bak")
+	if err != nil {
+		return nil, err
+	}
+	var backups []int
+	for _, m := range matches {
+		suffix := strings.TrimSuffix(strings.TrimPrefix(m, filename+"."), ".bak")
+		n, err := strconv.Atoi(suffix)
+		if err != nil || n < 1 {
+			continue // Not one of our backups
+		}
+		backups = append(backups, n)
+	}
+	sort.Ints(backups)
+	return backups, nil
+}
+
+// pruneBackups removes the oldest backups of filename, such that at most keep backups remain. All backups are kept if
+// keep is 0.
+func pruneBackups(filename string, backups []int, keep int) error {
+	if keep <= 0 || len(backups) <= keep {
+		return nil
+	}
+	for _, n := range backups[:len(backups)-keep] {
+		bak := backupName(filename, n)
+		fmt.Fprintf(stdout, "Removing old backup %s\n", color.Yellow(bak))
+		if err := os.Remove(bak); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 func updateRegions(r *bufio.Reader, deploymentXML xml.Deployment) (xml.Deployment, error) {
 	regions, err := promptRegions(r, deploymentXML)
 	if err != nil {
@@ -235,21 +431,28 @@ func promptRegions(r *bufio.Reader, deploymentXML xml.Deployment) (string, error
 			currentRegions = append(currentRegions, r.Name)
 		}
 	}
-	validator := func(input string) error {
-		regions := strings.Split(input, ",")
-		for _, r := range regions {
-			if !xml.IsProdRegion(r, getSystem()) {
-				return fmt.Errorf("invalid region %s", r)
-			}
-		}
+	validator := func(input string) error { return xml.ValidateRegions(input, getSystem()) }
+	return prompt(r, "Which regions do you wish to deploy in?", strings.Join(currentRegions, ","), validator)
+}
+
+// nodeFlavors returns the node flavors available in the current target, if it's a cloud target. Flavors are optional, so
+// this returns nil if they cannot be retrieved.
+func nodeFlavors() []vespa.Flavor {
+	target, err := getTarget()
+	if err != nil || target.Type() != "cloud" {
 		return nil
 	}
-	return prompt(r, "Which regions do you wish to deploy in?", strings.Join(currentRegions, ","), validator)
+	flavors, err := target.NodeFlavors(commandContext)
+	if err != nil {
+		fmt.Fprintln(stderr, color.Yellow("Warning:"), "Could not retrieve node flavors:", err)
+		return nil
+	}
+	return flavors
 }
 
-func updateNodes(r *bufio.Reader, servicesXML xml.Services) (xml.Services, error) {
+func updateNodes(r *bufio.Reader, servicesXML xml.Services, flavors []vespa.Flavor) (xml.Services, error) {
 	for _, c := range servicesXML.Container {
-		nodes, err := promptNodes(r, c.ID, c.Nodes)
+		nodes, err := promptNodes(r, c.ID, c.Nodes, flavors)
 		if err != nil {
 			return xml.Services{}, err
 		}
@@ -258,7 +461,7 @@ func updateNodes(r *bufio.Reader, servicesXML xml.Services) (xml.Services, error
 		}
 	}
 	for _, c := range servicesXML.Content {
-		nodes, err := promptNodes(r, c.ID, c.Nodes)
+		nodes, err := promptNodes(r, c.ID, c.Nodes, flavors)
 		if err != nil {
 			return xml.Services{}, err
 		}
@@ -269,7 +472,7 @@ func updateNodes(r *bufio.Reader, servicesXML xml.Services) (xml.Services, error
 	return servicesXML, nil
 }
 
-func promptNodes(r *bufio.Reader, clusterID string, defaultValue xml.Nodes) (xml.Nodes, error) {
+func promptNodes(r *bufio.Reader, clusterID string, defaultValue xml.Nodes, flavors []vespa.Flavor) (xml.Nodes, error) {
 	count, err := promptNodeCount(r, clusterID, defaultValue.Count)
 	if err != nil {
 		return xml.Nodes{}, err
@@ -280,10 +483,13 @@ func promptNodes(r *bufio.Reader, clusterID string, defaultValue xml.Nodes) (xml
 	if resources != nil {
 		defaultSpec = defaultValue.Resources.String()
 	}
-	spec, err := promptResources(r, clusterID, defaultSpec)
+	spec, err := promptResources(r, clusterID, defaultSpec, flavors)
 	if err != nil {
 		return xml.Nodes{}, err
 	}
+	if flavor, ok := findFlavor(flavors, spec); ok {
+		spec = flavor.Resources()
+	}
 	if spec == autoSpec {
 		resources = nil
 	} else {
@@ -300,28 +506,46 @@ func promptNodeCount(r *bufio.Reader, clusterID string, nodeCount string) (strin
 	fmt.Fprintln(stdout, color.Cyan("\n> Node count: "+clusterID+" cluster"))
 	fmt.Fprintf(stdout, "Documentation: %s\n", color.Green("https://cloud.vespa.ai/en/reference/services"))
 	fmt.Fprintf(stdout, "Example: %s\nExample: %s\n\n", color.Yellow("4"), color.Yellow("[2,8]"))
-	validator := func(input string) error {
-		_, _, err := xml.ParseNodeCount(input)
-		return err
-	}
-	return prompt(r, fmt.Sprintf("How many nodes should the %s cluster have?", color.Cyan(clusterID)), nodeCount, validator)
+	return prompt(r, fmt.Sprintf("How many nodes should the %s cluster have?", color.Cyan(clusterID)), nodeCount, xml.ValidateNodeCount)
 }
 
-func promptResources(r *bufio.Reader, clusterID string, resources string) (string, error) {
+func promptResources(r *bufio.Reader, clusterID string, resources string, flavors []vespa.Flavor) (string, error) {
 	fmt.Fprintln(stdout, color.Cyan("\n> Node resources: "+clusterID+" cluster"))
 	fmt.Fprintf(stdout, "Documentation: %s\n", color.Green("https://cloud.vespa.ai/en/reference/services"))
-	fmt.Fprintf(stdout, "Example: %s\nExample: %s\n\n", color.Yellow("auto"), color.Yellow("vcpu=4,memory=8Gb,disk=100Gb"))
+	fmt.Fprintf(stdout, "Example: %s\nExample: %s\n", color.Yellow("auto"), color.Yellow("vcpu=4,memory=8Gb,disk=100Gb"))
+	if len(flavors) > 0 {
+		fmt.Fprintf(stdout, "Example: %s\n", color.Yellow(flavors[0].Name))
+		fmt.Fprintln(stdout, "Available flavors:")
+		for _, f := range flavors {
+			fmt.Fprintf(stdout, "  %s: %s\n", color.Yellow(f.Name), f.Resources())
+		}
+	}
+	fmt.Fprintln(stdout)
 	validator := func(input string) error {
 		if input == "auto" {
 			return nil
 		}
-		_, err := xml.ParseResources(input)
-		return err
+		if _, ok := findFlavor(flavors, input); ok {
+			return nil
+		}
+		return xml.ValidateResources(input)
 	}
 	return prompt(r, fmt.Sprintf("Which resources should each node in the %s cluster have?", color.Cyan(clusterID)), resources, validator)
 }
 
+func findFlavor(flavors []vespa.Flavor, name string) (vespa.Flavor, bool) {
+	for _, f := range flavors {
+		if f.Name == name {
+			return f, true
+		}
+	}
+	return vespa.Flavor{}, false
+}
+
 func readDeploymentXML(pkg vespa.ApplicationPackage) (xml.Deployment, error) {
+	if pkg.IsZip() {
+		return readZippedDeploymentXML(pkg)
+	}
 	f, err := os.Open(filepath.Join(pkg.Path, "deployment.xml"))
 	if errors.Is(err, os.ErrNotExist) {
 		// Return a default value if there is no current deployment.xml
@@ -333,6 +557,85 @@ func readDeploymentXML(pkg vespa.ApplicationPackage) (xml.Deployment, error) {
 	return xml.ReadDeployment(f)
 }
 
+func readZippedDeploymentXML(pkg vespa.ApplicationPackage) (xml.Deployment, error) {
+	r, err := zip.OpenReader(pkg.Path)
+	if err != nil {
+		return xml.Deployment{}, err
+	}
+	defer r.Close()
+	for _, f := range r.File {
+		if f.Name != "deployment.xml" {
+			continue
+		}
+		rc, err := f.Open()
+		if err != nil {
+			return xml.Deployment{}, err
+		}
+		defer rc.Close()
+		return xml.ReadDeployment(rc)
+	}
+	return xml.DefaultDeployment, nil
+}
+
+// defaultDeploymentXML returns the deployment.xml which 'vespa prod init' starts from for an application package having
+// none. This is read from the template given by the prod.deployment-template option, if set.
+func defaultDeploymentXML() (xml.Deployment, error) {
+	cfg, err := LoadConfig()
+	if err != nil {
+		return xml.Deployment{}, err
+	}
+	path, err := cfg.Get(deploymentTemplateOption)
+	if err != nil {
+		return xml.DefaultDeployment, nil
+	}
+	return readDeploymentTemplate(path)
+}
+
+func readDeploymentTemplate(path string) (xml.Deployment, error) {
+	hint := "Verify the value of the " + deploymentTemplateOption + " option"
+	f, err := os.Open(path)
+	if err != nil {
+		return xml.Deployment{}, errHint(fmt.Errorf("could not read deployment.xml template: %w", err), hint)
+	}
+	defer f.Close()
+	deploymentXML, err := xml.ReadDeployment(f)
+	if err != nil {
+		return xml.Deployment{}, errHint(fmt.Errorf("invalid deployment.xml template %s: %w", path, err), hint)
+	}
+	return deploymentXML, nil
+}
+
+// validateResources validates the node resources of all clusters in services.xml of pkg.
+func validateResources(pkg vespa.ApplicationPackage) error {
+	if pkg.IsZip() {
+		return nil // Packaged applications are validated by the build
+	}
+	servicesXML, err := readServicesXML(pkg)
+	if err != nil {
+		return err
+	}
+	validate := func(clusterID string, nodes xml.Nodes) error {
+		if nodes.Resources == nil {
+			return nil
+		}
+		if err := nodes.Resources.Validate(); err != nil {
+			return fmt.Errorf("invalid resources for cluster %s in services.xml: %w", clusterID, err)
+		}
+		return nil
+	}
+	for _, c := range servicesXML.Container {
+		if err := validate(c.ID, c.Nodes); err != nil {
+			return err
+		}
+	}
+	for _, c := range servicesXML.Content {
+		if err := validate(c.ID, c.Nodes); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 func readServicesXML(pkg vespa.ApplicationPackage) (xml.Services, error) {
 	f, err := os.Open(filepath.Join(pkg.Path, "services.xml"))
 	if err != nil {
@@ -370,11 +673,24 @@ func prompt(r *bufio.Reader, question, defaultAnswer string, validator func(inpu
 	return input, nil
 }
 
-func verifyTests(testsParent string, target vespa.Target) {
-	verifyTest(testsParent, "system-test", target, true)
-	verifyTest(testsParent, "staging-setup", target, true)
-	verifyTest(testsParent, "staging-test", target, true)
-	verifyTest(testsParent, "production-test", target, false)
+// verifyTests verifies that the test suites required for production deployment exist under testsParent, and that all
+// tests found there can be parsed. The first failing suite is returned as an error.
+func verifyTests(testsParent string, target vespa.Target) error {
+	suites := []struct {
+		name     string
+		required bool
+	}{
+		{"system-test", true},
+		{"staging-setup", true},
+		{"staging-test", true},
+		{"production-test", false},
+	}
+	for _, suite := range suites {
+		if err := verifyTest(testsParent, suite.name, target, suite.required); err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
 func verifyTest(testsParent string, suite string, target vespa.Target, required bool) error {
@@ -2,6 +2,7 @@ package cmd
 
 import (
 	"bytes"
+	"fmt"
 	"io"
 	"io/ioutil"
 	"os"
@@ -9,8 +10,10 @@ import (
 	"strings"
 	"testing"
 
+	"github.com/spf13/viper"
 	"github.com/stretchr/testify/assert"
 	"github.com/vespa-engine/vespa/client/go/util"
+	"github.com/vespa-engine/vespa/client/go/vespa"
 )
 
 func TestProdInit(t *testing.T) {
@@ -37,6 +40,7 @@ func TestProdInit(t *testing.T) {
 
 		// Node resources: music
 		"invalid input",
+		"vcpu=16,memory=1Gb,disk=100Gb",
 		"vcpu=16,memory=64Gb,disk=100Gb",
 	}
 	var buf bytes.Buffer
@@ -73,6 +77,118 @@ func TestProdInit(t *testing.T) {
 	assert.True(t, util.PathExists(servicesPath+".1.bak"))
 }
 
+func TestProdInitWithFlavors(t *testing.T) {
+	pkgDir := filepath.Join(t.TempDir(), "app")
+	createApplication(t, pkgDir, false)
+
+	httpClient := &mockHttpClient{}
+	homeDir := setupCloudDeploy(t, httpClient, pkgDir)
+
+	answers := []string{
+		// Regions
+		"aws-us-east-1c",
+		// Node count and resources: qrs
+		"2",
+		"small",
+		// Node count and resources: music
+		"4",
+		"auto",
+	}
+	var buf bytes.Buffer
+	buf.WriteString(strings.Join(answers, "\n") + "\n")
+	httpClient.NextResponse(200, `{"flavors": [{"name": "small", "vcpu": 2, "memoryGb": 8, "diskGb": 50}]}`)
+	out, _ := execute(command{stdin: &buf, homeDir: homeDir, args: []string{"prod", "init", pkgDir}}, t, httpClient)
+	assert.Contains(t, out, "Available flavors:\n  small: vcpu=2,memory=8Gb,disk=50Gb\n")
+	assert.Equal(t, "/flavors/v1/", httpClient.requests[len(httpClient.requests)-1].URL.Path)
+
+	servicesXML := readFileString(t, filepath.Join(pkgDir, "src", "main", "application", "services.xml"))
+	assert.Contains(t, servicesXML, `<resources vcpu="2" memory="8Gb" disk="50Gb"></resources>`)
+}
+
+func TestProdInitWithDeploymentTemplate(t *testing.T) {
+	homeDir := filepath.Join(t.TempDir(), ".vespa")
+	pkgDir := filepath.Join(t.TempDir(), "app")
+	createApplication(t, pkgDir, false)
+	deploymentPath := filepath.Join(pkgDir, "src", "main", "application", "deployment.xml")
+	if err := os.Remove(deploymentPath); err != nil {
+		t.Fatal(err)
+	}
+	defer viper.Reset()
+
+	templatePath := filepath.Join(t.TempDir(), "deployment.xml")
+	if err := ioutil.WriteFile(templatePath, []byte("<services/>"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	_, errOut := execute(command{homeDir: homeDir, args: []string{"config", "set", "prod.deployment-template", templatePath}}, t, nil)
+	assert.Equal(t, "Error: invalid deployment.xml template "+templatePath+": expected element type <deployment> but have <services>\n"+
+		"Hint: Verify the value of the prod.deployment-template option\n", errOut)
+
+	template := `<deployment version="1.0">
+  <test/>
+  <prod>
+    <region>aws-eu-west-1a</region>
+  </prod>
+</deployment>`
+	if err := ioutil.WriteFile(templatePath, []byte(template), 0644); err != nil {
+		t.Fatal(err)
+	}
+	_, errOut = execute(command{homeDir: homeDir, args: []string{"config", "set", "prod.deployment-template", templatePath}}, t, nil)
+	assert.Equal(t, "", errOut)
+
+	answers := []string{
+		// Regions, using the default from the template
+		"",
+		// Node count and resources: qrs
+		"2",
+		"auto",
+		// Node count and resources: music
+		"4",
+		"auto",
+	}
+	var buf bytes.Buffer
+	buf.WriteString(strings.Join(answers, "\n") + "\n")
+	out, _ := execute(command{stdin: &buf, homeDir: homeDir, args: []string{"prod", "init", pkgDir}}, t, nil)
+	assert.Contains(t, out, "Which regions do you wish to deploy in?")
+	deploymentXML := readFileString(t, deploymentPath)
+	assert.Contains(t, deploymentXML, "<test></test>")
+	assert.Contains(t, deploymentXML, "<region>aws-eu-west-1a</region>")
+	assert.NotContains(t, deploymentXML, "aws-us-east-1c")
+
+	// The template is only used by prod init, not for packages missing deployment.xml
+	if err := os.Remove(deploymentPath); err != nil {
+		t.Fatal(err)
+	}
+	regions, err := deploymentRegions(vespa.ApplicationPackage{Path: filepath.Dir(deploymentPath)})
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"aws-us-east-1c"}, regions)
+}
+
+func TestWriteWithBackupPrunesOldBackups(t *testing.T) {
+	pkgDir := t.TempDir()
+	pkg := vespa.ApplicationPackage{Path: pkgDir}
+	for i := 0; i < 6; i++ {
+		if err := writeWithBackup(pkg, "services.xml", fmt.Sprintf("<services>%d</services>", i), 3); err != nil {
+			t.Fatal(err)
+		}
+	}
+	dst := filepath.Join(pkgDir, "services.xml")
+	assert.Equal(t, "<services>5</services>", readFileString(t, dst))
+	for i := 1; i <= 2; i++ {
+		assert.False(t, util.PathExists(fmt.Sprintf("%s.%d.bak", dst, i)))
+	}
+	for i := 3; i <= 5; i++ {
+		assert.Equal(t, fmt.Sprintf("<services>%d</services>", i-1), readFileString(t, fmt.Sprintf("%s.%d.bak", dst, i)))
+	}
+
+	// All backups are kept by default
+	if err := writeWithBackup(pkg, "services.xml", "<services>6</services>", 0); err != nil {
+		t.Fatal(err)
+	}
+	backups, err := findBackups(dst)
+	assert.Nil(t, err)
+	assert.Equal(t, []int{3, 4, 5, 6}, backups)
+}
+
 func readFileString(t *testing.T, filename string) string {
 	content, err := ioutil.ReadFile(filename)
 	if err != nil {
@@ -172,6 +288,124 @@ func TestProdSubmit(t *testing.T) {
 	assert.Contains(t, out, "See https://console.vespa.oath.cloud/tenant/t1/application/a1/prod/deployment for deployment progress")
 }
 
+func TestProdSubmitWithCertAuth(t *testing.T) {
+	homeDir := filepath.Join(t.TempDir(), ".vespa")
+	pkgDir := filepath.Join(t.TempDir(), "app")
+	createApplication(t, pkgDir, false)
+
+	httpClient := &mockHttpClient{}
+	execute(command{homeDir: homeDir, args: []string{"config", "set", "application", "t1.a1.i1"}}, t, httpClient)
+	execute(command{homeDir: homeDir, args: []string{"config", "set", "target", "cloud"}}, t, httpClient)
+	execute(command{homeDir: homeDir, args: []string{"cert", pkgDir}}, t, httpClient)
+
+	if cwd, err := os.Getwd(); err != nil {
+		t.Fatal(err)
+	} else {
+		defer os.Chdir(cwd)
+	}
+	if err := os.Chdir(pkgDir); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Setenv("CI", "true"); err != nil {
+		t.Fatal(err)
+	}
+	httpClient.NextResponse(200, `ok`)
+	out, errOut := execute(command{homeDir: homeDir, args: []string{"prod", "submit", "--auth", "cert"}}, t, httpClient)
+	assert.Equal(t, "", errOut, "no API key is required")
+	assert.Contains(t, out, "Success: Submitted")
+	assert.Equal(t, "", httpClient.lastRequest.Header.Get("X-Key-Id"))
+}
+
+func TestProdSubmitInvalidResources(t *testing.T) {
+	pkgDir := filepath.Join(t.TempDir(), "app")
+	createApplication(t, pkgDir, false)
+	servicesPath := filepath.Join(pkgDir, "src", "main", "application", "services.xml")
+	servicesXML := strings.Replace(readFileString(t, servicesPath), `memory="8Gb"`, `memory="1Gb"`, 1)
+	if err := ioutil.WriteFile(servicesPath, []byte(servicesXML), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	httpClient := &mockHttpClient{}
+	homeDir := setupCloudDeploy(t, httpClient, pkgDir)
+
+	_, errOut := execute(command{homeDir: homeDir, args: []string{"prod", "submit", pkgDir}}, t, httpClient)
+	assert.Contains(t, errOut, "invalid resources for cluster qrs in services.xml: memory 1Gb too low for 4 vcpu: must be at least 1Gb per vcpu")
+	assert.Empty(t, httpClient.requests)
+}
+
+func TestProdSubmitMissingTests(t *testing.T) {
+	pkgDir := filepath.Join(t.TempDir(), "app")
+	createApplication(t, pkgDir, false)
+	stagingTestDir := filepath.Join(pkgDir, "src", "test", "application", "tests", "staging-test")
+	if err := os.RemoveAll(stagingTestDir); err != nil {
+		t.Fatal(err)
+	}
+
+	httpClient := &mockHttpClient{}
+	homeDir := setupCloudDeploy(t, httpClient, pkgDir)
+
+	_, errOut := execute(command{homeDir: homeDir, args: []string{"prod", "submit", pkgDir}}, t, httpClient)
+	assert.Contains(t, errOut, "Error: no staging-test tests found: ")
+	assert.Contains(t, errOut, "Hint: No such directory: "+stagingTestDir+"\n")
+	assert.Empty(t, httpClient.requests)
+
+	// Tests which cannot be parsed also abort submission
+	writeTest(filepath.Join(stagingTestDir, "test.json"), []byte("{"), t)
+	_, errOut = execute(command{homeDir: homeDir, args: []string{"prod", "submit", pkgDir}}, t, httpClient)
+	assert.Contains(t, errOut, "Error: failed parsing test at "+filepath.Join(stagingTestDir, "test.json"))
+	assert.Empty(t, httpClient.requests)
+}
+
+func TestProdSubmitJSON(t *testing.T) {
+	pkgDir := filepath.Join(t.TempDir(), "app")
+	createApplication(t, pkgDir, false)
+
+	httpClient := &mockHttpClient{}
+	homeDir := setupCloudDeploy(t, httpClient, pkgDir)
+	if err := os.Setenv("CI", "true"); err != nil {
+		t.Fatal(err)
+	}
+	httpClient.NextResponse(200, `{"message": "application build 42, source revision of repository 'foo', branch 'master' with commit 'bar'", "build": 42}`)
+	out, outErr := execute(command{homeDir: homeDir, args: []string{"prod", "submit", "--format", "json"}}, t, httpClient)
+	assert.Equal(t, `{
+  "build": 42,
+  "tenant": "t1",
+  "application": "a1",
+  "regions": [
+    "aws-us-east-1c"
+  ],
+  "url": "https://console.vespa.oath.cloud/tenant/t1/application/a1/prod/deployment"
+}
+`, out)
+	assert.Contains(t, outErr, "Success: Submitted")
+
+	_, outErr = execute(command{homeDir: homeDir, args: []string{"prod", "submit", "--format", "xml"}}, t, httpClient)
+	assert.Equal(t, "Error: invalid format: \"xml\"\n", outErr)
+}
+
+func TestProdSubmitWithLabels(t *testing.T) {
+	pkgDir := filepath.Join(t.TempDir(), "app")
+	createApplication(t, pkgDir, false)
+
+	httpClient := &mockHttpClient{}
+	homeDir := setupCloudDeploy(t, httpClient, pkgDir)
+	if err := os.Setenv("CI", "true"); err != nil {
+		t.Fatal(err)
+	}
+	out, _ := execute(command{homeDir: homeDir, args: []string{"prod", "submit", "--label", "git-sha=abc123", "--label", "ci-job=a=b"}}, t, httpClient)
+	assert.Contains(t, out, "Success: Submitted")
+	body, err := ioutil.ReadAll(httpClient.lastRequest.Body)
+	assert.Nil(t, err)
+	assert.Contains(t, string(body), `{"labels":{"ci-job":"a=b","git-sha":"abc123"}}`)
+
+	_, outErr := execute(command{homeDir: homeDir, args: []string{"prod", "submit", "--label", "git-sha"}}, t, httpClient)
+	assert.Equal(t, "Error: invalid label: \"git-sha\"\nHint: Labels must be on the form key=value\n", outErr)
+	_, outErr = execute(command{homeDir: homeDir, args: []string{"prod", "submit", "--label", "-sha=abc123"}}, t, httpClient)
+	assert.Equal(t, "Error: invalid label key: \"-sha\"\n", outErr)
+	_, outErr = execute(command{homeDir: homeDir, args: []string{"prod", "submit", "--label", "git-sha="}}, t, httpClient)
+	assert.Equal(t, "Error: invalid value for label git-sha: must be between 1 and 256 characters long\n", outErr)
+}
+
 func TestProdSubmitWithJava(t *testing.T) {
 	homeDir := filepath.Join(t.TempDir(), ".vespa")
 	pkgDir := filepath.Join(t.TempDir(), "app")
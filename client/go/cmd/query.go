@@ -5,46 +5,91 @@
 package cmd
 
 import (
+	"bufio"
 	"fmt"
 	"log"
 	"net/http"
 	"net/url"
+	"os"
+	"strconv"
 	"strings"
 	"time"
 
+	"github.com/mattn/go-isatty"
 	"github.com/spf13/cobra"
 	"github.com/vespa-engine/vespa/client/go/util"
+	"github.com/vespa-engine/vespa/client/go/vespa"
 )
 
-var queryTimeoutSecs int
+// queryPrompt is the prompt shown by vespa query --interactive.
+const queryPrompt = "vespa> "
+
+var (
+	queryTimeoutSecs int
+	prettyArg        bool
+	noPrettyArg      bool
+	interactiveArg   bool
+)
 
 func init() {
 	rootCmd.AddCommand(queryCmd)
 	queryCmd.Flags().IntVarP(&queryTimeoutSecs, "timeout", "T", 10, "Timeout for the query in seconds")
+	queryCmd.Flags().BoolVarP(&prettyArg, "pretty", "", false, "Always pretty-print the response")
+	queryCmd.Flags().BoolVarP(&noPrettyArg, "no-pretty", "", false, "Never pretty-print the response")
+	queryCmd.Flags().BoolVarP(&interactiveArg, "interactive", "i", false, "Read queries from standard input, one per line, and print each response")
 }
 
 var queryCmd = &cobra.Command{
-	Use:     "query query-parameters",
-	Short:   "Issue a query to Vespa",
-	Example: `$ vespa query "yql=select * from music where album contains 'head';" hits=5`,
+	Use:   "query query-parameters",
+	Short: "Issue a query to Vespa",
+	Example: `$ vespa query "yql=select * from music where album contains 'head';" hits=5
+$ vespa query --no-pretty "yql=select * from music where true;"
+$ vespa query --interactive hits=5`,
 	Long: `Issue a query to Vespa.
 
 Any parameter from https://docs.vespa.ai/en/reference/query-api-reference.html
-can be set by the syntax [parameter-name]=[value].`,
+can be set by the syntax [parameter-name]=[value].
+
+The response is pretty-printed when writing to a terminal, and printed as
+received otherwise. Use --pretty or --no-pretty to override this.
+
+With --interactive, queries are read from standard input, one per line, and
+the response to each is pretty-printed unless --no-pretty is given. A line
+starting with "select" is sent as YQL, while other lines hold parameters on
+the same form as the arguments of this command, quoted where they contain
+spaces. Parameters given as arguments are added to every query. The commands
+"history" lists previous queries, "!n" repeats query number n and "!!" repeats
+the last query. Type "exit", press Ctrl-D or Ctrl-C to exit.`,
 	// TODO: Support referencing a query json file
 	DisableAutoGenTag: true,
 	SilenceUsage:      true,
-	Args:              cobra.MinimumNArgs(1),
+	Args: func(cmd *cobra.Command, args []string) error {
+		if interactiveArg {
+			return nil
+		}
+		return cobra.MinimumNArgs(1)(cmd, args)
+	},
 	RunE: func(cmd *cobra.Command, args []string) error {
+		if interactiveArg {
+			return queryInteractive(args)
+		}
 		return query(args)
 	},
 }
 
 func query(arguments []string) error {
+	if prettyArg && noPrettyArg {
+		return fmt.Errorf("cannot combine --pretty and --no-pretty")
+	}
 	service, err := getService("query", 0, "")
 	if err != nil {
 		return err
 	}
+	return queryService(service, arguments, prettyPrint())
+}
+
+// queryService sends a query with given arguments to service, and prints the response.
+func queryService(service *vespa.Service, arguments []string, pretty bool) error {
 	url, _ := url.Parse(service.BaseURL + "/search/")
 	urlQuery := url.Query()
 	for i := 0; i < len(arguments); i++ {
@@ -62,14 +107,18 @@ func query(arguments []string) error {
 	if err != nil {
 		return fmt.Errorf("invalid query timeout: %w", err)
 	}
-	response, err := service.Do(&http.Request{URL: url}, deadline+time.Second) // Slightly longer than query timeout
+	response, err := service.Do((&http.Request{URL: url}).WithContext(commandContext), deadline+time.Second) // Slightly longer than query timeout
 	if err != nil {
 		return fmt.Errorf("request failed: %w", err)
 	}
 	defer response.Body.Close()
 
 	if response.StatusCode == 200 {
-		log.Print(util.ReaderToJSON(response.Body))
+		if pretty {
+			log.Print(util.ReaderToJSON(response.Body))
+		} else {
+			log.Print(util.ReaderToString(response.Body))
+		}
 	} else if response.StatusCode/100 == 4 {
 		return fmt.Errorf("invalid query: %s\n%s", response.Status, util.ReaderToJSON(response.Body))
 	} else {
@@ -78,6 +127,157 @@ func query(arguments []string) error {
 	return nil
 }
 
+// queryInteractive reads queries from stdin, one per line, and sends each to the query service, until stdin is closed
+// or the command is interrupted. Each query includes defaultArgs.
+func queryInteractive(defaultArgs []string) error {
+	if prettyArg && noPrettyArg {
+		return fmt.Errorf("cannot combine --pretty and --no-pretty")
+	}
+	service, err := getService("query", 0, "")
+	if err != nil {
+		return err
+	}
+	interactive := stdinIsTerminal()
+	if interactive {
+		fmt.Fprintln(stderr, "Sending queries to", color.Cyan(service.BaseURL).String()+`. Type "exit" or press Ctrl-C to exit`)
+	}
+	lines := make(chan string)
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		defer close(lines)
+		scanner := bufio.NewScanner(stdin)
+		for scanner.Scan() {
+			select {
+			case lines <- scanner.Text():
+			case <-done:
+				return
+			}
+		}
+	}()
+	var history []string
+	for {
+		if interactive {
+			fmt.Fprint(stderr, queryPrompt)
+		}
+		var line string
+		select {
+		case <-commandContext.Done():
+			return nil
+		case l, ok := <-lines:
+			if !ok {
+				if interactive {
+					fmt.Fprintln(stderr)
+				}
+				return nil
+			}
+			line = strings.TrimSpace(l)
+		}
+		switch {
+		case line == "":
+			continue
+		case line == "exit" || line == "quit":
+			return nil
+		case line == "history":
+			for i, h := range history {
+				fmt.Fprintf(stdout, "%4d  %s\n", i+1, h)
+			}
+			continue
+		case strings.HasPrefix(line, "!"):
+			previous, err := historyEntry(history, line)
+			if err != nil {
+				printErr(err)
+				continue
+			}
+			fmt.Fprintln(stderr, previous)
+			line = previous
+		}
+		history = append(history, line)
+		args, err := splitQueryLine(line)
+		if err != nil {
+			printErr(err)
+			continue
+		}
+		if err := queryService(service, append(append([]string{}, defaultArgs...), args...), !noPrettyArg); err != nil {
+			printErr(err)
+		}
+	}
+}
+
+// historyEntry returns the entry in history referenced by ref, which is either "!!" or "!n" for entry number n.
+func historyEntry(history []string, ref string) (string, error) {
+	if len(history) == 0 {
+		return "", fmt.Errorf("no previous query")
+	}
+	if ref == "!!" {
+		return history[len(history)-1], nil
+	}
+	n, err := strconv.Atoi(ref[1:])
+	if err != nil || n < 1 || n > len(history) {
+		return "", fmt.Errorf("no such query in history: %s", ref)
+	}
+	return history[n-1], nil
+}
+
+// splitQueryLine splits an interactively entered query into arguments. A line starting with "select" is a single YQL
+// argument, while other lines are split on whitespace outside quotes.
+func splitQueryLine(line string) ([]string, error) {
+	if strings.HasPrefix(strings.ToLower(line), "select ") {
+		return []string{line}, nil
+	}
+	var (
+		args    []string
+		current strings.Builder
+		quote   rune
+		inArg   bool
+	)
+	for _, c := range line {
+		switch {
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			} else {
+				current.WriteRune(c)
+			}
+		case c == '"' || c == '\'':
+			quote = c
+			inArg = true
+		case c == ' ' || c == '\t':
+			if inArg {
+				args = append(args, current.String())
+				current.Reset()
+				inArg = false
+			}
+		default:
+			current.WriteRune(c)
+			inArg = true
+		}
+	}
+	if quote != 0 {
+		return nil, fmt.Errorf("unterminated quote in query: %s", line)
+	}
+	if inArg {
+		args = append(args, current.String())
+	}
+	return args, nil
+}
+
+// stdinIsTerminal returns whether stdin is a terminal.
+func stdinIsTerminal() bool {
+	f, ok := stdin.(*os.File)
+	return ok && isatty.IsTerminal(f.Fd())
+}
+
+func prettyPrint() bool {
+	if prettyArg {
+		return true
+	}
+	if noPrettyArg {
+		return false
+	}
+	return stdoutIsTerminal()
+}
+
 func splitArg(argument string) (string, string) {
 	equalsIndex := strings.Index(argument, "=")
 	if equalsIndex < 1 {
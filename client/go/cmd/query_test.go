@@ -5,6 +5,8 @@
 package cmd
 
 import (
+	"bytes"
+	"path/filepath"
 	"strconv"
 	"testing"
 
@@ -35,6 +37,24 @@ func TestQueryWithExplicitYqlParameter(t *testing.T) {
 		"yql=select from sources * where title contains 'foo'")
 }
 
+func TestQueryPrettyPrint(t *testing.T) {
+	defer func(f func() bool) { stdoutIsTerminal = f }(stdoutIsTerminal)
+	response := `{"root":{"fields":{"totalCount":1}}}`
+	pretty := "{\n    \"root\": {\n        \"fields\": {\n            \"totalCount\": 1\n        }\n    }\n}\n"
+	compact := response + "\n"
+
+	stdoutIsTerminal = func() bool { return true }
+	assertQueryOutput(t, pretty, response, "select * from sources * where true")
+	assertQueryOutput(t, compact, response, "--no-pretty", "select * from sources * where true")
+
+	stdoutIsTerminal = func() bool { return false }
+	assertQueryOutput(t, compact, response, "select * from sources * where true")
+	assertQueryOutput(t, pretty, response, "--pretty", "select * from sources * where true")
+
+	_, outErr := execute(command{args: []string{"query", "--pretty", "--no-pretty", "select * from sources * where true"}}, t, &mockHttpClient{})
+	assert.Equal(t, "Error: cannot combine --pretty and --no-pretty\n", outErr)
+}
+
 func TestIllegalQuery(t *testing.T) {
 	assertQueryError(t, 401, "query error message")
 }
@@ -43,11 +63,18 @@ func TestServerError(t *testing.T) {
 	assertQueryServiceError(t, 501, "server error message")
 }
 
+func assertQueryOutput(t *testing.T, expectedOutput, response string, args ...string) {
+	client := &mockHttpClient{}
+	client.NextResponse(200, response)
+	out, _ := execute(command{args: append([]string{"query"}, args...)}, t, client)
+	assert.Equal(t, expectedOutput, out)
+}
+
 func assertQuery(t *testing.T, expectedQuery string, query ...string) {
 	client := &mockHttpClient{}
 	client.NextResponse(200, "{\"query\":\"result\"}")
 	assert.Equal(t,
-		"{\n    \"query\": \"result\"\n}\n",
+		"{\"query\":\"result\"}\n",
 		executeCommand(t, client, []string{"query"}, query),
 		"query output")
 	queryURL, err := queryServiceURL(client)
@@ -84,3 +111,86 @@ func queryServiceURL(client *mockHttpClient) (string, error) {
 	}
 	return service.BaseURL, nil
 }
+
+func TestQueryCloudUsesCachedEndpoints(t *testing.T) {
+	homeDir := filepath.Join(t.TempDir(), ".vespa")
+	cacheDir := filepath.Join(t.TempDir(), ".cache", "vespa")
+	client := &mockHttpClient{}
+	execute(command{homeDir: homeDir, cacheDir: cacheDir, args: []string{"cert", "-a", "t1.a1.i1", mockApplicationPackage(t, false)}}, t, client)
+	execute(command{homeDir: homeDir, cacheDir: cacheDir, args: []string{"api-key", "-a", "t1.a1.i1"}}, t, client)
+	args := []string{"query", "-t", "cloud", "-a", "t1.a1.i1", "select * from sources * where true"}
+	endpoints := `{"endpoints":[{"cluster":"default","url":"https://default.example.com","scope":"zone"}]}`
+	discoveryPath := "/application/v4/tenant/t1/application/a1/instance/i1/environment/dev/region/aws-us-east-1c"
+
+	// Endpoints are discovered and cached
+	client.requests = nil
+	client.NextResponse(200, endpoints)
+	client.NextResponse(200, "{}")
+	_, errOut := execute(command{homeDir: homeDir, cacheDir: cacheDir, args: args}, t, client)
+	assert.Equal(t, "", errOut)
+	assert.Equal(t, 2, len(client.requests))
+	assert.Equal(t, discoveryPath, client.requests[0].URL.Path)
+	assert.Equal(t, "default.example.com", client.lastRequest.URL.Host)
+
+	// Cached endpoints are used without discovery
+	client.requests = nil
+	client.NextResponse(200, "{}")
+	_, errOut = execute(command{homeDir: homeDir, cacheDir: cacheDir, args: args}, t, client)
+	assert.Equal(t, "", errOut)
+	assert.Equal(t, 1, len(client.requests))
+	assert.Equal(t, "default.example.com", client.lastRequest.URL.Host)
+
+	// Endpoints are discovered again on request
+	client.requests = nil
+	client.NextResponse(200, endpoints)
+	client.NextResponse(200, "{}")
+	_, errOut = execute(command{homeDir: homeDir, cacheDir: cacheDir, args: append(args, "--refresh-endpoints")}, t, client)
+	assert.Equal(t, "", errOut)
+	assert.Equal(t, 2, len(client.requests))
+	assert.Equal(t, discoveryPath, client.requests[0].URL.Path)
+}
+
+func TestQueryInteractive(t *testing.T) {
+	client := &mockHttpClient{}
+	client.NextResponse(200, `{"query":"1"}`)
+	client.NextResponse(200, `{"query":"2"}`)
+	client.NextResponse(200, `{"query":"3"}`)
+	client.NextResponse(400, `{"error":"bad"}`)
+	client.NextResponse(400, `{"error":"bad"}`)
+	input := bytes.NewBufferString(`select * from music where true
+
+hits=5 'ranking=my profile'
+!5
+!2
+history
+ranking=bad
+!!
+"unterminated
+exit
+select * from ignored where true
+`)
+	out, outErr := execute(command{stdin: input, args: []string{"query", "--interactive", "timeout=5s"}}, t, client)
+	assert.Equal(t, "{\n    \"query\": \"1\"\n}\n"+
+		"{\n    \"query\": \"2\"\n}\n"+
+		"{\n    \"query\": \"3\"\n}\n"+
+		"   1  select * from music where true\n"+
+		"   2  hits=5 'ranking=my profile'\n"+
+		"   3  hits=5 'ranking=my profile'\n", out)
+	assert.Equal(t, "Error: no such query in history: !5\n"+
+		"hits=5 'ranking=my profile'\n"+
+		"Error: invalid query: Status 400\n{\n    \"error\": \"bad\"\n}\n"+
+		"ranking=bad\n"+
+		"Error: invalid query: Status 400\n{\n    \"error\": \"bad\"\n}\n"+
+		"Error: unterminated quote in query: \"unterminated\n", outErr)
+	var queries []string
+	for _, r := range client.requests {
+		queries = append(queries, r.URL.RawQuery)
+	}
+	assert.Equal(t, []string{
+		"timeout=5s&yql=select+%2A+from+music+where+true",
+		"hits=5&ranking=my+profile&timeout=5s",
+		"hits=5&ranking=my+profile&timeout=5s",
+		"ranking=bad&timeout=5s",
+		"ranking=bad&timeout=5s",
+	}, queries)
+}
@@ -0,0 +1,112 @@
+// Copyright Yahoo. Licensed under the terms of the Apache 2.0 license. See LICENSE in the project root.
+// Receipts of deployments, for audit trails
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/user"
+	"time"
+
+	"github.com/vespa-engine/vespa/client/go/build"
+	"github.com/vespa-engine/vespa/client/go/util"
+	"github.com/vespa-engine/vespa/client/go/vespa"
+)
+
+const (
+	outcomeSuccess = "success"
+	outcomeFailure = "failure"
+	outcomeSkipped = "skipped"
+)
+
+// receipt records a deployment or submission of an application package.
+type receipt struct {
+	Time          time.Time           `json:"time"`
+	User          string              `json:"user"`
+	CLIVersion    string              `json:"cliVersion"`
+	Target        string              `json:"target"`
+	Application   string              `json:"application,omitempty"`
+	PackageSHA256 string              `json:"packageSha256"`
+	Build         int64               `json:"build,omitempty"`
+	Deployments   []receiptDeployment `json:"deployments,omitempty"`
+	Outcome       string              `json:"outcome"`
+	Error         string              `json:"error,omitempty"`
+}
+
+// receiptDeployment records the deployment to a single zone. For non-cloud targets the zone is empty, and the deployment
+// is identified by its session ID instead of a run ID.
+type receiptDeployment struct {
+	Zone      string `json:"zone,omitempty"`
+	RunID     int64  `json:"runId,omitempty"`
+	SessionID int64  `json:"sessionId,omitempty"`
+	Outcome   string `json:"outcome"`
+	Error     string `json:"error,omitempty"`
+}
+
+// newReceipt creates a receipt for deploying the application package with given hash using opts. The outcome is
+// successful until a failed deployment is added.
+func newReceipt(opts vespa.DeploymentOpts, hash string) *receipt {
+	r := &receipt{
+		Time:          time.Now().UTC(),
+		User:          currentUser(),
+		CLIVersion:    build.Version,
+		Target:        opts.Target.Type(),
+		PackageSHA256: hash,
+		Outcome:       outcomeSuccess,
+	}
+	if targetType, err := getTargetType(); err == nil {
+		r.Target = targetType
+	}
+	if opts.IsCloud() {
+		r.Application = opts.Deployment.Application.String()
+	}
+	return r
+}
+
+// addDeployment records the deployment using opts, which resulted in sessionOrRunID or err.
+func (r *receipt) addDeployment(opts vespa.DeploymentOpts, sessionOrRunID int64, err error) {
+	d := receiptDeployment{Outcome: outcomeSuccess}
+	if opts.IsCloud() {
+		d.Zone = opts.Deployment.Zone.String()
+		d.RunID = sessionOrRunID
+	} else {
+		d.SessionID = sessionOrRunID
+	}
+	if err != nil {
+		d.Outcome = outcomeFailure
+		d.Error = util.Redact(err.Error())
+		d.RunID = 0
+		d.SessionID = 0
+		r.Outcome = outcomeFailure
+	}
+	r.Deployments = append(r.Deployments, d)
+}
+
+// fail records that the command failed with err.
+func (r *receipt) fail(err error) {
+	r.Outcome = outcomeFailure
+	r.Error = util.Redact(err.Error())
+}
+
+// writeReceipt atomically writes r as JSON to path. Nothing is written if path is empty.
+func writeReceipt(path string, r *receipt) error {
+	if path == "" {
+		return nil
+	}
+	data, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := util.AtomicWriteFile(path, append(data, '\n')); err != nil {
+		return fmt.Errorf("could not write receipt: %w", err)
+	}
+	return nil
+}
+
+func currentUser() string {
+	if u, err := user.Current(); err == nil && u.Username != "" {
+		return u.Username
+	}
+	return os.Getenv("USER")
+}
@@ -0,0 +1,139 @@
+// Copyright Yahoo. Licensed under the terms of the Apache 2.0 license. See LICENSE in the project root.
+// vespa reindex command
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/vespa-engine/vespa/client/go/vespa"
+)
+
+var (
+	reindexClusterArg string
+	reindexTypeArg    string
+
+	// reindexPollInterval is the interval between reads of reindexing status when waiting for reindexing to complete.
+	reindexPollInterval = 5 * time.Second
+)
+
+func init() {
+	rootCmd.AddCommand(reindexCmd)
+	reindexCmd.Flags().StringVarP(&reindexClusterArg, "cluster", "", "", "The content cluster to reindex. All clusters are reindexed if not given")
+	reindexCmd.Flags().StringVarP(&reindexTypeArg, "type", "", "", "The document type to reindex. All document types are reindexed if not given")
+}
+
+var reindexCmd = &cobra.Command{
+	Use:   "reindex",
+	Short: "Trigger reindexing of documents",
+	Long: `Trigger reindexing of documents.
+
+This reindexes the documents of all document types in all content clusters, or
+those of the given cluster and document type. Reindexing is required by some
+schema changes, and starts once the deployment of the change has converged.
+
+With --wait, the progress of each document type being reindexed is reported
+until reindexing completes, or the given number of seconds pass.`,
+	Example: `$ vespa reindex
+$ vespa reindex --cluster music --type album
+$ vespa reindex --wait 600`,
+	DisableAutoGenTag: true,
+	SilenceUsage:      true,
+	Args:              cobra.ExactArgs(0),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		target, err := getTarget()
+		if err != nil {
+			return err
+		}
+		if err := target.Reindex(commandContext, reindexClusterArg, reindexTypeArg); err != nil {
+			return fmt.Errorf("could not trigger reindexing: %w", err)
+		}
+		printSuccess("Triggered reindexing of ", reindexSelection())
+		if waitSecsArg > 0 {
+			return waitForReindexing(commandContext, target, time.Duration(waitSecsArg)*time.Second)
+		}
+		return nil
+	},
+}
+
+func reindexSelection() string {
+	selection := "all document types"
+	if reindexTypeArg != "" {
+		selection = "document type " + color.Cyan(reindexTypeArg).String()
+	}
+	if reindexClusterArg != "" {
+		return selection + " in cluster " + color.Cyan(reindexClusterArg).String()
+	}
+	return selection + " in all clusters"
+}
+
+// waitForReindexing polls the reindexing status of target until all selected document types are done reindexing,
+// timeout passes, or ctx is done. Progress is printed whenever the status of a document type changes.
+func waitForReindexing(ctx context.Context, target vespa.Target, timeout time.Duration) error {
+	log.Printf("Waiting up to %d %s for reindexing to complete ...", color.Cyan(int(timeout.Seconds())), color.Cyan("seconds"))
+	deadline := time.Now().Add(timeout)
+	printed := make(map[string]string)
+	for {
+		statuses, err := target.ReindexStatus(ctx)
+		if err != nil {
+			return fmt.Errorf("could not read reindexing status: %w", err)
+		}
+		var selected []vespa.ReindexStatus
+		done := true
+		for _, s := range statuses {
+			if (reindexClusterArg != "" && s.Cluster != reindexClusterArg) || (reindexTypeArg != "" && s.DocumentType != reindexTypeArg) {
+				continue
+			}
+			selected = append(selected, s)
+			done = done && s.Done()
+			key := s.Cluster + "/" + s.DocumentType
+			if line := formatReindexStatus(s); printed[key] != line {
+				log.Print(line)
+				printed[key] = line
+			}
+		}
+		if done && len(selected) > 0 {
+			failed := 0
+			for _, s := range selected {
+				if s.State == "failed" {
+					failed++
+				}
+			}
+			if failed > 0 {
+				return fmt.Errorf("reindexing failed for %d of %d document types", failed, len(selected))
+			}
+			printSuccess("Reindexing completed")
+			return nil
+		}
+		if !time.Now().Add(reindexPollInterval).Before(deadline) {
+			return errHint(fmt.Errorf("reindexing did not complete within %d seconds", int(timeout.Seconds())),
+				"Reindexing continues in the background. Wait longer with --wait")
+		}
+		select {
+		case <-time.After(reindexPollInterval):
+		case <-ctx.Done():
+			return fmt.Errorf("stopped waiting for reindexing: %w", ctx.Err())
+		}
+	}
+}
+
+func formatReindexStatus(s vespa.ReindexStatus) string {
+	line := fmt.Sprintf("%s/%s: ", s.Cluster, s.DocumentType)
+	switch s.State {
+	case "running":
+		line += fmt.Sprintf("%s (%.1f%%)", color.Yellow(s.State), s.Progress*100)
+	case "successful":
+		line += color.Green(s.State).String()
+	case "failed":
+		line += color.Red(s.State).String()
+	default:
+		line += s.State
+	}
+	if s.Message != "" {
+		line += ": " + s.Message
+	}
+	return line
+}
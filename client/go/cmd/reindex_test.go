@@ -0,0 +1,53 @@
+// Copyright Yahoo. Licensed under the terms of the Apache 2.0 license. See LICENSE in the project root.
+package cmd
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReindex(t *testing.T) {
+	defer func(interval time.Duration) { reindexPollInterval = interval }(reindexPollInterval)
+	reindexPollInterval = 0
+	client := &mockHttpClient{}
+	client.NextResponse(200, `{"message": "Reindexing document types [album] in 'music' of application default.default"}`)
+	client.NextResponse(200, `{"enabled": true, "clusters": {"music": {"pending": {"album": 42}, "ready": {"album": {"state": "successful"}, "artist": {"state": "successful"}}}}}`)
+	client.NextResponse(200, `{"enabled": true, "clusters": {"music": {"pending": {}, "ready": {"album": {"state": "running", "progress": 0.25}}}}}`)
+	client.NextResponse(200, `{"enabled": true, "clusters": {"music": {"pending": {}, "ready": {"album": {"state": "running", "progress": 0.25}}}}}`)
+	client.NextResponse(200, `{"enabled": true, "clusters": {"music": {"pending": {}, "ready": {"album": {"state": "successful", "progress": 1.0}}}}}`)
+	out, outErr := execute(command{args: []string{"reindex", "--cluster", "music", "--type", "album", "--wait", "60"}}, t, client)
+	assert.Equal(t, "", outErr)
+	assert.Equal(t, "Success: Triggered reindexing of document type album in cluster music\n"+
+		"Waiting up to 60 seconds for reindexing to complete ...\n"+
+		"music/album: pending\n"+
+		"music/album: running (25.0%)\n"+
+		"music/album: successful\n"+
+		"Success: Reindexing completed\n", out)
+	assert.Equal(t, "POST", client.requests[0].Method)
+	assert.Equal(t, "http://127.0.0.1:19071/application/v2/tenant/default/application/default/environment/prod/region/default/instance/default/reindex?clusterId=music&documentType=album",
+		client.requests[0].URL.String())
+	assert.Equal(t, "http://127.0.0.1:19071/application/v2/tenant/default/application/default/environment/prod/region/default/instance/default/reindexing",
+		client.lastRequest.URL.String())
+
+	// Reports failure
+	client.NextResponse(200, `{}`)
+	client.NextResponse(200, `{"enabled": true, "clusters": {"music": {"ready": {"album": {"state": "failed", "message": "out of disk"}, "artist": {"state": "successful"}}}}}`)
+	out, outErr = execute(command{args: []string{"reindex", "-w", "60"}}, t, client)
+	assert.Equal(t, "Success: Triggered reindexing of all document types in all clusters\n"+
+		"Waiting up to 60 seconds for reindexing to complete ...\n"+
+		"music/album: failed: out of disk\n"+
+		"music/artist: successful\n", out)
+	assert.Equal(t, "Error: reindexing failed for 1 of 2 document types\n", outErr)
+
+	// Waiting stops when the command is cancelled
+	reindexPollInterval = time.Hour
+	client.NextResponse(200, `{}`)
+	client.NextResponse(200, `{"enabled": true, "clusters": {"music": {"ready": {"album": {"state": "running", "progress": 0.5}}}}}`)
+	deadline := time.Now().Add(time.Second).Format(time.RFC3339)
+	start := time.Now()
+	_, outErr = execute(command{args: []string{"reindex", "-w", "7200", "--deadline", deadline}}, t, client)
+	assert.True(t, time.Since(start) < 10*time.Second, "waiting is cancelled")
+	assert.Contains(t, outErr, "deadline exceeded")
+}
@@ -5,16 +5,24 @@
 package cmd
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"io"
 	"io/ioutil"
 	"log"
+	"net/url"
 	"os"
+	"os/signal"
+	"time"
 
 	"github.com/logrusorgru/aurora/v3"
 	"github.com/mattn/go-colorable"
 	"github.com/mattn/go-isatty"
 	"github.com/spf13/cobra"
+	"github.com/vespa-engine/vespa/client/go/auth0"
+	"github.com/vespa-engine/vespa/client/go/util"
+	"github.com/vespa-engine/vespa/client/go/vespa"
 )
 
 // ErrCLI is an error returned to the user. It wraps an exit status, a regular error and optional hints for resolving
@@ -26,6 +34,8 @@ type ErrCLI struct {
 	error
 }
 
+func (e ErrCLI) Unwrap() error { return e.error }
+
 var (
 	rootCmd = &cobra.Command{
 		Use:   "vespa command-name",
@@ -35,38 +45,138 @@ var (
 Use it on Vespa instances running locally, remotely or in the cloud.
 Prefer web service API's to this in production.
 
+The number of HTTP requests in flight at the same time can be limited by
+setting the environment variable VESPA_CLI_MAX_CONCURRENCY.
+
+The endpoints of a Vespa Cloud deployment are cached for an hour after they
+are discovered, so that subsequent commands can skip discovery. Use
+--refresh-endpoints to discover them again.
+
+Output uses LF line endings. Use --crlf to use Windows (CRLF) line endings,
+and --bom to write a UTF-8 byte order mark before the output, for tools which
+require these.
+
+Unknown commands are dispatched to an executable named vespa-<command> on
+PATH, if one exists. Arguments following the command are passed to the
+executable, and flags to it must follow "--". The target, application,
+tenant and Vespa CLI home directory are passed in the environment variables
+VESPA_CLI_TARGET, VESPA_CLI_APPLICATION, VESPA_CLI_TENANT and VESPA_CLI_HOME,
+and the API URL in VESPA_CLI_API_URL when the target is cloud.
+
+The exit status distinguishes these types of failure, for use in scripts:
+
+  1    Any failure not listed below
+  3    Tests failed
+  4    Credentials are missing, or rejected by the target
+  5    Application package or other input is invalid
+  6    Network failure, service unavailable, or timed out waiting for a service
+  7    Deployment run failed, or the application is not deployed
+  130  Interrupted
+
 Vespa documentation: https://docs.vespa.ai`,
 		DisableAutoGenTag: true,
 		SilenceErrors:     true, // We have our own error printing
 		SilenceUsage:      false,
 		PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
-			return configureOutput()
+			if err := configureOutput(); err != nil {
+				return err
+			}
+			if err := configureCI(); err != nil {
+				return err
+			}
+			maxConcurrency, err := getMaxConcurrency()
+			if err != nil {
+				return err
+			}
+			util.SetMaxConcurrency(maxConcurrency)
+			if printRequestsArg {
+				util.SetVerboseWriter(stderr)
+			} else {
+				util.SetVerboseWriter(nil)
+			}
+			return configureContext()
 		},
 		Args: cobra.MinimumNArgs(1),
 		RunE: func(cmd *cobra.Command, args []string) error {
+			if path, ok := findPlugin(args[0]); ok {
+				return runPlugin(path, args[1:])
+			}
 			return fmt.Errorf("invalid command: %s", args[0])
 		},
 	}
 
-	targetArg      string
-	applicationArg string
-	waitSecsArg    int
-	colorArg       string
-	quietArg       bool
-	stdin          io.ReadWriter = os.Stdin
+	targetArg           string
+	applicationArg      string
+	waitSecsArg         int
+	colorArg            string
+	quietArg            bool
+	deadlineArg         string
+	ciArg               string
+	profileArg          string
+	printRequestsArg    bool
+	authArg             string
+	refreshEndpointsArg bool
+	packageRootArg      string
+	crlfArg             bool
+	bomArg              bool
+	configDirArg        string
+	stdin               io.ReadWriter = os.Stdin
+
+	// commandContext is the context of the current command, which is done when the command is cancelled
+	commandContext = context.Background()
+
+	// stopContext cancels the context of the current command and stops handling of interrupt signals
+	stopContext = func() {}
+
+	// notifyInterrupt returns a channel receiving interrupt signals, and a function which stops delivery of signals.
+	// This is a variable so that it can be overridden in tests.
+	notifyInterrupt = func() (<-chan os.Signal, func()) {
+		signals := make(chan os.Signal, 1)
+		signal.Notify(signals, os.Interrupt)
+		return signals, func() { signal.Stop(signals) }
+	}
+
+	// exit exits the program with the given status. This is a variable so that it can be overridden in tests.
+	exit = os.Exit
 
 	color  = aurora.NewAurora(false)
 	stdout = colorable.NewColorableStdout()
 	stderr = colorable.NewColorableStderr()
 )
 
+// Exit statuses distinguishing types of failure. Any other failure exits with status 1.
 const (
-	applicationFlag = "application"
-	targetFlag      = "target"
-	waitFlag        = "wait"
-	colorFlag       = "color"
-	quietFlag       = "quiet"
-	cloudAuthFlag   = "cloudAuth"
+	// testFailureStatus is the exit status when tests run by the command fail.
+	testFailureStatus = 3
+	// authFailureStatus is the exit status when credentials are missing, or rejected by the target.
+	authFailureStatus = 4
+	// validationFailureStatus is the exit status when an application package, or other input, is invalid.
+	validationFailureStatus = 5
+	// networkFailureStatus is the exit status when a request fails to complete, or waiting for a service times out.
+	networkFailureStatus = 6
+	// deploymentFailureStatus is the exit status when a deployment run fails, or the application is not deployed.
+	deploymentFailureStatus = 7
+	// interruptedStatus is the exit status of a command cancelled by an interrupt signal.
+	interruptedStatus = 130
+)
+
+const (
+	applicationFlag      = "application"
+	targetFlag           = "target"
+	waitFlag             = "wait"
+	colorFlag            = "color"
+	quietFlag            = "quiet"
+	deadlineFlag         = "deadline"
+	ciFlag               = "ci"
+	profileFlag          = "profile"
+	printRequestsFlag    = "print-requests"
+	authFlag             = "auth"
+	refreshEndpointsFlag = "refresh-endpoints"
+	packageRootFlag      = "package-root"
+	crlfFlag             = "crlf"
+	bomFlag              = "bom"
+	configDirFlag        = "config-dir"
+	cloudAuthFlag        = "cloudAuth"
 )
 
 func isTerminal() bool {
@@ -79,12 +189,17 @@ func isTerminal() bool {
 	return false
 }
 
+// stdoutIsTerminal returns whether stdout is a terminal. This is a variable so that it can be overridden in tests.
+var stdoutIsTerminal = func() bool {
+	f, ok := stdout.(*os.File)
+	return ok && isatty.IsTerminal(f.Fd())
+}
+
 func configureOutput() error {
 	if quietArg {
 		stdout = ioutil.Discard
 	}
 	log.SetFlags(0) // No timestamps
-	log.SetOutput(stdout)
 
 	config, err := LoadConfig()
 	if err != nil {
@@ -106,15 +221,80 @@ func configureOutput() error {
 		return errHint(fmt.Errorf("invalid value for %s option", colorFlag), "Must be \"auto\", \"never\" or \"always\"")
 	}
 	color = aurora.NewAurora(colorize)
+
+	// Wrap output last, as terminal detection requires the unwrapped output
+	if bomArg && !quietArg {
+		stdout = util.NewBOMWriter(stdout)
+	}
+	if crlfArg {
+		stdout = util.NewCRLFWriter(stdout)
+		stderr = util.NewCRLFWriter(stderr)
+	}
+	log.SetOutput(stdout)
+	return nil
+}
+
+// configureContext sets the context of the current command, which is also the default context of requests. The context
+// is cancelled on the first interrupt signal, and when the deadline given by the deadline flag passes.
+func configureContext() error {
+	ctx, cancel := context.WithCancel(context.Background())
+	if deadlineArg != "" {
+		deadline, err := time.Parse(time.RFC3339, deadlineArg)
+		if err != nil {
+			cancel()
+			return errHint(fmt.Errorf("invalid value for %s option: %w", deadlineFlag, err), "Deadline must be a timestamp in RFC3339 format, e.g. 2021-08-25T14:30:00Z")
+		}
+		var cancelDeadline context.CancelFunc
+		ctx, cancelDeadline = context.WithDeadline(ctx, deadline)
+		cancelParent := cancel
+		cancel = func() { cancelDeadline(); cancelParent() }
+	}
+	signals, stopSignals := notifyInterrupt()
+	done := make(chan struct{})
+	go handleInterrupts(signals, cancel, done)
+	stopContext = func() {
+		stopSignals()
+		close(done)
+		cancel()
+		commandContext = context.Background()
+	}
+	commandContext = ctx
 	return nil
 }
 
+// handleInterrupts cancels the current command on the first signal received on signals, and exits on the second. It
+// returns when done is closed.
+func handleInterrupts(signals <-chan os.Signal, cancel context.CancelFunc, done <-chan struct{}) {
+	select {
+	case <-signals:
+		fmt.Fprintln(stderr, color.Yellow("Interrupted:"), "Cancelling command. Interrupt again to exit immediately")
+		cancel()
+	case <-done:
+		return
+	}
+	select {
+	case <-signals:
+		exit(interruptedStatus)
+	case <-done:
+	}
+}
+
 func init() {
 	rootCmd.PersistentFlags().StringVarP(&targetArg, targetFlag, "t", "local", "The name or URL of the recipient of this command")
 	rootCmd.PersistentFlags().StringVarP(&applicationArg, applicationFlag, "a", "", "The application to manage")
 	rootCmd.PersistentFlags().IntVarP(&waitSecsArg, waitFlag, "w", 0, "Number of seconds to wait for a service to become ready")
 	rootCmd.PersistentFlags().StringVarP(&colorArg, colorFlag, "c", "auto", "Whether to use colors in output. Can be \"auto\", \"never\" or \"always\"")
 	rootCmd.PersistentFlags().BoolVarP(&quietArg, quietFlag, "q", false, "Quiet mode. Only errors are printed.")
+	rootCmd.PersistentFlags().StringVar(&ciArg, ciFlag, "", "Format errors and key messages as annotations for this CI system. Can be \"github\", \"teamcity\" or \"none\". Detected from the environment if not set")
+	rootCmd.PersistentFlags().StringVar(&deadlineArg, deadlineFlag, "", "Abort the command if it has not completed by this timestamp (RFC3339 format)")
+	rootCmd.PersistentFlags().StringVar(&profileArg, profileFlag, "", "The config profile to use for this command, instead of the active one")
+	rootCmd.PersistentFlags().BoolVar(&printRequestsArg, printRequestsFlag, false, "Print the URL of each HTTP request to stderr as it is made")
+	rootCmd.PersistentFlags().StringVar(&authArg, authFlag, "", `The authentication method to use with Vespa Cloud, overriding the configured one. Can be "access-token", "api-key" or "cert"`)
+	rootCmd.PersistentFlags().BoolVar(&crlfArg, crlfFlag, false, "Use Windows (CRLF) line endings in output")
+	rootCmd.PersistentFlags().BoolVar(&bomArg, bomFlag, false, "Write a UTF-8 byte order mark before standard output, for tools requiring one to detect the encoding")
+	rootCmd.PersistentFlags().StringVar(&configDirArg, configDirFlag, "", "The directory holding config, credentials and session state, overriding VESPA_CLI_HOME and $HOME/.vespa")
+	rootCmd.PersistentFlags().BoolVar(&refreshEndpointsArg, refreshEndpointsFlag, false, "Discover the endpoints of a Vespa Cloud deployment instead of using cached ones")
+	rootCmd.PersistentFlags().StringVar(&packageRootArg, packageRootFlag, "", "The directory containing services.xml of the application package, instead of finding it from the application directory")
 	bindFlagToConfig(targetFlag, rootCmd)
 	bindFlagToConfig(applicationFlag, rootCmd)
 	bindFlagToConfig(waitFlag, rootCmd)
@@ -128,14 +308,45 @@ func errHint(err error, hints ...string) ErrCLI { return ErrCLI{Status: 1, hints
 // Execute executes command and prints any errors.
 func Execute() error {
 	err := rootCmd.Execute()
+	stopContext()
+	stopContext = func() {}
+	if errors.Is(err, context.DeadlineExceeded) {
+		err = ErrCLI{Status: networkFailureStatus, hints: []string{"Use a later --deadline to allow more time"},
+			error: fmt.Errorf("deadline exceeded: command did not complete by %s", deadlineArg)}
+	} else if errors.Is(err, context.Canceled) {
+		err = ErrCLI{Status: interruptedStatus, error: fmt.Errorf("cancelled")}
+	} else if _, ok := err.(ErrCLI); !ok && errors.Is(err, auth0.ErrTokenExpired) {
+		err = ErrCLI{Status: authFailureStatus, hints: []string{"Try 'vespa auth login'"}, error: err}
+	}
 	if err != nil {
 		if cliErr, ok := err.(ErrCLI); ok {
 			if !cliErr.quiet {
 				printErrHint(cliErr, cliErr.hints...)
 			}
-		} else {
-			printErr(err)
+			if cliErr.Status == 1 {
+				cliErr.Status = errorStatus(cliErr.error)
+			}
+			return cliErr
 		}
+		printErr(err)
+		return ErrCLI{Status: errorStatus(err), error: err}
+	}
+	return nil
+}
+
+// errorStatus returns the exit status of a command failing with err, distinguishing the types of failure a caller may
+// want to handle differently.
+func errorStatus(err error) int {
+	var urlErr *url.Error
+	switch {
+	case errors.Is(err, vespa.ErrUnauthorized):
+		return authFailureStatus
+	case errors.Is(err, vespa.ErrInvalidApplicationPackage):
+		return validationFailureStatus
+	case errors.Is(err, vespa.ErrRunFailed), errors.Is(err, vespa.ErrNotDeployed):
+		return deploymentFailureStatus
+	case vespa.IsTransient(err), errors.Is(err, context.DeadlineExceeded), errors.Is(err, errWaitTimeout), errors.As(err, &urlErr):
+		return networkFailureStatus
 	}
-	return err
+	return 1
 }
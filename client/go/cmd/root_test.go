@@ -0,0 +1,52 @@
+// Copyright Yahoo. Licensed under the terms of the Apache 2.0 license. See LICENSE in the project root.
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/vespa-engine/vespa/client/go/vespa"
+)
+
+func TestExitStatus(t *testing.T) {
+	pkgPath := "testdata/applications/withTarget/target/application.zip"
+	deploy := func(client *mockHttpClient) int {
+		_, _, err := executeWithError(command{args: []string{"deploy", pkgPath}}, t, client)
+		cliErr, ok := err.(ErrCLI)
+		assert.True(t, ok)
+		return cliErr.Status
+	}
+
+	client := &mockHttpClient{}
+	client.NextResponse(401, "Unauthorized")
+	assert.Equal(t, authFailureStatus, deploy(client))
+
+	client.NextResponse(400, "Invalid package")
+	assert.Equal(t, validationFailureStatus, deploy(client))
+
+	client.NextError(&url.Error{Op: "Post", URL: "http://127.0.0.1:19071", Err: errors.New("connection refused")})
+	assert.Equal(t, networkFailureStatus, deploy(client))
+
+	client.NextResponse(503, "Unavailable")
+	assert.Equal(t, networkFailureStatus, deploy(client))
+
+	client.NextResponse(500, "Internal error")
+	assert.Equal(t, 1, deploy(client))
+
+	client.NextResponse(404, "No such tenant")
+	assert.Equal(t, 1, deploy(client))
+
+	_, _, err := executeWithError(command{args: []string{"deploy", "-t", "cloud", "-a", "t1.a1.i1", pkgPath}}, t, client)
+	assert.Equal(t, authFailureStatus, err.(ErrCLI).Status)
+
+	_, _, err = executeWithError(command{args: []string{"deploy", "--no-such-flag", pkgPath}}, t, client)
+	assert.Equal(t, 1, err.(ErrCLI).Status)
+
+	assert.Equal(t, deploymentFailureStatus, errorStatus(fmt.Errorf("run 42 %w: error", vespa.ErrRunFailed)))
+	assert.Equal(t, deploymentFailureStatus, errorStatus(fmt.Errorf("deployment of t1.a1.i1: %w", vespa.ErrNotDeployed)))
+	assert.Equal(t, networkFailureStatus, errorStatus(errWaitTimeout))
+	assert.Equal(t, 1, errorStatus(errors.New("other failure")))
+}
@@ -0,0 +1,59 @@
+// Copyright Yahoo. Licensed under the terms of the Apache 2.0 license. See LICENSE in the project root.
+// vespa runs command
+package cmd
+
+import (
+	"fmt"
+	"log"
+
+	"github.com/spf13/cobra"
+)
+
+var runsLimitArg int
+
+func init() {
+	rootCmd.AddCommand(runsCmd)
+	runsCmd.Flags().IntVarP(&runsLimitArg, "limit", "n", 10, "The maximum number of runs to show. Use 0 to show all runs")
+	runsCmd.PersistentFlags().StringVarP(&zoneArg, zoneFlag, "z", "dev.aws-us-east-1c", "The zone to list deployment runs for")
+}
+
+var runsCmd = &cobra.Command{
+	Use:   "runs",
+	Short: "List recent deployment runs",
+	Long: `List recent deployment runs.
+
+Shows the id, status, start time and platform version of the most recent
+deployment runs in a zone, newest first. All timestamps are shown in UTC.
+
+This command is only supported for the cloud target.`,
+	Example: `$ vespa runs
+$ vespa runs --limit 3 --zone perf.aws-us-east-1c`,
+	DisableAutoGenTag: true,
+	SilenceUsage:      true,
+	Args:              cobra.ExactArgs(0),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if runsLimitArg < 0 {
+			return fmt.Errorf("invalid limit: %d", runsLimitArg)
+		}
+		target, err := getTarget()
+		if err != nil {
+			return err
+		}
+		runs, err := target.Runs(commandContext, runsLimitArg)
+		if err != nil {
+			return fmt.Errorf("could not list runs: %w", err)
+		}
+		if len(runs) == 0 {
+			log.Print("No deployment runs found")
+			return nil
+		}
+		for _, run := range runs {
+			fmt.Fprintf(stdout, "%d\t%s\t%s\t%s\n",
+				color.Cyan(run.ID),
+				run.Status,
+				run.Start.UTC().Format("2006-01-02 15:04:05"),
+				run.Version)
+		}
+		return nil
+	},
+}
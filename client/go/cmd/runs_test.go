@@ -0,0 +1,29 @@
+// Copyright Yahoo. Licensed under the terms of the Apache 2.0 license. See LICENSE in the project root.
+package cmd
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRuns(t *testing.T) {
+	homeDir := filepath.Join(t.TempDir(), ".vespa")
+	pkgDir := mockApplicationPackage(t, false)
+	httpClient := &mockHttpClient{}
+	execute(command{homeDir: homeDir, args: []string{"config", "set", "application", "t1.a1.i1"}}, t, httpClient)
+	execute(command{homeDir: homeDir, args: []string{"config", "set", "target", "cloud"}}, t, httpClient)
+	execute(command{homeDir: homeDir, args: []string{"api-key"}}, t, httpClient)
+	execute(command{homeDir: homeDir, args: []string{"cert", pkgDir}}, t, httpClient)
+
+	httpClient.NextResponse(200, `{"runs": [{"id": 1, "status": "success", "start": 1631707000000, "versions": {"targetPlatform": "7.465.17"}},
+                                          {"id": 2, "status": "running", "start": 1631707900000, "versions": {"targetPlatform": "7.470.2"}}]}`)
+	out, _ := execute(command{homeDir: homeDir, args: []string{"runs", "--limit", "5"}}, t, httpClient)
+	assert.Equal(t, "2\trunning\t2021-09-15 12:11:40\t7.470.2\n1\tsuccess\t2021-09-15 11:56:40\t7.465.17\n", out)
+	assert.Equal(t, "https://api.vespa-external.aws.oath.cloud:4443/application/v4/tenant/t1/application/a1/instance/i1/job/dev-aws-us-east-1c",
+		httpClient.lastRequest.URL.String())
+
+	_, errOut := execute(command{homeDir: homeDir, args: []string{"runs", "--limit", "-1"}}, t, httpClient)
+	assert.Equal(t, "Error: invalid limit: -1\n", errOut)
+}
@@ -5,25 +5,71 @@
 package cmd
 
 import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"text/tabwriter"
+	"time"
+
 	"github.com/spf13/cobra"
+	"github.com/vespa-engine/vespa/client/go/util"
+	"github.com/vespa-engine/vespa/client/go/vespa"
+)
+
+// certExpiryWarningPeriod is how long before expiry a warning is printed for a server certificate.
+const certExpiryWarningPeriod = 30 * 24 * time.Hour
+
+// statusPollInterval is the interval between health checks when waiting for a service to become ready.
+var statusPollInterval = time.Second
+
+// shortStatusTimeout is the timeout of the endpoint discovery and the health check made for compact status output.
+var shortStatusTimeout = 2 * time.Second
+
+var (
+	statusAllArg       bool
+	checkCertExpiryArg bool
+	statusVersionsArg  bool
+	statusShortArg     bool
 )
 
 func init() {
 	rootCmd.AddCommand(statusCmd)
+	statusCmd.Flags().BoolVarP(&statusAllArg, "all", "A", false, "Show the health of all clusters")
+	statusCmd.Flags().BoolVarP(&statusShortArg, "short", "", false, "Print a single line with the deployment, its health and application generation, e.g. for shell prompts. Health is one of healthy, unreachable, unauthorized, not-deployed or undiscovered")
+	statusCmd.PersistentFlags().BoolVarP(&checkCertExpiryArg, "check-cert-expiry", "", false, "Report when the server certificate of each endpoint expires, and warn if it expires soon")
+	statusCmd.PersistentFlags().StringVarP(&retryIntervalArg, retryIntervalFlag, "", "", "Interval between health checks when waiting for a service to become ready, e.g. 500ms. Defaults to 1s")
+	statusCmd.PersistentFlags().StringVarP(&stableForArg, stableForFlag, "", "", "How long services on a target given by URL must report convergence continuously before they are considered ready, e.g. 10s")
+	statusCmd.PersistentFlags().BoolVarP(&statusVersionsArg, "versions", "", false, "Show the application and platform versions active in the deployment")
 	statusCmd.AddCommand(statusQueryCmd)
 	statusCmd.AddCommand(statusDocumentCmd)
 	statusCmd.AddCommand(statusDeployCmd)
 }
 
 var statusCmd = &cobra.Command{
-	Use:               "status",
-	Short:             "Verify that a service is ready to use (query by default)",
-	Example:           `$ vespa status query`,
+	Use:   "status",
+	Short: "Verify that a service is ready to use (query by default)",
+	Example: `$ vespa status query
+$ vespa status --all
+$ vespa status --wait 300
+$ vespa status --check-cert-expiry
+$ vespa status --versions
+$ vespa status --short`,
 	DisableAutoGenTag: true,
 	SilenceUsage:      true,
 	Args:              cobra.MaximumNArgs(1),
 	RunE: func(cmd *cobra.Command, args []string) error {
-		return waitForService("query", 0)
+		if statusShortArg {
+			if statusAllArg || waitSecsArg > 0 {
+				return fmt.Errorf("cannot combine --short with --all or --wait")
+			}
+			return printShortStatus()
+		}
+		if statusAllArg {
+			return printClustersStatus()
+		}
+		return printServiceStatus("query")
 	},
 }
 
@@ -35,7 +81,7 @@ var statusQueryCmd = &cobra.Command{
 	SilenceUsage:      true,
 	Args:              cobra.ExactArgs(0),
 	RunE: func(cmd *cobra.Command, args []string) error {
-		return waitForService("query", 0)
+		return printServiceStatus("query")
 	},
 }
 
@@ -47,7 +93,7 @@ var statusDocumentCmd = &cobra.Command{
 	SilenceUsage:      true,
 	Args:              cobra.ExactArgs(0),
 	RunE: func(cmd *cobra.Command, args []string) error {
-		return waitForService("document", 0)
+		return printServiceStatus("document")
 	},
 }
 
@@ -59,6 +105,272 @@ var statusDeployCmd = &cobra.Command{
 	SilenceUsage:      true,
 	Args:              cobra.ExactArgs(0),
 	RunE: func(cmd *cobra.Command, args []string) error {
-		return waitForService("deploy", 0)
+		return printServiceStatus("deploy")
 	},
 }
+
+func printServiceStatus(service string) error {
+	// Waiting for the service to be discovered and for it to become ready share the time given by --wait
+	deadline := time.Now().Add(time.Duration(waitSecsArg) * time.Second)
+	s, err := getService(service, 0, "")
+	if err != nil {
+		return err
+	}
+	if waitSecsArg > 0 {
+		err = waitForServiceWithProgress(s, deadline)
+	} else {
+		err = waitForServiceReady(commandContext, s)
+	}
+	if err != nil {
+		return err
+	}
+	if checkCertExpiryArg {
+		printCertificateExpiry(s)
+	}
+	if statusVersionsArg {
+		target, err := getTarget()
+		if err != nil {
+			return err
+		}
+		return printVersions(target)
+	}
+	return nil
+}
+
+// printShortStatus prints a single line with the deployment on the current target, whether its query service is
+// healthy, and the config generation of the application. Endpoint discovery and a single health check are bounded by
+// shortStatusTimeout, so this fails quickly if the control plane or the service is unreachable.
+func printShortStatus() error {
+	ctx, cancel := context.WithTimeout(commandContext, shortStatusTimeout)
+	defer cancel()
+	target, err := getTarget()
+	if err != nil {
+		return err
+	}
+	deployment, err := shortDeploymentName(target)
+	if err != nil {
+		return err
+	}
+	s, err := target.Service(ctx, "query", 0, 0, "")
+	if err != nil {
+		fmt.Fprintln(stdout, deployment, discoveryState(err))
+		return ErrCLI{Status: 1, quiet: true, error: err}
+	}
+	generation, err := s.ApplicationGeneration(shortStatusTimeout)
+	if err != nil {
+		fmt.Fprintln(stdout, deployment, "unreachable")
+		return ErrCLI{Status: 1, quiet: true, error: err}
+	}
+	fmt.Fprintf(stdout, "%s healthy gen=%d\n", deployment, generation)
+	return nil
+}
+
+// discoveryState returns the compact status of a deployment whose query service could not be found, due to err.
+func discoveryState(err error) string {
+	switch {
+	case errors.Is(err, vespa.ErrUnauthorized):
+		return "unauthorized"
+	case errors.Is(err, vespa.ErrNotDeployed):
+		return "not-deployed"
+	default:
+		return "undiscovered"
+	}
+}
+
+// shortDeploymentName returns the type of target and the deployment on it, e.g. "cloud:t1.a1.i1 dev.us-north-1".
+func shortDeploymentName(target vespa.Target) (string, error) {
+	if target.Type() == "cloud" {
+		deployment, err := deploymentInZone(zoneArg)
+		if err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("cloud:%s %s", deployment.Application, deployment.Zone), nil
+	}
+	app, err := getSelfHostedApplication()
+	if err != nil {
+		return "", err
+	}
+	for _, part := range []*string{&app.Tenant, &app.Application, &app.Instance} {
+		if *part == "" {
+			*part = "default"
+		}
+	}
+	return target.Type() + ":" + app.String(), nil
+}
+
+// printVersions prints the application and platform versions active in the deployment on target.
+func printVersions(target vespa.Target) error {
+	versions, err := target.Versions(commandContext)
+	if err != nil {
+		return fmt.Errorf("could not read deployment versions: %w", err)
+	}
+	log.Print("Application version: ", color.Cyan(versionOrUnknown(versions.Application)))
+	log.Print("Platform version: ", color.Cyan(versionOrUnknown(versions.Platform)))
+	return nil
+}
+
+func versionOrUnknown(version string) string {
+	if version == "" {
+		return "unknown"
+	}
+	return version
+}
+
+// waitForServiceWithProgress waits for service s to become ready until deadline passes, checking its health every
+// statusPollInterval unless s has a retry interval of its own. When running in a terminal, a spinner shows the time
+// elapsed while waiting.
+func waitForServiceWithProgress(s *vespa.Service, deadline time.Time) error {
+	var (
+		status  int
+		err     error
+		elapsed time.Duration
+	)
+	timeout := time.Until(deadline).Round(time.Second)
+	if s.RetryInterval == 0 {
+		s.RetryInterval = statusPollInterval
+	}
+	poll := func(update func(string)) error {
+		start := time.Now()
+		ticker := time.NewTicker(time.Second)
+		defer ticker.Stop()
+		done := make(chan struct{})
+		defer close(done)
+		go func() {
+			for {
+				select {
+				case <-ticker.C:
+					update(fmt.Sprintf("%s elapsed", time.Since(start).Round(time.Second)))
+				case <-done:
+					return
+				}
+			}
+		}()
+		status, err = s.Wait(commandContext, time.Until(deadline))
+		elapsed = time.Since(start).Round(time.Second)
+		if status/100 == 2 {
+			return nil
+		}
+		return errWaitTimeout
+	}
+	var waitErr error
+	if isTerminal() {
+		waitErr = util.SpinnerWithProgress(fmt.Sprintf("Waiting up to %s for %s to become ready ...", timeout, s.Description()), poll)
+	} else {
+		log.Printf("Waiting up to %d %s for service to become ready ...", color.Cyan(int(timeout.Seconds())), color.Cyan("seconds"))
+		waitErr = poll(func(string) {})
+	}
+	if waitErr == nil {
+		log.Print(s.Description(), " at ", color.Cyan(s.BaseURL), " is ", color.Green("ready"), " after ", color.Cyan(elapsed))
+		return nil
+	}
+	if err == nil {
+		err = fmt.Errorf("status %d", status)
+	}
+	return errHint(fmt.Errorf("%s at %s is %s after %s: %w", s.Description(), color.Cyan(s.BaseURL), color.Red("not ready"), elapsed, err),
+		"Increase the number of seconds to wait with --wait")
+}
+
+var errWaitTimeout = errors.New("timed out")
+
+// printCertificateExpiry prints when the certificate presented by service s in its last health check expires, and
+// warns if this is within certExpiryWarningPeriod.
+func printCertificateExpiry(s *vespa.Service) {
+	cert := s.ServerCertificate()
+	if cert == nil {
+		fmt.Fprintln(stderr, color.Yellow("Warning:"), fmt.Sprintf("No server certificate presented by %s", s.BaseURL))
+		return
+	}
+	expiresIn := time.Until(cert.NotAfter)
+	notAfter := cert.NotAfter.UTC().Format(time.RFC3339)
+	switch {
+	case expiresIn <= 0:
+		fmt.Fprintln(stderr, color.Yellow("Warning:"), fmt.Sprintf("Server certificate for %s expired at %s", s.BaseURL, notAfter))
+	case expiresIn < certExpiryWarningPeriod:
+		fmt.Fprintln(stderr, color.Yellow("Warning:"), fmt.Sprintf("Server certificate for %s expires in %s, at %s", s.BaseURL, formatExpiry(expiresIn), notAfter))
+	default:
+		log.Print("Server certificate for ", color.Cyan(s.BaseURL), " expires at ", color.Cyan(notAfter))
+	}
+}
+
+func formatExpiry(d time.Duration) string {
+	if d < time.Hour {
+		return fmt.Sprintf("%d minutes", int(d.Minutes()))
+	}
+	if d < 48*time.Hour {
+		return fmt.Sprintf("%d hours", int(d.Hours()))
+	}
+	return fmt.Sprintf("%d days", int(d.Hours()/24))
+}
+
+type clusterHealth struct {
+	cluster vespa.Cluster
+	status  int
+	err     error
+	latency time.Duration
+}
+
+// printClustersStatus checks the health of all clusters concurrently, and prints the result as a table.
+func printClustersStatus() error {
+	target, err := getTarget()
+	if err != nil {
+		return err
+	}
+	cfg, err := LoadConfig()
+	if err != nil {
+		return err
+	}
+	timeout := time.Duration(waitSecsArg) * time.Second
+	clusters, err := target.Clusters(commandContext, timeout)
+	if err != nil {
+		return fmt.Errorf("could not discover clusters: %w", err)
+	}
+	results := make([]clusterHealth, len(clusters))
+	var wg sync.WaitGroup
+	for i, c := range clusters {
+		if healthPath, err := cfg.Get(healthPathOption + "." + c.Service.Name); err == nil {
+			c.Service.HealthPath = healthPath
+		}
+		wg.Add(1)
+		go func(i int, c vespa.Cluster) {
+			defer wg.Done()
+			start := time.Now()
+			status, err := c.Service.Wait(commandContext, timeout)
+			results[i] = clusterHealth{cluster: c, status: status, err: err, latency: time.Since(start)}
+		}(i, c)
+	}
+	wg.Wait()
+
+	w := tabwriter.NewWriter(stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "CLUSTER\tTYPE\tENDPOINT\tLATENCY\tSTATUS")
+	unhealthy := 0
+	for _, r := range results {
+		status := color.Green("healthy").String()
+		if r.status/100 != 2 {
+			unhealthy++
+			if r.err == nil {
+				r.err = fmt.Errorf("status %d", r.status)
+			}
+			status = fmt.Sprintf("%s: %s", color.Red("unhealthy"), r.err)
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%dms\t%s\n", r.cluster.Name, r.cluster.Type, r.cluster.Service.BaseURL, r.latency.Milliseconds(), status)
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	if checkCertExpiryArg {
+		for _, r := range results {
+			if r.status/100 == 2 {
+				printCertificateExpiry(r.cluster.Service)
+			}
+		}
+	}
+	if statusVersionsArg {
+		if err := printVersions(target); err != nil {
+			return err
+		}
+	}
+	if unhealthy > 0 {
+		return fmt.Errorf("%d of %d clusters are unhealthy", unhealthy, len(results))
+	}
+	return nil
+}
@@ -5,9 +5,27 @@
 package cmd
 
 import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/vespa-engine/vespa/client/go/util"
+	"github.com/vespa-engine/vespa/client/go/vespa"
 )
 
 func TestStatusDeployCommand(t *testing.T) {
@@ -42,6 +60,15 @@ func TestStatusErrorResponse(t *testing.T) {
 	assertQueryStatusError("http://127.0.0.1:8080", []string{}, t)
 }
 
+func TestStatusQueryCommandWithHealthPath(t *testing.T) {
+	homeDir := filepath.Join(t.TempDir(), ".vespa")
+	execute(command{homeDir: homeDir, args: []string{"config", "set", "health-path.query", "/healthz"}}, t, nil)
+	client := &mockHttpClient{}
+	out, _ := execute(command{homeDir: homeDir, args: []string{"status", "query"}}, t, client)
+	assert.Equal(t, "Container (query API) at http://127.0.0.1:8080 is ready\n", out)
+	assert.Equal(t, "http://127.0.0.1:8080/healthz", client.lastRequest.URL.String())
+}
+
 func assertDeployStatus(target string, args []string, t *testing.T) {
 	client := &mockHttpClient{}
 	assert.Equal(t,
@@ -86,3 +113,283 @@ func assertQueryStatusError(target string, args []string, t *testing.T) {
 		outErr,
 		"vespa status container")
 }
+
+func TestStatusWaitPollsUntilReady(t *testing.T) {
+	defer func(interval time.Duration) { statusPollInterval = interval }(statusPollInterval)
+	statusPollInterval = 10 * time.Millisecond
+	client := &mockHttpClient{}
+	client.PathResponse("/application/v2/tenant/default/application/default/environment/prod/region/default/instance/default/serviceconverge", 200, `{"converged":true}`)
+	client.NextStatus(503)
+	client.NextStatus(503)
+	client.NextStatus(200)
+	out, outErr := execute(command{args: []string{"status", "query", "--wait", "10"}}, t, client)
+	assert.Equal(t, "", outErr)
+	assert.Equal(t, "Waiting up to 10 seconds for query service to become available ...\n"+
+		"Waiting up to 10 seconds for service to become ready ...\n"+
+		"Container (query API) at http://127.0.0.1:8080 is ready after 0s\n", out)
+
+	// Gives up when less than an interval is left before the deadline
+	statusPollInterval = time.Second
+	client.NextStatus(503)
+	client.NextStatus(503)
+	client.requests = nil
+	_, outErr = execute(command{args: []string{"status", "query", "--wait", "1"}}, t, client)
+	assert.Equal(t, "Error: Container (query API) at http://127.0.0.1:8080 is not ready after 0s: status 503\n"+
+		"Hint: Increase the number of seconds to wait with --wait\n", outErr)
+	assert.Equal(t, 1, countRequests(client, "/ApplicationStatus"))
+}
+
+func countRequests(client *mockHttpClient, path string) int {
+	n := 0
+	for _, req := range client.requests {
+		if req.URL.Path == path {
+			n++
+		}
+	}
+	return n
+}
+
+func TestStatusRetryInterval(t *testing.T) {
+	client := &mockHttpClient{}
+	client.PathResponse("/application/v2/tenant/default/application/default/environment/prod/region/default/instance/default/serviceconverge", 200, `{"converged":true}`)
+	client.NextStatus(503)
+	client.NextStatus(503)
+	client.NextStatus(503)
+	client.NextStatus(200)
+	out, outErr := execute(command{args: []string{"status", "query", "--wait", "1", "--retry-interval", "10ms"}}, t, client)
+	assert.Equal(t, "", outErr)
+	assert.Contains(t, out, "Container (query API) at http://127.0.0.1:8080 is ready after 0s\n")
+
+	_, outErr = execute(command{args: []string{"status", "query", "--retry-interval", "0s"}}, t, client)
+	assert.Equal(t, "Error: invalid retry interval: \"0s\": must be a positive duration\n", outErr)
+}
+
+func TestStatusStableFor(t *testing.T) {
+	client := &mockHttpClient{}
+	client.PathResponse("/application/v2/tenant/default/application/default/environment/prod/region/default/instance/default/serviceconverge", 200, `{"converged":true}`)
+	_, outErr := execute(command{args: []string{"status", "query", "-t", "http://127.0.0.1:19071", "--wait", "1", "--stable-for", "1h"}}, t, client)
+	assert.Contains(t, outErr, "services have not been converged for 1h0m0s")
+
+	_, outErr = execute(command{args: []string{"status", "query", "-t", "http://127.0.0.1:19071", "--stable-for", "soon"}}, t, client)
+	assert.Equal(t, "Error: invalid stable duration: \"soon\": must be a non-negative duration\n", outErr)
+}
+
+func TestStatusShort(t *testing.T) {
+	client := &mockHttpClient{}
+	client.NextResponse(200, `{"application": {"meta": {"generation": 52532}}}`)
+	out, outErr := execute(command{args: []string{"status", "--short"}}, t, client)
+	assert.Equal(t, "", outErr)
+	assert.Equal(t, "local:default.default.default healthy gen=52532\n", out)
+	assert.Equal(t, "http://127.0.0.1:8080/ApplicationStatus", client.lastRequest.URL.String())
+
+	client.NextError(errors.New("connection refused"))
+	out, outErr = execute(command{args: []string{"status", "--short", "-t", "http://mytarget"}}, t, client)
+	assert.Equal(t, "", outErr)
+	assert.Equal(t, "custom:default.default.default unreachable\n", out)
+
+	_, outErr = execute(command{args: []string{"status", "--short", "--all"}}, t, client)
+	assert.Equal(t, "Error: cannot combine --short with --all or --wait\n", outErr)
+}
+
+func TestStatusShortCloud(t *testing.T) {
+	pkgDir := filepath.Join(t.TempDir(), "app")
+	createApplication(t, pkgDir, false)
+	homeDir := filepath.Join(t.TempDir(), ".vespa")
+	execute(command{args: []string{"config", "set", "target", "cloud"}, homeDir: homeDir}, t, nil)
+	execute(command{args: []string{"config", "set", "application", "t1.a1.i1"}, homeDir: homeDir}, t, nil)
+	execute(command{args: []string{"api-key", "-a", "t1.a1.i1"}, homeDir: homeDir}, t, nil)
+	execute(command{args: []string{"cert", "-a", "t1.a1.i1", pkgDir}, homeDir: homeDir}, t, nil)
+
+	client := &mockHttpClient{}
+	client.PathResponse("/application/v4/tenant/t1/application/a1/instance/i1/environment/dev/region/aws-us-east-1c",
+		200, `{"endpoints": [{"cluster": "default", "url": "https://a1.t1.aws-us-east-1c.example.com", "scope": "zone"}]}`)
+	client.PathResponse("/ApplicationStatus", 200, `{"application": {"meta": {"generation": 7}}}`)
+	out, outErr := execute(command{args: []string{"status", "--short"}, homeDir: homeDir}, t, client)
+	assert.Equal(t, "", outErr)
+	assert.Equal(t, "cloud:t1.a1.i1 dev.aws-us-east-1c healthy gen=7\n", out)
+
+	deploymentPath := "/application/v4/tenant/t1/application/a1/instance/i1/environment/dev/region/aws-us-east-1c"
+	client.PathResponse(deploymentPath, 401, "")
+	out, outErr = execute(command{args: []string{"status", "--short", "--refresh-endpoints"}, homeDir: homeDir}, t, client)
+	assert.Equal(t, "", outErr)
+	assert.Equal(t, "cloud:t1.a1.i1 dev.aws-us-east-1c unauthorized\n", out)
+
+	client.PathResponse(deploymentPath, 404, "")
+	out, _ = execute(command{args: []string{"status", "--short", "--refresh-endpoints"}, homeDir: homeDir}, t, client)
+	assert.Equal(t, "cloud:t1.a1.i1 dev.aws-us-east-1c not-deployed\n", out)
+
+	client.PathResponse(deploymentPath, 503, "")
+	out, _ = execute(command{args: []string{"status", "--short", "--refresh-endpoints"}, homeDir: homeDir}, t, client)
+	assert.Equal(t, "cloud:t1.a1.i1 dev.aws-us-east-1c undiscovered\n", out)
+}
+
+func TestStatusWaitForbidden(t *testing.T) {
+	client := &mockHttpClient{}
+	homeDir := setupCloudDeploy(t, client, mockApplicationPackage(t, false))
+	client.PathResponse("/application/v4/tenant/t1/application/a1/instance/i1/environment/dev/region/aws-us-east-1c", 403, "")
+	start := time.Now()
+	_, errOut, err := executeWithError(command{args: []string{"status", "-t", "cloud", "-a", "t1.a1.i1", "--wait", "600", "--refresh-endpoints"}, homeDir: homeDir}, t, client)
+	assert.True(t, time.Since(start) < 10*time.Second, "waiting stops when access is denied")
+	assert.Contains(t, errOut, "status 403: unauthorized: access denied")
+	assert.Equal(t, authFailureStatus, err.(ErrCLI).Status)
+}
+
+func TestStatusDeadlineExceeded(t *testing.T) {
+	client := &mockHttpClient{}
+	_, errOut := execute(command{args: []string{"status", "deploy", "--deadline", "2000-01-01T00:00:00Z"}}, t, client)
+	assert.Contains(t, errOut, "deadline exceeded: command did not complete by 2000-01-01T00:00:00Z")
+	assert.Empty(t, client.requests)
+
+	_, errOut = execute(command{args: []string{"status", "deploy", "--deadline", "14:30"}}, t, client)
+	assert.Contains(t, errOut, "invalid value for deadline option")
+}
+
+type blockingHttpClient struct{ started chan struct{} }
+
+func (c *blockingHttpClient) Do(request *http.Request, timeout time.Duration) (*http.Response, error) {
+	close(c.started)
+	<-request.Context().Done() // Block until request is cancelled
+	return nil, request.Context().Err()
+}
+
+func (c *blockingHttpClient) UseCertificate(certificates []tls.Certificate) {}
+
+func (c *blockingHttpClient) UseRootCAs(pool *x509.CertPool) {}
+
+func (c *blockingHttpClient) UseTransportOptions(options util.TransportOptions) {}
+
+func TestStatusInterrupted(t *testing.T) {
+	defer func(f func() (<-chan os.Signal, func())) { notifyInterrupt = f }(notifyInterrupt)
+	defer func(f func(int)) { exit = f }(exit)
+	defer func(c util.HttpClient) { util.ActiveHttpClient = c }(util.ActiveHttpClient)
+
+	signals := make(chan os.Signal, 2)
+	notifyInterrupt = func() (<-chan os.Signal, func()) { return signals, func() {} }
+	exitStatus := make(chan int, 1)
+	exit = func(status int) { exitStatus <- status }
+	client := &blockingHttpClient{started: make(chan struct{})}
+	util.ActiveHttpClient = client
+	go func() {
+		<-client.started
+		signals <- os.Interrupt
+	}()
+
+	_, errOut, err := executeWithError(command{args: []string{"status", "deploy"}}, t, nil)
+	assert.Contains(t, errOut, "Interrupted: Cancelling command")
+	assert.True(t, strings.HasSuffix(errOut, "Error: cancelled\n"), errOut)
+	cliErr, ok := err.(ErrCLI)
+	assert.True(t, ok)
+	assert.Equal(t, 130, cliErr.Status)
+	select {
+	case <-exitStatus:
+		t.Fatal("exit is only called on second interrupt")
+	default:
+	}
+}
+
+func TestHandleInterruptsExitsOnSecondSignal(t *testing.T) {
+	defer func(f func(int)) { exit = f }(exit)
+	exitStatus := make(chan int, 1)
+	exit = func(status int) { exitStatus <- status }
+	signals := make(chan os.Signal, 2)
+	cancelled := false
+	signals <- os.Interrupt
+	signals <- os.Interrupt
+	handleInterrupts(signals, func() { cancelled = true }, make(chan struct{}))
+	assert.True(t, cancelled)
+	assert.Equal(t, 130, <-exitStatus)
+}
+
+func TestStatusAll(t *testing.T) {
+	healthy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/ApplicationStatus" {
+			w.WriteHeader(404)
+		}
+	}))
+	defer healthy.Close()
+	unhealthy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(503)
+	}))
+	defer unhealthy.Close()
+	defer func(c util.HttpClient) { util.ActiveHttpClient = c }(util.ActiveHttpClient)
+	util.ActiveHttpClient = util.CreateClient(10 * time.Second)
+
+	homeDir := filepath.Join(t.TempDir(), ".vespa")
+	keyFile := filepath.Join(t.TempDir(), "key")
+	certFile := filepath.Join(t.TempDir(), "cert")
+	kp, _ := vespa.CreateKeyPair()
+	ioutil.WriteFile(keyFile, kp.PrivateKey, 0600)
+	ioutil.WriteFile(certFile, kp.Certificate, 0600)
+	for k, v := range map[string]string{
+		"VESPA_CLI_DATA_PLANE_KEY_FILE":  keyFile,
+		"VESPA_CLI_DATA_PLANE_CERT_FILE": certFile,
+		"VESPA_CLI_ENDPOINTS": `{"endpoints":[{"cluster":"search","url":"` + healthy.URL + `"},
+                                             {"cluster":"feed","url":"` + unhealthy.URL + `"}]}`,
+	} {
+		defer os.Unsetenv(k)
+		os.Setenv(k, v)
+	}
+
+	out, errOut := execute(command{homeDir: homeDir, args: []string{"status", "--all", "-t", "cloud", "-a", "t1.a1.i1"}}, t, nil)
+	lines := strings.Split(strings.TrimSpace(out), "\n")
+	assert.Equal(t, 3, len(lines), out)
+	assert.Regexp(t, `^CLUSTER\s+TYPE\s+ENDPOINT\s+LATENCY\s+STATUS$`, lines[0])
+	assert.Regexp(t, `^feed\s+container\s+`+regexp.QuoteMeta(unhealthy.URL)+`\s+\d+ms\s+unhealthy: status 503$`, lines[1])
+	assert.Regexp(t, `^search\s+container\s+`+regexp.QuoteMeta(healthy.URL)+`\s+\d+ms\s+healthy$`, lines[2])
+	assert.Equal(t, "Error: 1 of 2 clusters are unhealthy\n", errOut)
+}
+
+func TestStatusCheckCertExpiry(t *testing.T) {
+	client := &mockHttpClient{}
+	out, errOut := execute(command{args: []string{"status", "--check-cert-expiry"}}, t, client)
+	assert.Equal(t, "Container (query API) at http://127.0.0.1:8080 is ready\n", out)
+	assert.Equal(t, "Warning: No server certificate presented by http://127.0.0.1:8080\n", errOut)
+
+	notAfter := time.Now().Add(365 * 24 * time.Hour).Truncate(time.Second)
+	client.tlsState = &tls.ConnectionState{PeerCertificates: []*x509.Certificate{createTestCertificate(t, notAfter)}}
+	out, errOut = execute(command{args: []string{"status", "query", "--check-cert-expiry"}}, t, client)
+	assert.Equal(t, "Container (query API) at http://127.0.0.1:8080 is ready\n"+
+		"Server certificate for http://127.0.0.1:8080 expires at "+notAfter.UTC().Format(time.RFC3339)+"\n", out)
+	assert.Equal(t, "", errOut)
+
+	notAfter = time.Now().Add(12*time.Hour + 30*time.Minute).Truncate(time.Second)
+	client.tlsState = &tls.ConnectionState{PeerCertificates: []*x509.Certificate{createTestCertificate(t, notAfter)}}
+	out, errOut = execute(command{args: []string{"status", "query", "--check-cert-expiry"}}, t, client)
+	assert.Equal(t, "Container (query API) at http://127.0.0.1:8080 is ready\n", out)
+	assert.Equal(t, "Warning: Server certificate for http://127.0.0.1:8080 expires in 12 hours, at "+notAfter.UTC().Format(time.RFC3339)+"\n", errOut)
+
+	// Flag is off by default
+	_, errOut = execute(command{args: []string{"status", "query"}}, t, client)
+	assert.Equal(t, "", errOut)
+}
+
+func TestStatusVersions(t *testing.T) {
+	client := &mockHttpClient{}
+	client.PathResponse("/application/v2/tenant/default/application/default/environment/prod/region/default/instance/default", 200,
+		`{"generation": 3, "applicationPackageFileReference": "./", "modelVersions": ["8.1.2"]}`)
+	out, errOut := execute(command{args: []string{"status", "--versions"}}, t, client)
+	assert.Equal(t, "", errOut)
+	assert.Equal(t, "Container (query API) at http://127.0.0.1:8080 is ready\n"+
+		"Application version: session 3\n"+
+		"Platform version: 8.1.2\n", out)
+
+	client.PathResponse("/application/v2/tenant/default/application/default/environment/prod/region/default/instance/default", 404, "")
+	_, errOut = execute(command{args: []string{"status", "deploy", "--versions"}}, t, client)
+	assert.Equal(t, "Error: could not read deployment versions: not deployed\n", errOut)
+}
+
+func createTestCertificate(t *testing.T, notAfter time.Time) *x509.Certificate {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.Nil(t, err)
+	template := x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "localhost"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     notAfter,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, &template, &template, &key.PublicKey, key)
+	assert.Nil(t, err)
+	cert, err := x509.ParseCertificate(der)
+	assert.Nil(t, err)
+	return cert
+}
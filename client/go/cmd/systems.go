@@ -0,0 +1,56 @@
+// Copyright Yahoo. Licensed under the terms of the Apache 2.0 license. See LICENSE in the project root.
+// vespa systems command
+package cmd
+
+import (
+	"fmt"
+	"text/tabwriter"
+
+	"github.com/spf13/cobra"
+	"github.com/vespa-engine/vespa/client/go/vespa"
+)
+
+func init() {
+	rootCmd.AddCommand(systemsCmd)
+}
+
+var systemsCmd = &cobra.Command{
+	Use:   "systems",
+	Short: "List the Vespa Cloud systems known to this client",
+	Long: `List the Vespa Cloud systems known to this client.
+
+The system used by the cloud target is selected with the environment variable
+VESPA_CLI_CLOUD_SYSTEM, and is public by default. Other systems can be used by
+setting the control-plane option to the base URL of a control plane serving a
+discovery document. Such a system is listed along with the known ones.
+
+The system in use is marked with an asterisk.`,
+	Example:           "$ vespa systems",
+	DisableAutoGenTag: true,
+	SilenceUsage:      true,
+	Args:              cobra.ExactArgs(0),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		systems := vespa.KnownSystems()
+		d, err := getControlPlane()
+		if err != nil {
+			return err
+		}
+		if d != nil {
+			systems = append(systems, d.AsSystem())
+		}
+		current, err := getCloudSystem()
+		if err != nil {
+			return err
+		}
+		w := tabwriter.NewWriter(stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "NAME\tAPI URL\tCONSOLE URL")
+		for _, s := range systems {
+			name := s.Name
+			if s == current {
+				name += "*"
+			}
+			fmt.Fprintf(w, "%s\t%s\t%s\n", name, s.APIURL, s.ConsoleURL)
+		}
+		return w.Flush()
+	},
+}
@@ -0,0 +1,21 @@
+// Copyright Yahoo. Licensed under the terms of the Apache 2.0 license. See LICENSE in the project root.
+package cmd
+
+import (
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSystems(t *testing.T) {
+	out, _ := execute(command{args: []string{"systems"}}, t, nil)
+	assert.Equal(t, "NAME      API URL                                            CONSOLE URL\n"+
+		"public*   https://api.vespa-external.aws.oath.cloud:4443     https://console.vespa.oath.cloud\n"+
+		"publiccd  https://api.vespa-external-cd.aws.oath.cloud:4443  https://console-cd.vespa.oath.cloud\n", out)
+
+	defer os.Unsetenv("VESPA_CLI_CLOUD_SYSTEM")
+	os.Setenv("VESPA_CLI_CLOUD_SYSTEM", "publiccd")
+	out, _ = execute(command{args: []string{"systems"}}, t, nil)
+	assert.Contains(t, out, "publiccd* ")
+}
@@ -55,7 +55,7 @@ $ vespa test src/test/application/tests/system-test/feed-and-query.json`,
 			for _, test := range failed {
 				fmt.Fprintln(stdout, test)
 			}
-			return ErrCLI{Status: 3, error: fmt.Errorf("tests failed"), quiet: true}
+			return ErrCLI{Status: testFailureStatus, error: fmt.Errorf("tests failed"), quiet: true}
 		} else {
 			plural := "s"
 			if count == 1 {
@@ -211,7 +211,7 @@ func verify(step step, defaultCluster string, defaultParameters map[string]strin
 		if err != nil {
 			return "", "", err
 		}
-		service, err = target.Service("query", 0, 0, cluster)
+		service, err = target.Service(commandContext, "query", 0, 0, cluster)
 		if err != nil {
 			return "", "", err
 		}
@@ -235,6 +235,7 @@ func verify(step step, defaultCluster string, defaultParameters map[string]strin
 		Header: header,
 		Body:   ioutil.NopCloser(bytes.NewReader(requestBody)),
 	}
+	request = request.WithContext(commandContext)
 	defer request.Body.Close()
 
 	statusCode := step.Response.Code
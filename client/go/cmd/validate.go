@@ -0,0 +1,92 @@
+// Copyright Yahoo. Licensed under the terms of the Apache 2.0 license. See LICENSE in the project root.
+// vespa validate command
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/spf13/cobra"
+	"github.com/vespa-engine/vespa/client/go/vespa"
+)
+
+var validateFormatArg string
+
+func init() {
+	rootCmd.AddCommand(validateCmd)
+	validateCmd.Flags().StringVarP(&validateFormatArg, "format", "", "human", `Output format. Must be "human" or "json"`)
+}
+
+var validateCmd = &cobra.Command{
+	Use:   "validate [application-directory]",
+	Short: "Check the layout of an application package",
+	Long: `Check the layout of an application package.
+
+Reports common mistakes in the directory layout of an application package,
+such as schemas or component jars placed in the wrong directory, which
+otherwise cause confusing deployment failures. Instance names declared in
+deployment.xml are also checked.
+
+This only inspects the files of the application package, and does not
+replace the validation done when deploying.
+
+With --format json, the problems found are printed to stdout as a JSON array,
+where each problem has a severity, file, rule and message, and the line of the
+file if known.`,
+	Example: `$ vespa validate
+$ vespa validate src/main/application
+$ vespa validate --format json`,
+	DisableAutoGenTag: true,
+	SilenceUsage:      true,
+	Args:              cobra.MaximumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if validateFormatArg != "human" && validateFormatArg != "json" {
+			return fmt.Errorf("invalid format: %q", validateFormatArg)
+		}
+		pkg, err := findApplicationPackage(args, false)
+		if err != nil {
+			return err
+		}
+		defer pkg.Close()
+		problems, err := pkg.Lint()
+		if err != nil {
+			return fmt.Errorf("could not check application package: %w", err)
+		}
+		if validateFormatArg == "json" {
+			if err := printProblems(problems); err != nil {
+				return err
+			}
+		} else if len(problems) == 0 {
+			printSuccess("No problems found in ", pkg.Name())
+		}
+		if len(problems) == 0 {
+			return nil
+		}
+		if validateFormatArg == "human" {
+			for _, p := range problems {
+				if p.Severity == vespa.SeverityError {
+					fmt.Fprintln(stderr, color.Red("Error:"), p)
+				} else {
+					fmt.Fprintln(stderr, color.Yellow("Warning:"), p)
+				}
+			}
+		}
+		noun := "problems"
+		if len(problems) == 1 {
+			noun = "problem"
+		}
+		return ErrCLI{Status: validationFailureStatus, error: fmt.Errorf("found %d %s in %s", len(problems), noun, pkg.Name())}
+	},
+}
+
+func printProblems(problems []vespa.Problem) error {
+	if problems == nil {
+		problems = []vespa.Problem{}
+	}
+	b, err := json.MarshalIndent(problems, "", "  ")
+	if err != nil {
+		return err
+	}
+	fmt.Fprintln(stdout, string(b))
+	return nil
+}
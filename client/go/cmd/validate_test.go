@@ -0,0 +1,87 @@
+// Copyright Yahoo. Licensed under the terms of the Apache 2.0 license. See LICENSE in the project root.
+package cmd
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidate(t *testing.T) {
+	pkgDir := mockApplicationPackage(t, false)
+	appDir := filepath.Join(pkgDir, "src", "main", "application")
+	out, errOut := execute(command{args: []string{"validate", pkgDir}}, t, nil)
+	assert.Equal(t, "Success: No problems found in "+appDir+"\n", out)
+	assert.Equal(t, "", errOut)
+
+	if _, err := os.Create(filepath.Join(appDir, "music.sd")); err != nil {
+		t.Fatal(err)
+	}
+	_, errOut = execute(command{args: []string{"validate", pkgDir}}, t, nil)
+	assert.Equal(t, "Error: music.sd: schema will not be found, move it to schemas/\n"+
+		"Error: found 1 problem in "+appDir+"\n", errOut)
+}
+
+func TestValidatePackageRoot(t *testing.T) {
+	dir := t.TempDir()
+	for _, app := range []string{"app1", "app2"} {
+		if err := os.MkdirAll(filepath.Join(dir, app), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := os.Create(filepath.Join(dir, app, "services.xml")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	_, errOut := execute(command{args: []string{"validate", dir}}, t, nil)
+	assert.Equal(t, "Error: multiple application packages found in '"+dir+"': "+filepath.Join(dir, "app1")+", "+filepath.Join(dir, "app2")+"\n"+
+		"Hint: Select one with --package-root, or give its directory as argument\n", errOut)
+
+	out, errOut := execute(command{args: []string{"validate", "--package-root", filepath.Join(dir, "app2")}}, t, nil)
+	assert.Equal(t, "Success: No problems found in "+filepath.Join(dir, "app2")+"\n", out)
+	assert.Equal(t, "", errOut)
+
+	_, errOut = execute(command{args: []string{"validate", "--package-root", filepath.Join(dir, "app2"), dir}}, t, nil)
+	assert.Equal(t, "Error: cannot combine --package-root with application directory "+dir+"\n", errOut)
+}
+
+func TestValidateJSON(t *testing.T) {
+	pkgDir := mockApplicationPackage(t, false)
+	appDir := filepath.Join(pkgDir, "src", "main", "application")
+	out, errOut := execute(command{args: []string{"validate", "--format", "json", pkgDir}}, t, nil)
+	assert.Equal(t, "[]\n", out)
+	assert.Equal(t, "", errOut)
+
+	for _, f := range []string{"music.sd", filepath.Join("searchdefinitions", "lyrics.sd")} {
+		path := filepath.Join(appDir, f)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := os.Create(path); err != nil {
+			t.Fatal(err)
+		}
+	}
+	out, errOut = execute(command{args: []string{"validate", "--format", "json", pkgDir}}, t, nil)
+	var problems []map[string]interface{}
+	assert.Nil(t, json.Unmarshal([]byte(out), &problems), out)
+	assert.Equal(t, []map[string]interface{}{
+		{
+			"severity": "error",
+			"file":     "music.sd",
+			"rule":     "schema-location",
+			"message":  "schema will not be found, move it to schemas/",
+		},
+		{
+			"severity": "warning",
+			"file":     "searchdefinitions/lyrics.sd",
+			"rule":     "deprecated-searchdefinitions",
+			"message":  "the searchdefinitions directory is deprecated, move schema to schemas/",
+		},
+	}, problems)
+	assert.Equal(t, "Error: found 2 problems in "+appDir+"\n", errOut)
+
+	_, errOut = execute(command{args: []string{"validate", "--format", "xml", pkgDir}}, t, nil)
+	assert.Equal(t, "Error: invalid format: \"xml\"\n", errOut)
+}
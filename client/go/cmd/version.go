@@ -82,7 +82,7 @@ func checkVersion() error {
 }
 
 func latestRelease() (release, error) {
-	req, err := http.NewRequest("GET", "https://api.github.com/repos/vespa-engine/vespa/releases", nil)
+	req, err := http.NewRequestWithContext(commandContext, "GET", "https://api.github.com/repos/vespa-engine/vespa/releases", nil)
 	if err != nil {
 		return release{}, err
 	}
@@ -5,10 +5,19 @@
 package util
 
 import (
+	"bytes"
 	"crypto/tls"
+	"crypto/x509"
 	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
 	"net/http"
 	"net/url"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/vespa-engine/vespa/client/go/build"
@@ -17,32 +26,161 @@ import (
 // Set this to a mock HttpClient instead to unit test HTTP requests
 var ActiveHttpClient = CreateClient(time.Second * 10)
 
+var (
+	concurrencyMu  sync.RWMutex
+	concurrencySem chan struct{}
+
+	verboseMu     sync.RWMutex
+	verboseWriter io.Writer
+)
+
+// secretParameters are substrings of query parameter names whose values are redacted when printing URLs.
+var secretParameters = []string{"token", "key", "secret", "password", "signature", "auth"}
+
 type HttpClient interface {
 	Do(request *http.Request, timeout time.Duration) (response *http.Response, error error)
 	UseCertificate(certificate []tls.Certificate)
+	UseRootCAs(pool *x509.CertPool)
+	UseTransportOptions(options TransportOptions)
+}
+
+// TransportOptions holds timeouts for the phases of a request, which apply in addition to the timeout of the request
+// as a whole. A zero timeout means the phase is limited only by the timeout of the request.
+type TransportOptions struct {
+	// DialTimeout is the maximum time to wait for a connection to be established, including name resolution.
+	DialTimeout time.Duration
+	// TLSHandshakeTimeout is the maximum time to wait for a TLS handshake to complete.
+	TLSHandshakeTimeout time.Duration
+	// ResponseHeaderTimeout is the maximum time to wait for the response headers, after the request is written.
+	ResponseHeaderTimeout time.Duration
+}
+
+// DefaultTransportOptions are the transport options of clients returned by CreateClient. Responses are not given a
+// separate timeout, as a deployment may take minutes to respond.
+var DefaultTransportOptions = TransportOptions{
+	DialTimeout:         30 * time.Second,
+	TLSHandshakeTimeout: 10 * time.Second,
 }
 
 type defaultHttpClient struct {
-	client *http.Client
+	mu           sync.Mutex
+	client       *http.Client
+	certificates []tls.Certificate
+	rootCAs      *x509.CertPool
+	options      TransportOptions
 }
 
 func (c *defaultHttpClient) Do(request *http.Request, timeout time.Duration) (response *http.Response, error error) {
-	if c.client.Timeout != timeout { // Set wanted timeout
-		c.client.Timeout = timeout
-	}
-	return c.client.Do(request)
+	c.mu.Lock()
+	client := *c.client // Copy client so that concurrent requests can use different timeouts
+	c.mu.Unlock()
+	client.Timeout = timeout
+	return client.Do(request)
 }
 
+// UseCertificate sets the client certificates presented to servers. The transport, and thus its open connections, is
+// kept if the certificates are unchanged.
 func (c *defaultHttpClient) UseCertificate(certificates []tls.Certificate) {
-	c.client.Transport = &http.Transport{TLSClientConfig: &tls.Config{
-		Certificates: certificates,
-	}}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if sameCertificates(c.certificates, certificates) {
+		return
+	}
+	c.certificates = certificates
+	c.configureTransport()
+}
+
+// UseRootCAs sets the pool of CA certificates used to verify server certificates. The system pool is used if pool is
+// nil. The transport, and thus its open connections, is kept if the pool is unchanged.
+func (c *defaultHttpClient) UseRootCAs(pool *x509.CertPool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if pool == c.rootCAs {
+		return
+	}
+	c.rootCAs = pool
+	c.configureTransport()
+}
+
+// UseTransportOptions sets the timeouts of the phases of requests.
+func (c *defaultHttpClient) UseTransportOptions(options TransportOptions) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.options = options
+	c.configureTransport()
+}
+
+func (c *defaultHttpClient) configureTransport() {
+	dialer := &net.Dialer{Timeout: c.options.DialTimeout}
+	c.client.Transport = &http.Transport{
+		Proxy:                 http.ProxyFromEnvironment,
+		DialContext:           dialer.DialContext,
+		TLSHandshakeTimeout:   c.options.TLSHandshakeTimeout,
+		ResponseHeaderTimeout: c.options.ResponseHeaderTimeout,
+		TLSClientConfig: &tls.Config{
+			Certificates:       c.certificates,
+			RootCAs:            c.rootCAs,
+			ClientSessionCache: tls.NewLRUClientSessionCache(0),
+		},
+	}
+}
+
+func sameCertificates(a, b []tls.Certificate) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if len(a[i].Certificate) != len(b[i].Certificate) {
+			return false
+		}
+		for j := range a[i].Certificate {
+			if !bytes.Equal(a[i].Certificate[j], b[i].Certificate[j]) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// LoadCACertificates loads all PEM encoded CA certificates in files with a .pem or .crt extension in directory dir,
+// like the capath option of OpenSSL, and returns them in a pool with the system CA certificates. Files not containing
+// any certificate are skipped.
+func LoadCACertificates(dir string) (*x509.CertPool, error) {
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("could not read CA certificate directory: %w", err)
+	}
+	pool, err := x509.SystemCertPool()
+	if err != nil {
+		pool = x509.NewCertPool() // No system pool, e.g. on Windows before Go 1.18
+	}
+	found := false
+	for _, f := range files {
+		ext := strings.ToLower(filepath.Ext(f.Name()))
+		if f.IsDir() || (ext != ".pem" && ext != ".crt") {
+			continue
+		}
+		data, err := ioutil.ReadFile(filepath.Join(dir, f.Name()))
+		if err != nil {
+			return nil, err
+		}
+		if pool.AppendCertsFromPEM(data) {
+			found = true
+		}
+	}
+	if !found {
+		return nil, fmt.Errorf("no CA certificates found in %s", dir)
+	}
+	return pool, nil
 }
 
 func CreateClient(timeout time.Duration) HttpClient {
-	return &defaultHttpClient{
-		client: &http.Client{Timeout: timeout},
+	c := &defaultHttpClient{
+		client:  &http.Client{Timeout: timeout},
+		options: DefaultTransportOptions,
 	}
+	c.configureTransport()
+	return c
 }
 
 // Convenience function for doing a HTTP GET
@@ -54,11 +192,104 @@ func HttpGet(host string, path string, description string) (*http.Response, erro
 	return HttpDo(&http.Request{URL: url}, time.Second*10, description)
 }
 
+// SetMaxConcurrency limits the number of requests which can be in flight through HttpDo at the same time to n.
+// Requests exceeding the limit block until a previous request completes, or their context is done. A request counts
+// against the limit only until its response headers arrive, not while its body is read. There is no limit if n is 0 or
+// less.
+func SetMaxConcurrency(n int) {
+	concurrencyMu.Lock()
+	defer concurrencyMu.Unlock()
+	if n > 0 {
+		concurrencySem = make(chan struct{}, n)
+	} else {
+		concurrencySem = nil
+	}
+}
+
+// SetVerboseWriter sets a writer to which the method and URL of each request made through HttpDo is written, as the
+// request is issued. Requests are not written anywhere if w is nil.
+func SetVerboseWriter(w io.Writer) {
+	verboseMu.Lock()
+	defer verboseMu.Unlock()
+	verboseWriter = w
+}
+
+// RedactURL returns u as a string, with passwords and the values of query parameters which may hold secrets redacted.
+func RedactURL(u *url.URL) string {
+	redacted := *u
+	if _, ok := redacted.User.Password(); ok {
+		redacted.User = url.UserPassword(redacted.User.Username(), "REDACTED")
+	}
+	query := redacted.Query()
+	changed := false
+	for name := range query {
+		lowerName := strings.ToLower(name)
+		for _, secret := range secretParameters {
+			if strings.Contains(lowerName, secret) {
+				query.Set(name, "REDACTED")
+				changed = true
+				break
+			}
+		}
+	}
+	if changed {
+		redacted.RawQuery = query.Encode()
+	}
+	return redacted.String()
+}
+
+var (
+	secretPatterns = []struct {
+		pattern     *regexp.Regexp
+		replacement string
+	}{
+		{regexp.MustCompile(`-----BEGIN ([A-Z0-9 ]+)-----[^-]*-----END ([A-Z0-9 ]+)-----`), "-----BEGIN $1-----\nREDACTED\n-----END $2-----"},
+		{regexp.MustCompile(`(?i)(authorization"?\s*[:=]\s*"?)(bearer\s+|basic\s+)?[^\s",]+`), "${1}${2}REDACTED"},
+		{regexp.MustCompile(`(?i)(bearer\s+)[a-z0-9\-._~+/]+=*`), "${1}REDACTED"},
+		{regexp.MustCompile(`(?i)([?&][^=&\s]*(?:` + strings.Join(secretParameters, "|") + `)[^=&\s]*=)[^&\s"']+`), "${1}REDACTED"},
+		{regexp.MustCompile(`(://[^:/@\s]+:)[^@/\s]+@`), "${1}REDACTED@"},
+	}
+)
+
+// Redact returns s with known secrets, such as authorization headers, bearer tokens, PEM blocks, secret query
+// parameters and passwords in URLs, replaced by REDACTED.
+func Redact(s string) string {
+	for _, p := range secretPatterns {
+		s = p.pattern.ReplaceAllString(s, p.replacement)
+	}
+	return s
+}
+
+// HttpDo sends request with the given timeout. The request fails if its context is done.
 func HttpDo(request *http.Request, timeout time.Duration, description string) (*http.Response, error) {
+	if err := request.Context().Err(); err != nil {
+		return nil, err
+	}
+	verboseMu.RLock()
+	w := verboseWriter
+	verboseMu.RUnlock()
+	if w != nil {
+		method := request.Method
+		if method == "" {
+			method = "GET"
+		}
+		fmt.Fprintf(w, "> %s %s\n", method, RedactURL(request.URL))
+	}
 	if request.Header == nil {
 		request.Header = make(http.Header)
 	}
 	request.Header.Set("User-Agent", fmt.Sprintf("Vespa CLI/%s", build.Version))
+	concurrencyMu.RLock()
+	sem := concurrencySem
+	concurrencyMu.RUnlock()
+	if sem != nil {
+		select {
+		case sem <- struct{}{}:
+		case <-request.Context().Done():
+			return nil, request.Context().Err()
+		}
+		defer func() { <-sem }()
+	}
 	response, err := ActiveHttpClient.Do(request, timeout)
 	if err != nil {
 		return nil, err
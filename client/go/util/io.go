@@ -8,6 +8,7 @@ import (
 	"bytes"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"io"
 	"io/ioutil"
 	"os"
@@ -43,13 +44,24 @@ func ReaderToBytes(reader io.Reader) []byte {
 
 // Returns the contents of reader as indented JSON
 func ReaderToJSON(reader io.Reader) string {
+	return ReaderToJSONIndent(reader, "", "    ")
+}
+
+// Returns the contents of reader as JSON, where each line begins with prefix and is indented by indent according to
+// its nesting. The JSON is compacted if both prefix and indent are empty
+func ReaderToJSONIndent(reader io.Reader, prefix, indent string) string {
 	bodyBytes, _ := ioutil.ReadAll(reader)
-	var prettyJSON bytes.Buffer
-	parseError := json.Indent(&prettyJSON, bodyBytes, "", "    ")
+	var formattedJSON bytes.Buffer
+	var parseError error
+	if prefix == "" && indent == "" {
+		parseError = json.Compact(&formattedJSON, bodyBytes)
+	} else {
+		parseError = json.Indent(&formattedJSON, bodyBytes, prefix, indent)
+	}
 	if parseError != nil { // Not JSON: Print plainly
 		return string(bodyBytes)
 	}
-	return prettyJSON.String()
+	return formattedJSON.String()
 }
 
 // AtomicWriteFile atomically writes data to filename.
@@ -68,3 +80,62 @@ func AtomicWriteFile(filename string, data []byte) error {
 	}
 	return os.Rename(tmpFile.Name(), filename)
 }
+
+// WriteOutput writes data to a file with given name in directory dir, creating the directory if necessary. An existing
+// file is only overwritten if force is true.
+func WriteOutput(dir, name string, data []byte, force bool) error {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	path := filepath.Join(dir, name)
+	if !force && PathExists(path) {
+		return fmt.Errorf("%s already exists: %w", path, os.ErrExist)
+	}
+	return AtomicWriteFile(path, data)
+}
+
+// utf8BOM is the byte order mark of UTF-8, which some Windows tools require to detect the encoding of a file.
+var utf8BOM = []byte{0xef, 0xbb, 0xbf}
+
+type crlfWriter struct {
+	w      io.Writer
+	lastCR bool
+}
+
+// NewCRLFWriter returns a writer which translates LF line endings to CRLF before writing to w. Line endings which are
+// already CRLF are left unchanged.
+func NewCRLFWriter(w io.Writer) io.Writer { return &crlfWriter{w: w} }
+
+func (c *crlfWriter) Write(p []byte) (int, error) {
+	var buf bytes.Buffer
+	buf.Grow(len(p))
+	for _, b := range p {
+		if b == '\n' && !c.lastCR {
+			buf.WriteByte('\r')
+		}
+		buf.WriteByte(b)
+		c.lastCR = b == '\r'
+	}
+	if _, err := c.w.Write(buf.Bytes()); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+type bomWriter struct {
+	w       io.Writer
+	written bool
+}
+
+// NewBOMWriter returns a writer which writes a UTF-8 byte order mark to w before the first write.
+func NewBOMWriter(w io.Writer) io.Writer { return &bomWriter{w: w} }
+
+func (b *bomWriter) Write(p []byte) (int, error) {
+	if !b.written {
+		if _, err := b.w.Write(utf8BOM); err != nil {
+			return 0, err
+		}
+		b.written = true
+	}
+	return b.w.Write(p)
+}
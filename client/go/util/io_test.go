@@ -0,0 +1,70 @@
+// Copyright Yahoo. Licensed under the terms of the Apache 2.0 license. See LICENSE in the project root.
+
+package util
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReaderToJSON(t *testing.T) {
+	input := `{"a": [1, 2], "b": {"c": "d"}}`
+	assert.Equal(t, "{\n    \"a\": [\n        1,\n        2\n    ],\n    \"b\": {\n        \"c\": \"d\"\n    }\n}",
+		ReaderToJSON(strings.NewReader(input)))
+	assert.Equal(t, "{\n\t\"a\": [\n\t\t1,\n\t\t2\n\t],\n\t\"b\": {\n\t\t\"c\": \"d\"\n\t}\n}",
+		ReaderToJSONIndent(strings.NewReader(input), "", "\t"))
+	assert.Equal(t, "{\n#  \"a\": [\n#    1,\n#    2\n#  ],\n#  \"b\": {\n#    \"c\": \"d\"\n#  }\n#}",
+		ReaderToJSONIndent(strings.NewReader(input), "#", "  "))
+	assert.Equal(t, `{"a":[1,2],"b":{"c":"d"}}`, ReaderToJSONIndent(strings.NewReader(input), "", ""))
+
+	assert.Equal(t, "not json", ReaderToJSON(strings.NewReader("not json")))
+	assert.Equal(t, "{not json", ReaderToJSONIndent(strings.NewReader("{not json"), "", "\t"))
+	assert.Equal(t, "{not json", ReaderToJSONIndent(strings.NewReader("{not json"), "", ""))
+}
+
+func TestWriteOutput(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "out", "logs")
+	path := filepath.Join(dir, "vespa.log")
+
+	// Creates directory
+	assert.Nil(t, WriteOutput(dir, "vespa.log", []byte("first"), false))
+	data, err := ioutil.ReadFile(path)
+	assert.Nil(t, err)
+	assert.Equal(t, "first", string(data))
+
+	// Refuses to overwrite
+	err = WriteOutput(dir, "vespa.log", []byte("second"), false)
+	assert.True(t, errors.Is(err, os.ErrExist))
+	data, err = ioutil.ReadFile(path)
+	assert.Nil(t, err)
+	assert.Equal(t, "first", string(data))
+
+	// Overwrites when forced
+	assert.Nil(t, WriteOutput(dir, "vespa.log", []byte("third"), true))
+	data, err = ioutil.ReadFile(path)
+	assert.Nil(t, err)
+	assert.Equal(t, "third", string(data))
+}
+
+func TestCRLFWriter(t *testing.T) {
+	var buf bytes.Buffer
+	w := NewCRLFWriter(&buf)
+	fmt.Fprint(w, "line 1\nline 2\r\n")
+	fmt.Fprint(w, "\nline 4\r")
+	fmt.Fprint(w, "\n")
+	assert.Equal(t, "line 1\r\nline 2\r\n\r\nline 4\r\n", buf.String())
+
+	buf.Reset()
+	w = NewBOMWriter(NewCRLFWriter(&buf))
+	fmt.Fprintln(w, `{"foo":1}`)
+	fmt.Fprintln(w, `{"bar":2}`)
+	assert.Equal(t, "\xef\xbb\xbf{\"foo\":1}\r\n{\"bar\":2}\r\n", buf.String())
+}
@@ -19,6 +19,11 @@ const (
 var messages = os.Stderr
 
 func Spinner(text string, fn func() error) error {
+	return SpinnerWithProgress(text, func(update func(string)) error { return fn() })
+}
+
+// SpinnerWithProgress is like Spinner, but fn can call update to replace the progress text shown after the spinner.
+func SpinnerWithProgress(text string, fn func(update func(progress string)) error) error {
 	initialMsg := text + " "
 	doneMsg := "\r" + initialMsg + spinnerTextDone + "\n"
 	failMsg := "\r" + initialMsg + spinnerTextFailed + "\n"
@@ -26,38 +31,29 @@ func Spinner(text string, fn func() error) error {
 }
 
 func Waiting(fn func() error) error {
-	return loading("", "", "", fn)
+	return loading("", "", "", func(update func(string)) error { return fn() })
 }
 
-func loading(initialMsg, doneMsg, failMsg string, fn func() error) error {
-	done := make(chan struct{})
-	errc := make(chan error)
-	go func() {
-		defer close(done)
-
-		s := spinner.New(spinner.CharSets[11], 100*time.Millisecond, spinner.WithWriter(messages))
-		s.Prefix = initialMsg
-		s.FinalMSG = doneMsg
-		s.HideCursor = true
-		s.Writer = messages
-
-		if err := s.Color(spinnerColor, "bold"); err != nil {
-			panic(Error(err, "failed setting spinner color"))
-		}
-
-		s.Start()
-		err := <-errc
-		if err != nil {
-			s.FinalMSG = failMsg
-		}
-
-		s.Stop()
-	}()
-
-	err := fn()
-	errc <- err
-	<-done
-
+func loading(initialMsg, doneMsg, failMsg string, fn func(update func(string)) error) error {
+	s := spinner.New(spinner.CharSets[11], 100*time.Millisecond, spinner.WithWriter(messages))
+	s.Prefix = initialMsg
+	s.FinalMSG = doneMsg
+	s.HideCursor = true
+	s.Writer = messages
+	if err := s.Color(spinnerColor, "bold"); err != nil {
+		panic(Error(err, "failed setting spinner color"))
+	}
+
+	s.Start()
+	err := fn(func(progress string) {
+		s.Lock()
+		defer s.Unlock()
+		s.Suffix = " " + progress
+	})
+	if err != nil {
+		s.FinalMSG = failMsg
+	}
+	s.Stop()
 	return err
 }
 
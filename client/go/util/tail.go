@@ -0,0 +1,73 @@
+// Copyright Yahoo. Licensed under the terms of the Apache 2.0 license. See LICENSE in the project root.
+package util
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+)
+
+// TailWriter writes lines to a terminal, keeping only the last lines written visible. Once more lines than its limit
+// are written, the visible lines are erased using ANSI escape codes, and the last lines are written again. Lines wider
+// than the terminal are assumed not to wrap.
+type TailWriter struct {
+	w       io.Writer
+	limit   int
+	visible []string
+	partial []byte
+}
+
+// NewTailWriter creates a TailWriter which writes to w, and keeps at most limit lines visible.
+func NewTailWriter(w io.Writer, limit int) *TailWriter {
+	if limit < 1 {
+		limit = 1
+	}
+	return &TailWriter{w: w, limit: limit}
+}
+
+// Write writes the complete lines of p, and holds any trailing incomplete line until it's completed, or Flush is
+// called.
+func (t *TailWriter) Write(p []byte) (int, error) {
+	t.partial = append(t.partial, p...)
+	var lines []string
+	for {
+		i := bytes.IndexByte(t.partial, '\n')
+		if i < 0 {
+			break
+		}
+		lines = append(lines, string(t.partial[:i]))
+		t.partial = t.partial[i+1:]
+	}
+	if len(lines) == 0 {
+		return len(p), nil
+	}
+	var buf bytes.Buffer
+	if len(t.visible)+len(lines) <= t.limit {
+		t.visible = append(t.visible, lines...)
+	} else {
+		if len(t.visible) > 0 {
+			// Move the cursor to the start of the first visible line, and erase from there to the end of the screen
+			fmt.Fprintf(&buf, "\x1b[%dF\x1b[J", len(t.visible))
+		}
+		visible := append(t.visible, lines...)
+		t.visible = append([]string(nil), visible[len(visible)-t.limit:]...)
+		lines = t.visible
+	}
+	for _, line := range lines {
+		buf.WriteString(line)
+		buf.WriteByte('\n')
+	}
+	if _, err := t.w.Write(buf.Bytes()); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// Flush writes any incomplete line held by this writer.
+func (t *TailWriter) Flush() error {
+	if len(t.partial) == 0 {
+		return nil
+	}
+	_, err := t.Write([]byte("\n"))
+	return err
+}
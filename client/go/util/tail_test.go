@@ -0,0 +1,35 @@
+// Copyright Yahoo. Licensed under the terms of the Apache 2.0 license. See LICENSE in the project root.
+package util
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTailWriter(t *testing.T) {
+	var buf bytes.Buffer
+	w := NewTailWriter(&buf, 2)
+
+	// Lines within the limit are written as is
+	w.Write([]byte("line 1\nline"))
+	w.Write([]byte(" 2\n"))
+	assert.Equal(t, "line 1\nline 2\n", buf.String())
+
+	// Visible lines are erased, and the last ones written again
+	buf.Reset()
+	w.Write([]byte("line 3\n"))
+	assert.Equal(t, "\x1b[2F\x1b[Jline 2\nline 3\n", buf.String())
+
+	buf.Reset()
+	w.Write([]byte("line 4\nline 5\nline 6\n"))
+	assert.Equal(t, "\x1b[2F\x1b[Jline 5\nline 6\n", buf.String())
+
+	// An incomplete line is written on flush
+	buf.Reset()
+	w.Write([]byte("line 7"))
+	assert.Equal(t, "", buf.String())
+	assert.Nil(t, w.Flush())
+	assert.Equal(t, "\x1b[2F\x1b[Jline 6\nline 7\n", buf.String())
+}
@@ -3,11 +3,14 @@ package vespa
 
 import (
 	"bytes"
+	"crypto"
 	"crypto/ecdsa"
 	"crypto/elliptic"
 	"crypto/md5"
 	"crypto/rand"
+	"crypto/rsa"
 	"crypto/sha256"
+	"crypto/tls"
 	"crypto/x509"
 	"crypto/x509/pkix"
 	"encoding/base64"
@@ -22,6 +25,7 @@ import (
 	"time"
 
 	"github.com/vespa-engine/vespa/client/go/util"
+	"golang.org/x/crypto/pkcs12"
 )
 
 const (
@@ -95,14 +99,52 @@ func CreateAPIKey() ([]byte, error) {
 	return pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: privateKeyDER}), nil
 }
 
+// LoadPKCS12 reads a private key and X509 certificate chain from pkcs12File, decrypting it with password.
+func LoadPKCS12(pkcs12File, password string) (tls.Certificate, error) {
+	data, err := ioutil.ReadFile(pkcs12File)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	blocks, err := pkcs12.ToPEM(data, password)
+	if err != nil {
+		return tls.Certificate{}, fmt.Errorf("could not decode pkcs12 file %s: %w", pkcs12File, err)
+	}
+	var pemCertificates, pemPrivateKey []byte
+	for _, block := range blocks {
+		if block.Type == "CERTIFICATE" {
+			pemCertificates = append(pemCertificates, pem.EncodeToMemory(block)...)
+		} else {
+			pemPrivateKey = append(pemPrivateKey, pem.EncodeToMemory(block)...)
+		}
+	}
+	return tls.X509KeyPair(pemCertificates, pemPrivateKey)
+}
+
+// ParseCertificate parses the first PEM-encoded X509 certificate in pemData.
+func ParseCertificate(pemData []byte) (*x509.Certificate, error) {
+	for {
+		var block *pem.Block
+		block, pemData = pem.Decode(pemData)
+		if block == nil {
+			return nil, fmt.Errorf("no certificate found")
+		}
+		if block.Type == "CERTIFICATE" {
+			return x509.ParseCertificate(block.Bytes)
+		}
+	}
+}
+
 type RequestSigner struct {
 	now           func() time.Time
 	rnd           io.Reader
 	KeyID         string
 	PemPrivateKey []byte
+	// ClockOffset is added to the local time when timestamping requests, to compensate for a skewed local clock.
+	ClockOffset time.Duration
 }
 
-// NewRequestSigner creates a new signer using the EC pemPrivateKey. keyID names the key used to sign requests.
+// NewRequestSigner creates a new signer using the EC or RSA pemPrivateKey. The key type is detected from the PEM
+// data when signing. keyID names the key used to sign requests.
 func NewRequestSigner(keyID string, pemPrivateKey []byte) *RequestSigner {
 	return &RequestSigner{
 		now:           time.Now,
@@ -114,12 +156,12 @@ func NewRequestSigner(keyID string, pemPrivateKey []byte) *RequestSigner {
 
 // SignRequest signs the given HTTP request using the private key in rs
 func (rs *RequestSigner) SignRequest(request *http.Request) error {
-	timestamp := rs.now().UTC().Format(time.RFC3339)
+	timestamp := rs.now().Add(rs.ClockOffset).UTC().Format(time.RFC3339)
 	contentHash, body, err := contentHash(request.Body)
 	if err != nil {
 		return err
 	}
-	privateKey, err := ECPrivateKeyFrom(rs.PemPrivateKey)
+	privateKey, err := PrivateKeyFrom(rs.PemPrivateKey)
 	if err != nil {
 		return err
 	}
@@ -145,12 +187,45 @@ func (rs *RequestSigner) SignRequest(request *http.Request) error {
 	return nil
 }
 
-func (rs *RequestSigner) hashAndSign(privateKey *ecdsa.PrivateKey, request *http.Request, timestamp, contentHash string) ([]byte, error) {
+func (rs *RequestSigner) hashAndSign(privateKey crypto.Signer, request *http.Request, timestamp, contentHash string) ([]byte, error) {
 	msg := []byte(request.Method + "\n" + request.URL.String() + "\n" + timestamp + "\n" + contentHash)
 	hasher := sha256.New()
 	hasher.Write(msg)
 	hash := hasher.Sum(nil)
-	return ecdsa.SignASN1(rs.rnd, privateKey, hash)
+	switch key := privateKey.(type) {
+	case *ecdsa.PrivateKey:
+		return ecdsa.SignASN1(rs.rnd, key, hash)
+	case *rsa.PrivateKey:
+		return rsa.SignPKCS1v15(rs.rnd, key, crypto.SHA256, hash)
+	default:
+		return nil, fmt.Errorf("unsupported private key type: %T", privateKey)
+	}
+}
+
+// PrivateKeyFrom reads an EC or RSA private key (in raw, PKCS1 or PKCS8 format) from the PEM-encoded pemPrivateKey.
+func PrivateKeyFrom(pemPrivateKey []byte) (crypto.Signer, error) {
+	privateKeyBlock, _ := pem.Decode(pemPrivateKey)
+	if privateKeyBlock == nil {
+		return nil, fmt.Errorf("invalid pem private key")
+	}
+	switch privateKeyBlock.Type {
+	case "EC PRIVATE KEY":
+		return x509.ParseECPrivateKey(privateKeyBlock.Bytes) // Raw EC private key
+	case "RSA PRIVATE KEY":
+		return x509.ParsePKCS1PrivateKey(privateKeyBlock.Bytes) // PKCS1 RSA private key
+	}
+	privateKey, err := x509.ParsePKCS8PrivateKey(privateKeyBlock.Bytes) // Try PKCS8 format
+	if err != nil {
+		return nil, err
+	}
+	switch key := privateKey.(type) {
+	case *ecdsa.PrivateKey:
+		return key, nil
+	case *rsa.PrivateKey:
+		return key, nil
+	default:
+		return nil, fmt.Errorf("invalid private key type: %T", privateKey)
+	}
 }
 
 // ECPrivateKeyFrom reads an EC private key (in raw or PKCS8 format) from the PEM-encoded pemPrivateKey.
@@ -174,7 +249,7 @@ func ECPrivateKeyFrom(pemPrivateKey []byte) (*ecdsa.PrivateKey, error) {
 }
 
 // PEMPublicKeyFrom extracts the public key from privateKey encoded as PEM.
-func PEMPublicKeyFrom(privateKey *ecdsa.PrivateKey) ([]byte, error) {
+func PEMPublicKeyFrom(privateKey crypto.Signer) ([]byte, error) {
 	publicKeyDER, err := x509.MarshalPKIXPublicKey(privateKey.Public())
 	if err != nil {
 		return nil, err
@@ -2,9 +2,19 @@
 package vespa
 
 import (
+	"crypto"
+	"crypto/ecdsa"
+	cryptorand "crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
 	"encoding/base64"
+	"encoding/pem"
 	"math/rand"
 	"net/http"
+	"net/http/httptest"
+	"path/filepath"
 	"strings"
 	"testing"
 	"time"
@@ -20,17 +30,34 @@ func TestCreateKeyPair(t *testing.T) {
 }
 
 func TestSignRequest(t *testing.T) {
-	fixedTime := time.Unix(0, 0)
-	rnd := rand.New(rand.NewSource(0)) // Fixed seed for testing purposes
-	privateKey, err := CreateAPIKey()
+	ecKey, err := CreateAPIKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	rsaKey, err := rsa.GenerateKey(cryptorand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pemRSAKey := pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(rsaKey)})
+	pkcs8RSAKeyDER, err := x509.MarshalPKCS8PrivateKey(rsaKey)
 	if err != nil {
 		t.Fatal(err)
 	}
+	pemPKCS8RSAKey := pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: pkcs8RSAKeyDER})
+
+	assertSignRequest(t, ecKey)
+	assertSignRequest(t, pemRSAKey)
+	assertSignRequest(t, pemPKCS8RSAKey)
+}
+
+func assertSignRequest(t *testing.T, pemPrivateKey []byte) {
+	fixedTime := time.Unix(0, 0)
+	rnd := rand.New(rand.NewSource(0)) // Fixed seed for testing purposes
 	rs := RequestSigner{
 		now:           func() time.Time { return fixedTime },
 		rnd:           rnd,
 		KeyID:         "my-key",
-		PemPrivateKey: []byte(privateKey),
+		PemPrivateKey: pemPrivateKey,
 	}
 	req, err := http.NewRequest("POST", "https://example.com", strings.NewReader("body"))
 	if err != nil {
@@ -46,12 +73,32 @@ func TestSignRequest(t *testing.T) {
 	assert.Equal(t, "my-key", req.Header.Get("X-Key-Id"))
 	key := req.Header.Get("X-Key")
 	assert.NotEmpty(t, key)
-	_, err = base64.StdEncoding.DecodeString(key)
+	pemPublicKey, err := base64.StdEncoding.DecodeString(key)
 	assert.Nil(t, err)
 	auth := req.Header.Get("X-Authorization")
 	assert.NotEmpty(t, auth)
-	_, err = base64.StdEncoding.DecodeString(auth)
+	signature, err := base64.StdEncoding.DecodeString(auth)
 	assert.Nil(t, err)
+
+	// Verify signature using the public key sent in the request
+	block, _ := pem.Decode(pemPublicKey)
+	if block == nil {
+		t.Fatal("invalid pem public key")
+	}
+	publicKey, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg := "POST\nhttps://example.com\n1970-01-01T00:00:00Z\nIw2DWNyOiJC0xY3utikS7i8gNXrpKlzIYbmOaP4xrLU="
+	hash := sha256.Sum256([]byte(msg))
+	switch pub := publicKey.(type) {
+	case *ecdsa.PublicKey:
+		assert.True(t, ecdsa.VerifyASN1(pub, hash[:], signature))
+	case *rsa.PublicKey:
+		assert.Nil(t, rsa.VerifyPKCS1v15(pub, crypto.SHA256, hash[:], signature))
+	default:
+		t.Fatalf("unexpected public key type: %T", publicKey)
+	}
 }
 
 func TestFingerprintMD5(t *testing.T) {
@@ -90,3 +137,35 @@ p5/1RvabehrxwS9E9iBrOuNMemDrkNYXRSLx32BrZWSOoLPbOnsKbwxf
 
 	assert.True(t, k1.Equal(k2))
 }
+
+func TestLoadPKCS12(t *testing.T) {
+	pkcs12File := filepath.Join("testdata", "client.p12")
+	_, err := LoadPKCS12(pkcs12File, "wrong password")
+	assert.NotNil(t, err)
+
+	kp, err := LoadPKCS12(pkcs12File, "secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	certificate, err := x509.ParseCertificate(kp.Certificate[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, "cloud.vespa.example", certificate.Subject.CommonName)
+
+	var clientCommonName string
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		clientCommonName = r.TLS.PeerCertificates[0].Subject.CommonName
+	}))
+	srv.TLS = &tls.Config{ClientAuth: tls.RequireAnyClientCert}
+	srv.StartTLS()
+	defer srv.Close()
+	client := srv.Client()
+	client.Transport.(*http.Transport).TLSClientConfig.Certificates = []tls.Certificate{kp}
+	response, err := client.Get(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	response.Body.Close()
+	assert.Equal(t, "cloud.vespa.example", clientCommonName)
+}
@@ -5,8 +5,14 @@
 package vespa
 
 import (
+	"archive/tar"
 	"archive/zip"
+	"bufio"
 	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -17,15 +23,25 @@ import (
 	"net/url"
 	"os"
 	"path/filepath"
+	"regexp"
+	"sort"
 	"strconv"
 	"strings"
 	"time"
+	"unicode"
 
 	"github.com/vespa-engine/vespa/client/go/util"
 )
 
 var DefaultApplication = ApplicationID{Tenant: "default", Application: "application", Instance: "default"}
 
+// DefaultMaxPackageSize is the default maximum size of a compressed application package.
+const DefaultMaxPackageSize int64 = 1 << 30
+
+var labelKeyPattern = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9._-]{0,62}$`)
+
+var deployParameterPattern = regexp.MustCompile(`^[a-zA-Z][a-zA-Z0-9._-]*$`)
+
 type ApplicationID struct {
 	Tenant      string
 	Application string
@@ -46,12 +62,90 @@ type DeploymentOpts struct {
 	ApplicationPackage ApplicationPackage
 	Target             Target
 	Deployment         Deployment
-	APIKey             []byte
+	// Labels are arbitrary key/value pairs attached to a submission, e.g. the commit that produced it.
+	Labels map[string]string
+	// MaxPackageSize is the maximum size in bytes of the compressed application package. DefaultMaxPackageSize is used
+	// if this is 0.
+	MaxPackageSize int64
+	// Parameters are added to the query of the deploy or prepare request, URL-encoded.
+	Parameters map[string]string
+	// Digest is the digest of ApplicationPackage, if already computed. The package is zipped to compute its size
+	// before uploading it otherwise.
+	Digest *PackageDigest
 }
 
 type ApplicationPackage struct {
 	Path     string
 	TestPath string
+	// Source is the path the package was loaded from, if it differs from Path. This is the case for packages read from
+	// a tar.gz file, which are converted to a zip file at Path.
+	Source string
+}
+
+// PackageFile is a file contained in an application package.
+type PackageFile struct {
+	Path string
+	Size int64
+}
+
+// PrepareResult is the result of preparing an application package.
+type PrepareResult struct {
+	SessionID int64
+	// ConfigChangeActions lists the actions required for the prepared config changes to take effect.
+	ConfigChangeActions []ConfigChangeAction
+}
+
+// ConfigChangeAction describes an action, e.g. restart or reindexing, which is required for a config change to take
+// effect.
+type ConfigChangeAction struct {
+	Type         string // One of "restart", "refeed" or "reindex"
+	Cluster      string
+	DocumentType string
+	Messages     []string
+}
+
+type submitOptions struct {
+	Labels map[string]string `json:"labels,omitempty"`
+}
+
+type configChangeActionsResponse struct {
+	ConfigChangeActions struct {
+		Restart []configChangeActionResponse `json:"restart"`
+		Refeed  []configChangeActionResponse `json:"refeed"`
+		Reindex []configChangeActionResponse `json:"reindex"`
+	} `json:"configChangeActions"`
+}
+
+type configChangeActionResponse struct {
+	ClusterName  string   `json:"clusterName"`
+	DocumentType string   `json:"documentType"`
+	Messages     []string `json:"messages"`
+}
+
+// ErrInvalidApplicationPackage is returned when the target rejects an application package as invalid.
+var ErrInvalidApplicationPackage = errors.New("invalid application package")
+
+// unauthorizedError wraps an error response rejecting the credentials of a request.
+type unauthorizedError struct{ error }
+
+func (e unauthorizedError) Is(target error) bool { return target == ErrUnauthorized }
+
+func (e unauthorizedError) Unwrap() error { return e.error }
+
+// transientError wraps an error which may be resolved by retrying the operation that caused it.
+type transientError struct{ error }
+
+func (e transientError) Unwrap() error { return e.error }
+
+// IsTransient returns whether err is a transient error, e.g. a network error or an unavailable service. Operations
+// failing with such errors are safe to retry, while other errors, e.g. validation errors, are not. Errors caused by a
+// cancelled context or an exceeded deadline are never transient.
+func IsTransient(err error) bool {
+	if errors.Is(err, context.DeadlineExceeded) || errors.Is(err, context.Canceled) {
+		return false
+	}
+	var te transientError
+	return errors.As(err, &te)
 }
 
 func (a ApplicationID) String() string {
@@ -62,6 +156,10 @@ func (a ApplicationID) SerializedForm() string {
 	return fmt.Sprintf("%s:%s:%s", a.Tenant, a.Application, a.Instance)
 }
 
+func (z ZoneID) String() string {
+	return fmt.Sprintf("%s.%s", z.Environment, z.Region)
+}
+
 func (d Deployment) String() string {
 	return fmt.Sprintf("deployment of %s in %s", d.Application, d.Zone)
 }
@@ -72,20 +170,108 @@ func (d DeploymentOpts) String() string {
 
 func (d *DeploymentOpts) IsCloud() bool { return d.Target.Type() == cloudTargetType }
 
-func (d *DeploymentOpts) url(path string) (*url.URL, error) {
-	service, err := d.Target.Service(deployService, 0, 0, "")
+func (d *DeploymentOpts) url(ctx context.Context, path string) (*url.URL, error) {
+	service, err := d.Target.Service(ctx, deployService, 0, 0, "")
 	if err != nil {
 		return nil, err
 	}
 	return url.Parse(service.BaseURL + path)
 }
 
+// application returns the application deployed to by these options on a self-hosted target.
+func (d *DeploymentOpts) application() ApplicationID {
+	if t, ok := d.Target.(*customTarget); ok {
+		return t.application
+	}
+	return selfHostedApplication(ApplicationID{})
+}
+
+// withApplication returns a copy of u with the application and instance names of a self-hosted deployment added to its
+// query, unless these are the defaults.
+func (d *DeploymentOpts) withApplication(u *url.URL) *url.URL {
+	app := d.application()
+	if app.Application == defaultSelfHostedName && app.Instance == defaultSelfHostedName {
+		return u
+	}
+	withApp := *u
+	query := withApp.Query()
+	query.Set("applicationName", app.Application)
+	query.Set("instance", app.Instance)
+	withApp.RawQuery = query.Encode()
+	return &withApp
+}
+
+// withParameters returns a copy of u with the parameters of these options added to its query.
+func (d *DeploymentOpts) withParameters(u *url.URL) *url.URL {
+	if len(d.Parameters) == 0 {
+		return u
+	}
+	withParams := *u
+	query := withParams.Query()
+	for key, value := range d.Parameters {
+		query.Set(key, value)
+	}
+	withParams.RawQuery = query.Encode()
+	return &withParams
+}
+
 func (ap *ApplicationPackage) HasCertificate() bool {
 	return ap.hasFile(filepath.Join("security", "clients.pem"), "security/clients.pem")
 }
 
 func (ap *ApplicationPackage) HasDeployment() bool { return ap.hasFile("deployment.xml", "") }
 
+// BuildMetaFile is the file in an application package holding metadata of its build, such as its version.
+const BuildMetaFile = "build-meta.json"
+
+type buildMeta struct {
+	Version string `json:"version"`
+}
+
+// DeclaredVersion returns the version declared in the build metadata of this application package, or empty if none is
+// declared.
+func (ap *ApplicationPackage) DeclaredVersion() (string, error) {
+	data, err := ap.readFile(BuildMetaFile)
+	if errors.Is(err, os.ErrNotExist) {
+		return "", nil
+	} else if err != nil {
+		return "", err
+	}
+	return parseBuildMetaVersion(data)
+}
+
+func parseBuildMetaVersion(data []byte) (string, error) {
+	var meta buildMeta
+	if err := json.Unmarshal(data, &meta); err != nil {
+		return "", fmt.Errorf("invalid %s: %w", BuildMetaFile, err)
+	}
+	return meta.Version, nil
+}
+
+// readFile reads the file with given slash-separated name in this application package.
+func (ap *ApplicationPackage) readFile(name string) ([]byte, error) {
+	if !ap.IsZip() {
+		return ioutil.ReadFile(filepath.Join(ap.Path, filepath.FromSlash(name)))
+	}
+	r, err := zip.OpenReader(ap.Path)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	for _, f := range r.File {
+		if f.Name != name {
+			continue
+		}
+		rc, err := f.Open()
+		if err != nil {
+			return nil, err
+		}
+		defer rc.Close()
+		return ioutil.ReadAll(rc)
+	}
+	return nil, fmt.Errorf("%s not found in %s: %w", name, ap.Name(), os.ErrNotExist)
+}
+
 func (ap *ApplicationPackage) hasFile(filename, zipName string) bool {
 	if zipName == "" {
 		zipName = filename
@@ -108,6 +294,22 @@ func (ap *ApplicationPackage) hasFile(filename, zipName string) bool {
 
 func (ap *ApplicationPackage) IsZip() bool { return isZip(ap.Path) }
 
+// Close removes the temporary zip file of a package read from a tar.gz file. It does nothing for other packages.
+func (ap *ApplicationPackage) Close() error {
+	if ap.Source == "" {
+		return nil
+	}
+	return os.Remove(ap.Path)
+}
+
+// Name returns the path this application package was loaded from, for display to the user.
+func (ap *ApplicationPackage) Name() string {
+	if ap.Source != "" {
+		return ap.Source
+	}
+	return ap.Path
+}
+
 func (ap *ApplicationPackage) IsJava() bool {
 	if ap.IsZip() {
 		r, err := zip.OpenReader(ap.Path)
@@ -151,32 +353,250 @@ func (ap *ApplicationPackage) zipReader(test bool) (io.ReadCloser, error) {
 	return f, nil
 }
 
-// FindApplicationPackage finds the path to an application package from the zip file or directory zipOrDir.
+// PackageDigest is the hash and size of a zipped application package.
+type PackageDigest struct {
+	// Hash is the hex-encoded SHA-256 hash of the zipped package.
+	Hash string
+	// Size is the size in bytes of the zipped package.
+	Size int64
+}
+
+// Digest zips this application package once, and returns the hash and size of the zip file. The zip file is not
+// stored.
+func (ap *ApplicationPackage) Digest() (PackageDigest, error) {
+	write, err := zipWriteFunc(ap.Path)
+	if err != nil {
+		return PackageDigest{}, err
+	}
+	h := sha256.New()
+	var size byteCounter
+	if err := write(io.MultiWriter(h, &size)); err != nil {
+		return PackageDigest{}, fmt.Errorf("could not hash application package at %s: %w", ap.Path, err)
+	}
+	return PackageDigest{Hash: hex.EncodeToString(h.Sum(nil)), Size: int64(size)}, nil
+}
+
+// Hash returns the hex-encoded SHA-256 hash of the zipped contents of this application package.
+func (ap *ApplicationPackage) Hash() (string, error) {
+	d, err := ap.Digest()
+	return d.Hash, err
+}
+
+// Size returns the size in bytes of the compressed application package.
+func (ap *ApplicationPackage) Size() (int64, error) {
+	d, err := ap.Digest()
+	return d.Size, err
+}
+
+// byteCounter is a writer which counts the bytes written to it.
+type byteCounter int64
+
+func (c *byteCounter) Write(p []byte) (int, error) {
+	*c += byteCounter(len(p))
+	return len(p), nil
+}
+
+// LargestFiles returns the n largest files in this application package by uncompressed size, largest first.
+func (ap *ApplicationPackage) LargestFiles(n int) ([]PackageFile, error) {
+	files, err := ap.Files()
+	if err != nil {
+		return nil, err
+	}
+	sort.SliceStable(files, func(i, j int) bool { return files[i].Size > files[j].Size })
+	if len(files) > n {
+		files = files[:n]
+	}
+	return files, nil
+}
+
+// Files returns all files in this application package, with paths relative to its root, in the order they are
+// zipped.
+func (ap *ApplicationPackage) Files() ([]PackageFile, error) {
+	var files []PackageFile
+	if ap.IsZip() {
+		r, err := zip.OpenReader(ap.Path)
+		if err != nil {
+			return nil, err
+		}
+		defer r.Close()
+		for _, f := range r.File {
+			if !f.FileInfo().IsDir() {
+				files = append(files, PackageFile{Path: strings.TrimPrefix(f.Name, "/"), Size: int64(f.UncompressedSize64)})
+			}
+		}
+		return files, nil
+	}
+	err := walkPackageDir(ap.Path, func(path, name string, info os.FileInfo) error {
+		files = append(files, PackageFile{Path: name, Size: info.Size()})
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return files, nil
+}
+
+// walkPackageDir calls fn for each file to include from the application package directory dir, with the path of the
+// file and its slash-separated path relative to dir.
+func walkPackageDir(dir string, fn func(path, name string, info os.FileInfo) error) error {
+	return filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			return nil
+		}
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		return fn(path, filepath.ToSlash(rel), info)
+	})
+}
+
+// PackageSearchDepth is the number of directory levels searched for services.xml, below and above the given directory,
+// when no application package is found in the directory itself.
+const PackageSearchDepth = 3
+
+// ErrAmbiguousApplicationPackage is returned when searching for an application package finds more than one.
+var ErrAmbiguousApplicationPackage = errors.New("multiple application packages found")
+
+// FindApplicationPackage finds the path to an application package from the zip file, tar.gz file or directory
+// zipOrDir. A tar.gz file is converted to a zip file in the temporary directory of the system, which is removed by
+// closing the returned package.
+//
+// If a directory holds no application package, the directories below it are searched for one, up to PackageSearchDepth
+// levels down, and then the directories above it are searched for services.xml. Finding more than one application
+// package below the directory is an error.
 func FindApplicationPackage(zipOrDir string, requirePackaging bool) (ApplicationPackage, error) {
 	if isZip(zipOrDir) {
 		return ApplicationPackage{Path: zipOrDir}, nil
 	}
-	if util.PathExists(filepath.Join(zipOrDir, "pom.xml")) {
-		zip := filepath.Join(zipOrDir, "target", "application.zip")
+	if isTarGz(zipOrDir) {
+		zipFile, err := tarGzToZip(zipOrDir)
+		if err != nil {
+			return ApplicationPackage{}, err
+		}
+		return ApplicationPackage{Path: zipFile, Source: zipOrDir}, nil
+	}
+	pkg, found, err := findApplicationPackageIn(zipOrDir, requirePackaging)
+	if found || err != nil {
+		return pkg, err
+	}
+	candidates, err := findPackagesBelow(zipOrDir, PackageSearchDepth)
+	if err != nil {
+		return ApplicationPackage{}, err
+	}
+	switch len(candidates) {
+	case 0:
+	case 1:
+		return findApplicationPackageFrom(candidates[0], requirePackaging)
+	default:
+		return ApplicationPackage{}, fmt.Errorf("%w in '%s': %s", ErrAmbiguousApplicationPackage, zipOrDir, strings.Join(candidates, ", "))
+	}
+	if dir, ok := findServicesAbove(zipOrDir, PackageSearchDepth); ok {
+		return findApplicationPackageFrom(dir, requirePackaging)
+	}
+	return ApplicationPackage{}, errors.New("Could not find an application package source in '" + zipOrDir + "'")
+}
+
+// ApplicationPackageAt returns the application package whose services.xml is in directory root.
+func ApplicationPackageAt(root string) (ApplicationPackage, error) {
+	if !util.PathExists(filepath.Join(root, "services.xml")) {
+		return ApplicationPackage{}, fmt.Errorf("no services.xml found in package root '%s'", root)
+	}
+	return ApplicationPackage{Path: root}, nil
+}
+
+// findApplicationPackageIn finds an application package in the conventional layout in directory dir, and returns
+// whether one was found.
+func findApplicationPackageIn(dir string, requirePackaging bool) (ApplicationPackage, bool, error) {
+	if util.PathExists(filepath.Join(dir, "pom.xml")) {
+		zip := filepath.Join(dir, "target", "application.zip")
 		if util.PathExists(zip) {
-			testZip := filepath.Join(zipOrDir, "target", "application-test.zip")
-			return ApplicationPackage{Path: zip, TestPath: testZip}, nil
+			testZip := filepath.Join(dir, "target", "application-test.zip")
+			return ApplicationPackage{Path: zip, TestPath: testZip}, true, nil
 		}
 		if requirePackaging {
-			return ApplicationPackage{}, errors.New("pom.xml exists but no target/application.zip. Run mvn package first")
+			return ApplicationPackage{}, false, errors.New("pom.xml exists but no target/application.zip. Run mvn package first")
 		}
 	}
-	if util.PathExists(filepath.Join(zipOrDir, "src", "main", "application")) {
-		if util.PathExists(filepath.Join(zipOrDir, "src", "test", "application")) {
-			return ApplicationPackage{Path: filepath.Join(zipOrDir, "src", "main", "application"),
-				TestPath: filepath.Join(zipOrDir, "src", "test", "application")}, nil
+	if util.PathExists(filepath.Join(dir, "src", "main", "application")) {
+		if util.PathExists(filepath.Join(dir, "src", "test", "application")) {
+			return ApplicationPackage{Path: filepath.Join(dir, "src", "main", "application"),
+				TestPath: filepath.Join(dir, "src", "test", "application")}, true, nil
 		}
-		return ApplicationPackage{Path: filepath.Join(zipOrDir, "src", "main", "application")}, nil
+		return ApplicationPackage{Path: filepath.Join(dir, "src", "main", "application")}, true, nil
 	}
-	if util.PathExists(filepath.Join(zipOrDir, "services.xml")) {
-		return ApplicationPackage{Path: zipOrDir}, nil
+	if util.PathExists(filepath.Join(dir, "services.xml")) {
+		return ApplicationPackage{Path: dir}, true, nil
 	}
-	return ApplicationPackage{}, errors.New("Could not find an application package source in '" + zipOrDir + "'")
+	return ApplicationPackage{}, false, nil
+}
+
+// findApplicationPackageFrom finds the application package whose services.xml is in directory dir. If dir is the
+// src/main/application directory of a Maven project, the package is found from the project directory.
+func findApplicationPackageFrom(dir string, requirePackaging bool) (ApplicationPackage, error) {
+	if mainDir := filepath.Dir(dir); filepath.Base(dir) == "application" && filepath.Base(mainDir) == "main" && filepath.Base(filepath.Dir(mainDir)) == "src" {
+		dir = filepath.Dir(filepath.Dir(mainDir))
+	}
+	pkg, _, err := findApplicationPackageIn(dir, requirePackaging)
+	return pkg, err
+}
+
+// findPackagesBelow returns the directories below dir, up to depth levels down, which contain services.xml or a Maven
+// project with an application package. Hidden directories and build output are skipped.
+func findPackagesBelow(dir string, depth int) ([]string, error) {
+	var found []string
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			if path == dir {
+				return err
+			}
+			return nil // Skip unreadable directories
+		}
+		if !info.IsDir() || path == dir {
+			return nil
+		}
+		if name := info.Name(); strings.HasPrefix(name, ".") || name == "target" || name == "node_modules" {
+			return filepath.SkipDir
+		}
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		if util.PathExists(filepath.Join(path, "services.xml")) || util.PathExists(filepath.Join(path, "src", "main", "application")) {
+			found = append(found, path)
+			return filepath.SkipDir // A package does not contain other packages
+		}
+		if strings.Count(rel, string(os.PathSeparator))+1 >= depth {
+			return filepath.SkipDir
+		}
+		return nil
+	})
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	return found, err
+}
+
+// findServicesAbove returns the nearest directory above dir, up to depth levels up, which contains services.xml.
+func findServicesAbove(dir string, depth int) (string, bool) {
+	abs, err := filepath.Abs(dir)
+	if err != nil {
+		return "", false
+	}
+	for i := 0; i < depth; i++ {
+		parent := filepath.Dir(abs)
+		if parent == abs {
+			break
+		}
+		abs = parent
+		if util.PathExists(filepath.Join(abs, "services.xml")) {
+			return abs, true
+		}
+	}
+	return "", false
 }
 
 func ApplicationFromString(s string) (ApplicationID, error) {
@@ -195,63 +615,152 @@ func ZoneFromString(s string) (ZoneID, error) {
 	return ZoneID{Environment: parts[0], Region: parts[1]}, nil
 }
 
-// Prepare deployment and return the session ID
-func Prepare(deployment DeploymentOpts) (int64, error) {
+// Prepare deployment with ctx and return the session ID along with any config change actions required by the deployment
+func Prepare(ctx context.Context, deployment DeploymentOpts) (PrepareResult, error) {
 	if deployment.IsCloud() {
-		return 0, fmt.Errorf("prepare is not supported with %s target", deployment.Target.Type())
+		return PrepareResult{}, fmt.Errorf("prepare is not supported with %s target", deployment.Target.Type())
+	}
+	if err := checkPackageSize(deployment); err != nil {
+		return PrepareResult{}, err
 	}
-	sessionURL, err := deployment.url("/application/v2/tenant/default/session")
+	tenant := deployment.application().Tenant
+	sessionURL, err := deployment.url(ctx, "/application/v2/tenant/"+tenant+"/session")
 	if err != nil {
-		return 0, err
+		return PrepareResult{}, err
 	}
-	sessionID, err := uploadApplicationPackage(sessionURL, deployment)
+	sessionID, err := uploadApplicationPackage(ctx, sessionURL, deployment)
 	if err != nil {
-		return 0, err
+		return PrepareResult{}, err
 	}
-	prepareURL, err := deployment.url(fmt.Sprintf("/application/v2/tenant/default/session/%d/prepared", sessionID))
+	prepareURL, err := deployment.url(ctx, fmt.Sprintf("/application/v2/tenant/%s/session/%d/prepared", tenant, sessionID))
 	if err != nil {
-		return 0, err
+		return PrepareResult{}, err
 	}
-	req, err := http.NewRequest("PUT", prepareURL.String(), nil)
+	req, err := http.NewRequestWithContext(ctx, "PUT", deployment.withParameters(deployment.withApplication(prepareURL)).String(), nil)
 	if err != nil {
-		return 0, err
+		return PrepareResult{}, err
 	}
 	serviceDescription := "Deploy service"
 	response, err := util.HttpDo(req, time.Second*30, serviceDescription)
 	if err != nil {
-		return 0, err
+		return PrepareResult{}, err
 	}
 	defer response.Body.Close()
 	if err := checkResponse(req, response, serviceDescription); err != nil {
-		return 0, err
+		return PrepareResult{}, err
+	}
+	var jsonResponse configChangeActionsResponse
+	jsonDec := json.NewDecoder(response.Body)
+	jsonDec.Decode(&jsonResponse) // Ignore error in case this is a non-JSON response
+	return PrepareResult{
+		SessionID:           sessionID,
+		ConfigChangeActions: jsonResponse.actions(),
+	}, nil
+}
+
+func (r configChangeActionsResponse) actions() []ConfigChangeAction {
+	var actions []ConfigChangeAction
+	add := func(actionType string, responses []configChangeActionResponse) {
+		for _, a := range responses {
+			actions = append(actions, ConfigChangeAction{
+				Type:         actionType,
+				Cluster:      a.ClusterName,
+				DocumentType: a.DocumentType,
+				Messages:     a.Messages,
+			})
+		}
 	}
-	return sessionID, nil
+	add("restart", r.ConfigChangeActions.Restart)
+	add("refeed", r.ConfigChangeActions.Refeed)
+	add("reindex", r.ConfigChangeActions.Reindex)
+	return actions
 }
 
-// Activate deployment with sessionID from a past prepare
-func Activate(sessionID int64, deployment DeploymentOpts) error {
+var (
+	// activateConflictTimeout is the maximum time to spend retrying an activation which conflicts with another
+	// activation in progress.
+	activateConflictTimeout = time.Minute
+	// activateRetryInterval is the initial interval between activation attempts after a conflict. The interval doubles
+	// for each attempt.
+	activateRetryInterval = time.Second
+)
+
+// ErrStaleSession is returned when activating a session which is unknown to the config server, e.g. because the session
+// has expired or the config server has been restarted since the session was prepared.
+var ErrStaleSession = errors.New("stale session")
+
+var staleSessionPattern = regexp.MustCompile(`(?i)session.*(not found|expired|not prepared|unknown)`)
+
+// Activate deployment with sessionID from a past prepare. Activations failing due to a conflict with another activation
+// are retried for a bounded period, or until ctx is done.
+func Activate(ctx context.Context, sessionID int64, deployment DeploymentOpts) error {
 	if deployment.IsCloud() {
 		return fmt.Errorf("activate is not supported with %s target", deployment.Target.Type())
 	}
-	u, err := deployment.url(fmt.Sprintf("/application/v2/tenant/default/session/%d/active", sessionID))
+	u, err := deployment.url(ctx, fmt.Sprintf("/application/v2/tenant/%s/session/%d/active", deployment.application().Tenant, sessionID))
 	if err != nil {
 		return err
 	}
-	req, err := http.NewRequest("PUT", u.String(), nil)
+	req, err := http.NewRequestWithContext(ctx, "PUT", u.String(), nil)
 	if err != nil {
 		return err
 	}
 	serviceDescription := "Deploy service"
-	response, err := util.HttpDo(req, time.Second*30, serviceDescription)
-	if err != nil {
-		return err
+	deadline := time.Now().Add(activateConflictTimeout)
+	interval := activateRetryInterval
+	for {
+		response, err := util.HttpDo(req, time.Second*30, serviceDescription)
+		if err != nil {
+			return err
+		}
+		// A conflict means another activation is in progress. Retry with backoff as long as time permits
+		if response.StatusCode == http.StatusConflict && time.Now().Add(interval).Before(deadline) {
+			response.Body.Close()
+			select {
+			case <-time.After(interval):
+			case <-ctx.Done():
+				return fmt.Errorf("stopped retrying activation: %w", ctx.Err())
+			}
+			interval *= 2
+			continue
+		}
+		defer response.Body.Close()
+		if response.StatusCode/100 == 4 {
+			body, err := ioutil.ReadAll(response.Body)
+			if err != nil {
+				return err
+			}
+			if isStaleSession(response.StatusCode, body) {
+				return fmt.Errorf("session %d is unknown or has expired (%s): %w", sessionID, response.Status, ErrStaleSession)
+			}
+			response.Body = ioutil.NopCloser(bytes.NewReader(body))
+		}
+		return checkResponse(req, response, serviceDescription)
 	}
-	defer response.Body.Close()
-	return checkResponse(req, response, serviceDescription)
 }
 
-func Deploy(opts DeploymentOpts) (int64, error) {
-	path := "/application/v2/tenant/default/prepareandactivate"
+// isStaleSession returns whether an activation failing with status and response body was rejected because its session
+// is unknown to the config server.
+func isStaleSession(status int, body []byte) bool {
+	if status == http.StatusNotFound || status == http.StatusGone {
+		return true
+	}
+	var response struct {
+		ErrorCode string `json:"error-code"`
+		Message   string `json:"message"`
+	}
+	if err := json.Unmarshal(body, &response); err != nil {
+		return false
+	}
+	return response.ErrorCode == "NOT_FOUND" || staleSessionPattern.MatchString(response.Message)
+}
+
+// Deploy uploads the application package of opts to the target of opts, and deploys it. The upload is made with ctx.
+func Deploy(ctx context.Context, opts DeploymentOpts) (int64, error) {
+	if err := checkPackageSize(opts); err != nil {
+		return 0, err
+	}
+	path := "/application/v2/tenant/" + opts.application().Tenant + "/prepareandactivate"
 	if opts.IsCloud() {
 		if err := checkDeploymentOpts(opts); err != nil {
 			return 0, err
@@ -266,11 +775,14 @@ func Deploy(opts DeploymentOpts) (int64, error) {
 			opts.Deployment.Zone.Environment,
 			opts.Deployment.Zone.Region)
 	}
-	u, err := opts.url(path)
+	u, err := opts.url(ctx, path)
 	if err != nil {
 		return 0, err
 	}
-	return uploadApplicationPackage(u, opts)
+	if !opts.IsCloud() {
+		u = opts.withApplication(u)
+	}
+	return uploadApplicationPackage(ctx, opts.withParameters(u), opts)
 }
 
 func copyToPart(dst *multipart.Writer, src io.Reader, fieldname, filename string) error {
@@ -290,39 +802,80 @@ func copyToPart(dst *multipart.Writer, src io.Reader, fieldname, filename string
 	return nil
 }
 
-func Submit(opts DeploymentOpts) error {
+// ValidateLabel returns an error if key or value is not valid for a deployment label. Keys must start with an
+// alphanumeric character, followed by at most 62 alphanumeric characters, '.', '_' or '-'. Values must be non-empty,
+// at most 256 characters long and cannot contain control characters.
+func ValidateLabel(key, value string) error {
+	if !labelKeyPattern.MatchString(key) {
+		return fmt.Errorf("invalid label key: %q", key)
+	}
+	if value == "" || len(value) > 256 {
+		return fmt.Errorf("invalid value for label %s: must be between 1 and 256 characters long", key)
+	}
+	for _, r := range value {
+		if unicode.IsControl(r) {
+			return fmt.Errorf("invalid value for label %s: cannot contain control characters", key)
+		}
+	}
+	return nil
+}
+
+// ValidateDeployParameter returns an error if key or value is not valid for a parameter of a deploy request. Keys must
+// start with a letter, followed by letters, digits, '.', '_' or '-'. Values cannot contain control characters.
+func ValidateDeployParameter(key, value string) error {
+	if !deployParameterPattern.MatchString(key) {
+		return fmt.Errorf("invalid deploy parameter name: %q", key)
+	}
+	for _, r := range value {
+		if unicode.IsControl(r) {
+			return fmt.Errorf("invalid value for deploy parameter %s: cannot contain control characters", key)
+		}
+	}
+	return nil
+}
+
+// Submit submits the application package in opts for production deployment, and returns the build number assigned
+// to it. The build number is 0 if the response does not contain one.
+func Submit(ctx context.Context, opts DeploymentOpts) (int64, error) {
 	if !opts.IsCloud() {
-		return fmt.Errorf("%s: submit is unsupported", opts)
+		return 0, fmt.Errorf("%s: submit is unsupported", opts)
 	}
 	if err := checkDeploymentOpts(opts); err != nil {
-		return err
+		return 0, err
+	}
+	if err := checkPackageSize(opts); err != nil {
+		return 0, err
 	}
 	path := fmt.Sprintf("/application/v4/tenant/%s/application/%s/submit", opts.Deployment.Application.Tenant, opts.Deployment.Application.Application)
-	u, err := opts.url(path)
+	u, err := opts.url(ctx, path)
 	if err != nil {
-		return err
+		return 0, err
 	}
 	var body bytes.Buffer
 	writer := multipart.NewWriter(&body)
-	if err := copyToPart(writer, strings.NewReader("{}"), "submitOptions", ""); err != nil {
-		return err
+	submitOpts, err := json.Marshal(submitOptions{Labels: opts.Labels})
+	if err != nil {
+		return 0, err
+	}
+	if err := copyToPart(writer, bytes.NewReader(submitOpts), "submitOptions", ""); err != nil {
+		return 0, err
 	}
 	applicationZip, err := opts.ApplicationPackage.zipReader(false)
 	if err != nil {
-		return err
+		return 0, err
 	}
 	if err := copyToPart(writer, applicationZip, "applicationZip", "application.zip"); err != nil {
-		return err
+		return 0, err
 	}
 	testApplicationZip, err := opts.ApplicationPackage.zipReader(true)
 	if err != nil {
-		return err
+		return 0, err
 	}
 	if err := copyToPart(writer, testApplicationZip, "applicationTestZip", "application-test.zip"); err != nil {
-		return err
+		return 0, err
 	}
 	if err := writer.Close(); err != nil {
-		return err
+		return 0, err
 	}
 	request := &http.Request{
 		URL:    u,
@@ -334,30 +887,84 @@ func Submit(opts DeploymentOpts) error {
 	serviceDescription := "Submit service"
 	sigKeyId := opts.Deployment.Application.SerializedForm()
 	if err := opts.Target.PrepareApiRequest(request, sigKeyId); err != nil {
-		return err
+		return 0, err
 	}
-	response, err := util.HttpDo(request, time.Minute*10, sigKeyId)
+	response, err := util.HttpDo(request.WithContext(ctx), time.Minute*10, sigKeyId)
 	if err != nil {
-		return err
+		return 0, err
 	}
 	defer response.Body.Close()
-	return checkResponse(request, response, serviceDescription)
+	if err := checkResponse(request, response, serviceDescription); err != nil {
+		return 0, err
+	}
+	var jsonResponse struct {
+		Build int64 `json:"build"`
+	}
+	jsonDec := json.NewDecoder(response.Body)
+	jsonDec.Decode(&jsonResponse) // Ignore error in case this is a non-JSON response
+	return jsonResponse.Build, nil
+}
+
+func checkPackageSize(opts DeploymentOpts) error {
+	maxSize := opts.MaxPackageSize
+	if maxSize == 0 {
+		maxSize = DefaultMaxPackageSize
+	}
+	var size int64
+	if opts.Digest != nil {
+		size = opts.Digest.Size
+	} else {
+		var err error
+		if size, err = opts.ApplicationPackage.Size(); err != nil {
+			return err
+		}
+	}
+	if size <= maxSize {
+		return nil
+	}
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "application package at %s is %s, which exceeds the limit of %s", opts.ApplicationPackage.Name(),
+		FormatSize(size), FormatSize(maxSize))
+	if files, err := opts.ApplicationPackage.LargestFiles(5); err == nil && len(files) > 0 {
+		sb.WriteString("\nConsider removing some of the largest files in the package (uncompressed size):")
+		for _, f := range files {
+			fmt.Fprintf(&sb, "\n%10s  %s", FormatSize(f.Size), f.Path)
+		}
+	}
+	return errors.New(sb.String())
+}
+
+// FormatSize formats size in bytes using binary units, e.g. 1.5 KiB.
+func FormatSize(size int64) string {
+	const unit = 1024
+	if size < unit {
+		return fmt.Sprintf("%d B", size)
+	}
+	div, exp := int64(unit), 0
+	for n := size / unit; n >= unit; n /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(size)/float64(div), "KMGTPE"[exp])
 }
 
 func checkDeploymentOpts(opts DeploymentOpts) error {
 	if !opts.ApplicationPackage.HasCertificate() {
 		return fmt.Errorf("%s: missing certificate in package", opts)
 	}
-	if !Auth0AccessTokenEnabled() {
-		if opts.APIKey == nil {
-			return fmt.Errorf("%s: missing api key", opts.String())
+	for key, value := range opts.Labels {
+		if err := ValidateLabel(key, value); err != nil {
+			return fmt.Errorf("%s: %w", opts, err)
 		}
 	}
 	return nil
 }
 
-func uploadApplicationPackage(url *url.URL, opts DeploymentOpts) (int64, error) {
-	zipReader, err := opts.ApplicationPackage.zipReader(false)
+// uploadApplicationPackage uploads the application package of opts to url. A directory package is zipped while it is
+// uploaded, with ctx. Note that signing the request with an API key reads the entire zip file into memory, as the
+// signature covers the hash of the request body.
+func uploadApplicationPackage(ctx context.Context, url *url.URL, opts DeploymentOpts) (int64, error) {
+	stream, err := newZipStream(opts.ApplicationPackage.Path)
 	if err != nil {
 		return 0, err
 	}
@@ -367,21 +974,27 @@ func uploadApplicationPackage(url *url.URL, opts DeploymentOpts) (int64, error)
 		URL:    url,
 		Method: "POST",
 		Header: header,
-		Body:   ioutil.NopCloser(zipReader),
+		Body:   stream,
 	}
 	serviceDescription := "Deploy service"
 	sigKeyId := opts.Deployment.Application.SerializedForm()
 	if err := opts.Target.PrepareApiRequest(request, sigKeyId); err != nil {
+		if zipErr := stream.Err(); zipErr != nil {
+			return 0, fmt.Errorf("could not zip application package at %s: %w", opts.ApplicationPackage.Path, zipErr)
+		}
 		return 0, err
 	}
 
 	var response *http.Response
 	err = util.Spinner("Uploading application package ...", func() error {
-		response, err = util.HttpDo(request, time.Minute*10, serviceDescription)
+		response, err = util.HttpDo(request.WithContext(ctx), time.Minute*10, serviceDescription)
 		return err
 	})
 	if err != nil {
-		return 0, err
+		if zipErr := stream.Err(); zipErr != nil {
+			return 0, fmt.Errorf("could not zip application package at %s: %w", opts.ApplicationPackage.Path, zipErr)
+		}
+		return 0, transientError{err}
 	}
 	defer response.Body.Close()
 
@@ -402,69 +1015,254 @@ func uploadApplicationPackage(url *url.URL, opts DeploymentOpts) (int64, error)
 }
 
 func checkResponse(req *http.Request, response *http.Response, serviceDescription string) error {
-	if response.StatusCode/100 == 4 {
-		return fmt.Errorf("invalid application package (%s)\n%s", response.Status, extractError(response.Body))
+	if response.StatusCode == 401 {
+		return unauthorizedError{fmt.Errorf("authentication with %s failed (%s)\n%s", strings.ToLower(serviceDescription), response.Status, extractError(response.Body))}
+	} else if response.StatusCode == 403 {
+		return unauthorizedError{fmt.Errorf("not authorized by %s (%s)\n%s", strings.ToLower(serviceDescription), response.Status, extractError(response.Body))}
+	} else if response.StatusCode == 400 {
+		return fmt.Errorf("%w (%s)\n%s", ErrInvalidApplicationPackage, response.Status, extractError(response.Body))
+	} else if response.StatusCode/100 == 4 {
+		err := fmt.Errorf("request to %s at %s failed (%s)\n%s", strings.ToLower(serviceDescription), req.URL.Host, response.Status, extractError(response.Body))
+		if response.StatusCode == 429 {
+			return transientError{err}
+		}
+		return err
 	} else if response.StatusCode != 200 {
-		return fmt.Errorf("error from %s at %s (%s):\n%s", strings.ToLower(serviceDescription), req.URL.Host, response.Status, util.ReaderToJSON(response.Body))
+		err := fmt.Errorf("error from %s at %s (%s):\n%s", strings.ToLower(serviceDescription), req.URL.Host, response.Status, util.ReaderToJSON(response.Body))
+		switch response.StatusCode {
+		case 502, 503, 504:
+			return transientError{err}
+		}
+		return err
 	}
 	return nil
 }
 
 func isZip(filename string) bool { return filepath.Ext(filename) == ".zip" }
 
-func zipDir(dir string, destination string) error {
-	if filepath.IsAbs(dir) {
-		message := "Path must be relative, but '" + dir + "'"
-		return errors.New(message)
+func isTarGz(filename string) bool {
+	return strings.HasSuffix(filename, ".tar.gz") || filepath.Ext(filename) == ".tgz"
+}
+
+// tarGzToZip writes the contents of the tar.gz file at filename to a new temporary zip file, and returns its path. If
+// all files in the archive are in a single top-level directory, that directory is the root of the zip file.
+func tarGzToZip(filename string) (string, error) {
+	var prefix string
+	if err := readTarGz(filename, func(r *tar.Reader) error {
+		var err error
+		prefix, err = tarPrefix(r)
+		return err
+	}); err != nil {
+		return "", err
 	}
-	if !util.PathExists(dir) {
-		message := "'" + dir + "' should be an application package zip or dir, but does not exist"
-		return errors.New(message)
+	tempZip, err := ioutil.TempFile("", "vespa-application-*.zip")
+	if err != nil {
+		return "", fmt.Errorf("could not create a temporary zip file for the application package: %w", err)
 	}
-	if !util.IsDirectory(dir) {
-		message := "'" + dir + "' should be an application package dir, but is a (non-zip) file"
-		return errors.New(message)
+	err = readTarGz(filename, func(r *tar.Reader) error { return tarToZip(r, prefix, tempZip) })
+	if closeErr := tempZip.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(tempZip.Name())
+		return "", err
 	}
+	return tempZip.Name(), nil
+}
 
-	file, err := os.Create(destination)
+// readTarGz calls fn with a reader of the tar.gz file at filename.
+func readTarGz(filename string, fn func(r *tar.Reader) error) error {
+	f, err := os.Open(filename)
 	if err != nil {
-		message := "Could not create a temporary zip file for the application package: " + err.Error()
-		return errors.New(message)
+		return fmt.Errorf("could not open application package at %s: %w", filename, err)
 	}
-	defer file.Close()
+	defer f.Close()
+	r := bufio.NewReader(f)
+	magic, err := r.Peek(2)
+	if err != nil || magic[0] != 0x1f || magic[1] != 0x8b {
+		return fmt.Errorf("application package %s is not a gzip-compressed file", filename)
+	}
+	gz, err := gzip.NewReader(r)
+	if err != nil {
+		return fmt.Errorf("could not read application package at %s: %w", filename, err)
+	}
+	defer gz.Close()
+	if err := fn(tar.NewReader(gz)); err != nil {
+		return fmt.Errorf("could not read application package at %s: %w", filename, err)
+	}
+	return nil
+}
 
-	w := zip.NewWriter(file)
-	defer w.Close()
+// tarEntryName returns the cleaned, slash-separated name of a regular file in a tar archive, and whether header is
+// for such a file.
+func tarEntryName(header *tar.Header) (string, bool, error) {
+	if header.Typeflag != tar.TypeReg && header.Typeflag != tar.TypeRegA {
+		return "", false, nil
+	}
+	name := strings.TrimPrefix(filepath.ToSlash(filepath.Clean(header.Name)), "/")
+	if name == ".." || strings.HasPrefix(name, "../") {
+		return "", false, fmt.Errorf("invalid path in archive: %s", header.Name)
+	}
+	return name, true, nil
+}
+
+// tarPrefix returns the top-level directory, with a trailing slash, holding all files in r, or an empty string if
+// there is no such directory.
+func tarPrefix(r *tar.Reader) (string, error) {
+	prefix := ""
+	for {
+		header, err := r.Next()
+		if err == io.EOF {
+			return prefix, nil
+		}
+		if err != nil {
+			return "", err
+		}
+		name, ok, err := tarEntryName(header)
+		if err != nil {
+			return "", err
+		}
+		if !ok {
+			continue
+		}
+		i := strings.Index(name, "/")
+		if i < 0 || (prefix != "" && prefix != name[:i+1]) {
+			return "", nil
+		}
+		prefix = name[:i+1]
+	}
+}
 
-	walker := func(path string, info os.FileInfo, err error) error {
+func tarToZip(r *tar.Reader, prefix string, w io.Writer) error {
+	zw := zip.NewWriter(w)
+	for {
+		header, err := r.Next()
+		if err == io.EOF {
+			break
+		}
 		if err != nil {
 			return err
 		}
-		if info.IsDir() {
-			return nil
+		name, ok, err := tarEntryName(header)
+		if err != nil {
+			return err
 		}
-		file, err := os.Open(path)
+		if !ok {
+			continue
+		}
+		zf, err := zw.Create(strings.TrimPrefix(name, prefix))
 		if err != nil {
 			return err
 		}
-		defer file.Close()
+		if _, err := io.Copy(zf, r); err != nil {
+			return err
+		}
+	}
+	return zw.Close()
+}
 
-		zippath, err := filepath.Rel(dir, path)
+func zipDir(dir string, destination string) error {
+	if err := checkZipDir(dir); err != nil {
+		return err
+	}
+	file, err := os.Create(destination)
+	if err != nil {
+		message := "Could not create a temporary zip file for the application package: " + err.Error()
+		return errors.New(message)
+	}
+	defer file.Close()
+	return writeZip(dir, file)
+}
+
+func checkZipDir(dir string) error {
+	if filepath.IsAbs(dir) {
+		message := "Path must be relative, but '" + dir + "'"
+		return errors.New(message)
+	}
+	if !util.PathExists(dir) {
+		message := "'" + dir + "' should be an application package zip or dir, but does not exist"
+		return errors.New(message)
+	}
+	if !util.IsDirectory(dir) {
+		message := "'" + dir + "' should be an application package dir, but is a (non-zip) file"
+		return errors.New(message)
+	}
+	return nil
+}
+
+// writeZip writes the files in dir to w as a zip file.
+func writeZip(dir string, w io.Writer) error {
+	zw := zip.NewWriter(w)
+	err := walkPackageDir(dir, func(path, name string, info os.FileInfo) error {
+		file, err := os.Open(path)
 		if err != nil {
 			return err
 		}
-		zipfile, err := w.Create(zippath)
+		defer file.Close()
+		zipfile, err := zw.Create(name)
 		if err != nil {
 			return err
 		}
-
 		_, err = io.Copy(zipfile, file)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	return zw.Close()
+}
+
+// zipStream is a reader of a zipped application package, which is written as it is read.
+type zipStream struct {
+	*io.PipeReader
+	done chan struct{}
+	err  error
+}
+
+// newZipStream starts writing the zip file, or the zipped contents of the directory, at path to a new zipStream.
+func newZipStream(path string) (*zipStream, error) {
+	write, err := zipWriteFunc(path)
+	if err != nil {
+		return nil, err
+	}
+	r, w := io.Pipe()
+	s := &zipStream{PipeReader: r, done: make(chan struct{})}
+	go func() {
+		defer close(s.done)
+		s.err = write(w)
+		w.CloseWithError(s.err)
+	}()
+	return s, nil
+}
+
+// zipWriteFunc returns a function which writes the zip file, or the zipped contents of the directory, at path to a
+// writer. The function must be called exactly once.
+func zipWriteFunc(path string) (func(w io.Writer) error, error) {
+	if isZip(path) {
+		f, err := os.Open(path)
 		if err != nil {
-			return err
+			return nil, fmt.Errorf("could not open application package at %s: %w", path, err)
 		}
-		return nil
+		return func(w io.Writer) error {
+			defer f.Close()
+			_, err := io.Copy(w, f)
+			return err
+		}, nil
+	}
+	if err := checkZipDir(path); err != nil {
+		return nil, err
+	}
+	return func(w io.Writer) error { return writeZip(path, w) }, nil
+}
+
+// Err stops the stream, and returns the error which stopped writing the zip file, if any.
+func (s *zipStream) Err() error {
+	s.Close()
+	<-s.done
+	if errors.Is(s.err, io.ErrClosedPipe) {
+		return nil // Stopped by reader
 	}
-	return filepath.Walk(dir, walker)
+	return s.err
 }
 
 // Returns the error message in the given JSON, or the entire content if it could not be extracted
@@ -2,13 +2,24 @@
 package vespa
 
 import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
 	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
 	"os"
 	"path/filepath"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/vespa-engine/vespa/client/go/util"
 )
 
 func TestApplicationFromString(t *testing.T) {
@@ -53,6 +64,126 @@ func TestFindApplicationPackage(t *testing.T) {
 	})
 }
 
+func TestFindApplicationPackageNested(t *testing.T) {
+	dir := t.TempDir()
+	writeFile(t, filepath.Join(dir, "README.md"))
+	writeFile(t, filepath.Join(dir, "vespa", "app", "services.xml"))
+	writeFile(t, filepath.Join(dir, "vespa", "app", "schemas", "music.sd"))
+	writeFile(t, filepath.Join(dir, ".git", "app", "services.xml"))
+	writeFile(t, filepath.Join(dir, "deep", "a", "b", "c", "services.xml"))
+	pkg, err := FindApplicationPackage(dir, false)
+	assert.Nil(t, err)
+	assert.Equal(t, filepath.Join(dir, "vespa", "app"), pkg.Path)
+
+	// Found from a directory inside the package
+	pkg, err = FindApplicationPackage(filepath.Join(dir, "vespa", "app", "schemas"), false)
+	assert.Nil(t, err)
+	assert.Equal(t, filepath.Join(dir, "vespa", "app"), pkg.Path)
+
+	// Maven project
+	mavenDir := t.TempDir()
+	writeFile(t, filepath.Join(mavenDir, "service", "pom.xml"))
+	writeFile(t, filepath.Join(mavenDir, "service", "src", "main", "application", "services.xml"))
+	writeFile(t, filepath.Join(mavenDir, "service", "target", "application.zip"))
+	pkg, err = FindApplicationPackage(mavenDir, true)
+	assert.Nil(t, err)
+	assert.Equal(t, filepath.Join(mavenDir, "service", "target", "application.zip"), pkg.Path)
+	pkg, err = FindApplicationPackage(filepath.Join(mavenDir, "service", "src", "main", "application"), true)
+	assert.Nil(t, err)
+	assert.Equal(t, filepath.Join(mavenDir, "service", "src", "main", "application"), pkg.Path)
+
+	// Ambiguous
+	writeFile(t, filepath.Join(dir, "other", "services.xml"))
+	_, err = FindApplicationPackage(dir, false)
+	assert.True(t, errors.Is(err, ErrAmbiguousApplicationPackage))
+	assert.EqualError(t, err, "multiple application packages found in '"+dir+"': "+
+		filepath.Join(dir, "other")+", "+filepath.Join(dir, "vespa", "app"))
+
+	pkg, err = ApplicationPackageAt(filepath.Join(dir, "other"))
+	assert.Nil(t, err)
+	assert.Equal(t, filepath.Join(dir, "other"), pkg.Path)
+	_, err = ApplicationPackageAt(filepath.Join(dir, "vespa"))
+	assert.EqualError(t, err, "no services.xml found in package root '"+filepath.Join(dir, "vespa")+"'")
+}
+
+func TestDeclaredVersion(t *testing.T) {
+	dir := t.TempDir()
+	pkg := ApplicationPackage{Path: dir}
+	version, err := pkg.DeclaredVersion()
+	assert.Nil(t, err)
+	assert.Equal(t, "", version)
+
+	assert.Nil(t, ioutil.WriteFile(filepath.Join(dir, "build-meta.json"), []byte(`{"version": "1.2.3", "buildTime": 1632738690}`), 0644))
+	version, err = pkg.DeclaredVersion()
+	assert.Nil(t, err)
+	assert.Equal(t, "1.2.3", version)
+
+	var buf bytes.Buffer
+	assert.Nil(t, writeZip(dir, &buf))
+	zipped := ApplicationPackage{Path: filepath.Join(t.TempDir(), "application.zip")}
+	assert.Nil(t, ioutil.WriteFile(zipped.Path, buf.Bytes(), 0644))
+	version, err = zipped.DeclaredVersion()
+	assert.Nil(t, err)
+	assert.Equal(t, "1.2.3", version)
+
+	assert.Nil(t, ioutil.WriteFile(filepath.Join(dir, "build-meta.json"), []byte(`1.2.3`), 0644))
+	_, err = pkg.DeclaredVersion()
+	assert.NotNil(t, err)
+}
+
+func TestFindApplicationPackageTarGz(t *testing.T) {
+	dir := t.TempDir()
+	tarGz := filepath.Join(dir, "application.tar.gz")
+	writeTarGz(t, tarGz, [][2]string{
+		{"./services.xml", "<services/>"},
+		{"schemas/music.sd", "schema music {}"},
+	})
+	pkg, err := FindApplicationPackage(tarGz, true)
+	assert.Nil(t, err)
+	assert.Equal(t, tarGz, pkg.Source)
+	assert.Equal(t, tarGz, pkg.Name())
+	assert.True(t, pkg.IsZip())
+	files, err := pkg.Files()
+	assert.Nil(t, err)
+	assert.Equal(t, []PackageFile{{Path: "services.xml", Size: 11}, {Path: "schemas/music.sd", Size: 15}}, files)
+	assert.Nil(t, pkg.Close())
+	assert.False(t, util.PathExists(pkg.Path))
+
+	// A single top-level directory is the root of the package
+	nested := filepath.Join(dir, "nested.tar.gz")
+	writeTarGz(t, nested, [][2]string{
+		{"app/services.xml", "<services/>"},
+		{"app/schemas/music.sd", "schema music {}"},
+	})
+	pkg, err = FindApplicationPackage(nested, true)
+	assert.Nil(t, err)
+	defer pkg.Close()
+	files, err = pkg.Files()
+	assert.Nil(t, err)
+	assert.Equal(t, []PackageFile{{Path: "services.xml", Size: 11}, {Path: "schemas/music.sd", Size: 15}}, files)
+
+	tgz := filepath.Join(dir, "application.tgz")
+	assert.Nil(t, ioutil.WriteFile(tgz, []byte("not gzip"), 0644))
+	_, err = FindApplicationPackage(tgz, true)
+	assert.EqualError(t, err, "application package "+tgz+" is not a gzip-compressed file")
+}
+
+// writeTarGz writes a tar.gz file containing the given name and content pairs.
+func writeTarGz(t *testing.T, filename string, files [][2]string) {
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gz)
+	for _, f := range files {
+		name, content := f[0], f[1]
+		assert.Nil(t, tw.WriteHeader(&tar.Header{Name: name, Mode: 0644, Size: int64(len(content)), Typeflag: tar.TypeReg}))
+		_, err := tw.Write([]byte(content))
+		assert.Nil(t, err)
+	}
+	assert.Nil(t, tw.Close())
+	assert.Nil(t, gz.Close())
+	assert.Nil(t, ioutil.WriteFile(filename, buf.Bytes(), 0644))
+}
+
 type pkgFixture struct {
 	expectedPath     string
 	existingFile     string
@@ -81,3 +212,162 @@ func writeFile(t *testing.T, name string) {
 		assert.Nil(t, err)
 	}
 }
+
+func TestDeployFailsOnOversizedPackage(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]int{"services.xml": 100, "models/large.onnx": 20000, "models/small.onnx": 5000}
+	for name, size := range files {
+		path := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		// Random-ish contents which do not compress well
+		data := make([]byte, size)
+		for i := range data {
+			data[i] = byte((i * 7919) ^ (i >> 3))
+		}
+		if err := ioutil.WriteFile(path, data, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	cwd, err := os.Getwd()
+	assert.Nil(t, err)
+	defer os.Chdir(cwd)
+	assert.Nil(t, os.Chdir(dir))
+
+	pkg := ApplicationPackage{Path: "."}
+	size, err := pkg.Size()
+	assert.Nil(t, err)
+	assert.True(t, size > 1024)
+
+	largest, err := pkg.LargestFiles(2)
+	assert.Nil(t, err)
+	assert.Equal(t, []PackageFile{{Path: "models/large.onnx", Size: 20000}, {Path: "models/small.onnx", Size: 5000}}, largest)
+
+	opts := DeploymentOpts{ApplicationPackage: pkg, Target: LocalTarget(), MaxPackageSize: 1024}
+	_, err = Deploy(context.Background(), opts)
+	assert.NotNil(t, err)
+	assert.True(t, strings.HasPrefix(err.Error(), "application package at . is "), err.Error())
+	assert.Contains(t, err.Error(), "which exceeds the limit of 1.0 KiB\n"+
+		"Consider removing some of the largest files in the package (uncompressed size):\n"+
+		"  19.5 KiB  models/large.onnx\n"+
+		"   4.9 KiB  models/small.onnx\n"+
+		"     100 B  services.xml")
+
+	_, err = Prepare(context.Background(), opts)
+	assert.NotNil(t, err)
+}
+
+func TestUploadStreamsApplicationPackage(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"services.xml", "schemas/music.sd", "components/app.jar"} {
+		path := filepath.Join(dir, "app", filepath.FromSlash(name))
+		assert.Nil(t, os.MkdirAll(filepath.Dir(path), 0755))
+		assert.Nil(t, ioutil.WriteFile(path, []byte(strings.Repeat(name, 1000)), 0644))
+	}
+	cwd, err := os.Getwd()
+	assert.Nil(t, err)
+	defer os.Chdir(cwd)
+	assert.Nil(t, os.Chdir(dir))
+
+	var uploaded []byte
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		uploaded, _ = ioutil.ReadAll(r.Body)
+		w.Write([]byte(`{"session-id":"42"}`))
+	}))
+	defer srv.Close()
+	defer func(c util.HttpClient) { util.ActiveHttpClient = c }(util.ActiveHttpClient)
+	util.ActiveHttpClient = util.CreateClient(10 * time.Second)
+
+	opts := DeploymentOpts{ApplicationPackage: ApplicationPackage{Path: "app"}, Target: CustomTarget(srv.URL)}
+	u, err := opts.url(context.Background(), "/application/v2/tenant/default/prepareandactivate")
+	assert.Nil(t, err)
+	sessionID, err := uploadApplicationPackage(context.Background(), u, opts)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(42), sessionID)
+
+	zipFile := filepath.Join(dir, "application.zip")
+	assert.Nil(t, zipDir("app", zipFile))
+	buffered, err := ioutil.ReadFile(zipFile)
+	assert.Nil(t, err)
+	assert.Equal(t, buffered, uploaded)
+	digest, err := opts.ApplicationPackage.Digest()
+	assert.Nil(t, err)
+	hash := sha256.Sum256(buffered)
+	assert.Equal(t, PackageDigest{Hash: hex.EncodeToString(hash[:]), Size: int64(len(buffered))}, digest)
+
+	// Errors while zipping fail the upload
+	assert.Nil(t, os.Symlink(filepath.Join(dir, "missing"), filepath.Join("app", "broken.xml")))
+	_, err = uploadApplicationPackage(context.Background(), u, opts)
+	assert.NotNil(t, err)
+	assert.True(t, strings.HasPrefix(err.Error(), "could not zip application package at app: "), err.Error())
+	assert.False(t, IsTransient(err))
+}
+
+func TestFilesMatchZipContents(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"services.xml", "schemas/music.sd", "components/app.jar", "search/query-profiles/default.xml"} {
+		path := filepath.Join(dir, "app", filepath.FromSlash(name))
+		assert.Nil(t, os.MkdirAll(filepath.Dir(path), 0755))
+		assert.Nil(t, ioutil.WriteFile(path, []byte(name), 0644))
+	}
+	cwd, err := os.Getwd()
+	assert.Nil(t, err)
+	defer os.Chdir(cwd)
+	assert.Nil(t, os.Chdir(dir))
+
+	pkg := ApplicationPackage{Path: "app"}
+	files, err := pkg.Files()
+	assert.Nil(t, err)
+	zipFile := filepath.Join(dir, "application.zip")
+	assert.Nil(t, zipDir("app", zipFile))
+	zipPkg := ApplicationPackage{Path: zipFile}
+	zipFiles, err := zipPkg.Files()
+	assert.Nil(t, err)
+	assert.Equal(t, 4, len(files))
+	assert.Equal(t, zipFiles, files)
+}
+
+func TestActivateRetriesConflict(t *testing.T) {
+	defer func(interval time.Duration) { activateRetryInterval = interval }(activateRetryInterval)
+	defer func(timeout time.Duration) { activateConflictTimeout = timeout }(activateConflictTimeout)
+	activateRetryInterval = 0
+	var statuses []int
+	nextStatuses := []int{409, 409, 200}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/application/v2/tenant/default/session/42/active", r.URL.Path)
+		status := nextStatuses[0]
+		nextStatuses = nextStatuses[1:]
+		statuses = append(statuses, status)
+		w.WriteHeader(status)
+	}))
+	defer srv.Close()
+	defer func(c util.HttpClient) { util.ActiveHttpClient = c }(util.ActiveHttpClient)
+	util.ActiveHttpClient = util.CreateClient(10 * time.Second)
+
+	opts := DeploymentOpts{Target: CustomTarget(srv.URL)}
+	assert.Nil(t, Activate(context.Background(), 42, opts))
+	assert.Equal(t, []int{409, 409, 200}, statuses)
+
+	// Stops retrying when cancelled
+	activateRetryInterval = time.Hour
+	activateConflictTimeout = 2 * time.Hour
+	statuses = nil
+	nextStatuses = []int{409, 200}
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(50*time.Millisecond, cancel)
+	start := time.Now()
+	err := Activate(ctx, 42, opts)
+	assert.True(t, errors.Is(err, context.Canceled), "got %v", err)
+	assert.True(t, time.Since(start) < 10*time.Second, "retrying is cancelled")
+	assert.Equal(t, []int{409}, statuses)
+
+	// Gives up when conflict persists
+	activateRetryInterval = 0
+	activateConflictTimeout = 0
+	statuses = nil
+	nextStatuses = []int{409, 200}
+	err = Activate(context.Background(), 42, opts)
+	assert.NotNil(t, err)
+	assert.Equal(t, []int{409}, statuses)
+}
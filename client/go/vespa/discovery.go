@@ -0,0 +1,56 @@
+// Copyright Yahoo. Licensed under the terms of the Apache 2.0 license. See LICENSE in the project root.
+package vespa
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"strings"
+
+	"github.com/vespa-engine/vespa/client/go/util"
+)
+
+// DiscoveryPath is the path of the discovery document served by a Vespa Cloud control plane.
+const DiscoveryPath = "/.well-known/vespa-cloud.json"
+
+// Discovery describes a Vespa Cloud control plane.
+type Discovery struct {
+	APIURL     string `json:"apiUrl"`
+	ConsoleURL string `json:"consoleUrl"`
+	System     string `json:"system"`
+}
+
+// Discover reads the discovery document of the control plane at baseURL.
+func Discover(baseURL string) (Discovery, error) {
+	response, err := util.HttpGet(strings.TrimSuffix(baseURL, "/"), DiscoveryPath, "Control plane")
+	if err != nil {
+		return Discovery{}, err
+	}
+	defer response.Body.Close()
+	if response.StatusCode != 200 {
+		return Discovery{}, fmt.Errorf("could not read discovery document from %s: status %d", baseURL, response.StatusCode)
+	}
+	data, err := ioutil.ReadAll(response.Body)
+	if err != nil {
+		return Discovery{}, err
+	}
+	return ParseDiscovery(data)
+}
+
+// ParseDiscovery parses and validates the discovery document in data.
+func ParseDiscovery(data []byte) (Discovery, error) {
+	var d Discovery
+	if err := json.Unmarshal(data, &d); err != nil {
+		return Discovery{}, fmt.Errorf("invalid discovery document: %w", err)
+	}
+	if d.APIURL == "" {
+		return Discovery{}, fmt.Errorf("invalid discovery document: missing apiUrl")
+	}
+	if d.ConsoleURL == "" {
+		return Discovery{}, fmt.Errorf("invalid discovery document: missing consoleUrl")
+	}
+	if d.System == "" {
+		return Discovery{}, fmt.Errorf("invalid discovery document: missing system")
+	}
+	return d, nil
+}
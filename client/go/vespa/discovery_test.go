@@ -0,0 +1,37 @@
+// Copyright Yahoo. Licensed under the terms of the Apache 2.0 license. See LICENSE in the project root.
+package vespa
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/vespa-engine/vespa/client/go/util"
+)
+
+func TestDiscover(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != DiscoveryPath {
+			w.WriteHeader(404)
+			return
+		}
+		w.Write([]byte(`{"apiUrl": "https://api.cp.internal:4443", "consoleUrl": "https://console.cp.internal", "system": "main"}`))
+	}))
+	defer srv.Close()
+	defer func(c util.HttpClient) { util.ActiveHttpClient = c }(util.ActiveHttpClient)
+	util.ActiveHttpClient = util.CreateClient(10 * time.Second)
+
+	d, err := Discover(srv.URL + "/")
+	assert.Nil(t, err)
+	assert.Equal(t, Discovery{APIURL: "https://api.cp.internal:4443", ConsoleURL: "https://console.cp.internal", System: "main"}, d)
+
+	_, err = Discover(srv.URL + "/other")
+	assert.NotNil(t, err)
+
+	_, err = ParseDiscovery([]byte(`{"apiUrl": "https://api.cp.internal:4443", "system": "main"}`))
+	assert.Equal(t, "invalid discovery document: missing consoleUrl", err.Error())
+	_, err = ParseDiscovery([]byte(`not json`))
+	assert.NotNil(t, err)
+}
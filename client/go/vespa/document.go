@@ -6,12 +6,18 @@ package vespa
 
 import (
 	"bytes"
+	"compress/gzip"
+	"context"
 	"encoding/json"
+	"errors"
 	"io"
 	"io/ioutil"
+	"net"
 	"net/http"
 	"net/url"
 	"os"
+	"sort"
+	"strconv"
 	"time"
 
 	"github.com/vespa-engine/vespa/client/go/curl"
@@ -49,6 +55,38 @@ const (
 type OperationOptions struct {
 	CurlOutput io.Writer
 	Timeout    time.Duration
+	// Compress enables gzip compression of request bodies larger than compressionThreshold.
+	Compress bool
+	// Retries is the number of times to retry an operation which failed to connect, or got a 502 or 503 response.
+	Retries int
+	// RetryInterval is the time to wait before retrying an operation.
+	RetryInterval time.Duration
+	// Context is the context of requests. Retrying stops when it is done. Requests are not cancelled if this is nil.
+	Context context.Context
+}
+
+// context returns the context of requests made with these options.
+func (o OperationOptions) context() context.Context {
+	if o.Context == nil {
+		return context.Background()
+	}
+	return o.Context
+}
+
+// compressionThreshold is the minimum size, in bytes, of request bodies compressed when compression is enabled.
+const compressionThreshold = 1024
+
+// compressBody returns data compressed with gzip.
+func compressBody(data []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	w := gzip.NewWriter(&buf)
+	if _, err := w.Write(data); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
 }
 
 func sendOperation(documentId string, jsonFile string, service *Service, operation string, options OperationOptions) util.OperationResult {
@@ -97,13 +135,41 @@ func sendOperation(documentId string, jsonFile string, service *Service, operati
 		return util.Failure("Invalid request path: '" + service.BaseURL + "/document/v1/" + documentPath + "': " + urlParseError.Error())
 	}
 
-	request := &http.Request{
-		URL:    url,
-		Method: operationToHTTPMethod(operation),
-		Header: header,
-		Body:   ioutil.NopCloser(bytes.NewReader(documentData)),
+	body := documentData
+	if options.Compress && len(documentData) > compressionThreshold {
+		compressed, err := compressBody(documentData)
+		if err != nil {
+			return util.FailureWithDetail("Failed to compress '"+jsonFile+"'", err.Error())
+		}
+		body = compressed
+		header.Add("Content-Encoding", "gzip")
+	}
+	var (
+		request  *http.Request
+		response *http.Response
+		err      error
+	)
+	ctx := options.context()
+	for attempt := 0; ; attempt++ {
+		request = &http.Request{
+			URL:           url,
+			Method:        operationToHTTPMethod(operation),
+			Header:        header,
+			Body:          ioutil.NopCloser(bytes.NewReader(body)),
+			ContentLength: int64(len(body)),
+		}
+		response, err = serviceDo(service, request, jsonFile, options)
+		if !isRetryable(response, err) || attempt >= options.Retries || ctx.Err() != nil {
+			break
+		}
+		if response != nil {
+			response.Body.Close()
+		}
+		select {
+		case <-time.After(options.RetryInterval):
+		case <-ctx.Done():
+		}
 	}
-	response, err := serviceDo(service, request, jsonFile, options)
 	if response == nil {
 		return util.Failure("Request failed: " + err.Error())
 	}
@@ -118,6 +184,16 @@ func sendOperation(documentId string, jsonFile string, service *Service, operati
 	}
 }
 
+// isRetryable returns whether an operation which got response, or failed with err, was certainly not applied, and can
+// be sent again. This is the case if no connection was made, or the service was unavailable.
+func isRetryable(response *http.Response, err error) bool {
+	if response != nil {
+		return response.StatusCode == http.StatusBadGateway || response.StatusCode == http.StatusServiceUnavailable
+	}
+	var opErr *net.OpError
+	return errors.As(err, &opErr) && opErr.Op == "dial"
+}
+
 func operationIn(doc map[string]interface{}) string {
 	if doc["put"] != nil {
 		return "put"
@@ -148,8 +224,16 @@ func serviceDo(service *Service, request *http.Request, filename string, options
 		return nil, err
 	}
 	cmd.Method = request.Method
-	for k, vs := range request.Header {
-		for _, v := range vs {
+	keys := make([]string, 0, len(request.Header))
+	for k := range request.Header {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys) // Stable order of headers in the printed command
+	for _, k := range keys {
+		if k == "Content-Encoding" {
+			continue // The equivalent curl command sends the uncompressed file
+		}
+		for _, v := range request.Header[k] {
 			cmd.Header(k, v)
 		}
 	}
@@ -160,7 +244,62 @@ func serviceDo(service *Service, request *http.Request, filename string, options
 	if _, err := io.WriteString(options.CurlOutput, out); err != nil {
 		return nil, err
 	}
-	return service.Do(request, options.Timeout)
+	return service.Do(request.WithContext(options.context()), options.Timeout)
+}
+
+// RemoveWhere removes all documents matching the given document selection from cluster. Cluster may be empty if the
+// application has a single content cluster. The document API removes
+// documents in chunks, and requests are repeated until it stops returning a continuation token.
+func RemoveWhere(selection string, cluster string, service *Service, options OperationOptions) util.OperationResult {
+	removed := 0
+	continuation := ""
+	for {
+		u, err := url.Parse(service.BaseURL + "/document/v1/")
+		if err != nil {
+			return util.Failure("Invalid request path: '" + service.BaseURL + "/document/v1/': " + err.Error())
+		}
+		q := u.Query()
+		q.Set("selection", selection)
+		if cluster != "" {
+			q.Set("cluster", cluster)
+		}
+		if continuation != "" {
+			q.Set("continuation", continuation)
+		}
+		u.RawQuery = q.Encode()
+		request := &http.Request{
+			URL:    u,
+			Method: "DELETE",
+		}
+		response, err := serviceDo(service, request, "", options)
+		if response == nil {
+			return util.Failure("Request failed: " + err.Error())
+		}
+		if response.StatusCode != 200 {
+			defer response.Body.Close()
+			if response.StatusCode/100 == 4 {
+				return util.FailureWithPayload("Invalid document operation: "+response.Status, util.ReaderToJSON(response.Body))
+			}
+			return util.FailureWithPayload(service.Description()+" at "+request.URL.Host+": "+response.Status, util.ReaderToJSON(response.Body))
+		}
+		var result removeWhereResponse
+		err = json.NewDecoder(response.Body).Decode(&result)
+		response.Body.Close()
+		if err != nil {
+			return util.FailureWithDetail("Invalid response from "+service.Description(), err.Error())
+		}
+		removed += result.DocumentCount
+		if result.Continuation == "" {
+			break
+		}
+		continuation = result.Continuation
+	}
+	return util.Success("Removed " + strconv.Itoa(removed) + " documents matching '" + selection + "'")
+}
+
+type removeWhereResponse struct {
+	DocumentCount int    `json:"documentCount"`
+	Continuation  string `json:"continuation"`
 }
 
 func Get(documentId string, service *Service, options OperationOptions) util.OperationResult {
@@ -0,0 +1,121 @@
+// Copyright Yahoo. Licensed under the terms of the Apache 2.0 license. See LICENSE in the project root.
+package vespa
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/vespa-engine/vespa/client/go/vespa/xml"
+)
+
+// rootFiles are the files which must be placed in the root of an application package.
+var rootFiles = []string{"services.xml", "hosts.xml", "deployment.xml", "validation-overrides.xml"}
+
+// Severity is the severity of a problem found in an application package.
+type Severity string
+
+const (
+	// SeverityError is the severity of problems which cause the application package to be deployed incorrectly.
+	SeverityError Severity = "error"
+	// SeverityWarning is the severity of problems which should be fixed, but do not yet cause any harm.
+	SeverityWarning Severity = "warning"
+)
+
+// Problem is a problem with the layout of an application package.
+type Problem struct {
+	Severity Severity `json:"severity"`
+	// Path is the path of the file or directory having the problem, relative to the root of the application package.
+	Path string `json:"file"`
+	// Line is the line in the file where the problem occurs, or 0 if the problem is with the file as a whole.
+	Line int `json:"line,omitempty"`
+	// Rule identifies the check which found the problem.
+	Rule    string `json:"rule"`
+	Message string `json:"message"`
+}
+
+func (p Problem) String() string {
+	if p.Line > 0 {
+		return fmt.Sprintf("%s:%d: %s", p.Path, p.Line, p.Message)
+	}
+	return fmt.Sprintf("%s: %s", p.Path, p.Message)
+}
+
+// Lint checks the directory layout of this application package against the expected conventions, and returns the
+// problems found, sorted by path.
+func (ap *ApplicationPackage) Lint() ([]Problem, error) {
+	files, err := ap.Files()
+	if err != nil {
+		return nil, err
+	}
+	var problems []Problem
+	hasServices := false
+	for _, f := range files {
+		dir, name := path.Split(f.Path)
+		dir = strings.TrimSuffix(dir, "/")
+		topDir := strings.SplitN(f.Path, "/", 2)[0]
+		switch {
+		case f.Path == "services.xml":
+			hasServices = true
+		case isRootFile(name) && dir != "":
+			problems = append(problems, Problem{Severity: SeverityError, Path: f.Path, Rule: "root-file-location",
+				Message: name + " must be placed in the root of the application package"})
+		case path.Ext(name) == ".sd" && dir == "searchdefinitions":
+			problems = append(problems, Problem{Severity: SeverityWarning, Path: f.Path, Rule: "deprecated-searchdefinitions",
+				Message: "the searchdefinitions directory is deprecated, move schema to schemas/"})
+		case path.Ext(name) == ".sd" && dir != "schemas":
+			problems = append(problems, Problem{Severity: SeverityError, Path: f.Path, Rule: "schema-location",
+				Message: "schema will not be found, move it to schemas/"})
+		case path.Ext(name) == ".jar" && topDir != "components":
+			problems = append(problems, Problem{Severity: SeverityError, Path: f.Path, Rule: "component-location",
+				Message: "component jar will not be found, move it to components/"})
+		}
+	}
+	if !hasServices {
+		problems = append(problems, Problem{Severity: SeverityError, Path: "services.xml", Rule: "missing-services",
+			Message: "missing services.xml in the root of the application package"})
+	}
+	deploymentProblems, err := ap.lintDeployment()
+	if err != nil {
+		return nil, err
+	}
+	problems = append(problems, deploymentProblems...)
+	sort.SliceStable(problems, func(i, j int) bool { return problems[i].Path < problems[j].Path })
+	return problems, nil
+}
+
+// lintDeployment returns the problems found in deployment.xml of this application package, which currently are invalid
+// instance names.
+func (ap *ApplicationPackage) lintDeployment() ([]Problem, error) {
+	data, err := ap.readFile("deployment.xml")
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	deployment, err := xml.ReadDeployment(bytes.NewReader(data))
+	if err != nil {
+		return nil, nil // Malformed XML is reported when deploying
+	}
+	var problems []Problem
+	for _, instance := range deployment.Instance {
+		if err := xml.ValidateInstanceName(instance.ID); err != nil {
+			problems = append(problems, Problem{Severity: SeverityError, Path: "deployment.xml", Rule: "instance-name",
+				Message: err.Error()})
+		}
+	}
+	return problems, nil
+}
+
+func isRootFile(name string) bool {
+	for _, f := range rootFiles {
+		if name == f {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,89 @@
+// Copyright Yahoo. Licensed under the terms of the Apache 2.0 license. See LICENSE in the project root.
+package vespa
+
+import (
+	"archive/zip"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLintWellFormed(t *testing.T) {
+	pkg := createLintPackage(t, "services.xml", "deployment.xml", "schemas/music.sd", "components/app.jar", "search/query-profiles/default.xml")
+	problems, err := pkg.Lint()
+	assert.Nil(t, err)
+	assert.Empty(t, problems)
+}
+
+func TestLintMalformed(t *testing.T) {
+	assertLint(t, []string{"services.xml: missing services.xml in the root of the application package"},
+		"schemas/music.sd")
+	assertLint(t, []string{
+		"conf/services.xml: services.xml must be placed in the root of the application package",
+		"services.xml: missing services.xml in the root of the application package",
+	}, "conf/services.xml")
+	assertLint(t, []string{
+		"music.sd: schema will not be found, move it to schemas/",
+		"schema/lyrics.sd: schema will not be found, move it to schemas/",
+	}, "services.xml", "music.sd", "schema/lyrics.sd")
+	assertLint(t, []string{"searchdefinitions/music.sd: the searchdefinitions directory is deprecated, move schema to schemas/"},
+		"services.xml", "searchdefinitions/music.sd")
+	assertLint(t, []string{
+		"app.jar: component jar will not be found, move it to components/",
+		"lib/dep.jar: component jar will not be found, move it to components/",
+	}, "services.xml", "schemas/music.sd", "app.jar", "lib/dep.jar", "components/ok.jar", "components/lib/ok.jar")
+	assertLint(t, []string{"config/deployment.xml: deployment.xml must be placed in the root of the application package"},
+		"services.xml", "config/deployment.xml")
+}
+
+func TestLintInstanceNames(t *testing.T) {
+	pkg := createLintPackage(t, "services.xml")
+	deploymentXML := `<deployment version="1.0"><instance id="default"/><instance id="Beta"/></deployment>`
+	assert.Nil(t, ioutil.WriteFile(filepath.Join(pkg.Path, "deployment.xml"), []byte(deploymentXML), 0644))
+	problems, err := pkg.Lint()
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(problems))
+	assert.Equal(t, "instance-name", problems[0].Rule)
+	assert.Contains(t, problems[0].Message, `invalid instance name "Beta"`)
+}
+
+func TestLintZip(t *testing.T) {
+	zipFile := filepath.Join(t.TempDir(), "application.zip")
+	f, err := os.Create(zipFile)
+	assert.Nil(t, err)
+	w := zip.NewWriter(f)
+	for _, name := range []string{"services.xml", "music.sd"} {
+		_, err := w.Create(name)
+		assert.Nil(t, err)
+	}
+	assert.Nil(t, w.Close())
+	assert.Nil(t, f.Close())
+	pkg := ApplicationPackage{Path: zipFile}
+	problems, err := pkg.Lint()
+	assert.Nil(t, err)
+	assert.Equal(t, []Problem{{Severity: SeverityError, Path: "music.sd", Rule: "schema-location", Message: "schema will not be found, move it to schemas/"}}, problems)
+}
+
+func assertLint(t *testing.T, expected []string, files ...string) {
+	pkg := createLintPackage(t, files...)
+	problems, err := pkg.Lint()
+	assert.Nil(t, err)
+	var actual []string
+	for _, p := range problems {
+		actual = append(actual, p.String())
+	}
+	assert.Equal(t, expected, actual)
+}
+
+func createLintPackage(t *testing.T, files ...string) ApplicationPackage {
+	dir := t.TempDir()
+	for _, f := range files {
+		path := filepath.Join(dir, filepath.FromSlash(f))
+		assert.Nil(t, os.MkdirAll(filepath.Dir(path), 0755))
+		assert.Nil(t, ioutil.WriteFile(path, []byte("content"), 0644))
+	}
+	return ApplicationPackage{Path: dir}
+}
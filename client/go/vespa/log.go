@@ -3,8 +3,11 @@ package vespa
 
 import (
 	"bufio"
+	"encoding/json"
 	"fmt"
 	"io"
+	"path"
+	"regexp"
 	"strconv"
 	"strings"
 	"time"
@@ -12,6 +15,61 @@ import (
 
 var dequoter = strings.NewReplacer("\\n", "\n", "\\t", "\t")
 
+var configGenerationPattern = regexp.MustCompile(`Application config generation: (\d+)`)
+
+// LogFormat is a format for printing log entries.
+type LogFormat int
+
+const (
+	// FormatPlain prints each log entry as a human-readable line.
+	FormatPlain LogFormat = iota
+	// FormatOTel prints each log entry as an OpenTelemetry log record in JSON.
+	FormatOTel
+	// FormatJSON prints each log entry as a JSON object on a single line.
+	FormatJSON
+)
+
+// ParseLogFormat parses the named log format.
+func ParseLogFormat(name string) (LogFormat, error) {
+	switch name {
+	case "plain":
+		return FormatPlain, nil
+	case "otel":
+		return FormatOTel, nil
+	case "json":
+		return FormatJSON, nil
+	}
+	return FormatPlain, fmt.Errorf("invalid log format: %q", name)
+}
+
+// otelLogRecord is a log record in the OTLP JSON encoding of OpenTelemetry.
+type otelLogRecord struct {
+	TimeUnixNano   string          `json:"timeUnixNano"`
+	SeverityNumber int             `json:"severityNumber"`
+	SeverityText   string          `json:"severityText"`
+	Body           otelAnyValue    `json:"body"`
+	Attributes     []otelAttribute `json:"attributes"`
+}
+
+type otelAnyValue struct {
+	StringValue string `json:"stringValue"`
+}
+
+type otelAttribute struct {
+	Key   string       `json:"key"`
+	Value otelAnyValue `json:"value"`
+}
+
+// jsonLogEntry is a log entry in the JSON format. Fields which are empty in the source are omitted.
+type jsonLogEntry struct {
+	Time      string `json:"time"`
+	Host      string `json:"host,omitempty"`
+	Service   string `json:"service,omitempty"`
+	Component string `json:"component,omitempty"`
+	Level     string `json:"level,omitempty"`
+	Message   string `json:"message"`
+}
+
 // LogEntry represents a Vespa log entry.
 type LogEntry struct {
 	Time      time.Time
@@ -31,11 +89,130 @@ func (le *LogEntry) Format(dequote bool) string {
 	return fmt.Sprintf("[%s] %-8s %-7s %-16s %s\t%s", t, le.Host, le.Level, le.Service, le.Component, msg)
 }
 
+// FormatOTel returns this entry as an OpenTelemetry log record in JSON.
+func (le *LogEntry) FormatOTel(dequote bool) (string, error) {
+	msg := le.Message
+	if dequote {
+		msg = dequoter.Replace(msg)
+	}
+	record := otelLogRecord{
+		TimeUnixNano:   strconv.FormatInt(le.Time.UnixNano(), 10),
+		SeverityNumber: OTelSeverity(le.Level),
+		SeverityText:   le.Level,
+		Body:           otelAnyValue{StringValue: msg},
+		Attributes: []otelAttribute{
+			{Key: "host.name", Value: otelAnyValue{StringValue: le.Host}},
+			{Key: "service.name", Value: otelAnyValue{StringValue: le.Service}},
+			{Key: "vespa.component", Value: otelAnyValue{StringValue: le.Component}},
+		},
+	}
+	b, err := json.Marshal(record)
+	if err != nil {
+		return "", err
+	}
+	return string(b), nil
+}
+
+// FormatJSON returns this entry as a JSON object on a single line, with its time in RFC 3339 format.
+func (le *LogEntry) FormatJSON(dequote bool) (string, error) {
+	msg := le.Message
+	if dequote {
+		msg = dequoter.Replace(msg)
+	}
+	b, err := json.Marshal(jsonLogEntry{
+		Time:      le.Time.UTC().Format(time.RFC3339Nano),
+		Host:      le.Host,
+		Service:   le.Service,
+		Component: le.Component,
+		Level:     le.Level,
+		Message:   msg,
+	})
+	if err != nil {
+		return "", err
+	}
+	return string(b), nil
+}
+
+// OTelSeverity returns the OpenTelemetry severity number of a named Vespa log level. Levels between info and debug
+// are mapped to the most severe debug levels.
+func OTelSeverity(level string) int {
+	switch level {
+	case "fatal":
+		return 21 // FATAL
+	case "error":
+		return 17 // ERROR
+	case "warning":
+		return 13 // WARN
+	case "info":
+		return 9 // INFO
+	case "config":
+		return 8 // DEBUG4
+	case "event":
+		return 7 // DEBUG3
+	case "debug":
+		return 5 // DEBUG
+	case "spam":
+		return 1 // TRACE
+	}
+	return 0 // UNSPECIFIED
+}
+
+// MatchesComponent returns whether the component of this entry matches pattern. The pattern is matched as a glob if it
+// contains any glob metacharacters, otherwise it's matched as a substring. An empty pattern matches any component.
+func (le *LogEntry) MatchesComponent(pattern string) bool {
+	if pattern == "" {
+		return true
+	}
+	if strings.ContainsAny(pattern, "*?[") {
+		matched, err := path.Match(pattern, le.Component)
+		return err == nil && matched
+	}
+	return strings.Contains(le.Component, pattern)
+}
+
+// MatchesHost returns whether the host of this entry contains hostname, ignoring case. An empty hostname matches any
+// host.
+func (le *LogEntry) MatchesHost(hostname string) bool {
+	return strings.Contains(strings.ToLower(le.Host), strings.ToLower(hostname))
+}
+
+// ConfigGeneration returns the application config generation switched to, if this entry is logged when switching to a
+// new config generation.
+func (le *LogEntry) ConfigGeneration() (int64, bool) {
+	match := configGenerationPattern.FindStringSubmatch(le.Message)
+	if match == nil {
+		return 0, false
+	}
+	generation, err := strconv.ParseInt(match[1], 10, 64)
+	if err != nil {
+		return 0, false
+	}
+	return generation, true
+}
+
+// FormatGeneration returns a tag for the config generation a log entry belongs to, where generation is 0 if unknown.
+func FormatGeneration(generation int64) string {
+	if generation == 0 {
+		return "[generation -]"
+	}
+	return fmt.Sprintf("[generation %d]", generation)
+}
+
 // ParseLogEntry parses a Vespa log entry from string s.
-func ParseLogEntry(s string) (LogEntry, error) {
+func ParseLogEntry(s string) (LogEntry, error) { return parseLogEntry(s, false) }
+
+// parseLogEntry parses a Vespa log entry from string s. If lenient, an entry with fewer fields than expected is
+// accepted if it has a time: its last field is the message, and the fields before it are taken in order, leaving the
+// missing ones empty.
+func parseLogEntry(s string, lenient bool) (LogEntry, error) {
 	parts := strings.SplitN(s, "\t", 7)
 	if len(parts) != 7 {
-		return LogEntry{}, fmt.Errorf("invalid number of log parts: %d: %q", len(parts), s)
+		if !lenient || len(parts) < 2 {
+			return LogEntry{}, fmt.Errorf("invalid number of log parts: %d: %q", len(parts), s)
+		}
+		message := parts[len(parts)-1]
+		parts = append(parts[:len(parts)-1], make([]string, 8-len(parts))...)
+		parts[6] = message
 	}
 	time, err := parseLogTimestamp(parts[0])
 	if err != nil {
@@ -52,12 +229,15 @@ func ParseLogEntry(s string) (LogEntry, error) {
 }
 
 // ReadLogEntries reads and parses all log entries from reader r.
-func ReadLogEntries(r io.Reader) ([]LogEntry, error) {
+func ReadLogEntries(r io.Reader) ([]LogEntry, error) { return readLogEntries(r, false) }
+
+// readLogEntries reads and parses all log entries from reader r, leniently if lenient is true. See parseLogEntry.
+func readLogEntries(r io.Reader, lenient bool) ([]LogEntry, error) {
 	var entries []LogEntry
 	scanner := bufio.NewScanner(r)
 	for scanner.Scan() {
 		line := scanner.Text()
-		logEntry, err := ParseLogEntry(line)
+		logEntry, err := parseLogEntry(line, lenient)
 		if err != nil {
 			return nil, err
 		}
@@ -69,6 +249,32 @@ func ReadLogEntries(r io.Reader) ([]LogEntry, error) {
 	return entries, nil
 }
 
+// logTail holds the last lines added to it, up to a maximum number.
+type logTail struct {
+	lines   []string
+	start   int  // Index of the earliest line, once lines is full
+	dropped bool // Whether any line has been dropped to make room for a later one
+}
+
+func newLogTail(max int) *logTail { return &logTail{lines: make([]string, 0, max)} }
+
+func (t *logTail) add(line string) {
+	if len(t.lines) < cap(t.lines) {
+		t.lines = append(t.lines, line)
+		return
+	}
+	t.lines[t.start] = line
+	t.start = (t.start + 1) % len(t.lines)
+	t.dropped = true
+}
+
+// writeTo writes the lines held, from the earliest, to w.
+func (t *logTail) writeTo(w io.Writer) {
+	for i := range t.lines {
+		fmt.Fprintln(w, t.lines[(t.start+i)%len(t.lines)])
+	}
+}
+
 // LogLevel returns an int representing a named log level.
 func LogLevel(name string) int {
 	switch name {
@@ -2,6 +2,7 @@
 package vespa
 
 import (
+	"strings"
 	"testing"
 	"time"
 
@@ -30,3 +31,117 @@ func TestParseLogEntry(t *testing.T) {
 	assert.Nil(t, err)
 	assert.Equal(t, "[2021-09-27 10:31:30.905535] host1a.dev.aws-us-east-1c info    logserver-container Container.com.yahoo.container.jdisc.ConfiguredApplication\tmessage containing newline\nand\ttab", logEntry.Format(true))
 }
+
+func TestLogEntryMatchesComponent(t *testing.T) {
+	logEntry := LogEntry{Component: "Container.com.yahoo.container.jdisc.ConfiguredApplication"}
+	assert.True(t, logEntry.MatchesComponent(""))
+	assert.True(t, logEntry.MatchesComponent("jdisc"))
+	assert.True(t, logEntry.MatchesComponent("Container.com.yahoo.*"))
+	assert.True(t, logEntry.MatchesComponent("*.ConfiguredApplication"))
+	assert.False(t, logEntry.MatchesComponent("sentinel"))
+	assert.False(t, logEntry.MatchesComponent("sentinel.*"))
+	assert.False(t, logEntry.MatchesComponent("[invalid"))
+}
+
+func TestLogEntryConfigGeneration(t *testing.T) {
+	logEntry := LogEntry{Message: "Switching to the latest deployed set of configurations and components. Application config generation: 52532"}
+	generation, ok := logEntry.ConfigGeneration()
+	assert.True(t, ok)
+	assert.Equal(t, int64(52532), generation)
+
+	logEntry = LogEntry{Message: "Some other message"}
+	_, ok = logEntry.ConfigGeneration()
+	assert.False(t, ok)
+
+	assert.Equal(t, "[generation 52532]", FormatGeneration(52532))
+	assert.Equal(t, "[generation -]", FormatGeneration(0))
+}
+
+func TestLogEntryFormatOTel(t *testing.T) {
+	in := "1632738690.905535	host1a.dev.aws-us-east-1c	806/53	logserver-container	Container.com.yahoo.container.jdisc.ConfiguredApplication	warning	message containing newline\\nand\\ttab"
+	logEntry, err := ParseLogEntry(in)
+	assert.Nil(t, err)
+	record, err := logEntry.FormatOTel(true)
+	assert.Nil(t, err)
+	expected := `{
+  "timeUnixNano": "1632738690905535000",
+  "severityNumber": 13,
+  "severityText": "warning",
+  "body": {"stringValue": "message containing newline\nand\ttab"},
+  "attributes": [
+    {"key": "host.name", "value": {"stringValue": "host1a.dev.aws-us-east-1c"}},
+    {"key": "service.name", "value": {"stringValue": "logserver-container"}},
+    {"key": "vespa.component", "value": {"stringValue": "Container.com.yahoo.container.jdisc.ConfiguredApplication"}}
+  ]
+}`
+	assert.JSONEq(t, expected, record)
+	assert.NotContains(t, record, "\n")
+
+	format, err := ParseLogFormat("otel")
+	assert.Nil(t, err)
+	assert.Equal(t, FormatOTel, format)
+	_, err = ParseLogFormat("xml")
+	assert.NotNil(t, err)
+}
+
+func TestLogEntryFormatJSON(t *testing.T) {
+	in := "1632738690.905535	host1a.dev.aws-us-east-1c	806/53	logserver-container	Container.com.yahoo.container.jdisc.ConfiguredApplication	warning	message containing newline\\nand\\ttab"
+	logEntry, err := ParseLogEntry(in)
+	assert.Nil(t, err)
+	entry, err := logEntry.FormatJSON(true)
+	assert.Nil(t, err)
+	assert.JSONEq(t, `{
+  "time": "2021-09-27T10:31:30.905535Z",
+  "host": "host1a.dev.aws-us-east-1c",
+  "service": "logserver-container",
+  "component": "Container.com.yahoo.container.jdisc.ConfiguredApplication",
+  "level": "warning",
+  "message": "message containing newline\nand\ttab"
+}`, entry)
+	assert.NotContains(t, entry, "\n")
+
+	// Empty fields are omitted
+	logEntry, err = ParseLogEntry("1632738690.000000\t\t806/53\t\t\tinfo\tmessage")
+	assert.Nil(t, err)
+	entry, err = logEntry.FormatJSON(false)
+	assert.Nil(t, err)
+	assert.Equal(t, `{"time":"2021-09-27T10:31:30Z","level":"info","message":"message"}`, entry)
+
+	// Entries missing fields are read leniently
+	entries, err := readLogEntries(strings.NewReader("1632738690.000000\thost1\t806/53\tcontainer\tshort message\n"+
+		"1632738691.000000\tonly a message\n"), true)
+	assert.Nil(t, err)
+	assert.Equal(t, []LogEntry{
+		{Time: time.Date(2021, 9, 27, 10, 31, 30, 0, time.UTC), Host: "host1", Service: "container", Message: "short message"},
+		{Time: time.Date(2021, 9, 27, 10, 31, 31, 0, time.UTC), Message: "only a message"},
+	}, entries)
+	_, err = ReadLogEntries(strings.NewReader("1632738691.000000\tonly a message\n"))
+	assert.NotNil(t, err)
+	_, err = readLogEntries(strings.NewReader("no time\n"), true)
+	assert.NotNil(t, err)
+
+	format, err := ParseLogFormat("json")
+	assert.Nil(t, err)
+	assert.Equal(t, FormatJSON, format)
+}
+
+func TestOTelSeverity(t *testing.T) {
+	assert.Equal(t, 21, OTelSeverity("fatal"))
+	assert.Equal(t, 17, OTelSeverity("error"))
+	assert.Equal(t, 13, OTelSeverity("warning"))
+	assert.Equal(t, 9, OTelSeverity("info"))
+	assert.Equal(t, 8, OTelSeverity("config"))
+	assert.Equal(t, 7, OTelSeverity("event"))
+	assert.Equal(t, 5, OTelSeverity("debug"))
+	assert.Equal(t, 1, OTelSeverity("spam"))
+	assert.Equal(t, 0, OTelSeverity("unknown"))
+}
+
+func TestLogEntryMatchesHost(t *testing.T) {
+	logEntry := LogEntry{Host: "host1a.dev.aws-us-east-1c"}
+	assert.True(t, logEntry.MatchesHost(""))
+	assert.True(t, logEntry.MatchesHost("host1a"))
+	assert.True(t, logEntry.MatchesHost("HOST1A.dev"))
+	assert.True(t, logEntry.MatchesHost("host1a.dev.aws-us-east-1c"))
+	assert.False(t, logEntry.MatchesHost("host2a"))
+}
@@ -0,0 +1,180 @@
+// Copyright Yahoo. Licensed under the terms of the Apache 2.0 license. See LICENSE in the project root.
+package vespa
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+)
+
+// ReindexStatus is the status of reindexing a document type in a content cluster.
+type ReindexStatus struct {
+	Cluster      string
+	DocumentType string
+	// State is one of "pending", "running", "successful", "failed" or "interrupted"
+	State    string
+	Progress float64
+	Message  string
+}
+
+// Done returns whether reindexing has stopped, successfully or not.
+func (s ReindexStatus) Done() bool {
+	return s.State == "successful" || s.State == "failed" || s.State == "interrupted"
+}
+
+// reindexingResponse is the reindexing status returned by a config server.
+type reindexingResponse struct {
+	Clusters map[string]struct {
+		Pending map[string]int64 `json:"pending"`
+		Ready   map[string]struct {
+			State    string  `json:"state"`
+			Progress float64 `json:"progress"`
+			Message  string  `json:"message"`
+		} `json:"ready"`
+	} `json:"clusters"`
+}
+
+func (r reindexingResponse) statuses() []ReindexStatus {
+	var statuses []ReindexStatus
+	for cluster, c := range r.Clusters {
+		for documentType, ready := range c.Ready {
+			if _, pending := c.Pending[documentType]; pending {
+				continue
+			}
+			statuses = append(statuses, ReindexStatus{Cluster: cluster, DocumentType: documentType, State: ready.State, Progress: ready.Progress, Message: ready.Message})
+		}
+		for documentType := range c.Pending {
+			statuses = append(statuses, ReindexStatus{Cluster: cluster, DocumentType: documentType, State: "pending"})
+		}
+	}
+	sortReindexStatuses(statuses)
+	return statuses
+}
+
+// controllerReindexingResponse is the reindexing status returned by a Vespa Cloud controller.
+type controllerReindexingResponse struct {
+	Clusters []struct {
+		Name    string `json:"name"`
+		Pending []struct {
+			Type string `json:"type"`
+		} `json:"pending"`
+		Ready []struct {
+			Type     string  `json:"type"`
+			State    string  `json:"state"`
+			Progress float64 `json:"progress"`
+			Message  string  `json:"message"`
+		} `json:"ready"`
+	} `json:"clusters"`
+}
+
+func (r controllerReindexingResponse) statuses() []ReindexStatus {
+	var statuses []ReindexStatus
+	for _, c := range r.Clusters {
+		pending := make(map[string]bool, len(c.Pending))
+		for _, p := range c.Pending {
+			pending[p.Type] = true
+			statuses = append(statuses, ReindexStatus{Cluster: c.Name, DocumentType: p.Type, State: "pending"})
+		}
+		for _, ready := range c.Ready {
+			if pending[ready.Type] {
+				continue
+			}
+			statuses = append(statuses, ReindexStatus{Cluster: c.Name, DocumentType: ready.Type, State: ready.State, Progress: ready.Progress, Message: ready.Message})
+		}
+	}
+	sortReindexStatuses(statuses)
+	return statuses
+}
+
+func sortReindexStatuses(statuses []ReindexStatus) {
+	sort.Slice(statuses, func(i, j int) bool {
+		if statuses[i].Cluster == statuses[j].Cluster {
+			return statuses[i].DocumentType < statuses[j].DocumentType
+		}
+		return statuses[i].Cluster < statuses[j].Cluster
+	})
+}
+
+// reindexQuery returns the query selecting the cluster and document type to reindex. Empty values select all.
+func reindexQuery(cluster, documentType string) string {
+	query := url.Values{}
+	if cluster != "" {
+		query.Set("clusterId", cluster)
+	}
+	if documentType != "" {
+		query.Set("documentType", documentType)
+	}
+	return query.Encode()
+}
+
+func (t *customTarget) applicationURL(ctx context.Context) (string, error) {
+	deployer, err := t.Service(ctx, deployService, 0, 0, "")
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%s/application/v2/tenant/%s/application/%s/environment/prod/region/default/instance/%s",
+		deployer.BaseURL, t.application.Tenant, t.application.Application, t.application.Instance), nil
+}
+
+func (t *customTarget) Reindex(ctx context.Context, cluster, documentType string) error {
+	applicationURL, err := t.applicationURL(ctx)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest("POST", applicationURL+"/reindex?"+reindexQuery(cluster, documentType), nil)
+	if err != nil {
+		return err
+	}
+	return readJSON(ctx, req, &t.tlsOptions, "reindexing response", nil)
+}
+
+func (t *customTarget) ReindexStatus(ctx context.Context) ([]ReindexStatus, error) {
+	applicationURL, err := t.applicationURL(ctx)
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequest("GET", applicationURL+"/reindexing", nil)
+	if err != nil {
+		return nil, err
+	}
+	var resp reindexingResponse
+	if err := readJSON(ctx, req, &t.tlsOptions, "reindexing response", &resp); err != nil {
+		return nil, err
+	}
+	return resp.statuses(), nil
+}
+
+func (t *cloudTarget) deploymentURL() string {
+	return fmt.Sprintf("%s/application/v4/tenant/%s/application/%s/instance/%s/environment/%s/region/%s",
+		t.apiURL,
+		t.deployment.Application.Tenant, t.deployment.Application.Application, t.deployment.Application.Instance,
+		t.deployment.Zone.Environment, t.deployment.Zone.Region)
+}
+
+func (t *cloudTarget) Reindex(ctx context.Context, cluster, documentType string) error {
+	req, err := http.NewRequest("POST", t.deploymentURL()+"/reindex?"+reindexQuery(cluster, documentType), nil)
+	if err != nil {
+		return err
+	}
+	if err := t.PrepareApiRequest(req, t.deployment.Application.SerializedForm()); err != nil {
+		return err
+	}
+	return readJSON(ctx, req, &t.tlsOptions, "reindexing response", nil)
+}
+
+func (t *cloudTarget) ReindexStatus(ctx context.Context) ([]ReindexStatus, error) {
+	req, err := http.NewRequest("GET", t.deploymentURL()+"/reindexing", nil)
+	if err != nil {
+		return nil, err
+	}
+	if err := t.PrepareApiRequest(req, t.deployment.Application.SerializedForm()); err != nil {
+		return nil, err
+	}
+	var resp controllerReindexingResponse
+	if err := readJSON(ctx, req, &t.tlsOptions, "reindexing response", &resp); err != nil {
+		return nil, err
+	}
+	return resp.statuses(), nil
+}
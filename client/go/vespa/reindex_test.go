@@ -0,0 +1,41 @@
+// Copyright Yahoo. Licensed under the terms of the Apache 2.0 license. See LICENSE in the project root.
+package vespa
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestControllerReindexingStatuses(t *testing.T) {
+	var resp controllerReindexingResponse
+	assert.Nil(t, json.Unmarshal([]byte(`{
+  "enabled": true,
+  "clusters": [
+    {
+      "name": "music",
+      "pending": [{"type": "album", "requiredGeneration": 4}],
+      "ready": [
+        {"type": "album", "state": "successful"},
+        {"type": "artist", "state": "running", "progress": 0.5}
+      ]
+    },
+    {
+      "name": "books",
+      "ready": [{"type": "book", "state": "failed", "message": "boom"}]
+    }
+  ]
+}`), &resp))
+	assert.Equal(t, []ReindexStatus{
+		{Cluster: "books", DocumentType: "book", State: "failed", Message: "boom"},
+		{Cluster: "music", DocumentType: "album", State: "pending"},
+		{Cluster: "music", DocumentType: "artist", State: "running", Progress: 0.5},
+	}, resp.statuses())
+}
+
+func TestReindexStatusDone(t *testing.T) {
+	for state, done := range map[string]bool{"pending": false, "running": false, "successful": true, "failed": true, "interrupted": true} {
+		assert.Equal(t, done, ReindexStatus{State: state}.Done(), state)
+	}
+}
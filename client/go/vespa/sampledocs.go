@@ -0,0 +1,209 @@
+// Copyright Yahoo. Licensed under the terms of the Apache 2.0 license. See LICENSE in the project root.
+package vespa
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+// DocumentIssue is a problem which would prevent a document from being indexed.
+type DocumentIssue struct {
+	Line    int    // The line of the document in its JSONL input
+	ID      string // The ID of the document, if known
+	Message string
+}
+
+func (i DocumentIssue) String() string {
+	if i.ID == "" {
+		return fmt.Sprintf("line %d: %s", i.Line, i.Message)
+	}
+	return fmt.Sprintf("line %d: %s: %s", i.Line, i.ID, i.Message)
+}
+
+// sampleOperation is a document operation in the JSON feed format.
+type sampleOperation struct {
+	Put    string                     `json:"put"`
+	Update string                     `json:"update"`
+	Remove string                     `json:"remove"`
+	Fields map[string]json.RawMessage `json:"fields"`
+}
+
+// ValidateSampleDocuments validates the document operations read from r, one JSON object per line, against schemas.
+// Each document must have a schema matching its document type, and declare only fields of that schema, with
+// values of the declared types. It returns the issues found, and the number of documents read.
+func ValidateSampleDocuments(r io.Reader, schemas []Schema) ([]DocumentIssue, int, error) {
+	bySchemaName := make(map[string]Schema, len(schemas))
+	for _, s := range schemas {
+		bySchemaName[s.Name] = s
+	}
+	var issues []DocumentIssue
+	count := 0
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 64*1024*1024)
+	line := 0
+	for scanner.Scan() {
+		line++
+		data := bytes.TrimSpace(scanner.Bytes())
+		if len(data) == 0 {
+			continue
+		}
+		count++
+		var op sampleOperation
+		if err := json.Unmarshal(data, &op); err != nil {
+			issues = append(issues, DocumentIssue{Line: line, Message: fmt.Sprintf("invalid JSON: %s", err)})
+			continue
+		}
+		for _, message := range validateOperation(op, bySchemaName) {
+			issues = append(issues, DocumentIssue{Line: line, ID: op.id(), Message: message})
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, 0, err
+	}
+	return issues, count, nil
+}
+
+func (op sampleOperation) id() string {
+	switch {
+	case op.Put != "":
+		return op.Put
+	case op.Update != "":
+		return op.Update
+	}
+	return op.Remove
+}
+
+func validateOperation(op sampleOperation, schemas map[string]Schema) []string {
+	id := op.id()
+	if id == "" {
+		return []string{`missing document ID in "put", "update" or "remove"`}
+	}
+	parts := strings.Split(id, ":")
+	if len(parts) < 5 || parts[0] != "id" {
+		return []string{"invalid document ID: must be on the form id:<namespace>:<document-type>:<key/value-pairs>:<user-specified>"}
+	}
+	docType := parts[2]
+	schema, ok := schemas[docType]
+	if !ok {
+		return []string{fmt.Sprintf("no schema for document type %q", docType)}
+	}
+	if op.Remove != "" {
+		return nil
+	}
+	fields := make(map[string]string, len(schema.Fields))
+	for _, f := range schema.Fields {
+		fields[f.Name] = f.Type
+	}
+	var messages []string
+	for _, name := range sortedKeys(op.Fields) {
+		fieldType, ok := fields[name]
+		if !ok {
+			messages = append(messages, fmt.Sprintf("unknown field %q in schema %s", name, schema.Name))
+			continue
+		}
+		if op.Update != "" {
+			continue // Values of updates are update operations, not field values
+		}
+		if err := checkFieldValue(fieldType, op.Fields[name]); err != nil {
+			messages = append(messages, fmt.Sprintf("field %q of type %s: %s", name, fieldType, err))
+		}
+	}
+	return messages
+}
+
+// checkFieldValue checks that value is a valid JSON representation of a field of type fieldType. Types not known
+// here, such as structs, are not checked.
+func checkFieldValue(fieldType string, value json.RawMessage) error {
+	var v interface{}
+	decoder := json.NewDecoder(bytes.NewReader(value))
+	decoder.UseNumber()
+	if err := decoder.Decode(&v); err != nil {
+		return err
+	}
+	baseType := fieldType
+	if i := strings.IndexAny(fieldType, "<("); i >= 0 {
+		baseType = fieldType[:i]
+	}
+	switch strings.TrimSpace(baseType) {
+	case "string", "uri", "predicate", "raw", "reference":
+		if _, ok := v.(string); !ok {
+			return fmt.Errorf("expected a string, got %s", jsonKind(v))
+		}
+	case "int", "long", "byte":
+		n, ok := v.(json.Number)
+		if !ok {
+			return fmt.Errorf("expected an integer, got %s", jsonKind(v))
+		}
+		if _, err := n.Int64(); err != nil {
+			return fmt.Errorf("expected an integer, got %s", n)
+		}
+	case "float", "double":
+		if _, ok := v.(json.Number); !ok {
+			return fmt.Errorf("expected a number, got %s", jsonKind(v))
+		}
+	case "bool":
+		if _, ok := v.(bool); !ok {
+			return fmt.Errorf("expected a boolean, got %s", jsonKind(v))
+		}
+	case "array":
+		elements, ok := v.([]interface{})
+		if !ok {
+			return fmt.Errorf("expected an array, got %s", jsonKind(v))
+		}
+		elementType := strings.TrimSuffix(strings.TrimPrefix(fieldType, "array<"), ">")
+		for i := range elements {
+			element, err := json.Marshal(elements[i])
+			if err != nil {
+				return err
+			}
+			if err := checkFieldValue(elementType, element); err != nil {
+				return fmt.Errorf("element %d: %w", i, err)
+			}
+		}
+	case "map", "weightedset", "tensor":
+		switch v.(type) {
+		case map[string]interface{}, []interface{}:
+		default:
+			return fmt.Errorf("expected an object or array, got %s", jsonKind(v))
+		}
+	case "position":
+		switch v.(type) {
+		case map[string]interface{}, string:
+		default:
+			return fmt.Errorf("expected an object or string, got %s", jsonKind(v))
+		}
+	}
+	return nil
+}
+
+func jsonKind(v interface{}) string {
+	switch v.(type) {
+	case nil:
+		return "null"
+	case string:
+		return "a string"
+	case json.Number:
+		return "a number"
+	case bool:
+		return "a boolean"
+	case []interface{}:
+		return "an array"
+	case map[string]interface{}:
+		return "an object"
+	}
+	return fmt.Sprintf("%T", v)
+}
+
+func sortedKeys(m map[string]json.RawMessage) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,41 @@
+// Copyright Yahoo. Licensed under the terms of the Apache 2.0 license. See LICENSE in the project root.
+package vespa
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidateSampleDocuments(t *testing.T) {
+	schema, err := ParseSchema(strings.NewReader(musicSchema))
+	assert.Nil(t, err)
+	docs := `{"put": "id:mynamespace:music::a-head-full-of-dreams", "fields": {"artist": "Coldplay", "year": 2015, "tags": {"pop": 1}}}
+
+{"put": "id:mynamespace:music::hardwired", "fields": {"artist": "Metallica", "year": "2016", "label": "Blackened"}}
+{"update": "id:mynamespace:music::love-is-here-to-stay", "fields": {"year": {"assign": 2014}, "album": {"assign": "foo"}}}
+{"remove": "id:mynamespace:music::a-head-full-of-dreams"}
+{"put": "id:mynamespace:album::hardwired", "fields": {}}
+{"put": "id:mynamespace:music::x", "fields": {"year": 2015.5, "tags": "pop"}}
+{"put": "music::x"}
+not json
+`
+	issues, count, err := ValidateSampleDocuments(strings.NewReader(docs), []Schema{schema})
+	assert.Nil(t, err)
+	assert.Equal(t, 8, count)
+	var messages []string
+	for _, issue := range issues {
+		messages = append(messages, issue.String())
+	}
+	assert.Equal(t, []string{
+		`line 3: id:mynamespace:music::hardwired: unknown field "label" in schema music`,
+		`line 3: id:mynamespace:music::hardwired: field "year" of type int: expected an integer, got a string`,
+		`line 4: id:mynamespace:music::love-is-here-to-stay: unknown field "album" in schema music`,
+		`line 6: id:mynamespace:album::hardwired: no schema for document type "album"`,
+		`line 7: id:mynamespace:music::x: field "tags" of type map<string, int>: expected an object or array, got a string`,
+		`line 7: id:mynamespace:music::x: field "year" of type int: expected an integer, got 2015.5`,
+		`line 8: music::x: invalid document ID: must be on the form id:<namespace>:<document-type>:<key/value-pairs>:<user-specified>`,
+		`line 9: invalid JSON: invalid character 'o' in literal null (expecting 'u')`,
+	}, messages)
+}
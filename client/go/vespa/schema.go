@@ -0,0 +1,152 @@
+// Copyright Yahoo. Licensed under the terms of the Apache 2.0 license. See LICENSE in the project root.
+package vespa
+
+import (
+	"archive/zip"
+	"bufio"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+var (
+	schemaDirs     = []string{"schemas", "searchdefinitions"}
+	schemaPattern  = regexp.MustCompile(`^\s*(?:schema|search)\s+([A-Za-z_][A-Za-z0-9_]*)`)
+	fieldPattern   = regexp.MustCompile(`^\s*field\s+([A-Za-z_][A-Za-z0-9_.]*)\s+type\s+([^{]+)`)
+	commentPattern = regexp.MustCompile(`#.*$`)
+)
+
+// Schema represents a schema in an application package.
+type Schema struct {
+	Name   string
+	Fields []Field
+}
+
+// Field represents a field declared in a schema.
+type Field struct {
+	Name string
+	Type string
+}
+
+// Schemas returns the schemas contained in this application package, sorted by name.
+func (ap *ApplicationPackage) Schemas() ([]Schema, error) {
+	var schemas []Schema
+	var err error
+	if ap.IsZip() {
+		schemas, err = ap.zippedSchemas()
+	} else {
+		schemas, err = ap.directorySchemas()
+	}
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(schemas, func(i, j int) bool { return schemas[i].Name < schemas[j].Name })
+	return schemas, nil
+}
+
+func (ap *ApplicationPackage) directorySchemas() ([]Schema, error) {
+	var schemas []Schema
+	for _, dir := range schemaDirs {
+		files, err := ioutil.ReadDir(filepath.Join(ap.Path, dir))
+		if os.IsNotExist(err) {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		for _, f := range files {
+			if f.IsDir() || filepath.Ext(f.Name()) != ".sd" {
+				continue
+			}
+			schema, err := readSchemaFile(filepath.Join(ap.Path, dir, f.Name()))
+			if err != nil {
+				return nil, err
+			}
+			schemas = append(schemas, schema)
+		}
+	}
+	return schemas, nil
+}
+
+func (ap *ApplicationPackage) zippedSchemas() ([]Schema, error) {
+	r, err := zip.OpenReader(ap.Path)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	var schemas []Schema
+	for _, f := range r.File {
+		name := strings.TrimPrefix(f.Name, "/")
+		if !isSchemaFile(name) {
+			continue
+		}
+		rc, err := f.Open()
+		if err != nil {
+			return nil, err
+		}
+		schema, err := ParseSchema(rc)
+		rc.Close()
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", name, err)
+		}
+		schemas = append(schemas, schema)
+	}
+	return schemas, nil
+}
+
+func isSchemaFile(name string) bool {
+	dir, file := filepath.Split(filepath.FromSlash(name))
+	if filepath.Ext(file) != ".sd" {
+		return false
+	}
+	dir = filepath.Clean(dir)
+	for _, schemaDir := range schemaDirs {
+		if dir == schemaDir {
+			return true
+		}
+	}
+	return false
+}
+
+func readSchemaFile(filename string) (Schema, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return Schema{}, err
+	}
+	defer f.Close()
+	schema, err := ParseSchema(f)
+	if err != nil {
+		return Schema{}, fmt.Errorf("%s: %w", filename, err)
+	}
+	return schema, nil
+}
+
+// ParseSchema reads the schema name and declared fields from r. Only the schema and field declarations are parsed,
+// all other contents of the schema are ignored.
+func ParseSchema(r io.Reader) (Schema, error) {
+	var schema Schema
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := commentPattern.ReplaceAllString(scanner.Text(), "")
+		if schema.Name == "" {
+			if m := schemaPattern.FindStringSubmatch(line); m != nil {
+				schema.Name = m[1]
+			}
+			continue
+		}
+		if m := fieldPattern.FindStringSubmatch(line); m != nil {
+			schema.Fields = append(schema.Fields, Field{Name: m[1], Type: strings.TrimSpace(m[2])})
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return Schema{}, err
+	}
+	if schema.Name == "" {
+		return Schema{}, fmt.Errorf("no schema declaration found")
+	}
+	return schema, nil
+}
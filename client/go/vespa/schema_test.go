@@ -0,0 +1,85 @@
+// Copyright Yahoo. Licensed under the terms of the Apache 2.0 license. See LICENSE in the project root.
+package vespa
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const musicSchema = `# A schema for music
+schema music {
+    document music {
+        field artist type string {
+            indexing: summary | index
+        }
+        field year type int { indexing: summary | attribute }
+        field tags type map<string, int> {
+            indexing: summary
+        }
+    }
+    field artist_lc type string { # Synthetic field
+        indexing: input artist | lowercase | attribute
+    }
+}
+`
+
+const lyricsSchema = `search lyrics {
+    document lyrics {
+        field embedding type tensor<float>(x[128]) {
+            indexing: attribute
+        }
+    }
+}
+`
+
+func TestSchemas(t *testing.T) {
+	dir := t.TempDir()
+	writeSchemas(t, filepath.Join(dir, "schemas"), map[string]string{"music.sd": musicSchema})
+	writeSchemas(t, filepath.Join(dir, "searchdefinitions"), map[string]string{"lyrics.sd": lyricsSchema, "README": "not a schema"})
+
+	expected := []Schema{
+		{Name: "lyrics", Fields: []Field{{Name: "embedding", Type: "tensor<float>(x[128])"}}},
+		{Name: "music", Fields: []Field{
+			{Name: "artist", Type: "string"},
+			{Name: "year", Type: "int"},
+			{Name: "tags", Type: "map<string, int>"},
+			{Name: "artist_lc", Type: "string"},
+		}},
+	}
+	pkg := ApplicationPackage{Path: dir}
+	schemas, err := pkg.Schemas()
+	assert.Nil(t, err)
+	assert.Equal(t, expected, schemas)
+
+	zipFile := filepath.Join(t.TempDir(), "application.zip")
+	cwd, err := os.Getwd()
+	assert.Nil(t, err)
+	defer os.Chdir(cwd)
+	assert.Nil(t, os.Chdir(dir))
+	assert.Nil(t, zipDir(".", zipFile))
+	zipPkg := ApplicationPackage{Path: zipFile}
+	schemas, err = zipPkg.Schemas()
+	assert.Nil(t, err)
+	assert.Equal(t, expected, schemas)
+}
+
+func TestParseSchemaWithoutDeclaration(t *testing.T) {
+	_, err := ParseSchema(strings.NewReader("field foo type string {}"))
+	assert.NotNil(t, err)
+}
+
+func writeSchemas(t *testing.T, dir string, files map[string]string) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	for name, contents := range files {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(contents), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
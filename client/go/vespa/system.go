@@ -0,0 +1,47 @@
+// Copyright Yahoo. Licensed under the terms of the Apache 2.0 license. See LICENSE in the project root.
+package vespa
+
+import "fmt"
+
+// System is a Vespa Cloud system, i.e. a control plane and its zones.
+type System struct {
+	Name       string `json:"name"`
+	APIURL     string `json:"apiUrl"`
+	ConsoleURL string `json:"consoleUrl"`
+}
+
+var (
+	// PublicSystem is the public Vespa Cloud system.
+	PublicSystem = System{
+		Name:       "public",
+		APIURL:     "https://api.vespa-external.aws.oath.cloud:4443",
+		ConsoleURL: "https://console.vespa.oath.cloud",
+	}
+	// PublicCDSystem is the system where changes to the public system are verified before release.
+	PublicCDSystem = System{
+		Name:       "publiccd",
+		APIURL:     "https://api.vespa-external-cd.aws.oath.cloud:4443",
+		ConsoleURL: "https://console-cd.vespa.oath.cloud",
+	}
+)
+
+// KnownSystems returns the systems known to this client. Other systems can be used by reading their discovery
+// document, see Discover.
+func KnownSystems() []System {
+	return []System{PublicSystem, PublicCDSystem}
+}
+
+// GetSystem returns the known system with given name.
+func GetSystem(name string) (System, error) {
+	for _, s := range KnownSystems() {
+		if s.Name == name {
+			return s, nil
+		}
+	}
+	return System{}, fmt.Errorf("unknown system: %q", name)
+}
+
+// AsSystem returns the system described by this discovery document.
+func (d Discovery) AsSystem() System {
+	return System{Name: d.System, APIURL: d.APIURL, ConsoleURL: d.ConsoleURL}
+}
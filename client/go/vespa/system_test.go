@@ -0,0 +1,18 @@
+// Copyright Yahoo. Licensed under the terms of the Apache 2.0 license. See LICENSE in the project root.
+package vespa
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestKnownSystems(t *testing.T) {
+	assert.Equal(t, []System{PublicSystem, PublicCDSystem}, KnownSystems())
+	s, err := GetSystem("public")
+	assert.Nil(t, err)
+	assert.Equal(t, "https://api.vespa-external.aws.oath.cloud:4443", s.APIURL)
+	assert.Equal(t, "https://console.vespa.oath.cloud", s.ConsoleURL)
+	_, err = GetSystem("foo")
+	assert.EqualError(t, err, `unknown system: "foo"`)
+}
@@ -4,8 +4,11 @@ package vespa
 
 import (
 	"bytes"
+	"context"
 	"crypto/tls"
+	"crypto/x509"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"io/ioutil"
@@ -13,9 +16,11 @@ import (
 	"net/http"
 	"net/url"
 	"os"
+	"regexp"
 	"sort"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/vespa-engine/vespa/client/go/auth0"
@@ -30,15 +35,42 @@ const (
 	deployService   = "deploy"
 	queryService    = "query"
 	documentService = "document"
-
-	retryInterval = 2 * time.Second
 )
 
+// ErrNotDeployed is returned when the target has no deployment of the application.
+var ErrNotDeployed = errors.New("not deployed")
+
+// ErrUnauthorized is returned when the target rejects the credentials of a request.
+var ErrUnauthorized = errors.New("unauthorized")
+
+// ErrRunFailed is returned when a deployment run ends unsuccessfully.
+var ErrRunFailed = errors.New("ended with unsuccessful status")
+
+// defaultSelfHostedName is the name of the tenant, application and instance deployed to by default on self-hosted Vespa.
+const defaultSelfHostedName = "default"
+
+// retryInterval is the default interval between requests when polling a service.
+var retryInterval = 2 * time.Second
+
+// maxBackoffInterval is the longest interval between requests made by WaitRequest, which doubles the interval after
+// each request.
+const maxBackoffInterval = 30 * time.Second
+
 // Service represents a Vespa service.
 type Service struct {
 	BaseURL    string
 	Name       string
 	TLSOptions TLSOptions
+
+	// HealthPath overrides the default path used for health checks of this service, if non-empty.
+	HealthPath string
+
+	// RetryInterval is the interval between requests when waiting for this service. The default interval is used if
+	// this is 0.
+	RetryInterval time.Duration
+
+	serverCertificate *x509.Certificate
+	invalidate        func() // Called when a request to this service fails, if non-nil
 }
 
 // Target represents a Vespa platform, running named Vespa services.
@@ -47,29 +79,195 @@ type Target interface {
 	Type() string
 
 	// Service returns the service for given name. If timeout is non-zero, wait for the service to converge.
-	Service(name string, timeout time.Duration, sessionOrRunID int64, cluster string) (*Service, error)
+	Service(ctx context.Context, name string, timeout time.Duration, sessionOrRunID int64, cluster string) (*Service, error)
 
 	// PrintLog writes the logs of this deployment using given options to control output.
-	PrintLog(options LogOptions) error
+	PrintLog(ctx context.Context, options LogOptions) error
+
+	// Runs returns the most recent deployment runs of this deployment, ordered by recency. At most limit runs are
+	// returned, unless limit is 0.
+	Runs(ctx context.Context, limit int) ([]RunSummary, error)
+
+	// FetchRunLog returns the log entries of deployment run runID which come after the entry with ID after, ordered by
+	// time. The ID of the last entry is also returned, for use as after in a subsequent call. Use -1 to fetch all
+	// entries.
+	FetchRunLog(ctx context.Context, runID, after int64) ([]JobLogEntry, int64, error)
+
+	// NodeFlavors returns the node flavors available in the system of this target.
+	NodeFlavors(ctx context.Context) ([]Flavor, error)
+
+	// Reindex triggers reindexing of documentType in cluster. Empty values select all document types or clusters.
+	Reindex(ctx context.Context, cluster, documentType string) error
 
+	// ReindexStatus returns the reindexing status of each document type in each cluster, ordered by cluster and type.
+	ReindexStatus(ctx context.Context) ([]ReindexStatus, error)
+
+	// Versions returns the application and platform versions active in the deployment on this target.
+	Versions(ctx context.Context) (DeploymentVersions, error)
+
+	// PackageVersion returns the version declared in the build metadata of the application package active in the
+	// deployment on this target. This is empty if no version is declared, or nothing is deployed.
+	PackageVersion(ctx context.Context) (string, error)
+
+	// Clusters returns the clusters of the deployment on this target, ordered by name. If timeout is non-zero, wait for
+	// clusters to be discovered.
+	Clusters(ctx context.Context, timeout time.Duration) ([]Cluster, error)
+
+	// WaitRequest sends a request with method to path on the API of this target, i.e., the controller of Vespa Cloud,
+	// or the config server of a self-hosted Vespa. The request is repeated until until returns true for the response
+	// body, or timeout passes. The request is sent once if timeout is 0. Any required authentication happens
+	// automatically.
+	WaitRequest(ctx context.Context, method, path string, until func(response []byte) (bool, error), timeout time.Duration) error
+
+	// SetRetryInterval sets the interval between requests when waiting for this target, and for the services it
+	// returns. The default interval is used if interval is 0.
+	SetRetryInterval(interval time.Duration)
+
+	// SetRunLogWriter sets the writer of the log of a deployment run, while waiting for the run to complete. The log is
+	// not written if w is nil. Targets without deployment runs ignore this.
+	SetRunLogWriter(w io.Writer)
+
+	// PrepareApiRequest adds the authentication required by the API of this target to req. The API key, if used, is
+	// identified by sigKeyId.
 	PrepareApiRequest(req *http.Request, sigKeyId string) error
 }
 
+// DeploymentVersions holds the versions active in a deployment.
+type DeploymentVersions struct {
+	// Application is the version of the active application package, e.g. "build 42" in Vespa Cloud, or "session 3"
+	// on a self-hosted Vespa.
+	Application string
+
+	// Platform is the Vespa version the deployment runs on.
+	Platform string
+}
+
+// EndpointCache stores the endpoints discovered for a deployment, so that later targets for the same deployment can
+// skip discovery.
+type EndpointCache interface {
+	// Read returns the cached endpoints of deployment, by cluster name, and whether any were found.
+	Read(deployment Deployment) (map[string]string, bool)
+
+	// Write stores the endpoints of deployment.
+	Write(deployment Deployment, urlsByCluster map[string]string)
+
+	// Remove removes the cached endpoints of deployment, e.g. because they could not be reached.
+	Remove(deployment Deployment)
+}
+
 // TLSOptions configures the certificate to use for service requests.
 type TLSOptions struct {
 	KeyPair         tls.Certificate
 	CertificateFile string
 	PrivateKeyFile  string
+
+	// CACertificateDir is a directory of CA certificates used to verify server certificates, in addition to the system
+	// certificate pool, if non-empty.
+	CACertificateDir string
+}
+
+// RunSummary summarizes a deployment run.
+type RunSummary struct {
+	ID      int64
+	Status  string
+	Start   time.Time
+	Version string
 }
 
+// JobLogEntry is an entry in the log of a deployment run.
+type JobLogEntry struct {
+	// Step is the step of the run which logged this entry, e.g. deployReal
+	Step    string
+	At      time.Time
+	Type    string
+	Message string
+}
+
+// Cluster is a cluster of a Vespa deployment, reachable through a service.
+type Cluster struct {
+	Name    string
+	Type    string
+	Service *Service
+}
+
+// Flavor is a node flavor, i.e. a preset of node resources.
+type Flavor struct {
+	Name     string  `json:"name"`
+	Vcpu     float64 `json:"vcpu"`
+	MemoryGb float64 `json:"memoryGb"`
+	DiskGb   float64 `json:"diskGb"`
+}
+
+// Resources returns the resources of this flavor in the format used by services.xml.
+func (f Flavor) Resources() string {
+	return fmt.Sprintf("vcpu=%s,memory=%sGb,disk=%sGb", formatFloat(f.Vcpu), formatFloat(f.MemoryGb), formatFloat(f.DiskGb))
+}
+
+func formatFloat(n float64) string { return strconv.FormatFloat(n, 'f', -1, 64) }
+
 // LogOptions configures the log output to produce when writing log messages.
 type LogOptions struct {
-	From    time.Time
-	To      time.Time
-	Follow  bool
-	Dequote bool
-	Writer  io.Writer
-	Level   int
+	From      time.Time
+	To        time.Time
+	Follow    bool
+	Dequote   bool
+	Writer    io.Writer
+	Level     int
+	Component string
+	Host      string
+	// MessageFilter is a regular expression which the message of each entry must match. An empty filter matches any
+	// message.
+	MessageFilter string
+	// ComponentFilter is a regular expression which the component of each entry must match. An empty filter matches
+	// any component.
+	ComponentFilter string
+	// ShowGeneration tags each entry with the config generation of its host, as seen in preceding entries. This only
+	// applies to FormatPlain.
+	ShowGeneration bool
+	Format         LogFormat
+	// MaxLines is the maximum number of entries to write, or 0 for no limit. The earliest entries are written, except
+	// when following logs, where this applies only to the entries logged before following started, of which the latest
+	// are written.
+	MaxLines int
+	// Truncated is called, if non-nil, when entries are left out due to MaxLines.
+	Truncated func()
+	// LevelMapping maps the level names used by the log source to the levels compared against Level, for sources not
+	// using the level names of Vespa. Names missing from the mapping are treated as debug. If nil, LogLevel is used.
+	LevelMapping map[string]int
+}
+
+// Validate returns an error if the filters of these options are invalid.
+func (o LogOptions) Validate() error {
+	_, _, err := o.filters()
+	return err
+}
+
+// filters returns the compiled message and component filters of these options, which are nil if empty.
+func (o LogOptions) filters() (*regexp.Regexp, *regexp.Regexp, error) {
+	var messageFilter, componentFilter *regexp.Regexp
+	var err error
+	if o.MessageFilter != "" {
+		if messageFilter, err = regexp.Compile(o.MessageFilter); err != nil {
+			return nil, nil, fmt.Errorf("invalid message filter: %q: %w", o.MessageFilter, err)
+		}
+	}
+	if o.ComponentFilter != "" {
+		if componentFilter, err = regexp.Compile(o.ComponentFilter); err != nil {
+			return nil, nil, fmt.Errorf("invalid component filter: %q: %w", o.ComponentFilter, err)
+		}
+	}
+	return messageFilter, componentFilter, nil
+}
+
+// level returns the int representing the named log level, according to the level mapping of these options.
+func (o LogOptions) level(name string) int {
+	if o.LevelMapping == nil {
+		return LogLevel(name)
+	}
+	if level, ok := o.LevelMapping[name]; ok {
+		return level
+	}
+	return LogLevel("debug")
 }
 
 func Auth0AccessTokenEnabled() bool {
@@ -81,22 +279,58 @@ func Auth0AccessTokenEnabled() bool {
 }
 
 type customTarget struct {
-	targetType string
-	baseURL    string
+	targetType  string
+	baseURL     string
+	tlsOptions  TLSOptions
+	stableFor   time.Duration
+	application ApplicationID
+
+	retryInterval time.Duration
 }
 
 func (t *customTarget) PrepareApiRequest(req *http.Request, sigKeyId string) error { return nil }
 
+func (t *customTarget) SetRetryInterval(interval time.Duration) { t.retryInterval = interval }
+
+func (t *customTarget) SetRunLogWriter(w io.Writer) {}
+
+func (t *customTarget) WaitRequest(ctx context.Context, method, path string, until func(response []byte) (bool, error), timeout time.Duration) error {
+	deployer, err := t.Service(ctx, deployService, 0, 0, "")
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(method, deployer.BaseURL+path, nil)
+	if err != nil {
+		return err
+	}
+	return waitRequest(ctx, fixedRequest(req), until, &t.tlsOptions, timeout, t.retryInterval)
+}
+
 // Do sends request to this service. Any required authentication happens automatically.
 func (s *Service) Do(request *http.Request, timeout time.Duration) (*http.Response, error) {
 	if s.TLSOptions.KeyPair.Certificate != nil {
-		util.ActiveHttpClient.UseCertificate([]tls.Certificate{s.TLSOptions.KeyPair})
+		util.ActiveHttpClient.UseCertificate(s.TLSOptions.certificates())
+	}
+	if err := useCACertificates(&s.TLSOptions); err != nil {
+		return nil, err
 	}
-	return util.HttpDo(request, timeout, s.Description())
+	response, err := util.HttpDo(request, timeout, s.Description())
+	s.checkFailure(err)
+	return response, err
 }
 
-// Wait polls the health check of this service until it succeeds or timeout passes.
-func (s *Service) Wait(timeout time.Duration) (int, error) {
+// checkFailure invalidates the URL of this service if err is the error of a request which failed before receiving a
+// response, for a reason other than the request being cancelled.
+func (s *Service) checkFailure(err error) {
+	var urlErr *url.Error
+	cancelled := errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded)
+	if s.invalidate != nil && errors.As(err, &urlErr) && !cancelled {
+		s.invalidate()
+	}
+}
+
+// Wait polls the health check of this service until it succeeds, timeout passes, or ctx is done.
+func (s *Service) Wait(ctx context.Context, timeout time.Duration) (int, error) {
 	url := s.BaseURL
 	switch s.Name {
 	case deployService:
@@ -106,12 +340,124 @@ func (s *Service) Wait(timeout time.Duration) (int, error) {
 	default:
 		return 0, fmt.Errorf("invalid service: %s", s.Name)
 	}
+	if s.HealthPath != "" {
+		if !strings.HasPrefix(s.HealthPath, "/") {
+			return 0, fmt.Errorf("invalid health check path for service %s: %q", s.Name, s.HealthPath)
+		}
+		url = s.BaseURL + s.HealthPath
+	}
 	req, err := http.NewRequest("GET", url, nil)
 	if err != nil {
 		return 0, err
 	}
 	okFunc := func(status int, response []byte) (bool, error) { return status/100 == 2, nil }
-	return wait(okFunc, func() *http.Request { return req }, &s.TLSOptions.KeyPair, timeout)
+	status, tlsState, err := waitWithTLSState(ctx, okFunc, fixedRequest(req), &s.TLSOptions, timeout, s.RetryInterval)
+	s.checkFailure(err)
+	s.serverCertificate = nil
+	if tlsState != nil && len(tlsState.PeerCertificates) > 0 {
+		s.serverCertificate = tlsState.PeerCertificates[0]
+	}
+	return status, err
+}
+
+// ApplicationGeneration returns the config generation of the application running on this service, as reported by its
+// application status. The status is requested once, and the request fails if no response arrives within timeout.
+func (s *Service) ApplicationGeneration(timeout time.Duration) (int64, error) {
+	if s.Name != queryService && s.Name != documentService {
+		return 0, fmt.Errorf("invalid service: %s", s.Name)
+	}
+	req, err := http.NewRequest("GET", s.BaseURL+"/ApplicationStatus", nil)
+	if err != nil {
+		return 0, err
+	}
+	response, err := s.Do(req, timeout)
+	if err != nil {
+		return 0, err
+	}
+	defer response.Body.Close()
+	if response.StatusCode/100 != 2 {
+		return 0, fmt.Errorf("status %d", response.StatusCode)
+	}
+	var status applicationStatusResponse
+	if err := json.NewDecoder(response.Body).Decode(&status); err != nil {
+		return 0, fmt.Errorf("invalid application status: %w", err)
+	}
+	return status.Application.Meta.Generation, nil
+}
+
+// ServerCertificate returns the certificate presented by this service in the last health check made by Wait, or nil
+// if the service did not present one.
+func (s *Service) ServerCertificate() *x509.Certificate { return s.serverCertificate }
+
+// WaitForVisible polls the document API of this service until the document with ID docID can be retrieved, timeout
+// passes, or ctx is done. Requests failing because the document API is not yet ready are retried.
+func (s *Service) WaitForVisible(ctx context.Context, docID string, timeout time.Duration) error {
+	if s.Name != documentService && s.Name != queryService {
+		return fmt.Errorf("invalid service: %s", s.Name)
+	}
+	documentPath, err := IdToURLPath(docID)
+	if err != nil {
+		return fmt.Errorf("invalid document id '%s': %w", docID, err)
+	}
+	req, err := http.NewRequest("GET", s.BaseURL+"/document/v1/"+documentPath, nil)
+	if err != nil {
+		return err
+	}
+	visibleFunc := func(status int, response []byte) (bool, error) {
+		return status == 200, nil // 404 until the document is visible, and 5xx until the document API is ready
+	}
+	status, err := wait(ctx, visibleFunc, fixedRequest(req), &s.TLSOptions, timeout, s.RetryInterval)
+	if err != nil {
+		return err
+	}
+	switch status {
+	case 200:
+		return nil
+	case 404:
+		return fmt.Errorf("document %s is not visible in %s", docID, s.Description())
+	}
+	return fmt.Errorf("%s at %s is not ready: status %d", s.Description(), s.BaseURL, status)
+}
+
+// WaitForDocuments polls the query API of this service until it reports at least min documents, timeout passes, or
+// ctx is done. Requests failing because the query API is not yet ready are retried.
+func (s *Service) WaitForDocuments(ctx context.Context, min int, timeout time.Duration) error {
+	if s.Name != queryService {
+		return fmt.Errorf("invalid service: %s", s.Name)
+	}
+	u, err := url.Parse(s.BaseURL + "/search/")
+	if err != nil {
+		return err
+	}
+	q := u.Query()
+	q.Set("yql", "select * from sources * where true limit 0;")
+	u.RawQuery = q.Encode()
+	req, err := http.NewRequest("GET", u.String(), nil)
+	if err != nil {
+		return err
+	}
+	count := -1
+	countFunc := func(status int, response []byte) (bool, error) {
+		if status/100 != 2 {
+			return false, nil // Query service not ready yet
+		}
+		var resp queryCountResponse
+		if err := json.Unmarshal(response, &resp); err != nil {
+			return false, nil
+		}
+		count = resp.Root.Fields.TotalCount
+		return count >= min, nil
+	}
+	if _, err := wait(ctx, countFunc, fixedRequest(req), &s.TLSOptions, timeout, s.RetryInterval); err != nil {
+		return err
+	}
+	if count < 0 {
+		return fmt.Errorf("could not get document count from %s", s.Description())
+	}
+	if count < min {
+		return fmt.Errorf("found %d documents, want at least %d", count, min)
+	}
+	return nil
 }
 
 func (s *Service) Description() string {
@@ -128,9 +474,9 @@ func (s *Service) Description() string {
 
 func (t *customTarget) Type() string { return t.targetType }
 
-func (t *customTarget) Service(name string, timeout time.Duration, sessionOrRunID int64, cluster string) (*Service, error) {
+func (t *customTarget) Service(ctx context.Context, name string, timeout time.Duration, sessionOrRunID int64, cluster string) (*Service, error) {
 	if timeout > 0 && name != deployService {
-		if err := t.waitForConvergence(timeout); err != nil {
+		if err := t.waitForConvergence(ctx, timeout, t.stableFor); err != nil {
 			return nil, err
 		}
 	}
@@ -140,13 +486,76 @@ func (t *customTarget) Service(name string, timeout time.Duration, sessionOrRunI
 		if err != nil {
 			return nil, err
 		}
-		return &Service{BaseURL: url, Name: name}, nil
+		return &Service{BaseURL: url, Name: name, TLSOptions: t.tlsOptions, RetryInterval: t.retryInterval}, nil
 	}
 	return nil, fmt.Errorf("unknown service: %s", name)
 }
 
-func (t *customTarget) PrintLog(options LogOptions) error {
-	return fmt.Errorf("reading logs from non-cloud deployment is currently unsupported")
+func (t *customTarget) PrintLog(ctx context.Context, options LogOptions) error {
+	applicationURL, err := t.applicationURL(ctx)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest("GET", applicationURL+"/logs", nil)
+	if err != nil {
+		return err
+	}
+	return printLogs(ctx, req, nil, &t.tlsOptions, options)
+}
+
+func (t *customTarget) Runs(ctx context.Context, limit int) ([]RunSummary, error) {
+	return nil, fmt.Errorf("listing runs of non-cloud deployment is unsupported")
+}
+
+func (t *customTarget) FetchRunLog(ctx context.Context, runID, after int64) ([]JobLogEntry, int64, error) {
+	return nil, 0, fmt.Errorf("reading run logs of non-cloud deployment is unsupported")
+}
+
+func (t *customTarget) Clusters(ctx context.Context, timeout time.Duration) ([]Cluster, error) {
+	deploy, err := t.Service(ctx, deployService, 0, 0, "")
+	if err != nil {
+		return nil, err
+	}
+	container, err := t.Service(ctx, queryService, 0, 0, "")
+	if err != nil {
+		return nil, err
+	}
+	return []Cluster{
+		{Name: "config", Type: deployService, Service: deploy},
+		{Name: "default", Type: "container", Service: container},
+	}, nil
+}
+
+func (t *customTarget) Versions(ctx context.Context) (DeploymentVersions, error) {
+	applicationURL, err := t.applicationURL(ctx)
+	if err != nil {
+		return DeploymentVersions{}, err
+	}
+	req, err := http.NewRequest("GET", applicationURL, nil)
+	if err != nil {
+		return DeploymentVersions{}, err
+	}
+	var resp applicationResponse
+	if err := readVersions(ctx, req, &t.tlsOptions, &resp); err != nil {
+		return DeploymentVersions{}, err
+	}
+	return resp.versions(), nil
+}
+
+func (t *customTarget) PackageVersion(ctx context.Context) (string, error) {
+	applicationURL, err := t.applicationURL(ctx)
+	if err != nil {
+		return "", err
+	}
+	req, err := http.NewRequest("GET", applicationURL+"/content/"+BuildMetaFile, nil)
+	if err != nil {
+		return "", err
+	}
+	return readPackageVersion(ctx, req, &t.tlsOptions)
+}
+
+func (t *customTarget) NodeFlavors(ctx context.Context) ([]Flavor, error) {
+	return nil, fmt.Errorf("listing node flavors of non-cloud target is unsupported")
 }
 
 func (t *customTarget) urlWithPort(serviceName string) (string, error) {
@@ -169,17 +578,20 @@ func (t *customTarget) urlWithPort(serviceName string) (string, error) {
 	return u.String(), nil
 }
 
-func (t *customTarget) waitForConvergence(timeout time.Duration) error {
-	deployer, err := t.Service(deployService, 0, 0, "")
+// waitForConvergence waits until services have converged on the latest deployment, and have stayed converged for at
+// least stableFor. A response reporting that services are not converged restarts the stable period.
+func (t *customTarget) waitForConvergence(ctx context.Context, timeout, stableFor time.Duration) error {
+	applicationURL, err := t.applicationURL(ctx)
 	if err != nil {
 		return err
 	}
-	url := fmt.Sprintf("%s/application/v2/tenant/default/application/default/environment/prod/region/default/instance/default/serviceconverge", deployer.BaseURL)
-	req, err := http.NewRequest("GET", url, nil)
+	req, err := http.NewRequest("GET", applicationURL+"/serviceconverge", nil)
 	if err != nil {
 		return err
 	}
 	converged := false
+	var convergedSince time.Time
+	var lastResponse serviceConvergeResponse
 	convergedFunc := func(status int, response []byte) (bool, error) {
 		if status/100 != 2 {
 			return false, nil
@@ -188,13 +600,28 @@ func (t *customTarget) waitForConvergence(timeout time.Duration) error {
 		if err := json.Unmarshal(response, &resp); err != nil {
 			return false, nil
 		}
-		converged = resp.Converged
+		lastResponse = resp
+		if !resp.Converged {
+			convergedSince = time.Time{}
+			converged = false
+			return false, nil
+		}
+		if convergedSince.IsZero() {
+			convergedSince = time.Now()
+		}
+		converged = time.Since(convergedSince) >= stableFor
 		return converged, nil
 	}
-	if _, err := wait(convergedFunc, func() *http.Request { return req }, nil, timeout); err != nil {
+	if _, err := wait(ctx, convergedFunc, fixedRequest(req), &t.tlsOptions, timeout, t.retryInterval); err != nil {
 		return err
 	}
 	if !converged {
+		if !convergedSince.IsZero() {
+			return fmt.Errorf("services have not been converged for %s", stableFor)
+		}
+		if lagging := lastResponse.lagging(); len(lagging) > 0 {
+			return fmt.Errorf("services have not converged: %s", strings.Join(lagging, ", "))
+		}
 		return fmt.Errorf("services have not converged")
 	}
 	return nil
@@ -208,10 +635,19 @@ type cloudTarget struct {
 	tlsOptions TLSOptions
 	logOptions LogOptions
 
-	urlsByCluster  map[string]string
-	authConfigPath string
-	systemName     string
-	cloudAuth      string
+	urlsByCluster map[string]string
+	endpointCache EndpointCache
+	// endpointsCached is whether urlsByCluster was read from endpointCache
+	endpointsCached bool
+	flavors         []Flavor
+	auth0           *auth0.Auth0
+	cloudAuth       string
+
+	syncClock   bool
+	clockSynced bool
+	clockOffset time.Duration
+
+	retryInterval time.Duration
 }
 
 func (t *cloudTarget) resolveEndpoint(cluster string) (string, error) {
@@ -240,82 +676,163 @@ func (t *cloudTarget) resolveEndpoint(cluster string) (string, error) {
 
 func (t *cloudTarget) Type() string { return t.targetType }
 
-func (t *cloudTarget) Service(name string, timeout time.Duration, runID int64, cluster string) (*Service, error) {
+func (t *cloudTarget) Service(ctx context.Context, name string, timeout time.Duration, runID int64, cluster string) (*Service, error) {
 	if name != deployService && t.urlsByCluster == nil {
-		if err := t.waitForEndpoints(timeout, runID); err != nil {
+		if err := t.waitForEndpoints(ctx, timeout, runID, cluster); err != nil {
 			return nil, err
 		}
 	}
 	switch name {
 	case deployService:
-		return &Service{Name: name, BaseURL: t.apiURL}, nil
-	case queryService:
-		queryURL, err := t.resolveEndpoint(cluster)
+		return &Service{Name: name, BaseURL: t.apiURL, RetryInterval: t.retryInterval}, nil
+	case queryService, documentService:
+		serviceURL, err := t.resolveEndpoint(cluster)
 		if err != nil {
 			return nil, err
 		}
-		return &Service{Name: name, BaseURL: queryURL, TLSOptions: t.tlsOptions}, nil
-	case documentService:
-		documentURL, err := t.resolveEndpoint(cluster)
-		if err != nil {
-			return nil, err
+		service := &Service{Name: name, BaseURL: serviceURL, TLSOptions: t.tlsOptions, RetryInterval: t.retryInterval}
+		if t.endpointsCached {
+			service.invalidate = func() { t.endpointCache.Remove(t.deployment) }
 		}
-		return &Service{Name: name, BaseURL: documentURL, TLSOptions: t.tlsOptions}, nil
+		return service, nil
 	}
 	return nil, fmt.Errorf("unknown service: %s", name)
 }
 
-func (t *cloudTarget) PrepareApiRequest(req *http.Request, sigKeyId string) error {
-	if Auth0AccessTokenEnabled() {
-		if t.cloudAuth == "access-token" {
-			if err := t.addAuth0AccessToken(req); err != nil {
-				return err
-			}
-		} else {
-			if t.apiKey == nil {
-				return fmt.Errorf("Deployment to cloud requires an API key. Try 'vespa api-key'")
-			}
-			signer := NewRequestSigner(sigKeyId, t.apiKey)
-			if err := signer.SignRequest(req); err != nil {
-				return err
-			}
+func (t *cloudTarget) SetRetryInterval(interval time.Duration) { t.retryInterval = interval }
+
+func (t *cloudTarget) SetRunLogWriter(w io.Writer) { t.logOptions.Writer = w }
+
+// WaitRequest sends a request with method to path on the controller API, until until returns true for the response
+// body, timeout passes, or ctx is done. The request is authenticated anew before each attempt.
+func (t *cloudTarget) WaitRequest(ctx context.Context, method, path string, until func(response []byte) (bool, error), timeout time.Duration) error {
+	req, err := http.NewRequest(method, t.apiURL+path, nil)
+	if err != nil {
+		return err
+	}
+	// Authenticate each request, as the signature or access token of a previous request may have expired
+	requestFunc := func() (*http.Request, error) {
+		if err := t.PrepareApiRequest(req, t.deployment.Application.SerializedForm()); err != nil {
+			return nil, err
 		}
-	} else {
-		signer := NewRequestSigner(sigKeyId, t.apiKey)
-		if err := signer.SignRequest(req); err != nil {
+		return req, nil
+	}
+	return waitRequest(ctx, requestFunc, until, &t.tlsOptions, timeout, t.retryInterval)
+}
+
+// PrepareApiRequest authenticates req using the authentication method of this target. An access token is used if
+// cloudAuth is "access-token", and the client certificate of this target is presented if cloudAuth is "cert".
+// Otherwise the request is signed with the API key, identified by sigKeyId.
+func (t *cloudTarget) PrepareApiRequest(req *http.Request, sigKeyId string) error {
+	switch t.cloudAuth {
+	case "access-token":
+		return t.addAuth0AccessToken(req)
+	case "cert":
+		return t.useClientCertificate()
+	}
+	if t.apiKey == nil {
+		return fmt.Errorf("Deployment to cloud requires an API key. Try 'vespa api-key'")
+	}
+	return t.signRequest(req, sigKeyId)
+}
+
+// useClientCertificate configures the HTTP client to present the client certificate of this target, for
+// authentication of API requests by mutual TLS.
+func (t *cloudTarget) useClientCertificate() error {
+	if len(t.tlsOptions.KeyPair.Certificate) == 0 {
+		return fmt.Errorf("certificate authentication is not configured")
+	}
+	util.ActiveHttpClient.UseCertificate(t.tlsOptions.certificates())
+	return useCACertificates(&t.tlsOptions)
+}
+
+func (t *cloudTarget) signRequest(req *http.Request, sigKeyId string) error {
+	if t.syncClock && !t.clockSynced {
+		offset, err := ClockOffset(t.apiURL)
+		if err != nil {
 			return err
 		}
+		t.clockOffset = offset
+		t.clockSynced = true
 	}
-	return nil
+	signer := NewRequestSigner(sigKeyId, t.apiKey)
+	signer.ClockOffset = t.clockOffset
+	return signer.SignRequest(req)
 }
 
-func (t *cloudTarget) addAuth0AccessToken(request *http.Request) error {
-	a, err := auth0.GetAuth0(t.authConfigPath, t.systemName, t.apiURL)
+// ClockOffset returns the difference between the time of the server at url, as given by the Date header of its
+// response, and the local time.
+func ClockOffset(url string) (time.Duration, error) {
+	req, err := http.NewRequest("HEAD", url, nil)
 	if err != nil {
-		return err
+		return 0, err
 	}
-	system, err := a.PrepareSystem(auth0.ContextWithCancel())
+	start := time.Now()
+	response, err := util.HttpDo(req, 10*time.Second, "")
 	if err != nil {
+		return 0, fmt.Errorf("could not synchronize clock with %s: %w", url, err)
+	}
+	response.Body.Close()
+	end := time.Now()
+	date, err := http.ParseTime(response.Header.Get("Date"))
+	if err != nil {
+		return 0, fmt.Errorf("could not synchronize clock with %s: invalid date header: %q", url, response.Header.Get("Date"))
+	}
+	// The Date header has a resolution of one second, so assume the server time is in the middle of that second
+	serverTime := date.Add(500 * time.Millisecond)
+	localTime := start.Add(end.Sub(start) / 2)
+	return serverTime.Sub(localTime).Round(time.Second), nil
+}
+
+func (t *cloudTarget) addAuth0AccessToken(request *http.Request) error {
+	if t.auth0 == nil {
+		return fmt.Errorf("access token authentication is not configured")
+	}
+	system, err := t.auth0.PrepareSystem(auth0.ContextWithCancel())
+	if errors.Is(err, auth0.ErrTokenExpired) {
+		return unauthorizedError{err}
+	} else if err != nil {
 		return err
 	}
 	request.Header.Set("Authorization", "Bearer "+system.AccessToken)
 	return nil
 }
 
-func (t *cloudTarget) logsURL() string {
-	return fmt.Sprintf("%s/application/v4/tenant/%s/application/%s/instance/%s/environment/%s/region/%s/logs",
-		t.apiURL,
-		t.deployment.Application.Tenant, t.deployment.Application.Application, t.deployment.Application.Instance,
-		t.deployment.Zone.Environment, t.deployment.Zone.Region)
-}
+func (t *cloudTarget) logsURL() string { return t.deploymentURL() + "/logs" }
 
-func (t *cloudTarget) PrintLog(options LogOptions) error {
+func (t *cloudTarget) PrintLog(ctx context.Context, options LogOptions) error {
 	req, err := http.NewRequest("GET", t.logsURL(), nil)
 	if err != nil {
 		return err
 	}
+	prepare := func(req *http.Request) error {
+		return t.PrepareApiRequest(req, t.deployment.Application.SerializedForm())
+	}
+	return printLogs(ctx, req, prepare, &t.tlsOptions, options)
+}
+
+// printLogs reads logs using req, and writes them using given options. The request is passed to prepare, if non-nil,
+// before it's sent, and an error returned by prepare stops reading. When following logs, requests are repeated, each
+// reading logs after the last entry read, until ctx is done.
+//
+// A partial response (206) may end in the middle of a line. That line is left out, and read in full by the next
+// request, which is made also when not following logs.
+func printLogs(ctx context.Context, req *http.Request, prepare func(*http.Request) error, tlsOptions *TLSOptions, options LogOptions) error {
+	messageFilter, componentFilter, err := options.filters()
+	if err != nil {
+		return err
+	}
 	lastFrom := options.From
-	requestFunc := func() *http.Request {
+	generations := make(map[string]int64) // Current config generation by host
+	written := 0
+	backfill := true   // Whether the logs read are the initial ones, to which MaxLines applies
+	truncated := false // Whether the last response ended in an incomplete line
+	limited := false   // Whether entries were left out due to MaxLines
+	var tail *logTail  // The last entries of the backfill, when following logs
+	if options.Follow && options.MaxLines > 0 {
+		tail = newLogTail(options.MaxLines)
+	}
+	requestFunc := func() (*http.Request, error) {
 		fromMillis := lastFrom.Unix() * 1000
 		q := req.URL.Query()
 		q.Set("from", strconv.FormatInt(fromMillis, 10))
@@ -324,14 +841,27 @@ func (t *cloudTarget) PrintLog(options LogOptions) error {
 			q.Set("to", strconv.FormatInt(toMillis, 10))
 		}
 		req.URL.RawQuery = q.Encode()
-		t.PrepareApiRequest(req, t.deployment.Application.SerializedForm())
-		return req
+		if prepare != nil {
+			if err := prepare(req); err != nil {
+				return nil, err
+			}
+		}
+		return req, nil
 	}
 	logFunc := func(status int, response []byte) (bool, error) {
 		if ok, err := isOK(status); !ok {
 			return ok, err
 		}
-		logEntries, err := ReadLogEntries(bytes.NewReader(response))
+		data := response
+		truncated = false
+		if status == http.StatusPartialContent {
+			if i := bytes.LastIndexByte(data, '\n'); i < len(data)-1 {
+				data = data[:i+1]
+				truncated = true
+			}
+		}
+		// Entries missing some fields can still be written as JSON, without those fields
+		logEntries, err := readLogEntries(bytes.NewReader(data), options.Format == FormatJSON)
 		if err != nil {
 			return true, err
 		}
@@ -339,51 +869,244 @@ func (t *cloudTarget) PrintLog(options LogOptions) error {
 			if !le.Time.After(lastFrom) {
 				continue
 			}
-			if LogLevel(le.Level) > options.Level {
+			if generation, ok := le.ConfigGeneration(); ok {
+				generations[le.Host] = generation
+			}
+			if le.Level != "" && options.level(le.Level) > options.Level {
+				continue
+			}
+			if !le.MatchesComponent(options.Component) {
+				continue
+			}
+			if !le.MatchesHost(options.Host) {
+				continue
+			}
+			if componentFilter != nil && !componentFilter.MatchString(le.Component) {
 				continue
 			}
-			fmt.Fprintln(options.Writer, le.Format(options.Dequote))
+			if messageFilter != nil && !messageFilter.MatchString(le.Message) {
+				continue
+			}
+			if backfill && tail == nil && options.MaxLines > 0 && written >= options.MaxLines {
+				if options.Truncated != nil {
+					options.Truncated()
+				}
+				limited = true
+				break
+			}
+			var line string
+			if options.Format == FormatOTel {
+				if line, err = le.FormatOTel(options.Dequote); err != nil {
+					return true, err
+				}
+			} else if options.Format == FormatJSON {
+				if line, err = le.FormatJSON(options.Dequote); err != nil {
+					return true, err
+				}
+			} else if options.ShowGeneration {
+				line = FormatGeneration(generations[le.Host]) + " " + le.Format(options.Dequote)
+			} else {
+				line = le.Format(options.Dequote)
+			}
+			if backfill && tail != nil {
+				tail.add(line)
+				continue
+			}
+			written++
+			fmt.Fprintln(options.Writer, line)
 		}
 		if len(logEntries) > 0 {
 			lastFrom = logEntries[len(logEntries)-1].Time
 		}
+		if backfill && (!truncated || limited) {
+			backfill = false
+			if tail != nil {
+				tail.writeTo(options.Writer)
+				if tail.dropped && options.Truncated != nil {
+					options.Truncated()
+				}
+			}
+		}
 		return false, nil
 	}
 	var timeout time.Duration
 	if options.Follow {
 		timeout = math.MaxInt64 // No timeout
 	}
-	_, err = wait(logFunc, requestFunc, &t.tlsOptions.KeyPair, timeout)
-	return err
+	for {
+		from := lastFrom
+		if _, err := wait(ctx, logFunc, requestFunc, tlsOptions, timeout, 0); err != nil || options.Follow {
+			return err
+		}
+		if !truncated || limited || !lastFrom.After(from) {
+			return nil
+		}
+	}
 }
 
-func (t *cloudTarget) waitForEndpoints(timeout time.Duration, runID int64) error {
+func (t *cloudTarget) Runs(ctx context.Context, limit int) ([]RunSummary, error) {
+	jobURL := fmt.Sprintf("%s/application/v4/tenant/%s/application/%s/instance/%s/job/%s-%s",
+		t.apiURL,
+		t.deployment.Application.Tenant, t.deployment.Application.Application, t.deployment.Application.Instance,
+		t.deployment.Zone.Environment, t.deployment.Zone.Region)
+	req, err := http.NewRequest("GET", jobURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	if err := t.PrepareApiRequest(req, t.deployment.Application.SerializedForm()); err != nil {
+		return nil, err
+	}
+	var runs []RunSummary
+	runsFunc := func(status int, response []byte) (bool, error) {
+		if ok, err := isOK(status); !ok {
+			if err == nil {
+				err = fmt.Errorf("status %d", status)
+			}
+			return false, err
+		}
+		var resp jobRunsResponse
+		if err := json.Unmarshal(response, &resp); err != nil {
+			return false, err
+		}
+		for _, r := range resp.Runs {
+			runs = append(runs, RunSummary{
+				ID:      r.ID,
+				Status:  r.Status,
+				Start:   time.Unix(0, r.Start*int64(time.Millisecond)),
+				Version: r.Versions.TargetPlatform,
+			})
+		}
+		return true, nil
+	}
+	if _, err := wait(ctx, runsFunc, fixedRequest(req), &t.tlsOptions, 0, 0); err != nil {
+		return nil, err
+	}
+	sort.Slice(runs, func(i, j int) bool { return runs[i].ID > runs[j].ID })
+	if limit > 0 && len(runs) > limit {
+		runs = runs[:limit]
+	}
+	return runs, nil
+}
+
+func (t *cloudTarget) Clusters(ctx context.Context, timeout time.Duration) ([]Cluster, error) {
+	if t.urlsByCluster == nil {
+		if err := t.waitForEndpoints(ctx, timeout, 0, ""); err != nil {
+			return nil, err
+		}
+	}
+	clusters := make([]Cluster, 0, len(t.urlsByCluster))
+	for name, url := range t.urlsByCluster {
+		service := &Service{Name: queryService, BaseURL: url, TLSOptions: t.tlsOptions, RetryInterval: t.retryInterval}
+		clusters = append(clusters, Cluster{Name: name, Type: "container", Service: service})
+	}
+	sort.Slice(clusters, func(i, j int) bool { return clusters[i].Name < clusters[j].Name })
+	return clusters, nil
+}
+
+func (t *cloudTarget) NodeFlavors(ctx context.Context) ([]Flavor, error) {
+	if t.flavors != nil {
+		return t.flavors, nil
+	}
+	req, err := http.NewRequest("GET", t.apiURL+"/flavors/v1/", nil)
+	if err != nil {
+		return nil, err
+	}
+	if err := t.PrepareApiRequest(req, t.deployment.Application.SerializedForm()); err != nil {
+		return nil, err
+	}
+	var flavors []Flavor
+	flavorsFunc := func(status int, response []byte) (bool, error) {
+		if ok, err := isOK(status); !ok {
+			if err == nil {
+				err = fmt.Errorf("status %d", status)
+			}
+			return false, err
+		}
+		var resp flavorsResponse
+		if err := json.Unmarshal(response, &resp); err != nil {
+			return false, err
+		}
+		flavors = resp.Flavors
+		return true, nil
+	}
+	if _, err := wait(ctx, flavorsFunc, fixedRequest(req), &t.tlsOptions, 0, 0); err != nil {
+		return nil, err
+	}
+	if flavors == nil {
+		flavors = []Flavor{}
+	}
+	t.flavors = flavors // Cache flavors for the lifetime of this target
+	return flavors, nil
+}
+
+func (t *cloudTarget) waitForEndpoints(ctx context.Context, timeout time.Duration, runID int64, cluster string) error {
 	if runID > 0 {
-		if err := t.waitForRun(runID, timeout); err != nil {
+		if err := t.waitForRun(ctx, runID, timeout); err != nil {
 			return err
 		}
+	} else if t.endpointCache != nil {
+		// Endpoints may change with a deployment run, so the cache is only used when not waiting for one
+		if urlsByCluster, ok := t.endpointCache.Read(t.deployment); ok {
+			if _, found := urlsByCluster[cluster]; cluster == "" || found {
+				t.urlsByCluster = urlsByCluster
+				t.endpointsCached = true
+				return nil
+			}
+		}
+	}
+	if err := t.discoverEndpoints(ctx, timeout, cluster); err != nil {
+		return err
+	}
+	// Discovery of a given cluster stops once that cluster is found, so only endpoints of all clusters are cached
+	if t.endpointCache != nil && cluster == "" {
+		t.endpointCache.Write(t.deployment, t.urlsByCluster)
 	}
-	return t.discoverEndpoints(timeout)
+	return nil
 }
 
-func (t *cloudTarget) waitForRun(runID int64, timeout time.Duration) error {
-	runURL := fmt.Sprintf("%s/application/v4/tenant/%s/application/%s/instance/%s/job/%s-%s/run/%d",
+func (t *cloudTarget) runURL(runID int64) string {
+	return fmt.Sprintf("%s/application/v4/tenant/%s/application/%s/instance/%s/job/%s-%s/run/%d",
 		t.apiURL,
 		t.deployment.Application.Tenant, t.deployment.Application.Application, t.deployment.Application.Instance,
 		t.deployment.Zone.Environment, t.deployment.Zone.Region, runID)
-	req, err := http.NewRequest("GET", runURL, nil)
+}
+
+func (t *cloudTarget) FetchRunLog(ctx context.Context, runID, after int64) ([]JobLogEntry, int64, error) {
+	req, err := http.NewRequest("GET", t.runURL(runID), nil)
+	if err != nil {
+		return nil, 0, err
+	}
+	q := req.URL.Query()
+	q.Set("after", strconv.FormatInt(after, 10))
+	req.URL.RawQuery = q.Encode()
+	if err := t.PrepareApiRequest(req, t.deployment.Application.SerializedForm()); err != nil {
+		return nil, 0, err
+	}
+	var resp jobResponse
+	if err := readJSON(ctx, req, &t.tlsOptions, "run response", &resp); err != nil {
+		return nil, 0, err
+	}
+	last := resp.LastID
+	if last == 0 {
+		last = after
+	}
+	return resp.entries(), last, nil
+}
+
+func (t *cloudTarget) waitForRun(ctx context.Context, runID int64, timeout time.Duration) error {
+	req, err := http.NewRequest("GET", t.runURL(runID), nil)
 	if err != nil {
 		return err
 	}
 	lastID := int64(-1)
-	requestFunc := func() *http.Request {
+	requestFunc := func() (*http.Request, error) {
 		q := req.URL.Query()
 		q.Set("after", strconv.FormatInt(lastID, 10))
 		req.URL.RawQuery = q.Encode()
 		if err := t.PrepareApiRequest(req, t.deployment.Application.SerializedForm()); err != nil {
-			panic(err)
+			return nil, err
 		}
-		return req
+		return req, nil
 	}
 	jobSuccessFunc := func(status int, response []byte) (bool, error) {
 		if ok, err := isOK(status); !ok {
@@ -400,11 +1123,11 @@ func (t *cloudTarget) waitForRun(runID int64, timeout time.Duration) error {
 			return false, nil
 		}
 		if resp.Status != "success" {
-			return false, fmt.Errorf("run %d ended with unsuccessful status: %s", runID, resp.Status)
+			return false, fmt.Errorf("run %d %w: %s", runID, ErrRunFailed, resp.Status)
 		}
 		return true, nil
 	}
-	_, err = wait(jobSuccessFunc, requestFunc, &t.tlsOptions.KeyPair, timeout)
+	_, err = wait(ctx, jobSuccessFunc, requestFunc, &t.tlsOptions, timeout, t.retryInterval)
 	return err
 }
 
@@ -412,30 +1135,142 @@ func (t *cloudTarget) printLog(response jobResponse, last int64) int64 {
 	if response.LastID == 0 {
 		return last
 	}
-	var msgs []logMessage
-	for step, stepMsgs := range response.Log {
-		for _, msg := range stepMsgs {
-			if step == "copyVespaLogs" && LogLevel(msg.Type) > t.logOptions.Level || LogLevel(msg.Type) == 3 {
-				continue
+	for _, entry := range response.entries() {
+		if entry.Step == "copyVespaLogs" && t.logOptions.level(entry.Type) > t.logOptions.Level || LogLevel(entry.Type) == 3 {
+			continue
+		}
+		fmt.Fprintf(t.logOptions.Writer, "[%s] %-7s %s\n", entry.At.Format("15:04:05"), entry.Type, entry.Message)
+	}
+	return response.LastID
+}
+
+func (t *cloudTarget) Versions(ctx context.Context) (DeploymentVersions, error) {
+	req, err := http.NewRequest("GET", t.deploymentURL(), nil)
+	if err != nil {
+		return DeploymentVersions{}, err
+	}
+	if err := t.PrepareApiRequest(req, t.deployment.Application.SerializedForm()); err != nil {
+		return DeploymentVersions{}, err
+	}
+	var resp deploymentResponse
+	if err := readVersions(ctx, req, &t.tlsOptions, &resp); err != nil {
+		return DeploymentVersions{}, err
+	}
+	return resp.versions(), nil
+}
+
+func (t *cloudTarget) PackageVersion(ctx context.Context) (string, error) {
+	req, err := http.NewRequest("GET", t.deploymentURL()+"/content/"+BuildMetaFile, nil)
+	if err != nil {
+		return "", err
+	}
+	if err := t.PrepareApiRequest(req, t.deployment.Application.SerializedForm()); err != nil {
+		return "", err
+	}
+	return readPackageVersion(ctx, req, &t.tlsOptions)
+}
+
+// readPackageVersion reads the build metadata of an application package using req with ctx, and returns the version declared
+// in it. A missing deployment or file declares no version.
+func readPackageVersion(ctx context.Context, req *http.Request, tlsOptions *TLSOptions) (string, error) {
+	var meta buildMeta
+	if err := readJSON(ctx, req, tlsOptions, BuildMetaFile, &meta); err != nil {
+		if isStatus(err, 404) {
+			return "", nil
+		}
+		return "", err
+	}
+	return meta.Version, nil
+}
+
+// readVersions sends req with ctx, and decodes the response into result.
+func readVersions(ctx context.Context, req *http.Request, tlsOptions *TLSOptions, result interface{}) error {
+	err := readJSON(ctx, req, tlsOptions, "deployment response", result)
+	if isStatus(err, 404) {
+		return ErrNotDeployed
+	}
+	return err
+}
+
+// statusError is the error of a request which got a response with an unsuccessful status.
+type statusError struct {
+	status int
+	body   []byte
+}
+
+func (e *statusError) Error() string { return fmt.Sprintf("status %d: %s", e.status, e.body) }
+
+// isStatus returns whether err is a statusError with given status.
+func isStatus(err error, status int) bool {
+	var statusErr *statusError
+	return errors.As(err, &statusErr) && statusErr.status == status
+}
+
+// readJSON sends req once with ctx, and decodes the JSON body of a successful response into result, if non-nil. The response
+// is called description in decoding errors. An unsuccessful response is a statusError, unless authentication failed.
+func readJSON(ctx context.Context, req *http.Request, tlsOptions *TLSOptions, description string, result interface{}) error {
+	responseFunc := func(status int, response []byte) (bool, error) {
+		if ok, err := isOK(status); !ok {
+			if err == nil {
+				err = &statusError{status: status, body: response}
 			}
-			msgs = append(msgs, msg)
+			return false, err
 		}
+		if result != nil {
+			if err := json.Unmarshal(response, result); err != nil {
+				return false, fmt.Errorf("invalid %s: %w", description, err)
+			}
+		}
+		return true, nil
 	}
-	sort.Slice(msgs, func(i, j int) bool { return msgs[i].At < msgs[j].At })
-	for _, msg := range msgs {
-		tm := time.Unix(msg.At/1000, (msg.At%1000)*1000)
-		fmtTime := tm.Format("15:04:05")
-		fmt.Fprintf(t.logOptions.Writer, "[%s] %-7s %s\n", fmtTime, msg.Type, msg.Message)
+	_, err := wait(ctx, responseFunc, fixedRequest(req), tlsOptions, 0, 0)
+	return err
+}
+
+// waitRequest sends the request returned by reqFn until until returns true for the response body, timeout passes, or
+// ctx is done.
+// Responses with a server error status are retried, while other responses with a non-2xx status fail immediately. The
+// interval between requests starts at interval, and doubles after each request, up to maxBackoffInterval.
+func waitRequest(ctx context.Context, reqFn requestFunc, until func(response []byte) (bool, error), tlsOptions *TLSOptions, timeout, interval time.Duration) error {
+	var req *http.Request
+	requestFunc := func() (*http.Request, error) {
+		r, err := reqFn()
+		req = r
+		return r, err
 	}
-	return response.LastID
+	done := false
+	var lastStatus int
+	responseFunc := func(status int, response []byte) (bool, error) {
+		lastStatus = status
+		if status/100 == 5 {
+			return false, nil
+		}
+		if ok, err := isOK(status); !ok {
+			if err == nil {
+				err = fmt.Errorf("status %d: %s", status, response)
+			}
+			return false, err
+		}
+		ok, err := until(response)
+		done = ok
+		return ok, err
+	}
+	if _, _, err := poll(ctx, responseFunc, requestFunc, tlsOptions, timeout, interval, maxBackoffInterval); err != nil {
+		return err
+	}
+	if !done {
+		if lastStatus/100 == 5 {
+			return fmt.Errorf("gave up waiting on %s %s: status %d", req.Method, req.URL, lastStatus)
+		}
+		return fmt.Errorf("gave up waiting on %s %s: condition not met", req.Method, req.URL)
+	}
+	return nil
 }
 
-func (t *cloudTarget) discoverEndpoints(timeout time.Duration) error {
-	deploymentURL := fmt.Sprintf("%s/application/v4/tenant/%s/application/%s/instance/%s/environment/%s/region/%s",
-		t.apiURL,
-		t.deployment.Application.Tenant, t.deployment.Application.Application, t.deployment.Application.Instance,
-		t.deployment.Zone.Environment, t.deployment.Zone.Region)
-	req, err := http.NewRequest("GET", deploymentURL, nil)
+// discoverEndpoints waits for the endpoints of this deployment to be discovered. If cluster is non-empty, this returns as
+// soon as the endpoint of that cluster is discovered, even if endpoints of other clusters are not yet available.
+func (t *cloudTarget) discoverEndpoints(ctx context.Context, timeout time.Duration, cluster string) error {
+	req, err := http.NewRequest("GET", t.deploymentURL(), nil)
 	if err != nil {
 		return err
 	}
@@ -444,6 +1279,9 @@ func (t *cloudTarget) discoverEndpoints(timeout time.Duration) error {
 	}
 	urlsByCluster := make(map[string]string)
 	endpointFunc := func(status int, response []byte) (bool, error) {
+		if status == 404 {
+			return false, fmt.Errorf("%s: %w", t.deployment, ErrNotDeployed)
+		}
 		if ok, err := isOK(status); !ok {
 			return ok, err
 		}
@@ -460,9 +1298,13 @@ func (t *cloudTarget) discoverEndpoints(timeout time.Duration) error {
 			}
 			urlsByCluster[endpoint.Cluster] = endpoint.URL
 		}
+		if cluster != "" {
+			_, found := urlsByCluster[cluster]
+			return found, nil
+		}
 		return true, nil
 	}
-	if _, err = wait(endpointFunc, func() *http.Request { return req }, &t.tlsOptions.KeyPair, timeout); err != nil {
+	if _, err = wait(ctx, endpointFunc, fixedRequest(req), &t.tlsOptions, timeout, t.retryInterval); err != nil {
 		return err
 	}
 	if len(urlsByCluster) == 0 {
@@ -473,36 +1315,83 @@ func (t *cloudTarget) discoverEndpoints(timeout time.Duration) error {
 }
 
 func isOK(status int) (bool, error) {
-	if status == 401 {
-		return false, fmt.Errorf("status %d: invalid api key", status)
+	switch status {
+	case 401:
+		return false, fmt.Errorf("status %d: %w: invalid api key or access token", status, ErrUnauthorized)
+	case 403:
+		return false, fmt.Errorf("status %d: %w: access denied", status, ErrUnauthorized)
 	}
 	return status/100 == 2, nil
 }
 
 // LocalTarget creates a target for a Vespa platform running locally.
 func LocalTarget() Target {
-	return &customTarget{targetType: localTargetType, baseURL: "http://127.0.0.1"}
+	return LocalTargetWithApplication(ApplicationID{})
+}
+
+// LocalTargetWithApplication creates a target for a Vespa platform running locally, managing given application.
+// Empty parts of application default to "default".
+func LocalTargetWithApplication(application ApplicationID) Target {
+	return &customTarget{targetType: localTargetType, baseURL: "http://127.0.0.1", application: selfHostedApplication(application)}
 }
 
 // CustomTarget creates a Target for a Vespa platform running at baseURL.
 func CustomTarget(baseURL string) Target {
-	return &customTarget{targetType: customTargetType, baseURL: baseURL}
+	return CustomTargetWithOptions(baseURL, TLSOptions{}, 0, ApplicationID{})
+}
+
+// CustomTargetWithTLS creates a Target for a Vespa platform running at baseURL, using tlsOptions for its services.
+func CustomTargetWithTLS(baseURL string, tlsOptions TLSOptions) Target {
+	return CustomTargetWithOptions(baseURL, tlsOptions, 0, ApplicationID{})
 }
 
-// CloudTarget creates a Target for the Vespa Cloud platform.
+// CustomTargetWithOptions creates a Target for a Vespa platform running at baseURL, using tlsOptions for its services.
+// When waiting for services, convergence must hold for at least stableFor before they are considered ready. The target
+// manages given application, where empty parts default to "default".
+func CustomTargetWithOptions(baseURL string, tlsOptions TLSOptions, stableFor time.Duration, application ApplicationID) Target {
+	return &customTarget{
+		targetType:  customTargetType,
+		baseURL:     baseURL,
+		tlsOptions:  tlsOptions,
+		stableFor:   stableFor,
+		application: selfHostedApplication(application),
+	}
+}
+
+// selfHostedApplication returns application with empty parts replaced by the defaults of a self-hosted Vespa.
+func selfHostedApplication(application ApplicationID) ApplicationID {
+	if application.Tenant == "" {
+		application.Tenant = defaultSelfHostedName
+	}
+	if application.Application == "" {
+		application.Application = defaultSelfHostedName
+	}
+	if application.Instance == "" {
+		application.Instance = defaultSelfHostedName
+	}
+	return application
+}
+
+// CloudTarget creates a Target for the Vespa Cloud platform. The authentication method of API requests is given by
+// cloudAuth, which is "access-token", "cert" or "api-key". The auth instance is used for access token authentication,
+// and may be nil if cloudAuth is not "access-token". If syncClock is true, the clock offset from the
+// API server is measured before signing the first request, and signing timestamps are adjusted by this offset.
+// Endpoints are read from endpointCache, if non-nil, before discovering them, and discovered endpoints are written to
+// it. The cache is not used if urlsByCluster is given.
 func CloudTarget(apiURL string, deployment Deployment, apiKey []byte, tlsOptions TLSOptions, logOptions LogOptions,
-	authConfigPath string, systemName string, cloudAuth string, urlsByCluster map[string]string) Target {
+	auth *auth0.Auth0, cloudAuth string, urlsByCluster map[string]string, syncClock bool, endpointCache EndpointCache) Target {
 	return &cloudTarget{
-		apiURL:         apiURL,
-		targetType:     cloudTargetType,
-		deployment:     deployment,
-		apiKey:         apiKey,
-		tlsOptions:     tlsOptions,
-		logOptions:     logOptions,
-		authConfigPath: authConfigPath,
-		systemName:     systemName,
-		cloudAuth:      cloudAuth,
-		urlsByCluster:  urlsByCluster,
+		apiURL:        apiURL,
+		targetType:    cloudTargetType,
+		deployment:    deployment,
+		apiKey:        apiKey,
+		tlsOptions:    tlsOptions,
+		logOptions:    logOptions,
+		auth0:         auth,
+		cloudAuth:     cloudAuth,
+		urlsByCluster: urlsByCluster,
+		endpointCache: endpointCache,
+		syncClock:     syncClock,
 	}
 }
 
@@ -513,11 +1402,68 @@ type deploymentEndpoint struct {
 }
 
 type deploymentResponse struct {
-	Endpoints []deploymentEndpoint `json:"endpoints"`
+	Endpoints          []deploymentEndpoint `json:"endpoints"`
+	Platform           string               `json:"platform"`
+	ApplicationVersion struct {
+		Build int64  `json:"build"`
+		Hash  string `json:"hash"`
+	} `json:"applicationVersion"`
+}
+
+func (r deploymentResponse) versions() DeploymentVersions {
+	versions := DeploymentVersions{Platform: r.Platform}
+	if r.ApplicationVersion.Build > 0 {
+		versions.Application = fmt.Sprintf("build %d", r.ApplicationVersion.Build)
+	} else {
+		versions.Application = r.ApplicationVersion.Hash
+	}
+	return versions
+}
+
+type applicationResponse struct {
+	Generation    int64    `json:"generation"`
+	ModelVersions []string `json:"modelVersions"`
+}
+
+func (r applicationResponse) versions() DeploymentVersions {
+	versions := DeploymentVersions{Application: fmt.Sprintf("session %d", r.Generation)}
+	if len(r.ModelVersions) > 0 {
+		versions.Platform = r.ModelVersions[len(r.ModelVersions)-1]
+	}
+	return versions
+}
+
+type applicationStatusResponse struct {
+	Application struct {
+		Meta struct {
+			Generation int64 `json:"generation"`
+		} `json:"meta"`
+	} `json:"application"`
 }
 
 type serviceConvergeResponse struct {
-	Converged bool `json:"converged"`
+	Converged        bool                     `json:"converged"`
+	WantedGeneration int64                    `json:"wantedGeneration"`
+	Services         []serviceConvergeService `json:"services"`
+}
+
+type serviceConvergeService struct {
+	Host              string `json:"host"`
+	Port              int    `json:"port"`
+	Type              string `json:"type"`
+	CurrentGeneration int64  `json:"currentGeneration"`
+}
+
+// lagging returns a description of the services in this response which are not on the wanted generation.
+func (r serviceConvergeResponse) lagging() []string {
+	var services []string
+	for _, s := range r.Services {
+		if s.CurrentGeneration != r.WantedGeneration {
+			services = append(services, fmt.Sprintf("%s on %s:%d (generation %d, want %d)",
+				s.Type, s.Host, s.Port, s.CurrentGeneration, r.WantedGeneration))
+		}
+	}
+	return services
 }
 
 type jobResponse struct {
@@ -527,49 +1473,184 @@ type jobResponse struct {
 	LastID int64                   `json:"lastId"`
 }
 
+// entries returns the log entries of all steps in this response, ordered by time.
+func (r jobResponse) entries() []JobLogEntry {
+	steps := make([]string, 0, len(r.Log))
+	for step := range r.Log {
+		steps = append(steps, step)
+	}
+	sort.Strings(steps)
+	var entries []JobLogEntry
+	for _, step := range steps {
+		for _, msg := range r.Log[step] {
+			entries = append(entries, JobLogEntry{
+				Step:    step,
+				At:      time.Unix(0, msg.At*int64(time.Millisecond)),
+				Type:    msg.Type,
+				Message: msg.Message,
+			})
+		}
+	}
+	sort.SliceStable(entries, func(i, j int) bool { return entries[i].At.Before(entries[j].At) })
+	return entries
+}
+
+type queryCountResponse struct {
+	Root struct {
+		Fields struct {
+			TotalCount int `json:"totalCount"`
+		} `json:"fields"`
+	} `json:"root"`
+}
+
+type jobRunsResponse struct {
+	Runs []jobRun `json:"runs"`
+}
+
+type jobRun struct {
+	ID       int64  `json:"id"`
+	Status   string `json:"status"`
+	Start    int64  `json:"start"`
+	Versions struct {
+		TargetPlatform string `json:"targetPlatform"`
+	} `json:"versions"`
+}
+
+type flavorsResponse struct {
+	Flavors []Flavor `json:"flavors"`
+}
+
 type logMessage struct {
 	At      int64  `json:"at"`
 	Type    string `json:"type"`
 	Message string `json:"message"`
 }
 
+var (
+	caPoolsMu sync.Mutex
+	caPools   = make(map[string]*x509.CertPool) // CA certificate pools by directory
+)
+
+// useCACertificates configures the HTTP client to verify server certificates using the CA certificates given in
+// tlsOptions, if any. The certificates of each directory are loaded once, so that the HTTP client keeps its
+// connections when the same pool is used again.
+func useCACertificates(tlsOptions *TLSOptions) error {
+	dir := tlsOptions.CACertificateDir
+	if dir == "" {
+		return nil
+	}
+	caPoolsMu.Lock()
+	defer caPoolsMu.Unlock()
+	pool, ok := caPools[dir]
+	if !ok {
+		var err error
+		if pool, err = util.LoadCACertificates(dir); err != nil {
+			return err
+		}
+		caPools[dir] = pool
+	}
+	util.ActiveHttpClient.UseRootCAs(pool)
+	return nil
+}
+
+// certificates returns the client certificates of tlsOptions, which are none if no key pair is set.
+func (o *TLSOptions) certificates() []tls.Certificate {
+	if len(o.KeyPair.Certificate) == 0 {
+		return nil
+	}
+	return []tls.Certificate{o.KeyPair}
+}
+
 type responseFunc func(status int, response []byte) (bool, error)
 
-type requestFunc func() *http.Request
+type requestFunc func() (*http.Request, error)
+
+// fixedRequest returns a requestFunc which returns req on every call.
+func fixedRequest(req *http.Request) requestFunc {
+	return func() (*http.Request, error) { return req, nil }
+}
+
+// wait sends the request returned by reqFn, and passes the response to fn, until fn returns true or an error, or timeout
+// passes. The request is sent once if timeout is 0. Requests are sent every interval, or every retryInterval if interval
+// is 0. Requests are made with ctx, and waiting stops when ctx is done, returning an error wrapping the error of ctx.
+// An error returned by reqFn also stops waiting, and is returned as is.
+func wait(ctx context.Context, fn responseFunc, reqFn requestFunc, tlsOptions *TLSOptions, timeout, interval time.Duration) (int, error) {
+	status, _, err := waitWithTLSState(ctx, fn, reqFn, tlsOptions, timeout, interval)
+	return status, err
+}
+
+// waitWithTLSState works like wait, but also returns the TLS connection state of the last response received, if any.
+func waitWithTLSState(ctx context.Context, fn responseFunc, reqFn requestFunc, tlsOptions *TLSOptions, timeout, interval time.Duration) (int, *tls.ConnectionState, error) {
+	return poll(ctx, fn, reqFn, tlsOptions, timeout, interval, 0)
+}
 
-func wait(fn responseFunc, reqFn requestFunc, certificate *tls.Certificate, timeout time.Duration) (int, error) {
-	if certificate != nil {
-		util.ActiveHttpClient.UseCertificate([]tls.Certificate{*certificate})
+// poll works like waitWithTLSState, but doubles the interval after each request, up to maxInterval. The interval is
+// fixed if maxInterval is less than interval.
+func poll(ctx context.Context, fn responseFunc, reqFn requestFunc, tlsOptions *TLSOptions, timeout, interval, maxInterval time.Duration) (int, *tls.ConnectionState, error) {
+	if interval == 0 {
+		interval = retryInterval
+	}
+	if tlsOptions != nil {
+		util.ActiveHttpClient.UseCertificate(tlsOptions.certificates())
+		if err := useCACertificates(tlsOptions); err != nil {
+			return 0, nil, err
+		}
 	}
 	var (
 		httpErr    error
 		response   *http.Response
 		statusCode int
+		tlsState   *tls.ConnectionState
 	)
+	minInterval := interval
 	deadline := time.Now().Add(timeout)
 	loopOnce := timeout == 0
-	for time.Now().Before(deadline) || loopOnce {
-		response, httpErr = util.HttpDo(reqFn(), 10*time.Second, "")
+	for {
+		if err := ctx.Err(); err != nil {
+			return statusCode, tlsState, fmt.Errorf("stopped waiting: %w", err)
+		}
+		req, err := reqFn()
+		if err != nil {
+			return statusCode, tlsState, err
+		}
+		response, httpErr = util.HttpDo(req.WithContext(ctx), 10*time.Second, "")
 		if httpErr == nil {
 			statusCode = response.StatusCode
+			tlsState = response.TLS
 			body, err := ioutil.ReadAll(response.Body)
 			if err != nil {
-				return 0, err
+				return 0, nil, err
 			}
 			response.Body.Close()
 			ok, err := fn(statusCode, body)
 			if err != nil {
-				return statusCode, err
+				return statusCode, tlsState, err
 			}
 			if ok {
-				return statusCode, nil
+				return statusCode, tlsState, nil
 			}
+		} else if err := ctx.Err(); err != nil {
+			return statusCode, tlsState, fmt.Errorf("stopped waiting: %w", err) // No point in retrying once the context is done
 		}
 		timeLeft := time.Until(deadline)
-		if loopOnce || timeLeft < retryInterval {
+		if loopOnce || timeLeft < minInterval {
 			break
 		}
-		time.Sleep(retryInterval)
+		sleep := interval
+		if sleep > timeLeft {
+			sleep = timeLeft // Make a last attempt at the deadline, rather than giving up while backing off
+		}
+		select {
+		case <-time.After(sleep):
+		case <-ctx.Done():
+			return statusCode, tlsState, fmt.Errorf("stopped waiting: %w", ctx.Err())
+		}
+		if interval < maxInterval {
+			interval *= 2
+			if interval > maxInterval {
+				interval = maxInterval
+			}
+		}
 	}
-	return statusCode, httpErr
+	return statusCode, tlsState, httpErr
 }
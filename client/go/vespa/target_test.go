@@ -3,31 +3,53 @@ package vespa
 
 import (
 	"bytes"
+	"context"
 	"crypto/tls"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
 	"fmt"
 	"io"
 	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
 	"time"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/vespa-engine/vespa/client/go/auth0"
+	"github.com/vespa-engine/vespa/client/go/util"
 )
 
 type mockVespaApi struct {
 	deploymentConverged bool
 	serverURL           string
+	documentCounts      []int
+	endpointClusters    [][]string
+	flavorRequests      int
 }
 
 func (v *mockVespaApi) mockVespaHandler(w http.ResponseWriter, req *http.Request) {
 	switch req.URL.Path {
 	case "/application/v4/tenant/t1/application/a1/instance/i1/environment/dev/region/us-north-1":
-		response := "{}"
-		if v.deploymentConverged {
+		response := `{"platform": "7.465.17", "applicationVersion": {"hash": "f00ba4", "build": 42}}`
+		if len(v.endpointClusters) > 0 {
+			var endpoints []string
+			for _, cluster := range v.endpointClusters[0] {
+				endpoints = append(endpoints, fmt.Sprintf(`{"url": "%s/%s","scope": "zone","cluster": "%s"}`, v.serverURL, cluster, cluster))
+			}
+			v.endpointClusters = v.endpointClusters[1:]
+			response = `{"endpoints": [` + strings.Join(endpoints, ",") + `]}`
+		} else if v.deploymentConverged {
 			response = fmt.Sprintf(`{"endpoints": [{"url": "%s","scope": "zone","cluster": "cluster1"}]}`, v.serverURL)
 		}
 		w.Write([]byte(response))
+	case "/application/v4/tenant/t1/application/a1/instance/i2/environment/dev/region/us-north-1":
+		w.WriteHeader(404)
+		w.Write([]byte(`{"error-code": "NOT_FOUND", "message": "No deployment found"}`))
 	case "/application/v4/tenant/t1/application/a1/instance/i1/job/dev-us-north-1/run/42":
 		var response string
 		if v.deploymentConverged {
@@ -43,11 +65,35 @@ func (v *mockVespaApi) mockVespaHandler(w http.ResponseWriter, req *http.Request
 	case "/application/v2/tenant/default/application/default/environment/prod/region/default/instance/default/serviceconverge":
 		response := fmt.Sprintf(`{"converged": %t}`, v.deploymentConverged)
 		w.Write([]byte(response))
-	case "/application/v4/tenant/t1/application/a1/instance/i1/environment/dev/region/us-north-1/logs":
+	case "/application/v4/tenant/t1/application/a1/instance/i1/environment/dev/region/us-north-1/logs",
+		"/application/v2/tenant/default/application/default/environment/prod/region/default/instance/default/logs":
 		log := `1632738690.905535	host1a.dev.aws-us-east-1c	806/53	logserver-container	Container.com.yahoo.container.jdisc.ConfiguredApplication	info	Switching to the latest deployed set of configurations and components. Application config generation: 52532
 1632738698.600189	host1a.dev.aws-us-east-1c	1723/33590	config-sentinel	sentinel.sentinel.config-owner	config	Sentinel got 3 service elements [tenant(vespa-team), application(music), instance(mpolden)] for config generation 52532
+1632738699.120433	host2a.dev.aws-us-east-1c	1750/12	searchnode	searchnode.proton.server.proton	warning	Low memory
 `
 		w.Write([]byte(log))
+	case "/application/v4/tenant/t1/application/a1/instance/i1/job/dev-us-north-1":
+		w.Write([]byte(`{"runs": [{"id": 41, "status": "success", "start": 1631707000000, "versions": {"targetPlatform": "7.465.17"}},
+                                  {"id": 43, "status": "running", "start": 1631707900000, "versions": {"targetPlatform": "7.470.2"}},
+                                  {"id": 42, "status": "deploymentFailed", "start": 1631707700000, "versions": {"targetPlatform": "7.465.17"}}]}`))
+	case "/flavors/v1/":
+		v.flavorRequests++
+		w.Write([]byte(`{"flavors": [{"name": "small", "vcpu": 2, "memoryGb": 8, "diskGb": 50},
+                                     {"name": "large", "vcpu": 16, "memoryGb": 64, "diskGb": 937.5}]}`))
+	case "/search/":
+		if len(v.documentCounts) == 0 {
+			w.WriteHeader(503)
+			return
+		}
+		count := v.documentCounts[0]
+		v.documentCounts = v.documentCounts[1:]
+		if count < 0 { // Not ready
+			w.WriteHeader(503)
+			return
+		}
+		w.Write([]byte(fmt.Sprintf(`{"root": {"id": "toplevel", "relevance": 1.0, "fields": {"totalCount": %d}}}`, count)))
+	case "/healthz":
+		w.Write([]byte("OK"))
 	case "/status.html":
 		w.Write([]byte("OK"))
 	case "/ApplicationStatus":
@@ -82,11 +128,11 @@ func TestCustomTargetWait(t *testing.T) {
 	defer srv.Close()
 	target := CustomTarget(srv.URL)
 
-	_, err := target.Service("query", time.Millisecond, 42, "")
+	_, err := target.Service(context.Background(), "query", time.Millisecond, 42, "")
 	assert.NotNil(t, err)
 
 	vc.deploymentConverged = true
-	_, err = target.Service("query", time.Millisecond, 42, "")
+	_, err = target.Service(context.Background(), "query", time.Millisecond, 42, "")
 	assert.Nil(t, err)
 
 	assertServiceWait(t, 200, target, "deploy")
@@ -94,6 +140,276 @@ func TestCustomTargetWait(t *testing.T) {
 	assertServiceWait(t, 500, target, "document")
 }
 
+func TestCustomTargetWaitStableConvergence(t *testing.T) {
+	defer func(interval time.Duration) { retryInterval = interval }(retryInterval)
+	retryInterval = 10 * time.Millisecond
+	responses := []bool{true, false, true}
+	requests := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		converged := true
+		if requests < len(responses) {
+			converged = responses[requests]
+		}
+		requests++
+		fmt.Fprintf(w, `{"converged": %t}`, converged)
+	}))
+	defer srv.Close()
+
+	target := CustomTargetWithOptions(srv.URL, TLSOptions{}, 50*time.Millisecond, ApplicationID{})
+	_, err := target.Service(context.Background(), "query", 5*time.Second, 42, "")
+	assert.Nil(t, err)
+	assert.Greater(t, requests, len(responses)+1, "wait continues until convergence is stable")
+
+	requests = 0
+	responses = []bool{true, true, false}
+	_, err = target.Service(context.Background(), "query", 25*time.Millisecond, 42, "")
+	assert.NotNil(t, err)
+}
+
+func TestCustomTargetRetryInterval(t *testing.T) {
+	responses := []bool{false, false, false}
+	requests := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		converged := requests >= len(responses)
+		requests++
+		fmt.Fprintf(w, `{"converged": %t}`, converged)
+	}))
+	defer srv.Close()
+
+	target := CustomTarget(srv.URL)
+	target.SetRetryInterval(10 * time.Millisecond)
+	service, err := target.Service(context.Background(), "query", time.Second, 42, "")
+	assert.Nil(t, err)
+	assert.Equal(t, len(responses)+1, requests)
+	assert.Equal(t, 10*time.Millisecond, service.RetryInterval)
+
+	requests = 0
+	target.SetRetryInterval(0)
+	_, err = target.Service(context.Background(), "query", time.Second, 42, "")
+	assert.NotNil(t, err, "default interval leaves no time for a second request")
+	assert.Equal(t, 1, requests)
+}
+
+func TestWaitRequest(t *testing.T) {
+	requests := 0
+	var (
+		keyIDs       []string
+		signatures   = make(map[string]bool)
+		requestTimes []time.Time
+	)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.URL.Path != "/application/v4/tenant/t1" {
+			w.WriteHeader(404)
+			return
+		}
+		requests++
+		keyIDs = append(keyIDs, req.Header.Get("X-Key-Id"))
+		signatures[req.Header.Get("X-Authorization")] = true
+		requestTimes = append(requestTimes, time.Now())
+		if requests == 1 {
+			w.WriteHeader(503)
+			return
+		}
+		fmt.Fprintf(w, `{"ready": %t}`, requests > 3)
+	}))
+	defer srv.Close()
+	ready := func(response []byte) (bool, error) {
+		var resp struct {
+			Ready bool `json:"ready"`
+		}
+		if err := json.Unmarshal(response, &resp); err != nil {
+			return false, err
+		}
+		return resp.Ready, nil
+	}
+
+	customTarget := CustomTarget(srv.URL)
+	customTarget.SetRetryInterval(time.Millisecond)
+	assert.Nil(t, customTarget.WaitRequest(context.Background(), "GET", "/application/v4/tenant/t1", ready, time.Second))
+	assert.Equal(t, 4, requests)
+
+	requests = 0
+	keyIDs = nil
+	signatures = make(map[string]bool)
+	requestTimes = nil
+	cloudTarget := createCloudTarget(t, srv.URL, ioutil.Discard)
+	cloudTarget.SetRetryInterval(10 * time.Millisecond)
+	assert.Nil(t, cloudTarget.WaitRequest(context.Background(), "GET", "/application/v4/tenant/t1", ready, 10*time.Second))
+	assert.Equal(t, 4, requests)
+	if !Auth0AccessTokenEnabled() {
+		assert.Equal(t, []string{"t1:a1:i1", "t1:a1:i1", "t1:a1:i1", "t1:a1:i1"}, keyIDs)
+		assert.Equal(t, 4, len(signatures), "each request is signed")
+	}
+	for i := 1; i < len(requestTimes); i++ {
+		interval := requestTimes[i].Sub(requestTimes[i-1])
+		minInterval := (10 * time.Millisecond) << (i - 1)
+		assert.True(t, interval >= minInterval, "interval %d is %s, at least %s", i, interval, minInterval)
+	}
+
+	requests = 0
+	err := cloudTarget.WaitRequest(context.Background(), "GET", "/application/v4/tenant/t1", ready, 0)
+	assert.EqualError(t, err, "gave up waiting on GET "+srv.URL+"/application/v4/tenant/t1: status 503")
+	err = cloudTarget.WaitRequest(context.Background(), "GET", "/application/v4/tenant/t2", ready, time.Second)
+	assert.EqualError(t, err, "status 404: ")
+	requests = 1
+	err = cloudTarget.WaitRequest(context.Background(), "GET", "/application/v4/tenant/t1", ready, 0)
+	assert.EqualError(t, err, "gave up waiting on GET "+srv.URL+"/application/v4/tenant/t1: condition not met")
+}
+
+func TestCustomTargetNamedApplication(t *testing.T) {
+	var requests []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		requests = append(requests, req.URL.RequestURI())
+		w.Write([]byte(`{"converged": true, "session-id": "42"}`))
+	}))
+	defer srv.Close()
+
+	target := CustomTargetWithOptions(srv.URL, TLSOptions{}, 0, ApplicationID{Tenant: "t1", Application: "a1"})
+	_, err := target.Service(context.Background(), "query", time.Second, 42, "")
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"/application/v2/tenant/t1/application/a1/environment/prod/region/default/instance/default/serviceconverge"}, requests)
+
+	dir := t.TempDir()
+	assert.Nil(t, ioutil.WriteFile(filepath.Join(dir, "services.xml"), []byte("<services/>"), 0644))
+	cwd, err := os.Getwd()
+	assert.Nil(t, err)
+	defer os.Chdir(cwd)
+	assert.Nil(t, os.Chdir(dir))
+	requests = nil
+	opts := DeploymentOpts{ApplicationPackage: ApplicationPackage{Path: "."}, Target: target}
+	_, err = Deploy(context.Background(), opts)
+	assert.Nil(t, err)
+	assert.Nil(t, Activate(context.Background(), 42, opts))
+	assert.Equal(t, []string{
+		"/application/v2/tenant/t1/prepareandactivate?applicationName=a1&instance=default",
+		"/application/v2/tenant/t1/session/42/active",
+	}, requests)
+
+	requests = nil
+	_, err = CustomTarget(srv.URL).Service(context.Background(), "query", time.Second, 42, "")
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"/application/v2/tenant/default/application/default/environment/prod/region/default/instance/default/serviceconverge"}, requests)
+}
+
+func TestCustomTargetWaitReportsLaggingServices(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Write([]byte(`{
+  "services": [
+    {"host": "node1.example.com", "port": 19071, "type": "configserver", "currentGeneration": 3},
+    {"host": "node2.example.com", "port": 19100, "type": "container", "currentGeneration": 2},
+    {"host": "node3.example.com", "port": 19100, "type": "searchnode", "currentGeneration": 3}
+  ],
+  "currentGeneration": 2,
+  "wantedGeneration": 3,
+  "converged": false
+}`))
+	}))
+	defer srv.Close()
+
+	target := CustomTarget(srv.URL)
+	_, err := target.Service(context.Background(), "query", time.Millisecond, 42, "")
+	assert.EqualError(t, err, "services have not converged: container on node2.example.com:19100 (generation 2, want 3)")
+}
+
+func TestServiceWaitWithHealthPath(t *testing.T) {
+	vc := mockVespaApi{}
+	srv := httptest.NewServer(http.HandlerFunc(vc.mockVespaHandler))
+	defer srv.Close()
+
+	s := Service{BaseURL: srv.URL, Name: queryService}
+	status, err := s.Wait(context.Background(), 0)
+	assert.Nil(t, err)
+	assert.Equal(t, 500, status)
+
+	s.HealthPath = "/healthz"
+	status, err = s.Wait(context.Background(), 0)
+	assert.Nil(t, err)
+	assert.Equal(t, 200, status)
+
+	s.HealthPath = "healthz"
+	_, err = s.Wait(context.Background(), 0)
+	assert.NotNil(t, err)
+}
+
+func TestWaitCancelled(t *testing.T) {
+	defer func(interval time.Duration) { retryInterval = interval }(retryInterval)
+	retryInterval = time.Minute
+	vc := mockVespaApi{}
+	srv := httptest.NewServer(http.HandlerFunc(vc.mockVespaHandler))
+	defer srv.Close()
+
+	for _, waitFunc := range []func(ctx context.Context) error{
+		func(ctx context.Context) error {
+			s := Service{BaseURL: srv.URL, Name: queryService} // Not ready
+			_, err := s.Wait(ctx, time.Hour)
+			return err
+		},
+		func(ctx context.Context) error {
+			return CustomTarget(srv.URL).PrintLog(ctx, LogOptions{Writer: ioutil.Discard, Follow: true})
+		},
+	} {
+		ctx, cancel := context.WithCancel(context.Background())
+		time.AfterFunc(50*time.Millisecond, cancel)
+		start := time.Now()
+		err := waitFunc(ctx)
+		assert.True(t, errors.Is(err, context.Canceled), "got %v", err)
+		assert.True(t, time.Since(start) < 10*time.Second, "waiting is cancelled")
+	}
+}
+
+func TestServiceWaitForDocuments(t *testing.T) {
+	defer func(interval time.Duration) { retryInterval = interval }(retryInterval)
+	retryInterval = 0
+	vc := mockVespaApi{documentCounts: []int{-1, 0, 2, 5}}
+	srv := httptest.NewServer(http.HandlerFunc(vc.mockVespaHandler))
+	defer srv.Close()
+
+	s := Service{BaseURL: srv.URL, Name: queryService}
+	assert.Nil(t, s.WaitForDocuments(context.Background(), 3, time.Minute))
+	assert.Empty(t, vc.documentCounts)
+
+	vc.documentCounts = []int{1}
+	assert.Equal(t, fmt.Errorf("found 1 documents, want at least 3"), s.WaitForDocuments(context.Background(), 3, 0))
+
+	vc.documentCounts = []int{-1}
+	assert.NotNil(t, s.WaitForDocuments(context.Background(), 1, 0))
+}
+
+func TestServiceWaitForVisible(t *testing.T) {
+	defer func(interval time.Duration) { retryInterval = interval }(retryInterval)
+	retryInterval = 0
+	var statuses []int
+	var paths []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		paths = append(paths, req.URL.Path)
+		status := 200
+		if len(statuses) > 0 {
+			status, statuses = statuses[0], statuses[1:]
+		}
+		w.WriteHeader(status)
+	}))
+	defer srv.Close()
+
+	s := Service{BaseURL: srv.URL, Name: documentService}
+	statuses = []int{503, 404, 404}
+	assert.Nil(t, s.WaitForVisible(context.Background(), "id:mynamespace:music::a-head-full-of-dreams", time.Minute))
+	assert.Empty(t, statuses)
+	assert.Equal(t, 4, len(paths))
+	assert.Equal(t, "/document/v1/mynamespace/music/docid/a-head-full-of-dreams", paths[0])
+
+	statuses = []int{404}
+	assert.EqualError(t, s.WaitForVisible(context.Background(), "id:mynamespace:music::a-head-full-of-dreams", 0),
+		"document id:mynamespace:music::a-head-full-of-dreams is not visible in Container (document API)")
+
+	statuses = []int{503}
+	assert.EqualError(t, s.WaitForVisible(context.Background(), "id:mynamespace:music::a-head-full-of-dreams", 0),
+		"Container (document API) at "+srv.URL+" is not ready: status 503")
+
+	assert.NotNil(t, s.WaitForVisible(context.Background(), "invalid", 0))
+	deployer := Service{BaseURL: srv.URL, Name: deployService}
+	assert.EqualError(t, deployer.WaitForVisible(context.Background(), "id:mynamespace:music::a-head-full-of-dreams", 0), "invalid service: deploy")
+}
+
 func TestCloudTargetWait(t *testing.T) {
 	vc := mockVespaApi{}
 	srv := httptest.NewServer(http.HandlerFunc(vc.mockVespaHandler))
@@ -104,11 +420,11 @@ func TestCloudTargetWait(t *testing.T) {
 	target := createCloudTarget(t, srv.URL, &logWriter)
 	assertServiceWait(t, 200, target, "deploy")
 
-	_, err := target.Service("query", time.Millisecond, 42, "")
+	_, err := target.Service(context.Background(), "query", time.Millisecond, 42, "")
 	assert.NotNil(t, err)
 
 	vc.deploymentConverged = true
-	_, err = target.Service("query", time.Millisecond, 42, "")
+	_, err = target.Service(context.Background(), "query", time.Millisecond, 42, "")
 	assert.Nil(t, err)
 
 	assertServiceWait(t, 500, target, "query")
@@ -120,6 +436,294 @@ func TestCloudTargetWait(t *testing.T) {
 	assert.Equal(t, expectedTime+" info    Deploying platform version 7.465.17 and application version 1.0.2 ...\n", logWriter.String())
 }
 
+func TestCloudTargetPartialEndpoints(t *testing.T) {
+	defer func(interval time.Duration) { retryInterval = interval }(retryInterval)
+	retryInterval = 0
+	vc := mockVespaApi{}
+	srv := httptest.NewServer(http.HandlerFunc(vc.mockVespaHandler))
+	defer srv.Close()
+	vc.serverURL = srv.URL
+
+	// Returns as soon as the wanted cluster is discovered
+	vc.endpointClusters = [][]string{{"cluster1"}, {"cluster1", "cluster2"}}
+	target := createCloudTarget(t, srv.URL, ioutil.Discard)
+	s, err := target.Service(context.Background(), "query", time.Minute, 0, "cluster1")
+	assert.Nil(t, err)
+	assert.Equal(t, srv.URL+"/cluster1", s.BaseURL)
+	assert.Equal(t, 1, len(vc.endpointClusters))
+
+	// Waits for a cluster which appears later
+	vc.endpointClusters = [][]string{{"cluster1"}, {"cluster1", "cluster2"}}
+	target = createCloudTarget(t, srv.URL, ioutil.Discard)
+	s, err = target.Service(context.Background(), "query", time.Minute, 0, "cluster2")
+	assert.Nil(t, err)
+	assert.Equal(t, srv.URL+"/cluster2", s.BaseURL)
+	assert.Equal(t, 0, len(vc.endpointClusters))
+}
+
+type mapEndpointCache map[string]map[string]string
+
+func (c mapEndpointCache) Read(deployment Deployment) (map[string]string, bool) {
+	urls, ok := c[deployment.String()]
+	return urls, ok
+}
+
+func (c mapEndpointCache) Write(deployment Deployment, urlsByCluster map[string]string) {
+	c[deployment.String()] = urlsByCluster
+}
+
+func (c mapEndpointCache) Remove(deployment Deployment) { delete(c, deployment.String()) }
+
+func TestCloudTargetEndpointCache(t *testing.T) {
+	defer func(interval time.Duration) { retryInterval = interval }(retryInterval)
+	retryInterval = 0
+	vc := mockVespaApi{}
+	srv := httptest.NewServer(http.HandlerFunc(vc.mockVespaHandler))
+	defer srv.Close()
+	vc.serverURL = srv.URL
+	cache := mapEndpointCache{}
+	newTarget := func() Target {
+		target := createCloudTarget(t, srv.URL, ioutil.Discard)
+		target.(*cloudTarget).endpointCache = cache
+		return target
+	}
+
+	// Endpoints discovered for a single cluster may be partial, and are not cached
+	vc.endpointClusters = [][]string{{"cluster1"}, {"cluster1", "cluster2"}}
+	_, err := newTarget().Service(context.Background(), "query", time.Minute, 0, "cluster1")
+	assert.Nil(t, err)
+	assert.Empty(t, cache)
+
+	vc.endpointClusters = [][]string{{"cluster1"}}
+	_, err = newTarget().Service(context.Background(), "query", time.Minute, 0, "")
+	assert.Nil(t, err)
+	deployment := newTarget().(*cloudTarget).deployment
+	assert.Equal(t, map[string]string{"cluster1": srv.URL + "/cluster1"}, cache[deployment.String()])
+
+	// Cached endpoints are removed when they cannot be reached
+	unreachable := httptest.NewServer(http.NotFoundHandler())
+	unreachable.Close()
+	cache.Write(deployment, map[string]string{"cluster1": unreachable.URL})
+	s, err := newTarget().Service(context.Background(), "query", time.Minute, 0, "cluster1")
+	assert.Nil(t, err)
+	assert.Equal(t, unreachable.URL, s.BaseURL)
+	req, err := http.NewRequest("GET", s.BaseURL+"/search/", nil)
+	assert.Nil(t, err)
+	_, err = s.Do(req, time.Second)
+	assert.NotNil(t, err)
+	assert.Empty(t, cache)
+}
+
+func TestClusters(t *testing.T) {
+	vc := mockVespaApi{}
+	srv := httptest.NewServer(http.HandlerFunc(vc.mockVespaHandler))
+	defer srv.Close()
+	vc.serverURL = srv.URL
+	vc.endpointClusters = [][]string{{"feed", "default"}}
+
+	target := createCloudTarget(t, srv.URL, ioutil.Discard)
+	clusters, err := target.Clusters(context.Background(), 0)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(clusters))
+	assert.Equal(t, "default", clusters[0].Name)
+	assert.Equal(t, "container", clusters[0].Type)
+	assert.Equal(t, srv.URL+"/default", clusters[0].Service.BaseURL)
+	assert.Equal(t, "feed", clusters[1].Name)
+
+	clusters, err = CustomTarget("http://192.0.2.42").Clusters(context.Background(), 0)
+	assert.Nil(t, err)
+	assert.Equal(t, []Cluster{
+		{Name: "config", Type: "deploy", Service: &Service{Name: "deploy", BaseURL: "http://192.0.2.42:19071"}},
+		{Name: "default", Type: "container", Service: &Service{Name: "query", BaseURL: "http://192.0.2.42:8080"}},
+	}, clusters)
+}
+
+func TestVersions(t *testing.T) {
+	vc := mockVespaApi{}
+	srv := httptest.NewServer(http.HandlerFunc(vc.mockVespaHandler))
+	defer srv.Close()
+
+	target := createCloudTarget(t, srv.URL, ioutil.Discard)
+	versions, err := target.Versions(context.Background())
+	assert.Nil(t, err)
+	assert.Equal(t, DeploymentVersions{Application: "build 42", Platform: "7.465.17"}, versions)
+
+	target.(*cloudTarget).deployment.Application.Instance = "i2"
+	_, err = target.Versions(context.Background())
+	assert.True(t, errors.Is(err, ErrNotDeployed))
+}
+
+func TestReadPackageVersion(t *testing.T) {
+	status, body := 200, `{"version": "1.2.3"}`
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(status)
+		w.Write([]byte(body))
+	}))
+	defer srv.Close()
+	read := func() (string, error) {
+		req, err := http.NewRequest("GET", srv.URL+"/content/"+BuildMetaFile, nil)
+		assert.Nil(t, err)
+		return readPackageVersion(context.Background(), req, nil)
+	}
+
+	version, err := read()
+	assert.Nil(t, err)
+	assert.Equal(t, "1.2.3", version)
+
+	status, body = 404, "not found"
+	version, err = read()
+	assert.Nil(t, err, "a missing file declares no version")
+	assert.Equal(t, "", version)
+
+	status, body = 500, "boom"
+	_, err = read()
+	assert.EqualError(t, err, "status 500: boom")
+
+	status, body = 200, "{"
+	_, err = read()
+	assert.EqualError(t, err, "invalid build-meta.json: unexpected end of JSON input")
+}
+
+func TestCloudTargetNotDeployed(t *testing.T) {
+	vc := mockVespaApi{}
+	srv := httptest.NewServer(http.HandlerFunc(vc.mockVespaHandler))
+	defer srv.Close()
+
+	target := createCloudTarget(t, srv.URL, ioutil.Discard)
+	target.(*cloudTarget).deployment.Application.Instance = "i2"
+	start := time.Now()
+	_, err := target.Service(context.Background(), queryService, time.Minute, 0, "")
+	assert.True(t, errors.Is(err, ErrNotDeployed))
+	assert.Equal(t, "deployment of t1.a1.i2 in dev.us-north-1: not deployed", err.Error())
+	assert.True(t, time.Since(start) < 10*time.Second, "fails without waiting for timeout")
+}
+
+func TestCloudTargetCertificateAuth(t *testing.T) {
+	defer func(c util.HttpClient) { util.ActiveHttpClient = c }(util.ActiveHttpClient)
+	util.ActiveHttpClient = util.CreateClient(10 * time.Second)
+	var peerCertificates [][]byte
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		for _, cert := range r.TLS.PeerCertificates {
+			peerCertificates = append(peerCertificates, cert.Raw)
+		}
+		assert.Equal(t, "", r.Header.Get("X-Authorization"), "request is not signed")
+		assert.Equal(t, "", r.Header.Get("Authorization"), "request has no access token")
+		w.Write([]byte(`{"runs": []}`))
+	}))
+	srv.TLS = &tls.Config{ClientAuth: tls.RequireAnyClientCert}
+	srv.StartTLS()
+	defer srv.Close()
+	caDir := t.TempDir()
+	caCert := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: srv.Certificate().Raw})
+	assert.Nil(t, ioutil.WriteFile(filepath.Join(caDir, "ca.pem"), caCert, 0644))
+
+	kp, err := CreateKeyPair()
+	assert.Nil(t, err)
+	x509KeyPair, err := tls.X509KeyPair(kp.Certificate, kp.PrivateKey)
+	assert.Nil(t, err)
+	deployment := Deployment{
+		Application: ApplicationID{Tenant: "t1", Application: "a1", Instance: "i1"},
+		Zone:        ZoneID{Environment: "dev", Region: "us-north-1"},
+	}
+	target := CloudTarget(srv.URL, deployment, nil, TLSOptions{KeyPair: x509KeyPair, CACertificateDir: caDir},
+		LogOptions{}, nil, "cert", nil, false, nil)
+
+	// Requests not made through wait must also present the certificate
+	req, err := http.NewRequest("GET", srv.URL+"/application/v4/tenant/t1/application/a1/instance/i1/job/dev-us-north-1", nil)
+	assert.Nil(t, err)
+	assert.Nil(t, target.PrepareApiRequest(req, deployment.Application.SerializedForm()))
+	response, err := util.HttpDo(req, 10*time.Second, "")
+	assert.Nil(t, err)
+	response.Body.Close()
+	assert.Equal(t, 200, response.StatusCode)
+	assert.Equal(t, [][]byte{x509KeyPair.Certificate[0]}, peerCertificates)
+
+	target = CloudTarget(srv.URL, deployment, nil, TLSOptions{}, LogOptions{}, nil, "cert", nil, false, nil)
+	assert.EqualError(t, target.PrepareApiRequest(req, deployment.Application.SerializedForm()), "certificate authentication is not configured")
+
+	// Failing to authenticate a request stops waiting
+	assert.EqualError(t, target.(*cloudTarget).waitForRun(context.Background(), 42, time.Second), "certificate authentication is not configured")
+	assert.EqualError(t, target.PrintLog(context.Background(), LogOptions{Writer: ioutil.Discard}), "certificate authentication is not configured")
+}
+
+type mockSecretStore map[string]string
+
+func (s mockSecretStore) Get(namespace, key string) (string, error) {
+	if v, ok := s[namespace+"/"+key]; ok {
+		return v, nil
+	}
+	return "", errors.New("secret not found")
+}
+
+func (s mockSecretStore) Delete(namespace, key string) error {
+	delete(s, namespace+"/"+key)
+	return nil
+}
+
+func TestCloudTargetRefreshesAccessToken(t *testing.T) {
+	tokenRequests := 0
+	tokenStatus := 200
+	tokenSrv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		tokenRequests++
+		assert.Nil(t, req.ParseForm())
+		assert.Equal(t, "refresh_token", req.Form.Get("grant_type"))
+		assert.Equal(t, "refresh", req.Form.Get("refresh_token"))
+		w.WriteHeader(tokenStatus)
+		fmt.Fprint(w, `{"access_token": "renewed", "expires_in": 3600}`)
+	}))
+	defer tokenSrv.Close()
+	var authorization string
+	apiSrv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		authorization = req.Header.Get("Authorization")
+		fmt.Fprint(w, `{"ready": true}`)
+	}))
+	defer apiSrv.Close()
+
+	configPath := filepath.Join(t.TempDir(), "auth.json")
+	assert.Nil(t, ioutil.WriteFile(configPath, []byte("{}"), 0600))
+	a, err := auth0.GetAuth0WithConfig(configPath, "public", "", auth0.DeviceFlowConfig{
+		Audience:           "https://api.example.com",
+		ClientID:           "client",
+		DeviceCodeEndpoint: tokenSrv.URL + "/device/code",
+		OauthTokenEndpoint: tokenSrv.URL + "/token",
+	})
+	assert.Nil(t, err)
+	a.Secrets = mockSecretStore{"vespa-cli/public": "refresh"}
+	storeToken := func(token string, expiresAt time.Time) {
+		assert.Nil(t, a.AddSystem(&auth0.System{Name: "public", AccessToken: token, Scopes: []string{"openid", "offline_access"}, ExpiresAt: expiresAt}))
+	}
+	target := CloudTarget(apiSrv.URL, Deployment{
+		Application: ApplicationID{Tenant: "t1", Application: "a1", Instance: "i1"},
+		Zone:        ZoneID{Environment: "dev", Region: "us-north-1"},
+	}, nil, TLSOptions{}, LogOptions{}, a, "access-token", nil, false, nil)
+	ready := func(response []byte) (bool, error) { return true, nil }
+
+	// Valid token is used as is
+	storeToken("valid", time.Now().Add(time.Hour))
+	assert.Nil(t, target.WaitRequest(context.Background(), "GET", "/", ready, 0))
+	assert.Equal(t, "Bearer valid", authorization)
+	assert.Equal(t, 0, tokenRequests)
+
+	// Token expiring soon is renewed and persisted
+	storeToken("expiring", time.Now().Add(time.Minute))
+	assert.Nil(t, target.WaitRequest(context.Background(), "GET", "/", ready, 0))
+	assert.Equal(t, "Bearer renewed", authorization)
+	assert.Equal(t, 1, tokenRequests)
+	data, err := ioutil.ReadFile(configPath)
+	assert.Nil(t, err)
+	assert.Contains(t, string(data), `"access_token": "renewed"`)
+
+	// Failed renewal is an error, and the expired token is not sent
+	authorization = ""
+	tokenStatus = 403
+	storeToken("expired", time.Now().Add(-time.Hour))
+	err = target.WaitRequest(context.Background(), "GET", "/", ready, 0)
+	assert.NotNil(t, err)
+	assert.True(t, errors.Is(err, auth0.ErrTokenExpired))
+	assert.True(t, errors.Is(err, ErrUnauthorized))
+	assert.Equal(t, "", authorization)
+}
+
 func TestLog(t *testing.T) {
 	vc := mockVespaApi{}
 	srv := httptest.NewServer(http.HandlerFunc(vc.mockVespaHandler))
@@ -129,12 +733,317 @@ func TestLog(t *testing.T) {
 
 	var buf bytes.Buffer
 	target := createCloudTarget(t, srv.URL, ioutil.Discard)
-	if err := target.PrintLog(LogOptions{Writer: &buf, Level: 3}); err != nil {
+	if err := target.PrintLog(context.Background(), LogOptions{Writer: &buf, Level: 3}); err != nil {
 		t.Fatal(err)
 	}
 	expected := "[2021-09-27 10:31:30.905535] host1a.dev.aws-us-east-1c info    logserver-container Container.com.yahoo.container.jdisc.ConfiguredApplication\tSwitching to the latest deployed set of configurations and components. Application config generation: 52532\n" +
-		"[2021-09-27 10:31:38.600189] host1a.dev.aws-us-east-1c config  config-sentinel  sentinel.sentinel.config-owner\tSentinel got 3 service elements [tenant(vespa-team), application(music), instance(mpolden)] for config generation 52532\n"
+		"[2021-09-27 10:31:38.600189] host1a.dev.aws-us-east-1c config  config-sentinel  sentinel.sentinel.config-owner\tSentinel got 3 service elements [tenant(vespa-team), application(music), instance(mpolden)] for config generation 52532\n" +
+		"[2021-09-27 10:31:39.120433] host2a.dev.aws-us-east-1c warning searchnode       searchnode.proton.server.proton\tLow memory\n"
+	assert.Equal(t, expected, buf.String())
+
+	buf.Reset()
+	if err := target.PrintLog(context.Background(), LogOptions{Writer: &buf, Level: 3, Component: "sentinel.*"}); err != nil {
+		t.Fatal(err)
+	}
+	expected = "[2021-09-27 10:31:38.600189] host1a.dev.aws-us-east-1c config  config-sentinel  sentinel.sentinel.config-owner\tSentinel got 3 service elements [tenant(vespa-team), application(music), instance(mpolden)] for config generation 52532\n"
+	assert.Equal(t, expected, buf.String())
+
+	buf.Reset()
+	if err := target.PrintLog(context.Background(), LogOptions{Writer: &buf, Level: 3, Host: "host2a"}); err != nil {
+		t.Fatal(err)
+	}
+	expected = "[2021-09-27 10:31:39.120433] host2a.dev.aws-us-east-1c warning searchnode       searchnode.proton.server.proton\tLow memory\n"
+	assert.Equal(t, expected, buf.String())
+}
+
+func TestCustomTargetLog(t *testing.T) {
+	vc := mockVespaApi{}
+	srv := httptest.NewServer(http.HandlerFunc(vc.mockVespaHandler))
+	defer srv.Close()
+
+	var buf bytes.Buffer
+	target := CustomTarget(srv.URL)
+	from := time.Unix(1632738690, 905535000) // Entries at this time or earlier are excluded
+	to := time.Unix(1632738700, 0)
+	if err := target.PrintLog(context.Background(), LogOptions{Writer: &buf, Level: 2, From: from, To: to, Dequote: true}); err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, "[2021-09-27 10:31:39.120433] host2a.dev.aws-us-east-1c warning searchnode       searchnode.proton.server.proton\tLow memory\n", buf.String())
+}
+
+func TestLogCustomLevelMapping(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/application/v4/tenant/t1/application/a1/instance/i1/environment/dev/region/us-north-1/logs", r.URL.Path)
+		w.Write([]byte("1632738690.905535\thost1\t1/1\tproxy\tproxy.upstream\tcrit\tUpstream unreachable\n" +
+			"1632738691.905535\thost1\t1/1\tproxy\tproxy.config\tnotice\tReloaded config\n" +
+			"1632738692.905535\thost1\t1/1\tproxy\tproxy.request\tdebug\tGET /\n" +
+			"1632738693.905535\thost1\t1/1\tproxy\tproxy.request\ttrace\tGET / took 3 ms\n"))
+	}))
+	defer srv.Close()
+
+	var buf bytes.Buffer
+	target := createCloudTarget(t, srv.URL, ioutil.Discard)
+	levels := map[string]int{"emerg": 0, "alert": 0, "crit": 0, "err": 0, "warning": 1, "notice": 2, "info": 2, "debug": 3}
+	assert.Nil(t, target.PrintLog(context.Background(), LogOptions{Writer: &buf, Level: LogLevel("info"), LevelMapping: levels}))
+	expected := "[2021-09-27 10:31:30.905535] host1    crit    proxy            proxy.upstream\tUpstream unreachable\n" +
+		"[2021-09-27 10:31:31.905535] host1    notice  proxy            proxy.config\tReloaded config\n"
 	assert.Equal(t, expected, buf.String())
+
+	// Without a mapping, unknown level names are treated as debug
+	buf.Reset()
+	assert.Nil(t, target.PrintLog(context.Background(), LogOptions{Writer: &buf, Level: LogLevel("info")}))
+	assert.Equal(t, "", buf.String())
+}
+
+func TestLogMaxLines(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("1632738690.905535\thost1\t1/1\tc\tc.a\tinfo\tFirst\n" +
+			"1632738691.905535\thost1\t1/1\tc\tc.a\tinfo\tSecond\n" +
+			"1632738692.905535\thost1\t1/1\tc\tc.a\tinfo\tThird\n"))
+	}))
+	defer srv.Close()
+
+	var buf bytes.Buffer
+	truncated := 0
+	target := createCloudTarget(t, srv.URL, ioutil.Discard)
+	assert.Nil(t, target.PrintLog(context.Background(), LogOptions{Writer: &buf, Level: 3, MaxLines: 2, Truncated: func() { truncated++ }}))
+	expected := "[2021-09-27 10:31:30.905535] host1    info    c                c.a\tFirst\n" +
+		"[2021-09-27 10:31:31.905535] host1    info    c                c.a\tSecond\n"
+	assert.Equal(t, expected, buf.String())
+	assert.Equal(t, 1, truncated)
+
+	// Not truncated when within the limit
+	buf.Reset()
+	truncated = 0
+	assert.Nil(t, target.PrintLog(context.Background(), LogOptions{Writer: &buf, Level: 3, MaxLines: 3, Truncated: func() { truncated++ }}))
+	assert.Equal(t, 3, strings.Count(buf.String(), "\n"))
+	assert.Equal(t, 0, truncated)
+}
+
+func TestLogMaxLinesFollow(t *testing.T) {
+	defer func(interval time.Duration) { retryInterval = interval }(retryInterval)
+	retryInterval = 0
+	responses := []string{
+		"1632738690.905535\thost1\t1/1\tc\tc.a\tinfo\tFirst\n" +
+			"1632738691.905535\thost1\t1/1\tc\tc.a\tinfo\tSecond\n" +
+			"1632738692.905535\thost1\t1/1\tc\tc.a\tinfo\tThird\n",
+		"1632738693.905535\thost1\t1/1\tc\tc.a\tinfo\tFourth\n" +
+			"1632738694.905535\thost1\t1/1\tc\tc.a\tinfo\tFifth\n",
+	}
+	requests := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if requests == len(responses) {
+			w.WriteHeader(401) // Stops following
+			return
+		}
+		w.Write([]byte(responses[requests]))
+		requests++
+	}))
+	defer srv.Close()
+
+	var buf bytes.Buffer
+	truncated := 0
+	target := createCloudTarget(t, srv.URL, ioutil.Discard)
+	err := target.PrintLog(context.Background(), LogOptions{Writer: &buf, Level: 3, Follow: true, MaxLines: 2, Truncated: func() { truncated++ }})
+	assert.NotNil(t, err)
+	expected := "[2021-09-27 10:31:31.905535] host1    info    c                c.a\tSecond\n" +
+		"[2021-09-27 10:31:32.905535] host1    info    c                c.a\tThird\n" +
+		"[2021-09-27 10:31:33.905535] host1    info    c                c.a\tFourth\n" +
+		"[2021-09-27 10:31:34.905535] host1    info    c                c.a\tFifth\n"
+	assert.Equal(t, expected, buf.String(), "the latest entries of the backfill are written, then all new ones")
+	assert.Equal(t, 1, truncated)
+}
+
+func TestLogFilters(t *testing.T) {
+	requests := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		w.Write([]byte("1632738690.905535\thost1\t1/1\tcontainer\tContainer.com.example.Foo\tinfo\tOut of memory\n" +
+			"1632738691.905535\thost1\t1/1\tcontainer\tContainer.com.example.Bar\tinfo\tOut of disk\n" +
+			"1632738692.905535\thost1\t1/1\tsearchnode\tsearchnode.proton\tinfo\tOut of memory\n"))
+	}))
+	defer srv.Close()
+
+	var buf bytes.Buffer
+	target := createCloudTarget(t, srv.URL, ioutil.Discard)
+	assert.Nil(t, target.PrintLog(context.Background(), LogOptions{Writer: &buf, Level: 3, MessageFilter: "memory$", ComponentFilter: `^Container\.`}))
+	assert.Equal(t, "[2021-09-27 10:31:30.905535] host1    info    container        Container.com.example.Foo\tOut of memory\n", buf.String())
+
+	buf.Reset()
+	assert.Nil(t, target.PrintLog(context.Background(), LogOptions{Writer: &buf, Level: 3, MessageFilter: "Out of"}))
+	assert.Equal(t, 3, strings.Count(buf.String(), "\n"))
+	assert.Equal(t, 2, requests)
+
+	err := target.PrintLog(context.Background(), LogOptions{Writer: &buf, Level: 3, MessageFilter: "(memory"})
+	assert.EqualError(t, err, "invalid message filter: \"(memory\": error parsing regexp: missing closing ): `(memory`")
+	err = target.PrintLog(context.Background(), LogOptions{Writer: &buf, Level: 3, ComponentFilter: "["})
+	assert.EqualError(t, err, "invalid component filter: \"[\": error parsing regexp: missing closing ]: `[`")
+	assert.Equal(t, 2, requests, "no request is made with invalid filters")
+}
+
+func TestLogPartialContent(t *testing.T) {
+	defer func(interval time.Duration) { retryInterval = interval }(retryInterval)
+	retryInterval = 0
+	entries := []string{
+		"1632738690.905535\thost1\t1/1\tcontainer\tcom.yahoo.Foo\tinfo\tFirst\n",
+		"1632738691.905535\thost1\t1/1\tcontainer\tcom.yahoo.Foo\tinfo\tSecond, cut off in the first response\n",
+		"1632738692.905535\thost1\t1/1\tcontainer\tcom.yahoo.Foo\tinfo\tThird\n",
+	}
+	var froms []string
+	follow := false
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		from := r.URL.Query().Get("from")
+		froms = append(froms, from)
+		switch {
+		case len(froms) == 1:
+			w.WriteHeader(206) // Response ends in the middle of the second entry
+			w.Write([]byte(entries[0] + entries[1][:60]))
+		case len(froms) == 2:
+			w.Write([]byte(strings.Join(entries, ""))) // Entries from the start of the second of the last entry read
+		case follow:
+			w.WriteHeader(401) // Stops following
+		default:
+			t.Errorf("unexpected request from %s", from)
+			w.WriteHeader(500)
+		}
+	}))
+	defer srv.Close()
+
+	expected := "[2021-09-27 10:31:30.905535] host1    info    container        com.yahoo.Foo\tFirst\n" +
+		"[2021-09-27 10:31:31.905535] host1    info    container        com.yahoo.Foo\tSecond, cut off in the first response\n" +
+		"[2021-09-27 10:31:32.905535] host1    info    container        com.yahoo.Foo\tThird\n"
+	target := createCloudTarget(t, srv.URL, ioutil.Discard)
+	for _, follow = range []bool{false, true} {
+		froms = nil
+		var buf bytes.Buffer
+		err := target.PrintLog(context.Background(), LogOptions{Writer: &buf, Level: 3, Follow: follow, From: time.Unix(0, 0)})
+		if follow {
+			assert.NotNil(t, err)
+		} else {
+			assert.Nil(t, err)
+			assert.Equal(t, []string{"0", "1632738690000"}, froms)
+		}
+		assert.Equal(t, expected, buf.String(), "follow=%t", follow)
+	}
+}
+
+func TestRuns(t *testing.T) {
+	vc := mockVespaApi{}
+	srv := httptest.NewServer(http.HandlerFunc(vc.mockVespaHandler))
+	defer srv.Close()
+
+	target := createCloudTarget(t, srv.URL, ioutil.Discard)
+	runs, err := target.Runs(context.Background(), 0)
+	assert.Nil(t, err)
+	assert.Equal(t, []RunSummary{
+		{ID: 43, Status: "running", Start: time.Unix(1631707900, 0), Version: "7.470.2"},
+		{ID: 42, Status: "deploymentFailed", Start: time.Unix(1631707700, 0), Version: "7.465.17"},
+		{ID: 41, Status: "success", Start: time.Unix(1631707000, 0), Version: "7.465.17"},
+	}, runs)
+
+	runs, err = target.Runs(context.Background(), 2)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(runs))
+	assert.Equal(t, int64(43), runs[0].ID)
+	assert.Equal(t, int64(42), runs[1].ID)
+
+	_, err = LocalTarget().Runs(context.Background(), 0)
+	assert.NotNil(t, err)
+}
+
+func TestFetchRunLog(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/application/v4/tenant/t1/application/a1/instance/i1/job/dev-us-north-1/run/42", r.URL.Path)
+		if r.URL.Query().Get("after") == "7" {
+			w.Write([]byte(`{"active": false, "status": "success"}`))
+			return
+		}
+		assert.Equal(t, "-1", r.URL.Query().Get("after"))
+		w.Write([]byte(`{"active": true, "status": "running", "lastId": 7,
+                         "log": {"installReal": [{"at": 1631707710000, "type": "info", "message": "Installing"},
+                                                 {"at": 1631707712500, "type": "warning", "message": "Slow install"}],
+                                 "deployReal": [{"at": 1631707708431, "type": "info", "message": "Deploying"},
+                                                {"at": 1631707711000, "type": "debug", "message": "Deployed"}]}}`))
+	}))
+	defer srv.Close()
+
+	target := createCloudTarget(t, srv.URL, ioutil.Discard)
+	entries, last, err := target.FetchRunLog(context.Background(), 42, -1)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(7), last)
+	assert.Equal(t, []JobLogEntry{
+		{Step: "deployReal", At: time.Unix(1631707708, 431000000), Type: "info", Message: "Deploying"},
+		{Step: "installReal", At: time.Unix(1631707710, 0), Type: "info", Message: "Installing"},
+		{Step: "deployReal", At: time.Unix(1631707711, 0), Type: "debug", Message: "Deployed"},
+		{Step: "installReal", At: time.Unix(1631707712, 500000000), Type: "warning", Message: "Slow install"},
+	}, entries)
+
+	entries, last, err = target.FetchRunLog(context.Background(), 42, last)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(7), last)
+	assert.Empty(t, entries)
+
+	_, _, err = LocalTarget().FetchRunLog(context.Background(), 42, -1)
+	assert.NotNil(t, err)
+}
+
+func TestNodeFlavors(t *testing.T) {
+	vc := mockVespaApi{}
+	srv := httptest.NewServer(http.HandlerFunc(vc.mockVespaHandler))
+	defer srv.Close()
+
+	target := createCloudTarget(t, srv.URL, ioutil.Discard)
+	want := []Flavor{
+		{Name: "small", Vcpu: 2, MemoryGb: 8, DiskGb: 50},
+		{Name: "large", Vcpu: 16, MemoryGb: 64, DiskGb: 937.5},
+	}
+	for i := 0; i < 2; i++ {
+		flavors, err := target.NodeFlavors(context.Background())
+		assert.Nil(t, err)
+		assert.Equal(t, want, flavors)
+	}
+	assert.Equal(t, 1, vc.flavorRequests, "flavors are cached")
+	assert.Equal(t, "vcpu=16,memory=64Gb,disk=937.5Gb", want[1].Resources())
+
+	_, err := LocalTarget().NodeFlavors(context.Background())
+	assert.NotNil(t, err)
+}
+
+func TestCloudTargetSyncClock(t *testing.T) {
+	skew := time.Hour
+	headRequests := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.Method == "HEAD" {
+			headRequests++
+		}
+		w.Header().Set("Date", time.Now().Add(skew).UTC().Format(http.TimeFormat))
+	}))
+	defer srv.Close()
+	target := createCloudTarget(t, srv.URL, ioutil.Discard)
+	ct := target.(*cloudTarget)
+	if ct.apiKey == nil {
+		t.Skip("request signing is not used with access token authentication")
+	}
+	ct.syncClock = true
+
+	for i := 0; i < 2; i++ {
+		req, err := http.NewRequest("GET", srv.URL+"/application/v4/tenant/t1", nil)
+		assert.Nil(t, err)
+		assert.Nil(t, target.PrepareApiRequest(req, "t1"))
+		timestamp, err := time.Parse(time.RFC3339, req.Header.Get("X-Timestamp"))
+		assert.Nil(t, err)
+		assert.InDelta(t, float64(skew), float64(time.Until(timestamp)), float64(3*time.Second))
+	}
+	assert.Equal(t, 1, headRequests, "clock is synchronized once")
+}
+
+func TestClockOffsetInvalidDate(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Date", "yesterday")
+	}))
+	defer srv.Close()
+	_, err := ClockOffset(srv.URL)
+	assert.EqualError(t, err, "could not synchronize clock with "+srv.URL+": invalid date header: \"yesterday\"")
 }
 
 func createCloudTarget(t *testing.T, url string, logWriter io.Writer) Target {
@@ -152,7 +1061,7 @@ func createCloudTarget(t *testing.T, url string, logWriter io.Writer) Target {
 	target := CloudTarget("https://example.com", Deployment{
 		Application: ApplicationID{Tenant: "t1", Application: "a1", Instance: "i1"},
 		Zone:        ZoneID{Environment: "dev", Region: "us-north-1"},
-	}, apiKey, TLSOptions{KeyPair: x509KeyPair}, LogOptions{Writer: logWriter}, "", "", "", nil)
+	}, apiKey, TLSOptions{KeyPair: x509KeyPair}, LogOptions{Writer: logWriter}, nil, "", nil, false, nil)
 	if ct, ok := target.(*cloudTarget); ok {
 		ct.apiURL = url
 	} else {
@@ -162,16 +1071,16 @@ func createCloudTarget(t *testing.T, url string, logWriter io.Writer) Target {
 }
 
 func assertServiceURL(t *testing.T, url string, target Target, service string) {
-	s, err := target.Service(service, 0, 42, "")
+	s, err := target.Service(context.Background(), service, 0, 42, "")
 	assert.Nil(t, err)
 	assert.Equal(t, url, s.BaseURL)
 }
 
 func assertServiceWait(t *testing.T, expectedStatus int, target Target, service string) {
-	s, err := target.Service(service, 0, 42, "")
+	s, err := target.Service(context.Background(), service, 0, 42, "")
 	assert.Nil(t, err)
 
-	status, err := s.Wait(0)
+	status, err := s.Wait(context.Background(), 0)
 	assert.Nil(t, err)
 	assert.Equal(t, expectedStatus, status)
 }
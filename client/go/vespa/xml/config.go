@@ -13,6 +13,12 @@ import (
 
 var DefaultDeployment Deployment
 
+const (
+	// minMemoryPerVcpu and maxMemoryPerVcpu are the bounds of the ratio between memory, in Gb, and vcpu of a node.
+	minMemoryPerVcpu = 1.0
+	maxMemoryPerVcpu = 32.0
+)
+
 func init() {
 	defaultDeploymentRaw := `<deployment version="1.0">
   <prod>
@@ -36,7 +42,8 @@ type Deployment struct {
 }
 
 type Instance struct {
-	Prod Prod `xml:"prod"`
+	ID   string `xml:"id,attr"`
+	Prod Prod   `xml:"prod"`
 }
 
 type Prod struct {
@@ -121,10 +128,27 @@ func ReadDeployment(r io.Reader) (Deployment, error) {
 	if err := dec.Decode(&deployment); err != nil {
 		return Deployment{}, err
 	}
+	if root := rootElement(rawXML.Bytes()); root != "deployment" {
+		return Deployment{}, fmt.Errorf("expected element type <deployment> but have <%s>", root)
+	}
 	deployment.rawXML = rawXML
 	return deployment, nil
 }
 
+// rootElement returns the local name of the root element of the XML document in data.
+func rootElement(data []byte) string {
+	dec := xml.NewDecoder(bytes.NewReader(data))
+	for {
+		token, err := dec.Token()
+		if err != nil {
+			return ""
+		}
+		if start, ok := token.(xml.StartElement); ok {
+			return start.Name.Local
+		}
+	}
+}
+
 // ReadServices reads services.xml from reader r.
 func ReadServices(r io.Reader) (Services, error) {
 	var services Services
@@ -186,6 +210,37 @@ func ParseResources(s string) (Resources, error) {
 	return Resources{Vcpu: vcpu, Memory: memory, Disk: disk}, nil
 }
 
+// Validate returns an error if the ratio between memory and vcpu of resources r is outside the bounds supported by
+// Vespa Cloud. If vcpu or memory is given as a range, the ratio is checked at both ends of the range.
+func (r Resources) Validate() error {
+	minVcpu, maxVcpu, err := parseResourceRange(r.Vcpu, parseNumber)
+	if err != nil {
+		return fmt.Errorf("invalid vcpu: %w", err)
+	}
+	minMemory, maxMemory, err := parseResourceRange(r.Memory, parseMemory)
+	if err != nil {
+		return fmt.Errorf("invalid memory: %w", err)
+	}
+	if err := validateMemoryRatio(minVcpu, minMemory); err != nil {
+		return err
+	}
+	return validateMemoryRatio(maxVcpu, maxMemory)
+}
+
+func validateMemoryRatio(vcpu, memoryGb float64) error {
+	if vcpu <= 0 {
+		return fmt.Errorf("vcpu must be positive, got %s", formatNumber(vcpu))
+	}
+	ratio := memoryGb / vcpu
+	if ratio < minMemoryPerVcpu {
+		return fmt.Errorf("memory %sGb too low for %s vcpu: must be at least %sGb per vcpu", formatNumber(memoryGb), formatNumber(vcpu), formatNumber(minMemoryPerVcpu))
+	}
+	if ratio > maxMemoryPerVcpu {
+		return fmt.Errorf("memory %sGb too high for %s vcpu: must be at most %sGb per vcpu", formatNumber(memoryGb), formatNumber(vcpu), formatNumber(maxMemoryPerVcpu))
+	}
+	return nil
+}
+
 // ParseNodeCount parses a node count range from string s.
 func ParseNodeCount(s string) (int, int, error) {
 	parseErr := fmt.Errorf("invalid node count: %q", s)
@@ -224,6 +279,56 @@ func IsProdRegion(s string, system string) bool {
 	return false
 }
 
+// parseResourceRange parses a single resource value or a range of values, e.g. "[2, 8]", using parse.
+func parseResourceRange(s string, parse func(string) (float64, error)) (float64, float64, error) {
+	s = strings.TrimSpace(s)
+	if strings.HasPrefix(s, "[") && strings.HasSuffix(s, "]") {
+		parts := strings.Split(s[1:len(s)-1], ",")
+		if len(parts) != 2 {
+			return 0, 0, fmt.Errorf("invalid range: %q", s)
+		}
+		min, err := parse(strings.TrimSpace(parts[0]))
+		if err != nil {
+			return 0, 0, err
+		}
+		max, err := parse(strings.TrimSpace(parts[1]))
+		if err != nil {
+			return 0, 0, err
+		}
+		return min, max, nil
+	}
+	n, err := parse(s)
+	return n, n, err
+}
+
+func parseNumber(s string) (float64, error) {
+	n, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid number: %q", s)
+	}
+	return n, nil
+}
+
+// parseMemory parses memory size s, e.g. "8Gb", and returns its value in Gb.
+func parseMemory(s string) (float64, error) {
+	lower := strings.ToLower(s)
+	unit := 1.0
+	for suffix, multiplier := range map[string]float64{"mb": 1.0 / 1000, "gb": 1, "tb": 1000} {
+		if strings.HasSuffix(lower, suffix) {
+			lower = strings.TrimSpace(strings.TrimSuffix(lower, suffix))
+			unit = multiplier
+			break
+		}
+	}
+	n, err := strconv.ParseFloat(lower, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid memory size: %q", s)
+	}
+	return n * unit, nil
+}
+
+func formatNumber(n float64) string { return strconv.FormatFloat(n, 'f', -1, 64) }
+
 func parseResource(field, s string) (string, error) {
 	parts := strings.SplitN(s, "=", 2)
 	if len(parts) != 2 || strings.TrimSpace(parts[0]) != field {
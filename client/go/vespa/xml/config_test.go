@@ -251,6 +251,19 @@ func TestParseResources(t *testing.T) {
 	assertResources(t, "vcpu=[2.5,  8],memory=[32Gb,150Gb],disk=[100Gb, 1Tb]", Resources{Vcpu: "[2.5,  8]", Memory: "[32Gb,150Gb]", Disk: "[100Gb, 1Tb]"}, false)
 }
 
+func TestValidateResources(t *testing.T) {
+	assertValidResources(t, "vcpu=4,memory=8Gb,disk=100Gb", "")
+	assertValidResources(t, "vcpu=2,memory=64Gb,disk=100Gb", "")
+	assertValidResources(t, "vcpu=16,memory=16000Mb,disk=100Gb", "")
+	assertValidResources(t, "vcpu=[2.5,  8],memory=[32Gb,150Gb],disk=[100Gb, 1Tb]", "")
+	assertValidResources(t, "vcpu=16,memory=1Gb,disk=100Gb", "memory 1Gb too low for 16 vcpu: must be at least 1Gb per vcpu")
+	assertValidResources(t, "vcpu=2,memory=1Tb,disk=100Gb", "memory 1000Gb too high for 2 vcpu: must be at most 32Gb per vcpu")
+	assertValidResources(t, "vcpu=[2,16],memory=[8Gb,8Gb],disk=100Gb", "memory 8Gb too low for 16 vcpu: must be at least 1Gb per vcpu")
+	assertValidResources(t, "vcpu=0,memory=8Gb,disk=100Gb", "vcpu must be positive, got 0")
+	assertValidResources(t, "vcpu=foo,memory=8Gb,disk=100Gb", `invalid vcpu: invalid number: "foo"`)
+	assertValidResources(t, "vcpu=2,memory=8Xb,disk=100Gb", `invalid memory: invalid memory size: "8Xb"`)
+}
+
 func TestParseNodeCount(t *testing.T) {
 	assertNodeCount(t, "2", 2, 2, false)
 	assertNodeCount(t, "[4,8]", 4, 8, false)
@@ -298,3 +311,20 @@ func assertResources(t *testing.T, input string, want Resources, wantErr bool) {
 		t.Errorf("got %+v, want %+v", got, want)
 	}
 }
+
+func assertValidResources(t *testing.T, input string, wantErr string) {
+	r, err := ParseResources(input)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = r.Validate()
+	if wantErr == "" {
+		if err != nil {
+			t.Errorf("got error %q for %q, want none", err, input)
+		}
+		return
+	}
+	if err == nil || err.Error() != wantErr {
+		t.Errorf("got error %v for %q, want %q", err, input, wantErr)
+	}
+}
@@ -0,0 +1,42 @@
+package xml
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+var instanceNamePattern = regexp.MustCompile(`^[a-z]([a-z0-9-]{0,61}[a-z0-9])?$`)
+
+// ValidateRegions returns an error if input is not a comma-separated list of production regions in system.
+func ValidateRegions(input, system string) error {
+	for _, r := range strings.Split(input, ",") {
+		if !IsProdRegion(r, system) {
+			return fmt.Errorf("invalid region %s", r)
+		}
+	}
+	return nil
+}
+
+// ValidateNodeCount returns an error if input is not a node count, or a range of node counts.
+func ValidateNodeCount(input string) error {
+	_, _, err := ParseNodeCount(input)
+	return err
+}
+
+// ValidateResources returns an error if input is not node resources supported by Vespa Cloud.
+func ValidateResources(input string) error {
+	r, err := ParseResources(input)
+	if err != nil {
+		return err
+	}
+	return r.Validate()
+}
+
+// ValidateInstanceName returns an error if input is not a valid instance name.
+func ValidateInstanceName(input string) error {
+	if !instanceNamePattern.MatchString(input) {
+		return fmt.Errorf("invalid instance name %q: must be at most 63 lowercase letters, digits or hyphens, starting with a letter and not ending with a hyphen", input)
+	}
+	return nil
+}
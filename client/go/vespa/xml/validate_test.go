@@ -0,0 +1,52 @@
+package xml
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestValidateRegions(t *testing.T) {
+	public := func(input string) error { return ValidateRegions(input, "public") }
+	assertValidator(t, public, "aws-us-east-1c", "")
+	assertValidator(t, public, "aws-us-east-1c,aws-eu-west-1a", "")
+	assertValidator(t, public, "aws-us-east-1c,mars-north-1", "invalid region mars-north-1")
+	assertValidator(t, public, "", "invalid region ")
+	publicCD := func(input string) error { return ValidateRegions(input, "publiccd") }
+	assertValidator(t, publicCD, "aws-us-west-2a", "invalid region aws-us-west-2a")
+}
+
+func TestValidateNodeCount(t *testing.T) {
+	assertValidator(t, ValidateNodeCount, "4", "")
+	assertValidator(t, ValidateNodeCount, "[2, 8]", "")
+	assertValidator(t, ValidateNodeCount, "four", `invalid node count: "four"`)
+}
+
+func TestValidateResourcesInput(t *testing.T) {
+	assertValidator(t, ValidateResources, "vcpu=4,memory=8Gb,disk=100Gb", "")
+	assertValidator(t, ValidateResources, "vcpu=4,memory=8Gb", `invalid resources: "vcpu=4,memory=8Gb"`)
+	assertValidator(t, ValidateResources, "vcpu=16,memory=1Gb,disk=100Gb", "memory 1Gb too low for 16 vcpu: must be at least 1Gb per vcpu")
+}
+
+func TestValidateInstanceName(t *testing.T) {
+	assertValidator(t, ValidateInstanceName, "default", "")
+	assertValidator(t, ValidateInstanceName, "my-instance2", "")
+	assertValidator(t, ValidateInstanceName, "My-instance", `invalid instance name "My-instance"`)
+	assertValidator(t, ValidateInstanceName, "2nd", `invalid instance name "2nd"`)
+	assertValidator(t, ValidateInstanceName, "instance-", `invalid instance name "instance-"`)
+	assertValidator(t, ValidateInstanceName, strings.Repeat("a", 64), `invalid instance name "`+strings.Repeat("a", 64)+`"`)
+	assertValidator(t, ValidateInstanceName, "", `invalid instance name ""`)
+}
+
+func assertValidator(t *testing.T, validator func(input string) error, input, wantErr string) {
+	t.Helper()
+	err := validator(input)
+	if wantErr == "" {
+		if err != nil {
+			t.Errorf("got error %q for input %q, want none", err, input)
+		}
+		return
+	}
+	if err == nil || !strings.HasPrefix(err.Error(), wantErr) {
+		t.Errorf("got error %v for input %q, want error starting with %q", err, input, wantErr)
+	}
+}